@@ -1,12 +1,15 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"fan-chain/core"
+	"fan-chain/storage"
 )
 
 // 处理余额查询
@@ -29,17 +32,53 @@ func (s *Server) handleBalance(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 查询余额
-	account, _ := s.state.GetAccount(address)
+	// 查询余额（?height=H 查询历史余额）
+	s.writeAccountBalance(w, r, address)
+}
+
+// writeAccountBalance 输出账户余额：默认为当前状态，带 ?height=H 时从归档中查询该高度区块执行后的状态
+func (s *Server) writeAccountBalance(w http.ResponseWriter, r *http.Request, address string) {
+	var account *core.Account
+	heightStr := r.URL.Query().Get("height")
+	var height uint64
+
+	if heightStr == "" {
+		account, _ = s.state.GetAccount(address)
+	} else {
+		var err error
+		height, err = strconv.ParseUint(heightStr, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid height", http.StatusBadRequest)
+			return
+		}
+		if !s.db.ArchiveMode() {
+			http.Error(w, "Historical queries require archive mode (\"archive\": true in config.json)", http.StatusBadRequest)
+			return
+		}
+		account, err = s.db.GetAccountAtHeight(address, height)
+		if errors.Is(err, storage.ErrHistoryUnavailable) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to get account history: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
 	totalBalance := uint64(0)
 	availableBalance := uint64(0)
 	stakedBalance := uint64(0)
+	unbondingBalance := uint64(0)
+	unbondingUntil := uint64(0)
 	nonce := uint64(0)
 
 	if account != nil {
 		availableBalance = account.AvailableBalance
 		stakedBalance = account.StakedBalance
-		totalBalance = availableBalance + stakedBalance
+		unbondingBalance = account.UnbondingBalance
+		unbondingUntil = account.StakeLockedUntil
+		totalBalance = account.TotalBalance()
 		nonce = account.Nonce
 	}
 
@@ -47,9 +86,14 @@ func (s *Server) handleBalance(w http.ResponseWriter, r *http.Request) {
 		"address":           address,
 		"available_balance": availableBalance,
 		"staked_balance":    stakedBalance,
+		"unbonding_balance": unbondingBalance,
+		"unbonding_until":   unbondingUntil,
 		"total_balance":     totalBalance,
 		"nonce":             nonce,
 	}
+	if heightStr != "" {
+		response["height"] = height
+	}
 
 	writeJSON(w, response)
 }
@@ -96,7 +140,7 @@ func (s *Server) handleAccounts(w http.ResponseWriter, r *http.Request) {
 
 	sorted := make([]accountWithBalance, 0, len(accounts))
 	for _, acc := range accounts {
-		total := acc.AvailableBalance + acc.StakedBalance
+		total := acc.TotalBalance()
 		sorted = append(sorted, accountWithBalance{
 			Address:          acc.Address,
 			AvailableBalance: acc.AvailableBalance,
@@ -173,29 +217,14 @@ func (s *Server) handleAccountDetail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 返回账户详情
-	account, _ := s.state.GetAccount(address)
-	totalBalance := uint64(0)
-	availableBalance := uint64(0)
-	stakedBalance := uint64(0)
-	nonce := uint64(0)
-
-	if account != nil {
-		availableBalance = account.AvailableBalance
-		stakedBalance = account.StakedBalance
-		totalBalance = availableBalance + stakedBalance
-		nonce = account.Nonce
-	}
-
-	response := map[string]interface{}{
-		"address":           address,
-		"available_balance": availableBalance,
-		"staked_balance":    stakedBalance,
-		"total_balance":     totalBalance,
-		"nonce":             nonce,
+	// 如果是 /account/{address}/proof 请求
+	if len(parts) >= 2 && parts[1] == "proof" {
+		s.handleAccountProof(w, address)
+		return
 	}
 
-	writeJSON(w, response)
+	// 返回账户详情（?height=H 查询历史状态）
+	s.writeAccountBalance(w, r, address)
 }
 
 // 处理账户交易历史查询
@@ -256,6 +285,33 @@ func (s *Server) handleAccountTransactions(w http.ResponseWriter, r *http.Reques
 	writeJSON(w, response)
 }
 
+// 处理账户状态证明查询（轻客户端）
+// 返回账户（不存在时为非包含证明）、稀疏Merkle兄弟路径以及最新签名检查点
+// 只有 matches_checkpoint 为 true 时证明才能直接对检查点的StateRoot验证；否则客户端应稍后重试
+func (s *Server) handleAccountProof(w http.ResponseWriter, address string) {
+	proof, err := s.state.GetAccountProof(address)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to build account proof: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"address":            address,
+		"proof":              proof,
+		"matches_checkpoint": false,
+	}
+
+	if s.getLatestCheckpoint != nil {
+		checkpoint, err := s.getLatestCheckpoint()
+		if err == nil && checkpoint != nil {
+			response["checkpoint"] = checkpoint
+			response["matches_checkpoint"] = checkpoint.StateRoot.String() == proof.StateRoot
+		}
+	}
+
+	writeJSON(w, response)
+}
+
 // 处理状态快照查询
 func (s *Server) handleStateSnapshot(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
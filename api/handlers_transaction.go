@@ -63,6 +63,13 @@ func (s *Server) handleTransactionByHash(w http.ResponseWriter, r *http.Request)
 
 	// 解析交易哈希
 	hashStr := strings.TrimPrefix(r.URL.Path, "/transaction/")
+
+	// /transaction/{hash}/receipt
+	if strings.HasSuffix(hashStr, "/receipt") {
+		s.handleTransactionReceipt(w, strings.TrimSuffix(hashStr, "/receipt"))
+		return
+	}
+
 	if hashStr == "" {
 		http.Error(w, "Transaction hash required", http.StatusBadRequest)
 		return
@@ -98,6 +105,55 @@ func (s *Server) handleTransactionByHash(w http.ResponseWriter, r *http.Request)
 	writeJSON(w, response)
 }
 
+// 查询交易回执（含区块TxRoot包含证明）
+func (s *Server) handleTransactionReceipt(w http.ResponseWriter, hashStr string) {
+	hashBytes, err := hex.DecodeString(hashStr)
+	if err != nil || len(hashBytes) != 32 {
+		http.Error(w, "Invalid transaction hash", http.StatusBadRequest)
+		return
+	}
+	hash := core.BytesToHash(hashBytes)
+
+	receipt, err := s.db.GetReceipt(hash)
+	if err != nil {
+		http.Error(w, "Receipt not found", http.StatusNotFound)
+		return
+	}
+
+	block, err := s.db.GetBlockByHeight(receipt.BlockHeight)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Block #%d not found: %v", receipt.BlockHeight, err), http.StatusNotFound)
+		return
+	}
+
+	// 回执记录的位置必须与区块内交易一致（防止区块被重组覆盖后返回错误证明）
+	if receipt.TxIndex >= len(block.Transactions) || block.Transactions[receipt.TxIndex].Hash() != hash {
+		http.Error(w, "Receipt is stale (block was replaced)", http.StatusConflict)
+		return
+	}
+
+	proof, err := block.TxProof(receipt.TxIndex)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to build tx proof: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"tx_hash":       receipt.TxHash,
+		"status":        receipt.StatusString(),
+		"error_code":    receipt.ErrorCode,
+		"error":         receipt.Error,
+		"block_height":  receipt.BlockHeight,
+		"block_hash":    receipt.BlockHash,
+		"tx_index":      receipt.TxIndex,
+		"gas_used":      receipt.GasUsed,
+		"state_changes": receipt.StateChanges,
+		"proof":         proof,
+	}
+
+	writeJSON(w, response)
+}
+
 // 处理交易历史查询
 func (s *Server) handleTransactions(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
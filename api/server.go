@@ -13,15 +13,16 @@ import (
 
 // API服务器
 type Server struct {
-	port              int
-	db                *storage.Database
-	state             *state.StateManager
-	blockchain        *core.Blockchain
-	getLatestBlock    func() *core.Block
-	getPeerCount      func() int
-	getAddress        func() string
-	getNodeName       func() string
-	submitTransaction func(*core.Transaction) error
+	port                int
+	db                  *storage.Database
+	state               *state.StateManager
+	blockchain          *core.Blockchain
+	getLatestBlock      func() *core.Block
+	getPeerCount        func() int
+	getAddress          func() string
+	getNodeName         func() string
+	submitTransaction   func(*core.Transaction) error
+	getLatestCheckpoint func() (*core.Checkpoint, error)
 }
 
 // 创建API服务器
@@ -49,6 +50,11 @@ func (s *Server) SetCallbacks(
 	s.submitTransaction = submitTransaction
 }
 
+// SetGetLatestCheckpoint 设置获取最新检查点的回调（账户证明使用）
+func (s *Server) SetGetLatestCheckpoint(fn func() (*core.Checkpoint, error)) {
+	s.getLatestCheckpoint = fn
+}
+
 // 启动API服务器
 func (s *Server) Start() error {
 	// 注册路由
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -9,14 +10,14 @@ import (
 	"sort"
 	"time"
 
+	"fan-chain/consensus"
 	"fan-chain/core"
 	"fan-chain/crypto"
+	"fan-chain/state"
 )
 
 func (n *Node) StartBlockProduction() {
 	var lastProposer string
-	var waitCount int
-	const maxWaitTime = 1 // 5秒failover（1个区块周期 × 5秒）- 符合fan.md P5协议
 
 	// 【架构变更】验证者集合只从Checkpoint加载，不需要定时重载
 	// 初始加载会在节点启动时通过LoadLatestCheckpoint完成
@@ -47,59 +48,57 @@ func (n *Node) StartBlockProduction() {
 
 		// 【架构变更】验证者集合只在Checkpoint时更新，不需要定时重载
 
-		// 【关键】VRF选择proposer - 这是防止分叉的核心
-		proposer, err := n.consensus.SelectProposer(nextHeight, latestBlock.Hash())
+		// 【关键】VRF出块者排名 - 首选出块者在第一个时间槽出块，备用出块者按名次依次顺延
+		ranked, err := n.consensus.RankProposers(nextHeight, latestBlock)
 		if err != nil {
 			log.Printf("Failed to select proposer: %v", err)
+			time.Sleep(time.Second)
 			continue
 		}
-
-		// 【强制规则】只有VRF选中的validator才能出块
-		if proposer != n.address {
-			// 不是自己的轮次，等待
-			if proposer != lastProposer {
-				// 新的proposer
-				log.Printf("Block #%d: VRF selected %s (not me), waiting...", nextHeight, proposer[:10])
-				lastProposer = proposer
-				waitCount = 1
-			} else {
-				// 同一个proposer持续多轮
-				waitCount++
-
-				if waitCount >= maxWaitTime {
-					// 【家长制】Failover前检查：如果有大哥（peer高度更高），不要Failover！
-					// 应该等同步完成，而不是自己出块导致分叉
-					if n.p2pServer != nil {
-						bestPeerHeight := n.p2pServer.GetBestPeerHeight()
-						if bestPeerHeight > nextHeight {
-							// 有大哥在出块！我落后了，不要Failover，继续等同步
-							log.Printf("👑 【家长制】大哥高度 %d > 我的下一个高度 %d，不Failover，等同步！", bestPeerHeight, nextHeight)
-							waitCount = 0 // 重置等待计数
-							// 主动触发同步请求
-							n.p2pServer.RequestSyncFromBestPeer(nextHeight, bestPeerHeight+100)
-							time.Sleep(2 * time.Second) // 等待同步
-							continue
-						}
-					}
-
-					// 没有大哥（所有peer都不比我高），可以Failover
-					log.Printf("⚠️ Validator %s timeout after %d block (5s), I will take over!", proposer[:10], maxWaitTime)
-
-					// 【关键修复】重置状态并直接出块，不再等待或同步
-					waitCount = 0
-					lastProposer = ""
-
-					// 直接跳出等待循环，进入出块流程
-					// 不需要再检查 proposer != n.address，直接由自己出块
-					log.Printf("🔄 Failover: Taking over block production for height %d", nextHeight)
-					goto PRODUCE_BLOCK
-				}
+		rank := -1
+		for i, addr := range ranked {
+			if addr == n.address {
+				rank = i
+				break
 			}
+		}
+		if rank < 0 {
 			time.Sleep(time.Second)
 			continue
 		}
 
-	PRODUCE_BLOCK:
+		// 【强制规则】时间槽未开启不能出块：首选出块者失联时由排名最靠前的在线备用出块者接管
+		slotStart := consensus.ProposerSlotStart(latestBlock, rank)
+		if wait := time.Until(time.UnixMilli(slotStart)); wait > 0 {
+			if ranked[0] != lastProposer {
+				log.Printf("Block #%d: VRF selected %s (not me, I am backup #%d), waiting...", nextHeight, ranked[0][:10], rank)
+				lastProposer = ranked[0]
+			}
+			if wait > time.Second {
+				wait = time.Second
+			}
+			time.Sleep(wait)
+			continue
+		}
+
+		if rank > 0 {
+			// 【家长制】Failover前检查：如果有大哥（peer高度更高），不要Failover！
+			// 应该等同步完成，而不是自己出块导致分叉
+			if n.p2pServer != nil {
+				bestPeerHeight := n.p2pServer.GetBestPeerHeight()
+				if bestPeerHeight > nextHeight {
+					// 有大哥在出块！我落后了，不要Failover，继续等同步
+					log.Printf("👑 【家长制】大哥高度 %d > 我的下一个高度 %d，不Failover，等同步！", bestPeerHeight, nextHeight)
+					// 主动触发同步请求
+					n.p2pServer.RequestSyncFromBestPeer(nextHeight, bestPeerHeight+100)
+					time.Sleep(2 * time.Second) // 等待同步
+					continue
+				}
+			}
+
+			log.Printf("🔄 Failover: %s missed its slot, backup #%d taking over block production for height %d",
+				ranked[0][:10], rank, nextHeight)
+		}
 
 		// 【家长制-统一检查】无论是VRF选中自己还是Failover，出块前都必须检查是否落后
 		// 这是100天修剪机制的要求：从checkpoint恢复后必须先同步区块历史，不能直接出块
@@ -141,8 +140,7 @@ func (n *Node) StartBlockProduction() {
 
 		// 确认可以出块
 		lastProposer = ""
-		waitCount = 0
-		log.Printf("✓ Block #%d: I am VRF selected proposer, producing block...", nextHeight)
+		log.Printf("✓ Block #%d: I am proposer rank %d, producing block...", nextHeight, rank)
 
 		if err := n.produceBlock(nextHeight, latestBlock); err != nil {
 			log.Printf("Failed to produce block: %v", err)
@@ -152,9 +150,15 @@ func (n *Node) StartBlockProduction() {
 }
 
 func (n *Node) produceBlock(height uint64, prevBlock *core.Block) error {
-	// 计算新区块时间戳（毫秒级）：确保至少比前一区块大出块间隔，避免竞争出块时时间戳冲突
-	blockIntervalMs := int64(core.BlockInterval()) * 1000 // 转换为毫秒
-	minTimestamp := prevBlock.Header.Timestamp + blockIntervalMs
+	// 计算新区块时间戳（毫秒级）：不早于本节点出块名次的时间槽（首选出块者为前一区块加出块间隔）
+	rank, err := n.consensus.ProposerRank(height, prevBlock, n.address)
+	if err != nil {
+		return fmt.Errorf("failed to rank proposers: %v", err)
+	}
+	if rank < 0 {
+		rank = 0
+	}
+	minTimestamp := consensus.ProposerSlotStart(prevBlock, rank)
 	currentTimestamp := time.Now().UnixMilli()
 
 	// 使用两者中的较大值，确保时间戳严格递增
@@ -170,34 +174,81 @@ func (n *Node) produceBlock(height uint64, prevBlock *core.Block) error {
 		PreviousHash: prevBlock.Hash(),
 		Timestamp:    newTimestamp,
 		Proposer:     n.address,
-		StateRoot:    n.consensus.CalculateStateRoot(),
 	}
 
-	vrfSeed := append(prevBlock.Hash().Bytes(), core.Uint64ToBytes(height)...)
-	vrfProof, err := crypto.ComputeVRF(n.privateKey, vrfSeed)
+	vrfProof, vrfOutput, err := n.consensus.ProveVRF(height, prevBlock)
 	if err != nil {
 		return fmt.Errorf("failed to compute VRF: %v", err)
 	}
-	header.VRFProof = vrfProof.Proof
-	header.VRFOutput = vrfProof.Output
+	header.VRFProof = vrfProof
+	header.VRFOutput = vrfOutput
 
-	rawUserTxs := n.loadPendingTransactions()
-	userTxs := n.validateAndDeduplicateTransactions(rawUserTxs)
+	// 接管出块时在区块头记录错过轮次的出块者，执行区块时据此累计其MissedBlocks
+	if core.VRFActive(height) {
+		expected, err := n.consensus.SelectProposer(height, prevBlock)
+		if err != nil {
+			return fmt.Errorf("failed to select proposer: %v", err)
+		}
+		if expected != n.address {
+			header.MissedProposer = expected
+		}
+	}
 
 	activeVals := n.consensus.ValidatorSet().GetActiveValidators()
 	rewardTxs := n.consensus.CreateRewardTransactions(n.address, activeVals)
 
-	allTxs := append(userTxs, rewardTxs...)
+	// 双签惩罚交易放在最前面，按出块前的状态计算罚没金额
+	slashTxs := n.createSlashTransactions()
+	systemTxs := append(append([]*core.Transaction{}, slashTxs...), rewardTxs...)
 
-	tempBlock := &core.Block{
-		Header:       header,
-		Transactions: allTxs,
+	rawUserTxs := n.selectPendingTransactions(header, systemTxs)
+	userTxs := n.validateAndDeduplicateTransactions(height, rawUserTxs)
+
+	stateSnapshot := n.state.CreateSnapshot()
+
+	// 执行区块中的交易（严格验证，因为是新产生的区块），执行后的状态根写入区块头再签名
+	// 用户交易执行失败时从交易池剔除并重新组装区块，不因单笔交易放弃出块
+	var allTxs []*core.Transaction
+	var receipts []*core.Receipt
+	for {
+		allTxs = make([]*core.Transaction, 0, len(slashTxs)+len(userTxs)+len(rewardTxs))
+		allTxs = append(allTxs, slashTxs...)
+		allTxs = append(allTxs, userTxs...)
+		allTxs = append(allTxs, rewardTxs...)
+
+		tempBlock := &core.Block{
+			Header:       header,
+			Transactions: allTxs,
+		}
+		header.TxRoot = tempBlock.CalculateTxRoot()
+
+		receipts, err = n.state.ExecuteBlock(tempBlock, false)
+		if err == nil {
+			break
+		}
+		n.state.RestoreSnapshot(stateSnapshot)
+
+		var execErr *state.TxExecutionError
+		if !errors.As(err, &execErr) || execErr.Tx.Type.IsSystemTx() {
+			return fmt.Errorf("failed to execute tx: %v", err)
+		}
+		log.Printf("⚠️  [TX_POOL] Evicting tx %x from block #%d: %v", execErr.Tx.Hash().Bytes()[:8], height, execErr.Err)
+		n.txPool.Remove(execErr.Tx.Hash())
+		userTxs = dropSenderTxsFrom(userTxs, execErr.Tx)
+	}
+	if core.StateRootActive(height) {
+		stateRoot, err := n.state.CalculateStateRoot(height)
+		if err != nil {
+			n.state.RestoreSnapshot(stateSnapshot)
+			return fmt.Errorf("failed to calculate state root: %v", err)
+		}
+		header.StateRoot = stateRoot
 	}
-	header.TxRoot = tempBlock.CalculateTxRoot()
 
 	headerData := header.SignData()
 	signature, err := crypto.Sign(n.privateKey, headerData)
 	if err != nil {
+		n.state.RestoreSnapshot(stateSnapshot)
 		return fmt.Errorf("failed to sign block: %v", err)
 	}
 	header.Signature = signature
@@ -214,17 +265,14 @@ func (n *Node) produceBlock(height uint64, prevBlock *core.Block) error {
 	}
 
 	if err := n.chain.ValidateBlock(block); err != nil {
+		n.state.RestoreSnapshot(stateSnapshot)
 		return fmt.Errorf("block validation failed: %v", err)
 	}
 
-	stateSnapshot := n.state.CreateSnapshot()
-
-	// 执行区块中的交易（严格验证，因为是新产生的区块）
-	for _, tx := range block.Transactions {
-		if err := n.state.ExecuteTransaction(tx, false); err != nil {
-			n.state.RestoreSnapshot(stateSnapshot)
-			return fmt.Errorf("failed to execute tx: %v", err)
-		}
+	// 回执在状态根写入区块头之前生成，按最终的区块哈希更正
+	blockHash := block.Hash().String()
+	for _, receipt := range receipts {
+		receipt.BlockHash = blockHash
 	}
 
 	// 【原子性提交顺序】区块先落盘，状态后提交
@@ -242,12 +290,21 @@ func (n *Node) produceBlock(height uint64, prevBlock *core.Block) error {
 		return fmt.Errorf("failed to commit state (P0 check): %v", err)
 	}
 
+	// 交易回执（回执缺失不影响共识，只记录警告）
+	if err := n.db.SaveReceipts(receipts); err != nil {
+		log.Printf("Warning: failed to save receipts for block #%d: %v", height, err)
+	}
+
 	// 3. 更新内存中的链状态
 	if err := n.chain.AddBlock(block); err != nil {
 		// 内存状态更新失败不影响持久化数据，重启会恢复
 		return fmt.Errorf("failed to add block: %v", err)
 	}
 
+	// 4. 区块已上链，移除交易池中已打包的交易
+	n.txPool.RemoveIncluded(block.Transactions)
+	n.evidencePool.RemoveIncluded(block.Transactions)
+
 	if n.p2pServer != nil {
 		n.p2pServer.BroadcastBlock(block)
 	}
@@ -265,35 +322,53 @@ func (n *Node) produceBlock(height uint64, prevBlock *core.Block) error {
 	return nil
 }
 
-func (n *Node) loadPendingTransactions() []*core.Transaction {
-	txs := make([]*core.Transaction, 0)
+// selectPendingTransactions 从交易池选出本块要打包的交易
+// 交易数受MaxTxPerBlock限制，交易总大小受MaxBlockSize（扣除区块头和奖励交易）限制
+// 交易只在区块成功上链后才从池中移除，出块失败不会丢交易
+func (n *Node) selectPendingTransactions(header *core.BlockHeader, systemTxs []*core.Transaction) []*core.Transaction {
+	// 先清理nonce已被消耗或已过期的交易
+	n.txPool.Prune(n.nonceOf)
 
-	files, err := os.ReadDir(n.pendingTxDir)
-	if err != nil {
-		return txs
-	}
+	consensusConfig := core.GetConsensusConfig()
+	maxTx := consensusConfig.TransactionParams.MaxTxPerBlock
+	maxBlockSize := consensusConfig.BlockParams.MaxBlockSize
 
-	for _, file := range files {
-		if file.IsDir() {
-			continue
+	// 系统交易（惩罚、奖励）先占用名额
+	if maxTx > 0 {
+		if uint64(len(systemTxs)) >= maxTx {
+			return []*core.Transaction{}
 		}
+		maxTx -= uint64(len(systemTxs))
+	}
 
-		filePath := filepath.Join(n.pendingTxDir, file.Name())
-		data, err := os.ReadFile(filePath)
-		if err != nil {
-			continue
-		}
+	baseSize, err := n.calculateBlockSize(&core.Block{Header: header, Transactions: systemTxs})
+	if err != nil {
+		log.Printf("[TX_POOL] Failed to calculate base block size: %v", err)
+		return []*core.Transaction{}
+	}
+	// 预留区块签名空间（ML-DSA-65签名约3.3KB，JSON base64后约4.4KB）
+	baseSize += 8192
+	if baseSize >= maxBlockSize {
+		return []*core.Transaction{}
+	}
 
-		var tx core.Transaction
-		if err := json.Unmarshal(data, &tx); err != nil {
+	txs := n.txPool.Pending(n.nonceOf, maxTx, maxBlockSize-baseSize)
+	if len(txs) > 0 {
+		log.Printf("[TX_POOL] Selected %d/%d transactions for block #%d", len(txs), n.txPool.Len(), header.Height)
+	}
+	return txs
+}
+
+// dropSenderTxsFrom 剔除执行失败的交易及同一发送者nonce更高的后续交易（nonce断档后必然失败）
+func dropSenderTxsFrom(txs []*core.Transaction, failed *core.Transaction) []*core.Transaction {
+	kept := make([]*core.Transaction, 0, len(txs))
+	for _, tx := range txs {
+		if tx.From == failed.From && tx.Nonce >= failed.Nonce {
 			continue
 		}
-
-		txs = append(txs, &tx)
-		os.Remove(filePath)
+		kept = append(kept, tx)
 	}
-
-	return txs
+	return kept
 }
 
 // generateCheckpoint 生成检查点（包含总量检查）
@@ -325,7 +400,7 @@ func (n *Node) generateCheckpoint(height uint64, block *core.Block) error {
 			}
 
 			// 重新加载状态
-			if err := n.state.ReloadStateFromHeight(n.db, validHeight); err != nil {
+			if err := n.reloadStateAt(validHeight); err != nil {
 				return fmt.Errorf("failed to reload state: %v", err)
 			}
 
@@ -344,7 +419,7 @@ func (n *Node) generateCheckpoint(height uint64, block *core.Block) error {
 	}
 
 	// 计算StateRoot
-	stateRoot, err := n.state.CalculateStateRoot()
+	stateRoot, err := n.state.CalculateStateRoot(height)
 	if err != nil {
 		return fmt.Errorf("failed to calculate state root: %v", err)
 	}
@@ -353,13 +428,64 @@ func (n *Node) generateCheckpoint(height uint64, block *core.Block) error {
 	checkpoint := core.NewCheckpoint(
 		height,
 		block.Hash(),
-		block.Header.PreviousHash,  // 添加前一个区块哈希
+		block.Header.PreviousHash, // 添加前一个区块哈希
 		stateRoot,
 		block.Header.Timestamp,
 		n.address,
 	)
+	checkpoint.VRFOutput = block.Header.VRFOutput
 
-	// 【竞争性激活】从所有质押账户中选择前N名作为活跃验证者
+	validators, err := n.selectCheckpointValidators()
+	if err != nil {
+		return err
+	}
+	checkpoint.Validators = validators
+
+	// 签名checkpoint（提议者签名 + 本节点的验证者多签）
+	if err := checkpoint.Sign(n.privateKey); err != nil {
+		return fmt.Errorf("failed to sign checkpoint: %v", err)
+	}
+	n.addOwnCheckpointSignature(checkpoint)
+
+	// 与接收checkpoint的节点一致：出块者候选改用新快照
+	if len(checkpoint.Validators) > 0 {
+		n.consensus.ValidatorSet().LoadFromCheckpoint(checkpoint.Validators)
+	}
+
+	// 保存checkpoint文件
+	if err := n.saveCheckpoint(checkpoint); err != nil {
+		return fmt.Errorf("failed to save checkpoint: %v", err)
+	}
+
+	// 创建状态快照
+	snapshot, err := n.state.CreateCheckpointSnapshot(height)
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot: %v", err)
+	}
+
+	// 保存状态快照（按高度保留最新的CheckpointKeepCount个）
+	compressedData, err := snapshot.Compress()
+	if err != nil {
+		return fmt.Errorf("failed to compress snapshot: %v", err)
+	}
+	if err := n.saveStateSnapshot(height, compressedData); err != nil {
+		return fmt.Errorf("failed to save snapshot: %v", err)
+	}
+
+	log.Printf("✅ Checkpoint created at height %d, StateRoot: %s", height, stateRoot.String()[:16])
+
+	// 广播checkpoint和状态快照给所有peers（让History等节点直接接收）
+	if n.p2pServer != nil {
+		n.p2pServer.BroadcastCheckpoint(checkpoint, compressedData)
+	}
+
+	return nil
+}
+
+// selectCheckpointValidators 【竞争性激活】从当前状态选出checkpoint的验证者快照
+// 生成checkpoint和验证收到的checkpoint使用同一逻辑，保证结果一致
+func (n *Node) selectCheckpointValidators() ([]core.ValidatorSnapshot, error) {
+	// 从所有质押账户中选择前N名作为活跃验证者
 	// 1. 获取所有满足最低质押要求的账户
 	consensusConfig := core.GetConsensusConfig()
 	minStake := consensusConfig.EconomicParams.ValidatorStakeRequired
@@ -368,7 +494,7 @@ func (n *Node) generateCheckpoint(height uint64, block *core.Block) error {
 	// 【重要】使用合并后的账户列表（数据库+缓存），确保不遗漏任何账户
 	allAccounts, err := n.state.GetAllAccountsMerged()
 	if err != nil {
-		return fmt.Errorf("failed to get all accounts: %v", err)
+		return nil, fmt.Errorf("failed to get all accounts: %v", err)
 	}
 
 	// 2. 筛选出所有质押账户
@@ -380,20 +506,21 @@ func (n *Node) generateCheckpoint(height uint64, block *core.Block) error {
 	candidates := make([]candidateValidator, 0)
 
 	for _, acc := range allAccounts {
-		if acc.StakedBalance >= minStake {
-			// 【注意】Account结构中没有VRFPublicKey字段
-			// VRF公钥在实际使用中从节点公钥获取，这里设为空
+		if acc.StakedBalance >= minStake && !acc.Jailed {
 			candidates = append(candidates, candidateValidator{
 				address:       acc.Address,
 				stakedBalance: acc.StakedBalance,
-				vrfPublicKey:  []byte{}, // 设为空字节数组
+				vrfPublicKey:  acc.VRFPublicKey, // 链上登记的ECVRF公钥（未登记为空）
 			})
 		}
 	}
 
 	// 3. 按质押量排序（降序）
 	sort.Slice(candidates, func(i, j int) bool {
-		return candidates[i].stakedBalance > candidates[j].stakedBalance
+		if candidates[i].stakedBalance != candidates[j].stakedBalance {
+			return candidates[i].stakedBalance > candidates[j].stakedBalance
+		}
+		return candidates[i].address < candidates[j].address // 质押相同按地址排序，各节点结果一致
 	})
 
 	// 4. 取前N名作为活跃验证者
@@ -406,21 +533,16 @@ func (n *Node) generateCheckpoint(height uint64, block *core.Block) error {
 	}
 
 	// 5. 创建验证者快照
+	validators := make([]core.ValidatorSnapshot, 0, activeCount)
 	for i := 0; i < activeCount; i++ {
 		candidate := candidates[i]
 
-		// 提取VRF公钥的前32字节作为精简版本
-		vrfKey := candidate.vrfPublicKey
-		if len(vrfKey) > 32 {
-			vrfKey = vrfKey[:32]
-		}
-
 		snapshot := core.ValidatorSnapshot{
 			Address:   candidate.address,
 			Stake:     candidate.stakedBalance,
-			VRFPubKey: vrfKey,
+			VRFPubKey: candidate.vrfPublicKey,
 		}
-		checkpoint.Validators = append(checkpoint.Validators, snapshot)
+		validators = append(validators, snapshot)
 
 		log.Printf("  ✓ Validator[%d]: %s (stake: %d FAN)",
 			i+1, candidate.address[:10], candidate.stakedBalance/1000000)
@@ -435,41 +557,7 @@ func (n *Node) generateCheckpoint(height uint64, block *core.Block) error {
 		}
 	}
 
-	// 签名checkpoint
-	if err := checkpoint.Sign(n.privateKey); err != nil {
-		return fmt.Errorf("failed to sign checkpoint: %v", err)
-	}
-
-	// 保存checkpoint文件
-	if err := n.db.SaveCheckpoint(checkpoint, n.config.DataDir); err != nil {
-		return fmt.Errorf("failed to save checkpoint: %v", err)
-	}
-
-	// 创建状态快照
-	snapshot, err := n.state.CreateCheckpointSnapshot(height)
-	if err != nil {
-		return fmt.Errorf("failed to create snapshot: %v", err)
-	}
-
-	// 保存状态快照（使用单点管理）
-	compressedData, err := snapshot.Compress()
-	if err != nil {
-		return fmt.Errorf("failed to compress snapshot: %v", err)
-	}
-	if err := n.db.SaveStateSnapshot(height, compressedData, n.config.DataDir); err != nil {
-		return fmt.Errorf("failed to save snapshot: %v", err)
-	}
-
-	// 单点checkpoint设计：不需要清理，SaveCheckpoint已经强制删除旧文件
-
-	log.Printf("✅ Checkpoint created at height %d, StateRoot: %s", height, stateRoot.String()[:16])
-
-	// 广播checkpoint和状态快照给所有peers（让History等节点直接接收）
-	if n.p2pServer != nil {
-		n.p2pServer.BroadcastCheckpoint(checkpoint, compressedData)
-	}
-
-	return nil
+	return validators, nil
 }
 
 // tryAddBlockData 尝试向区块添加Data字段（机场链接等）
@@ -557,7 +645,7 @@ func (n *Node) verifyTotalSupply() error {
 	// 验证总量是否正确
 	if !isCorrect {
 		return fmt.Errorf("total supply mismatch: expected %d, got %d (diff: %d)",
-			TOTAL_SUPPLY, totalSupply, int64(TOTAL_SUPPLY) - int64(totalSupply))
+			TOTAL_SUPPLY, totalSupply, int64(TOTAL_SUPPLY)-int64(totalSupply))
 	}
 
 	return nil
@@ -570,7 +658,7 @@ func (n *Node) findLastValidBlock() (uint64, error) {
 	// 从当前高度向前回溯，最多回溯100个区块
 	for height := currentHeight; height > 0 && currentHeight-height < 100; height-- {
 		// 恢复到该高度的状态
-		if err := n.state.ReloadStateFromHeight(n.db, height); err != nil {
+		if err := n.reloadStateAt(height); err != nil {
 			continue
 		}
 
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+
+	"fan-chain/core"
+)
+
+// checkpoint到达前最多暂存多少个checkpoint的签名
+const maxPendingCheckpointSigs = 16
+
+// trustedCheckpointValidators 验证checkpoint多签时信任的验证者集合
+// 使用本节点当前的验证者集合（来自创世状态或之前已应用的checkpoint），而不是待验证checkpoint自带的快照；
+// 新节点还没有验证者集合时使用配置的trusted_validators，都没有时返回空集合，多签验证一律失败
+func (n *Node) trustedCheckpointValidators(cp *core.Checkpoint) []core.ValidatorSnapshot {
+	active := n.consensus.ValidatorSet().GetActiveValidators()
+	if len(active) == 0 {
+		validators := make([]core.ValidatorSnapshot, 0, len(n.config.TrustedValidators))
+		for _, v := range n.config.TrustedValidators {
+			validators = append(validators, core.ValidatorSnapshot{
+				Address: v.Address,
+				Stake:   v.Stake,
+			})
+		}
+		return validators
+	}
+
+	validators := make([]core.ValidatorSnapshot, 0, len(active))
+	for _, v := range active {
+		validators = append(validators, core.ValidatorSnapshot{
+			Address:   v.Address,
+			Stake:     v.StakedAmount,
+			VRFPubKey: v.VRFPublicKey,
+		})
+	}
+	return validators
+}
+
+// isCheckpointSigner 签名者是否属于可信验证者集合（其他地址的签名不计入也不保存）
+func (n *Node) isCheckpointSigner(cp *core.Checkpoint, address string) bool {
+	for _, v := range n.trustedCheckpointValidators(cp) {
+		if v.Address == address {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyCheckpointLocally 本节点已执行到checkpoint高度时独立验证：
+// 区块哈希、状态根、验证者快照都与本地计算结果一致才算通过
+func (n *Node) verifyCheckpointLocally(cp *core.Checkpoint) bool {
+	latest := n.chain.GetLatestBlock()
+	if latest == nil || latest.Header.Height != cp.Height || latest.Hash() != cp.BlockHash {
+		return false
+	}
+
+	stateRoot, err := n.state.CalculateStateRoot(cp.Height)
+	if err != nil || stateRoot != cp.StateRoot {
+		return false
+	}
+
+	validators, err := n.selectCheckpointValidators()
+	if err != nil || !sameValidatorSnapshots(validators, cp.Validators) {
+		return false
+	}
+	return true
+}
+
+func sameValidatorSnapshots(a, b []core.ValidatorSnapshot) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Address != b[i].Address || a[i].Stake != b[i].Stake ||
+			!bytes.Equal(a[i].VRFPubKey, b[i].VRFPubKey) {
+			return false
+		}
+	}
+	return true
+}
+
+// addOwnCheckpointSignature 本节点是活跃验证者时签名checkpoint，返回新签名（未签名返回nil）
+func (n *Node) addOwnCheckpointSignature(cp *core.Checkpoint) *core.CheckpointSignature {
+	if !n.isActiveValidator(n.address) || cp.HasSignature(n.address) {
+		return nil
+	}
+
+	sig, err := core.NewCheckpointSignature(cp, n.address, n.privateKey, n.publicKey)
+	if err != nil {
+		log.Printf("⚠️ Failed to sign checkpoint #%d: %v", cp.Height, err)
+		return nil
+	}
+	if _, err := cp.AddSignature(sig); err != nil {
+		log.Printf("⚠️ Failed to add own checkpoint signature: %v", err)
+		return nil
+	}
+	return sig
+}
+
+// saveCheckpoint 保存checkpoint：先合并在checkpoint之前到达的签名，再检查是否已最终确认
+func (n *Node) saveCheckpoint(cp *core.Checkpoint) error {
+	n.checkpointSigMu.Lock()
+	defer n.checkpointSigMu.Unlock()
+
+	cpHash := cp.Hash()
+	for _, sig := range n.pendingCheckpointSigs[cpHash] {
+		if n.isCheckpointSigner(cp, sig.Validator) {
+			cp.AddSignature(sig)
+		}
+	}
+	delete(n.pendingCheckpointSigs, cpHash)
+
+	if err := n.db.SaveCheckpoint(cp, n.config.DataDir); err != nil {
+		return err
+	}
+	n.checkFinalityLocked(cp)
+	return nil
+}
+
+// HandleCheckpointSignature 处理收到的验证者签名：加入本地同一checkpoint并检查最终确认
+// checkpoint还没到达时先暂存，checkpoint保存时合并
+func (n *Node) HandleCheckpointSignature(height uint64, cpHash core.Hash, sig *core.CheckpointSignature) error {
+	if err := sig.Verify(cpHash); err != nil {
+		return err
+	}
+
+	n.checkpointSigMu.Lock()
+	defer n.checkpointSigMu.Unlock()
+
+	cp, err := n.db.GetLatestCheckpoint(n.config.DataDir)
+	if err != nil {
+		return err
+	}
+	if cp == nil || height > cp.Height {
+		n.bufferCheckpointSignatureLocked(cpHash, sig)
+		return nil
+	}
+	if height < cp.Height {
+		return fmt.Errorf("stale signature (local checkpoint is #%d)", cp.Height)
+	}
+	if cp.Hash() != cpHash {
+		return fmt.Errorf("signature for a different checkpoint at height %d", height)
+	}
+	if !n.isCheckpointSigner(cp, sig.Validator) {
+		return fmt.Errorf("%s is not a validator", sig.Validator)
+	}
+
+	added, err := cp.AddSignature(sig)
+	if err != nil || !added {
+		return err
+	}
+	if err := n.db.SaveCheckpoint(cp, n.config.DataDir); err != nil {
+		return fmt.Errorf("failed to save checkpoint: %v", err)
+	}
+	n.checkFinalityLocked(cp)
+	return nil
+}
+
+// bufferCheckpointSignatureLocked 暂存checkpoint到达前收到的签名（数量有上限，超出时清空重来）
+func (n *Node) bufferCheckpointSignatureLocked(cpHash core.Hash, sig *core.CheckpointSignature) {
+	if _, ok := n.pendingCheckpointSigs[cpHash]; !ok && len(n.pendingCheckpointSigs) >= maxPendingCheckpointSigs {
+		n.pendingCheckpointSigs = make(map[core.Hash][]*core.CheckpointSignature)
+	}
+
+	pending := n.pendingCheckpointSigs[cpHash]
+	if len(pending) >= core.MaxValidators() {
+		return
+	}
+	for _, existing := range pending {
+		if existing.Validator == sig.Validator {
+			return
+		}
+	}
+	n.pendingCheckpointSigs[cpHash] = append(pending, sig)
+}
+
+// checkFinalityLocked 超过1/2质押签名后记录checkpoint最终确认
+func (n *Node) checkFinalityLocked(cp *core.Checkpoint) {
+	if cp.Height <= n.finalizedCheckpoint {
+		return
+	}
+
+	validators := n.trustedCheckpointValidators(cp)
+	if cp.VerifyQuorum(validators) != nil {
+		return
+	}
+
+	signed, total := cp.SignedStake(validators)
+	n.finalizedCheckpoint = cp.Height
+	log.Printf("🔏 Checkpoint #%d finalized: %d/%d FAN stake signed (%d signatures)",
+		cp.Height, signed/core.FANUnit(), total/core.FANUnit(), len(cp.Signatures))
+}
+
+// mergeCheckpointSignatures 合并收到的同一checkpoint上携带的签名
+func (n *Node) mergeCheckpointSignatures(cp *core.Checkpoint) {
+	cpHash := cp.Hash()
+	for i := range cp.Signatures {
+		n.HandleCheckpointSignature(cp.Height, cpHash, &cp.Signatures[i])
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 )
@@ -25,10 +26,29 @@ type Config struct {
 	PublicIP  string   `json:"public_ip"`  // 公网IP（用于NAT环境下跳过自己）
 	SeedPeers []string `json:"seed_peers"`
 
+	PeerBanMinutes      int  `json:"peer_ban_minutes"`      // 不良节点封禁时长（分钟，0=默认24小时，本地策略不参与共识）
+	AllowPlaintextPeers bool `json:"allow_plaintext_peers"` // 过渡期允许不支持加密握手的旧版本节点明文连接（默认拒绝）
+
+	// 交易池
+	MempoolMaxSize int `json:"mempool_max_size"` // 交易池容量（交易数，0=默认10000，本地策略不参与共识）
+	TxRelay        bool `json:"tx_relay"`         // 非验证者节点也接收并转发交易（中继节点）
+
+	// 归档模式：按区块高度记录账户历史，API支持 ?height=H 查询历史余额（本地策略不参与共识）
+	Archive bool `json:"archive"`
+
+	// 新节点本地尚无验证者集合时，验证checkpoint多签所信任的验证者集合（取自可信来源，本地策略不参与共识）
+	TrustedValidators []TrustedValidator `json:"trusted_validators"`
+
 	// 注意：Checkpoint配置已移至consensus.json（共识参数）
 	// CheckpointInterval 和 CheckpointKeepCount 现在从 core.GetConsensusConfig() 获取
 }
 
+// TrustedValidator 可信验证者（地址和质押量，质押量为多签权重）
+type TrustedValidator struct {
+	Address string `json:"address"`
+	Stake   uint64 `json:"stake"`
+}
+
 // 默认配置
 func DefaultConfig() *Config {
 	return &Config{
@@ -41,6 +61,7 @@ func DefaultConfig() *Config {
 		APIPort:        9000,
 		P2PHost:        "0.0.0.0",
 		SeedPeers:      []string{},
+		MempoolMaxSize: 10000,
 	}
 }
 
@@ -71,6 +92,11 @@ func (cfg *Config) ApplyEnv() {
 	if v := os.Getenv("FAN_P2P_HOST"); v != "" {
 		cfg.P2PHost = v
 	}
+	if v := os.Getenv("FAN_ARCHIVE"); v != "" {
+		if archive, err := strconv.ParseBool(v); err == nil {
+			cfg.Archive = archive
+		}
+	}
 	if v := os.Getenv("FAN_SEED_PEERS"); v != "" {
 		cfg.SeedPeers = strings.Split(v, ",")
 		for i := range cfg.SeedPeers {
@@ -111,6 +137,11 @@ func (cfg *Config) EnsureDirs() error {
 	return os.MkdirAll(cfg.DataDir, 0700)
 }
 
+// 交易池journal路径（崩溃恢复用）
+func (cfg *Config) MempoolJournalPath() string {
+	return filepath.Join(cfg.DataDir, "txpool.journal")
+}
+
 // 获取数据库路径（返回DataDir，OpenDatabase会自动添加blockchain.db子目录）
 func (cfg *Config) DBPath() string {
 	return cfg.DataDir
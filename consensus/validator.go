@@ -1,21 +1,27 @@
 package consensus
 
 import (
+	"bytes"
 	"fmt"
 	"log"
 	"sort"
 	"time"
 
 	"fan-chain/core"
+	"fan-chain/crypto"
 	"fan-chain/state"
 	"fan-chain/storage"
 	"golang.org/x/crypto/sha3"
 )
 
+// 备用出块者时间槽允许的本地时钟误差
+const slotClockTolerance = time.Second
+
 // 验证者集合
 type ValidatorSet struct {
 	validators       []*core.Validator
 	activeValidators []*core.Validator
+	snapshot         []core.ValidatorSnapshot // 最近应用的checkpoint验证者快照（出块者候选来源）
 	lastUpdate       time.Time
 }
 
@@ -42,6 +48,8 @@ func (vs *ValidatorSet) LoadFromState(db *storage.Database) error {
 			validator := &core.Validator{
 				Address:       acc.Address,
 				StakedAmount:  acc.StakedBalance,
+				VRFPublicKey:  acc.VRFPublicKey,
+				MissedBlocks:  acc.MissedBlocks,
 				Status:        core.ValActive,
 				LastBlockTime: time.Now().Unix(),
 				LastHeartbeat: time.Now().Unix(),
@@ -57,6 +65,7 @@ func (vs *ValidatorSet) LoadFromState(db *storage.Database) error {
 func (vs *ValidatorSet) LoadFromCheckpoint(validators []core.ValidatorSnapshot) {
 	vs.validators = make([]*core.Validator, 0)
 	vs.activeValidators = make([]*core.Validator, 0)
+	vs.snapshot = append([]core.ValidatorSnapshot{}, validators...)
 
 	for _, snapshot := range validators {
 		validator := &core.Validator{
@@ -100,6 +109,11 @@ func (vs *ValidatorSet) UpdateActiveSet() {
 	vs.lastUpdate = time.Now()
 }
 
+// Snapshot 最近应用的checkpoint验证者快照
+func (vs *ValidatorSet) Snapshot() []core.ValidatorSnapshot {
+	return vs.snapshot
+}
+
 func (vs *ValidatorSet) GetActiveValidators() []*core.Validator {
 	return vs.activeValidators
 }
@@ -113,6 +127,16 @@ func (vs *ValidatorSet) IsActiveValidator(address string) bool {
 	return false
 }
 
+// GetActiveValidator 按地址查找活跃验证者
+func (vs *ValidatorSet) GetActiveValidator(address string) *core.Validator {
+	for _, v := range vs.activeValidators {
+		if v.Address == address {
+			return v
+		}
+	}
+	return nil
+}
+
 func (vs *ValidatorSet) AddValidator(validator *core.Validator) {
 	// 检查是否已存在
 	for _, v := range vs.validators {
@@ -145,12 +169,12 @@ func (vs *ValidatorSet) RemoveValidator(address string) {
 // 共识引擎
 // 共识引擎
 type ConsensusEngine struct {
-	validatorSet     *ValidatorSet
-	stateManager     *state.StateManager
-	nodeAddress      string
-	nodePrivateKey   []byte
-	nodePublicKey    []byte
-	getOnlinePeersFn func() []string
+	validatorSet   *ValidatorSet
+	stateManager   *state.StateManager
+	nodeAddress    string
+	nodePrivateKey []byte
+	nodePublicKey  []byte
+	vrfKey         *crypto.VRFPrivateKey
 }
 
 func NewConsensusEngine(sm *state.StateManager) *ConsensusEngine {
@@ -168,29 +192,63 @@ func (ce *ConsensusEngine) SetNodeKeys(address string, privateKey, publicKey []b
 	ce.nodeAddress = address
 	ce.nodePrivateKey = privateKey
 	ce.nodePublicKey = publicKey
+
+	// VRF密钥由节点私钥确定性派生，重启后公钥不变
+	vrfKey, err := crypto.DeriveVRFKey(privateKey)
+	if err != nil {
+		log.Printf("⚠️ Failed to derive VRF key: %v", err)
+		return
+	}
+	ce.vrfKey = vrfKey
 }
 
-func (ce *ConsensusEngine) SetOnlinePeersFunction(fn func() []string) {
-	ce.getOnlinePeersFn = fn
+// VRFPublicKey 本节点的ECVRF公钥（需通过质押交易登记到链上）
+func (ce *ConsensusEngine) VRFPublicKey() []byte {
+	if ce.vrfKey == nil {
+		return nil
+	}
+	return ce.vrfKey.PublicKey
+}
+
+// VRFInput 计算height区块的VRF输入：前一块的VRF输出 || 高度
+// 前一块VRF输出唯一且无法被出块者操纵（创世块等无输出时退回区块哈希）
+func VRFInput(height uint64, prevBlock *core.Block) []byte {
+	input := prevBlock.Header.VRFOutput
+	if len(input) == 0 {
+		input = prevBlock.Hash().Bytes()
+	}
+	return append(append([]byte{}, input...), core.Uint64ToBytes(height)...)
+}
+
+// ProveVRF 为height区块计算本节点的VRF证明和输出
+func (ce *ConsensusEngine) ProveVRF(height uint64, prevBlock *core.Block) (proof, output []byte, err error) {
+	if ce.vrfKey == nil {
+		return nil, nil, fmt.Errorf("VRF key not initialized")
+	}
+	return ce.vrfKey.Prove(VRFInput(height, prevBlock))
+}
+
+// proposerSeed 出块者选择种子
+// ECVRF激活后使用前一块的VRF输出（不受交易排序等可操纵字段影响），之前沿用区块哈希
+func proposerSeed(height uint64, prevBlock *core.Block) []byte {
+	if core.VRFActive(height) && len(prevBlock.Header.VRFOutput) > 0 {
+		return prevBlock.Header.VRFOutput
+	}
+	return prevBlock.Hash().Bytes()
 }
 
 // SelectProposer 【P2协议】VRF预计算选择出块者
 // 核心原则：
 // 1. VRF出块顺序在Checkpoint前一块（Block N-1）预计算
-// 2. 种子基于Checkpoint区块哈希，整个周期内顺序固定
+// 2. 种子基于前一块（ECVRF激活后为其VRF输出），所有节点计算结果一致
 // 3. 等概率轮询，不看质押量
-func (ce *ConsensusEngine) SelectProposer(height uint64, prevBlockHash core.Hash) (string, error) {
-	activeVals := ce.validatorSet.GetActiveValidators()
-	if len(activeVals) == 0 {
-		return "", fmt.Errorf("no active validators")
+// 4. 候选集合只来自链上数据（checkpoint验证者快照 + 链上监禁记录），见EligibleProposers
+func (ce *ConsensusEngine) SelectProposer(height uint64, prevBlock *core.Block) (string, error) {
+	sortedValidators, err := ce.eligibleProposers()
+	if err != nil {
+		return "", err
 	}
 
-	sortedValidators := make([]*core.Validator, len(activeVals))
-	copy(sortedValidators, activeVals)
-	sort.Slice(sortedValidators, func(i, j int) bool {
-		return sortedValidators[i].Address < sortedValidators[j].Address
-	})
-
 	// 【P2协议】计算当前区块所属的Checkpoint周期
 	checkpointInterval := core.GetConsensusConfig().BlockParams.CheckpointInterval
 	if checkpointInterval == 0 {
@@ -209,7 +267,7 @@ func (ce *ConsensusEngine) SelectProposer(height uint64, prevBlockHash core.Hash
 	cycleStart := height - cycleOffset
 
 	// 【P2协议核心】种子 = 前一个Checkpoint哈希 + 周期起始高度
-	seed := append(prevBlockHash.Bytes(), core.Uint64ToBytes(cycleStart)...)
+	seed := append(append([]byte{}, proposerSeed(height, prevBlock)...), core.Uint64ToBytes(cycleStart)...)
 
 	// 为周期内每个位置生成确定性的proposer
 	positionSeed := append(seed, core.Uint64ToBytes(cycleOffset)...)
@@ -226,19 +284,164 @@ func (ce *ConsensusEngine) SelectProposer(height uint64, prevBlockHash core.Hash
 	return sortedValidators[selectedIndex].Address, nil
 }
 
-// GetCycleProposers 【P2协议】获取整个Checkpoint周期的出块顺序
-func (ce *ConsensusEngine) GetCycleProposers(cycleStartHeight uint64, seedHash core.Hash) ([]string, error) {
-	activeVals := ce.validatorSet.GetActiveValidators()
-	if len(activeVals) == 0 {
+// EligibleProposers 出块者候选集合：checkpoint验证者快照中链上仍是验证者（质押足额且未被监禁）的账户，按地址排序
+// 只依赖链上数据，与本节点连接了哪些peer无关，同一链状态下所有节点得到相同结果；
+// 快照中的验证者全部被监禁时退回整个快照，避免出块停止
+func EligibleProposers(snapshot []core.ValidatorSnapshot, getAccount func(address string) (*core.Account, error)) []*core.Validator {
+	eligible := make([]*core.Validator, 0, len(snapshot))
+	for _, s := range snapshot {
+		acc, err := getAccount(s.Address)
+		if err != nil || acc == nil || !acc.IsValidator() {
+			continue
+		}
+		eligible = append(eligible, snapshotValidator(s))
+	}
+
+	if len(eligible) == 0 {
+		for _, s := range snapshot {
+			eligible = append(eligible, snapshotValidator(s))
+		}
+	}
+
+	sort.Slice(eligible, func(i, j int) bool {
+		return eligible[i].Address < eligible[j].Address
+	})
+	return eligible
+}
+
+func snapshotValidator(s core.ValidatorSnapshot) *core.Validator {
+	return &core.Validator{
+		Address:      s.Address,
+		StakedAmount: s.Stake,
+		VRFPublicKey: s.VRFPubKey,
+		Status:       core.ValActive,
+	}
+}
+
+// eligibleProposers 当前链状态下的出块者候选（按地址排序）
+// 还没有应用过checkpoint时（创世启动）退回活跃验证者集合
+func (ce *ConsensusEngine) eligibleProposers() ([]*core.Validator, error) {
+	var validators []*core.Validator
+	if snapshot := ce.validatorSet.Snapshot(); len(snapshot) > 0 && ce.stateManager != nil {
+		validators = EligibleProposers(snapshot, ce.stateManager.GetAccount)
+	} else {
+		validators = make([]*core.Validator, len(ce.validatorSet.GetActiveValidators()))
+		copy(validators, ce.validatorSet.GetActiveValidators())
+		sort.Slice(validators, func(i, j int) bool {
+			return validators[i].Address < validators[j].Address
+		})
+	}
+
+	if len(validators) == 0 {
 		return nil, fmt.Errorf("no active validators")
 	}
+	return validators, nil
+}
 
-	sortedValidators := make([]*core.Validator, len(activeVals))
-	copy(sortedValidators, activeVals)
-	sort.Slice(sortedValidators, func(i, j int) bool {
-		return sortedValidators[i].Address < sortedValidators[j].Address
+// RankProposers 【有序故障转移】height区块的出块者排名
+// 第0名是SelectProposer选中的首选出块者，其余候选按 sha3(种子||高度||地址) 排序依次作为备用出块者，
+// 排名k的出块者在ProposerSlotStart(prevBlock, k)之后才能出块，故障转移按时间表进行而不是抢跑
+func (ce *ConsensusEngine) RankProposers(height uint64, prevBlock *core.Block) ([]string, error) {
+	primary, err := ce.SelectProposer(height, prevBlock)
+	if err != nil {
+		return nil, err
+	}
+	candidates, err := ce.eligibleProposers()
+	if err != nil {
+		return nil, err
+	}
+
+	seed := append(append([]byte{}, proposerSeed(height, prevBlock)...), core.Uint64ToBytes(height)...)
+	type rankedCandidate struct {
+		address string
+		key     [32]byte
+	}
+	backups := make([]rankedCandidate, 0, len(candidates))
+	for _, v := range candidates {
+		if v.Address == primary {
+			continue
+		}
+		backups = append(backups, rankedCandidate{
+			address: v.Address,
+			key:     sha3.Sum256(append(append([]byte{}, seed...), []byte(v.Address)...)),
+		})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return bytes.Compare(backups[i].key[:], backups[j].key[:]) < 0
 	})
 
+	ranked := make([]string, 0, len(candidates))
+	ranked = append(ranked, primary)
+	for _, b := range backups {
+		ranked = append(ranked, b.address)
+	}
+	return ranked, nil
+}
+
+// ProposerRank 出块者在height区块排名中的名次，不是候选时返回-1
+func (ce *ConsensusEngine) ProposerRank(height uint64, prevBlock *core.Block, address string) (int, error) {
+	ranked, err := ce.RankProposers(height, prevBlock)
+	if err != nil {
+		return -1, err
+	}
+	return proposerIndex(ranked, address), nil
+}
+
+// ProposerSlotStart 排名rank的出块者最早可出块的时间（毫秒）
+// 首选出块者在前一块之后一个出块间隔，每个备用名次再顺延一个出块间隔
+func ProposerSlotStart(prevBlock *core.Block, rank int) int64 {
+	intervalMs := int64(core.BlockInterval()) * 1000
+	return prevBlock.Header.Timestamp + intervalMs*int64(rank+1)
+}
+
+// checkProposerSlot 排名rank的出块者的时间槽必须已开启：区块时间戳和本地时钟都不能早于时间槽
+func checkProposerSlot(block, prevBlock *core.Block, rank int) error {
+	if rank < 0 {
+		return fmt.Errorf("block #%d: %s is not a ranked proposer", block.Header.Height, block.Header.Proposer)
+	}
+
+	slotStart := ProposerSlotStart(prevBlock, rank)
+	if block.Header.Timestamp < slotStart {
+		return fmt.Errorf("block #%d: proposer rank %d produced at %d before its slot %d",
+			block.Header.Height, rank, block.Header.Timestamp, slotStart)
+	}
+	if time.Now().UnixMilli()+slotClockTolerance.Milliseconds() < slotStart {
+		return fmt.Errorf("block #%d: slot of proposer rank %d has not opened yet", block.Header.Height, rank)
+	}
+	return nil
+}
+
+func proposerIndex(ranked []string, address string) int {
+	for i, addr := range ranked {
+		if addr == address {
+			return i
+		}
+	}
+	return -1
+}
+
+// ScheduledRank 区块出块者在排名中的名次，不是候选或时间槽未开启时返回-1
+// 备用出块者在其时间槽开启后出块同样合法，名次越小优先级越高（用于接收区块和同高度分叉选择）
+func (ce *ConsensusEngine) ScheduledRank(block, prevBlock *core.Block) int {
+	ranked, err := ce.RankProposers(block.Header.Height, prevBlock)
+	if err != nil {
+		return -1
+	}
+
+	rank := proposerIndex(ranked, block.Header.Proposer)
+	if checkProposerSlot(block, prevBlock, rank) != nil {
+		return -1
+	}
+	return rank
+}
+
+// GetCycleProposers 【P2协议】获取整个Checkpoint周期的出块顺序
+func (ce *ConsensusEngine) GetCycleProposers(cycleStartHeight uint64, seedHash core.Hash) ([]string, error) {
+	sortedValidators, err := ce.eligibleProposers()
+	if err != nil {
+		return nil, err
+	}
+
 	checkpointInterval := core.GetConsensusConfig().BlockParams.CheckpointInterval
 	if checkpointInterval == 0 {
 		checkpointInterval = 5
@@ -264,31 +467,61 @@ func (ce *ConsensusEngine) GetCycleProposers(cycleStartHeight uint64, seedHash c
 	return proposers, nil
 }
 
-func (ce *ConsensusEngine) filterOnlineValidators(validators []*core.Validator) []*core.Validator {
-	if ce.getOnlinePeersFn == nil {
-		return validators
+// VerifyProposer 验证提案者是否为活跃验证者
+// 排名k的出块者只能在其时间槽开启后出块（区块时间戳和本地时钟都不能早于时间槽），与ScheduledRank规则一致，不依赖ECVRF激活；
+// ECVRF激活后还要用链上登记的公钥验证区块的VRFProof/VRFOutput，
+// 并检查MissedProposer：接管出块时必须记录被选中的出块者，正常出块时必须为空（激活前始终为空）
+func (ce *ConsensusEngine) VerifyProposer(block *core.Block, prevBlock *core.Block) error {
+	candidates, err := ce.eligibleProposers()
+	if err != nil {
+		return err
 	}
 
-	onlinePeers := ce.getOnlinePeersFn()
-	onlineMap := make(map[string]bool)
-	for _, addr := range onlinePeers {
-		onlineMap[addr] = true
+	var validator *core.Validator
+	for _, v := range candidates {
+		if v.Address == block.Header.Proposer {
+			validator = v
+			break
+		}
+	}
+	if validator == nil {
+		return fmt.Errorf("proposer %s is not an active validator", block.Header.Proposer)
+	}
+
+	ranked, err := ce.RankProposers(block.Header.Height, prevBlock)
+	if err != nil {
+		return err
+	}
+	if err := checkProposerSlot(block, prevBlock, proposerIndex(ranked, block.Header.Proposer)); err != nil {
+		return err
 	}
 
-	online := make([]*core.Validator, 0)
-	for _, v := range validators {
-		if v.Address == ce.nodeAddress || onlineMap[v.Address] {
-			online = append(online, v)
+	if !core.VRFActive(block.Header.Height) {
+		if block.Header.MissedProposer != "" {
+			return fmt.Errorf("block #%d: missed proposer recorded before VRF activation", block.Header.Height)
 		}
+		return nil
 	}
 
-	return online
-}
+	if len(validator.VRFPublicKey) == 0 {
+		return fmt.Errorf("proposer %s has no registered VRF public key", block.Header.Proposer)
+	}
 
-// VerifyProposer 验证提案者是否为活跃验证者
-func (ce *ConsensusEngine) VerifyProposer(block *core.Block, prevBlockHash core.Hash) error {
-	if !ce.validatorSet.IsActiveValidator(block.Header.Proposer) {
-		return fmt.Errorf("proposer %s is not an active validator", block.Header.Proposer)
+	output, err := crypto.VerifyVRFProof(validator.VRFPublicKey, VRFInput(block.Header.Height, prevBlock), block.Header.VRFProof)
+	if err != nil {
+		return fmt.Errorf("block #%d: %v", block.Header.Height, err)
+	}
+	if !bytes.Equal(output, block.Header.VRFOutput) {
+		return fmt.Errorf("block #%d: VRF output does not match proof", block.Header.Height)
+	}
+
+	expected := ranked[0]
+	if expected == block.Header.Proposer {
+		if block.Header.MissedProposer != "" {
+			return fmt.Errorf("block #%d: selected proposer cannot record a missed proposer", block.Header.Height)
+		}
+	} else if block.Header.MissedProposer != expected {
+		return fmt.Errorf("block #%d: missed proposer %q, expected %s", block.Header.Height, block.Header.MissedProposer, expected)
 	}
 	return nil
 }
@@ -315,7 +548,3 @@ func (ce *ConsensusEngine) CreateRewardTransactions(proposer string, validators
 
 	return txs
 }
-
-func (ce *ConsensusEngine) CalculateStateRoot() core.Hash {
-	return core.Hash{}
-}
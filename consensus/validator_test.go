@@ -0,0 +1,204 @@
+package consensus
+
+import (
+	"fmt"
+	"testing"
+
+	"fan-chain/core"
+	"fan-chain/state"
+	"fan-chain/storage"
+)
+
+func testAddress(i int) string {
+	return fmt.Sprintf("F%036d", i)
+}
+
+// newTestEngine 使用独立数据库的共识引擎，模拟网络中的一个节点
+func newTestEngine(t *testing.T) (*ConsensusEngine, *state.StateManager) {
+	db, err := storage.OpenDatabase(t.TempDir())
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	sm := state.NewStateManager(db)
+	return NewConsensusEngine(sm), sm
+}
+
+// applyHistory 所有节点执行相同的链上历史：验证者账户 + 被监禁的验证者 + checkpoint快照
+func applyHistory(sm *state.StateManager, ce *ConsensusEngine, count int, jailed map[int]bool) {
+	snapshot := make([]core.ValidatorSnapshot, 0, count)
+	for i := 0; i < count; i++ {
+		acc := core.NewAccount(testAddress(i))
+		acc.NodeType = core.NodeValidator
+		acc.StakedBalance = core.ValidatorStakeRequired() + uint64(i)
+		acc.Jailed = jailed[i]
+		sm.UpdateAccount(acc)
+
+		snapshot = append(snapshot, core.ValidatorSnapshot{Address: acc.Address, Stake: acc.StakedBalance})
+	}
+	ce.ValidatorSet().LoadFromCheckpoint(snapshot)
+}
+
+func testPrevBlock(height uint64) *core.Block {
+	block := core.NewBlock(height-1, core.Hash{byte(height)}, testAddress(0), nil)
+	block.Header.Timestamp = 1700000000000 + int64(height)*5000
+	return block
+}
+
+// 同一链状态下，不同节点本地视图（连接的peer、回调顺序）不同也必须选出相同的出块者
+func TestSelectProposerDeterministicAcrossNodes(t *testing.T) {
+	const validatorCount = 7
+	jailed := map[int]bool{2: true, 5: true}
+
+	engines := make([]*ConsensusEngine, 4)
+	for i := range engines {
+		ce, sm := newTestEngine(t)
+		applyHistory(sm, ce, validatorCount, jailed)
+		engines[i] = ce
+	}
+
+	// 各节点本地集合出现不同的偏差：不应影响出块者选择
+	engines[1].ValidatorSet().RemoveValidator(testAddress(0))
+	engines[2].ValidatorSet().AddValidator(&core.Validator{
+		Address:      testAddress(99),
+		StakedAmount: core.ValidatorStakeRequired(),
+		Status:       core.ValActive,
+	})
+	engines[3].ValidatorSet().RemoveValidator(testAddress(3))
+	engines[3].ValidatorSet().RemoveValidator(testAddress(6))
+
+	for height := uint64(1); height <= 200; height++ {
+		prev := testPrevBlock(height)
+
+		expected, err := engines[0].SelectProposer(height, prev)
+		if err != nil {
+			t.Fatalf("height %d: %v", height, err)
+		}
+		if expected == testAddress(2) || expected == testAddress(5) || expected == testAddress(99) {
+			t.Fatalf("height %d: ineligible proposer %s selected", height, expected)
+		}
+
+		for i, ce := range engines[1:] {
+			got, err := ce.SelectProposer(height, prev)
+			if err != nil {
+				t.Fatalf("engine %d height %d: %v", i+1, height, err)
+			}
+			if got != expected {
+				t.Fatalf("engine %d height %d: proposer %s, engine 0 selected %s", i+1, height, got, expected)
+			}
+		}
+	}
+}
+
+// 监禁记录上链后所有节点同时把该验证者移出候选
+func TestEligibleProposersFollowOnChainLiveness(t *testing.T) {
+	ce, sm := newTestEngine(t)
+	applyHistory(sm, ce, 4, nil)
+
+	eligible, err := ce.eligibleProposers()
+	if err != nil {
+		t.Fatalf("eligible proposers: %v", err)
+	}
+	if len(eligible) != 4 {
+		t.Fatalf("eligible = %d, want 4", len(eligible))
+	}
+
+	acc, err := sm.GetAccount(testAddress(1))
+	if err != nil {
+		t.Fatalf("get account: %v", err)
+	}
+	acc.Jailed = true
+	sm.UpdateAccount(acc)
+
+	eligible, err = ce.eligibleProposers()
+	if err != nil {
+		t.Fatalf("eligible proposers: %v", err)
+	}
+	if len(eligible) != 3 {
+		t.Fatalf("eligible = %d after jailing, want 3", len(eligible))
+	}
+	for i, v := range eligible {
+		if v.Address == testAddress(1) {
+			t.Fatalf("jailed validator still eligible")
+		}
+		if i > 0 && eligible[i-1].Address >= v.Address {
+			t.Fatalf("eligible proposers not sorted by address")
+		}
+	}
+}
+
+// 出块者排名：首选与SelectProposer一致，每个候选恰好出现一次，各节点排名相同；备用出块者时间槽未开启前不合法
+func TestRankProposersSchedule(t *testing.T) {
+	engineA, smA := newTestEngine(t)
+	applyHistory(smA, engineA, 5, map[int]bool{4: true})
+	engineB, smB := newTestEngine(t)
+	applyHistory(smB, engineB, 5, map[int]bool{4: true})
+
+	prev := testPrevBlock(10)
+	ranked, err := engineA.RankProposers(10, prev)
+	if err != nil {
+		t.Fatalf("rank proposers: %v", err)
+	}
+	primary, _ := engineA.SelectProposer(10, prev)
+	if len(ranked) != 4 || ranked[0] != primary {
+		t.Fatalf("ranked = %v, want 4 candidates led by %s", ranked, primary)
+	}
+	seen := make(map[string]bool)
+	for _, addr := range ranked {
+		if seen[addr] || addr == testAddress(4) {
+			t.Fatalf("invalid ranking %v", ranked)
+		}
+		seen[addr] = true
+	}
+
+	rankedB, _ := engineB.RankProposers(10, prev)
+	for i := range ranked {
+		if ranked[i] != rankedB[i] {
+			t.Fatalf("rankings differ across nodes: %v vs %v", ranked, rankedB)
+		}
+	}
+
+	block := core.NewBlock(10, prev.Hash(), ranked[2], nil)
+	block.Header.Timestamp = ProposerSlotStart(prev, 1)
+	if rank := engineA.ScheduledRank(block, prev); rank != -1 {
+		t.Fatalf("backup #2 accepted in slot #1 (rank %d)", rank)
+	}
+	block.Header.Timestamp = ProposerSlotStart(prev, 2)
+	if rank := engineA.ScheduledRank(block, prev); rank != 2 {
+		t.Fatalf("backup #2 rank = %d in its own slot, want 2", rank)
+	}
+}
+
+// ECVRF激活前VerifyProposer同样执行名次和时间槽规则，与ScheduledRank一致
+func TestVerifyProposerSlotWithoutVRF(t *testing.T) {
+	ce, sm := newTestEngine(t)
+	applyHistory(sm, ce, 4, nil)
+
+	prev := testPrevBlock(10)
+	if core.VRFActive(10) {
+		t.Skip("VRF active at test height")
+	}
+	ranked, err := ce.RankProposers(10, prev)
+	if err != nil {
+		t.Fatalf("rank proposers: %v", err)
+	}
+
+	block := core.NewBlock(10, prev.Hash(), ranked[1], nil)
+	block.Header.Timestamp = ProposerSlotStart(prev, 0)
+	if err := ce.VerifyProposer(block, prev); err == nil {
+		t.Fatalf("backup accepted before its slot")
+	}
+	block.Header.Timestamp = ProposerSlotStart(prev, 1)
+	if err := ce.VerifyProposer(block, prev); err != nil {
+		t.Fatalf("backup in its slot rejected: %v", err)
+	}
+	if ce.ScheduledRank(block, prev) != 1 {
+		t.Fatalf("ScheduledRank disagrees with VerifyProposer")
+	}
+
+	block.Header.MissedProposer = ranked[0]
+	if err := ce.VerifyProposer(block, prev); err == nil {
+		t.Fatalf("missed proposer accepted before VRF activation")
+	}
+}
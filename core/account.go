@@ -38,7 +38,13 @@ type Account struct {
 	NodeStatus string   `json:"node_status,omitempty"`
 
 	// 验证者特有
-	StakeLockedUntil int64 `json:"stake_locked_until,omitempty"`
+	UnbondingBalance uint64 `json:"unbonding_balance,omitempty"`  // 解绑期中的质押（到期前仍可被罚没）
+	StakeLockedUntil uint64 `json:"stake_locked_until,omitempty"` // 解绑余额到期高度（到达此高度由状态机释放到可用余额）
+	VRFPublicKey     []byte `json:"vrf_public_key,omitempty"`     // ECVRF公钥（通过质押交易登记）
+	SlashedHeight    uint64 `json:"slashed_height,omitempty"`     // 最近一次双签罚没对应的高度（同一证据不重复罚没）
+	MissedBlocks     uint64 `json:"missed_blocks,omitempty"`      // 连续错过的出块轮次（出块后清零）
+	Jailed           bool   `json:"jailed,omitempty"`             // 连续错过OfflineSlashBlks轮后被监禁，需提交解除监禁交易
+	JailedUntil      uint64 `json:"jailed_until,omitempty"`       // 监禁到期高度（达到此高度前不能解除监禁）
 
 	// 未来扩展
 	CodeHash    Hash `json:"code_hash,omitempty"`
@@ -56,9 +62,9 @@ func NewAccount(address string) *Account {
 	}
 }
 
-// 总余额
+// 总余额（含解绑期中的质押）
 func (a *Account) TotalBalance() uint64 {
-	return a.AvailableBalance + a.StakedBalance
+	return a.AvailableBalance + a.StakedBalance + a.UnbondingBalance
 }
 
 // 增加余额
@@ -105,10 +111,70 @@ func (a *Account) Unstake(amount uint64) error {
 	return nil
 }
 
+// 开始解绑：质押转入解绑余额，到matureHeight后才能释放到可用余额
+// 解绑期内再次解押与已有解绑余额合并，到期高度顺延
+func (a *Account) BeginUnbonding(amount, matureHeight uint64) error {
+	if a.StakedBalance < amount {
+		return fmt.Errorf("insufficient staked balance")
+	}
+
+	a.StakedBalance -= amount
+	a.UnbondingBalance += amount
+	a.StakeLockedUntil = matureHeight
+
+	if a.StakedBalance == 0 {
+		a.NodeType = NodeRegular
+	}
+
+	return nil
+}
+
+// 释放到期的解绑余额，返回释放金额（未到期返回0）
+func (a *Account) ReleaseUnbonding(height uint64) uint64 {
+	if a.UnbondingBalance == 0 || height < a.StakeLockedUntil {
+		return 0
+	}
+
+	amount := a.UnbondingBalance
+	a.AvailableBalance += amount
+	a.UnbondingBalance = 0
+	a.StakeLockedUntil = 0
+	return amount
+}
+
+// 可罚没的质押（质押余额 + 解绑期中的余额）
+func (a *Account) SlashableStake() uint64 {
+	return a.StakedBalance + a.UnbondingBalance
+}
+
+// 罚没质押：先扣质押余额，不足部分从解绑余额扣除
+func (a *Account) SlashStake(amount uint64) error {
+	if a.SlashableStake() < amount {
+		return fmt.Errorf("insufficient slashable stake: have %d, want %d", a.SlashableStake(), amount)
+	}
+
+	fromStaked := amount
+	if fromStaked > a.StakedBalance {
+		fromStaked = a.StakedBalance
+	}
+	a.StakedBalance -= fromStaked
+	a.UnbondingBalance -= amount - fromStaked
+	if a.UnbondingBalance == 0 {
+		a.StakeLockedUntil = 0
+	}
+
+	if a.StakedBalance == 0 {
+		a.NodeType = NodeRegular
+	}
+
+	return nil
+}
+
 // 是否是验证者
 func (a *Account) IsValidator() bool {
 	return a.NodeType == NodeValidator &&
-		a.StakedBalance >= ValidatorStakeRequired()
+		a.StakedBalance >= ValidatorStakeRequired() &&
+		!a.Jailed
 }
 
 // JSON序列化
@@ -122,11 +188,12 @@ func (a *Account) FromJSON(data []byte) error {
 
 // 字符串表示
 func (a *Account) String() string {
-	return fmt.Sprintf("Account{%s: %d FAN (available:%d, staked:%d), nonce:%d}",
+	return fmt.Sprintf("Account{%s: %d FAN (available:%d, staked:%d, unbonding:%d), nonce:%d}",
 		a.Address,
 		a.TotalBalance()/FANUnit(),
 		a.AvailableBalance/FANUnit(),
 		a.StakedBalance/FANUnit(),
+		a.UnbondingBalance/FANUnit(),
 		a.Nonce)
 }
 
@@ -0,0 +1,167 @@
+package core
+
+import (
+	"crypto/sha256"
+	"fmt"
+)
+
+// 状态树：256层稀疏Merkle树（SHA-256）
+// - 账户键：StateKey(address) = sha256(address)，按位从高到低决定左右分支
+// - 叶子节点：sha256(0x00 || key || AccountLeafHash(account))
+// - 中间节点：sha256(0x01 || left || right)
+// - 空子树：全零哈希；只含一个账户的子树直接用该叶子表示（压缩路径）
+// 树形只由账户集合决定，与写入顺序无关
+const StateTreeDepth = 256
+
+const (
+	stateLeafPrefix     = 0x00
+	stateInternalPrefix = 0x01
+)
+
+// AccountProof 账户在状态树中的证明（轻客户端验证余额用）
+// 账户存在时为包含证明；不存在时为非包含证明（路径终止于空子树或另一个账户的叶子）
+type AccountProof struct {
+	Address    string   `json:"address"`
+	Account    *Account `json:"account,omitempty"`     // 为空表示账户不存在（余额为0）
+	Siblings   []string `json:"siblings"`              // 从根到叶子的兄弟节点，第i个对应键的第i位
+	OtherKey   string   `json:"other_key,omitempty"`   // 非包含证明：路径终点处其他账户的键
+	OtherValue string   `json:"other_value,omitempty"` // 非包含证明：该账户的叶子值
+	StateRoot  string   `json:"state_root"`            // 生成证明时的状态根
+}
+
+// StateKey 账户在状态树中的键
+func StateKey(address string) Hash {
+	return sha256.Sum256([]byte(address))
+}
+
+// AccountLeafHash 计算账户叶子值
+// 登记了VRF公钥、被双签罚没过、有出块缺席记录、处于监禁期、有解绑余额的账户追加对应字段（其余账户叶子值与旧格式一致）
+func AccountLeafHash(acc *Account) Hash {
+	data := fmt.Sprintf("%s:%d:%d:%d:%d",
+		acc.Address,
+		acc.AvailableBalance,
+		acc.StakedBalance,
+		acc.Nonce,
+		acc.NodeType,
+	)
+	if len(acc.VRFPublicKey) > 0 {
+		data += fmt.Sprintf(":%x", acc.VRFPublicKey)
+	}
+	if acc.SlashedHeight > 0 {
+		data += fmt.Sprintf(":slashed=%d", acc.SlashedHeight)
+	}
+	if acc.MissedBlocks > 0 || acc.Jailed {
+		data += fmt.Sprintf(":missed=%d:jailed=%t", acc.MissedBlocks, acc.Jailed)
+	}
+	if acc.JailedUntil > 0 {
+		data += fmt.Sprintf(":jailed_until=%d", acc.JailedUntil)
+	}
+	if acc.UnbondingBalance > 0 {
+		data += fmt.Sprintf(":unbonding=%d@%d", acc.UnbondingBalance, acc.StakeLockedUntil)
+	}
+	return sha256.Sum256([]byte(data))
+}
+
+// StateLeafNode 计算叶子节点哈希
+func StateLeafNode(key, value Hash) Hash {
+	data := make([]byte, 0, 65)
+	data = append(data, stateLeafPrefix)
+	data = append(data, key[:]...)
+	data = append(data, value[:]...)
+	return sha256.Sum256(data)
+}
+
+// StateInternalNode 计算中间节点哈希
+func StateInternalNode(left, right Hash) Hash {
+	data := make([]byte, 0, 65)
+	data = append(data, stateInternalPrefix)
+	data = append(data, left[:]...)
+	data = append(data, right[:]...)
+	return sha256.Sum256(data)
+}
+
+// StateKeyBit 取键在指定深度的分支位（0=左，1=右）
+func StateKeyBit(key Hash, depth int) int {
+	return int(key[depth/8]>>(7-uint(depth%8))) & 1
+}
+
+// Verify 验证账户证明能还原出给定状态根
+func (p *AccountProof) Verify(stateRoot Hash) error {
+	if len(p.Siblings) > StateTreeDepth {
+		return fmt.Errorf("proof too long: %d siblings", len(p.Siblings))
+	}
+
+	key := StateKey(p.Address)
+
+	var node Hash
+	switch {
+	case p.Account != nil:
+		if p.Account.Address != p.Address {
+			return fmt.Errorf("proof account %s does not match address %s", p.Account.Address, p.Address)
+		}
+		if p.OtherKey != "" {
+			return fmt.Errorf("inclusion proof must not carry another leaf")
+		}
+		node = StateLeafNode(key, AccountLeafHash(p.Account))
+
+	case p.OtherKey != "":
+		otherKey, err := hexToHash(p.OtherKey)
+		if err != nil {
+			return fmt.Errorf("invalid other key: %v", err)
+		}
+		otherValue, err := hexToHash(p.OtherValue)
+		if err != nil {
+			return fmt.Errorf("invalid other value: %v", err)
+		}
+		if otherKey == key {
+			return fmt.Errorf("other leaf has the proven key")
+		}
+		// 终点叶子必须位于同一路径上，才能说明该路径上没有目标账户
+		for i := range p.Siblings {
+			if StateKeyBit(otherKey, i) != StateKeyBit(key, i) {
+				return fmt.Errorf("other leaf is not on the key path at depth %d", i)
+			}
+		}
+		node = StateLeafNode(otherKey, otherValue)
+
+	default:
+		// 路径终止于空子树
+		node = Hash{}
+	}
+
+	for depth := len(p.Siblings) - 1; depth >= 0; depth-- {
+		sibling, err := hexToHash(p.Siblings[depth])
+		if err != nil {
+			return fmt.Errorf("invalid sibling at depth %d: %v", depth, err)
+		}
+		if StateKeyBit(key, depth) == 0 {
+			node = StateInternalNode(node, sibling)
+		} else {
+			node = StateInternalNode(sibling, node)
+		}
+	}
+
+	if node != stateRoot {
+		return fmt.Errorf("state root mismatch: proof=%s, expected=%s", node.String(), stateRoot.String())
+	}
+
+	return nil
+}
+
+// VerifyAgainstCheckpoint 轻客户端验证入口：先验证检查点多签，再验证账户证明
+// validators 需由轻客户端从可信来源获得（如之前已确认的checkpoint），而非来自同一个API响应
+func (p *AccountProof) VerifyAgainstCheckpoint(cp *Checkpoint, validators []ValidatorSnapshot) error {
+	if cp == nil {
+		return fmt.Errorf("checkpoint is nil")
+	}
+
+	if err := cp.VerifyQuorum(validators); err != nil {
+		return fmt.Errorf("checkpoint not final: %v", err)
+	}
+
+	if err := p.Verify(cp.StateRoot); err != nil {
+		return fmt.Errorf("account proof verification failed at height %d: %v", cp.Height, err)
+	}
+
+	return nil
+}
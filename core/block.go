@@ -12,7 +12,7 @@ type BlockHeader struct {
 	Height       uint64 `json:"height"`
 	PreviousHash Hash   `json:"previous_hash"`
 	Timestamp    int64  `json:"timestamp"`
-	StateRoot    Hash   `json:"state_root"`
+	StateRoot    Hash   `json:"state_root"` // 执行本区块后的状态根（StateRootActive起必须填写并由导入节点验证）
 	TxRoot       Hash   `json:"tx_root"`
 	Proposer     string `json:"proposer"` // 出块者地址
 
@@ -20,6 +20,9 @@ type BlockHeader struct {
 	VRFProof  []byte `json:"vrf_proof"`
 	VRFOutput []byte `json:"vrf_output"`
 
+	// 接管出块时记录VRF选中但未出块的验证者（链上缺席统计）
+	MissedProposer string `json:"missed_proposer,omitempty"`
+
 	// 签名
 	Signature []byte `json:"signature"`
 }
@@ -92,6 +95,9 @@ func (h *BlockHeader) Bytes() []byte {
 	if len(h.VRFOutput) > 0 {
 		buf.Write(h.VRFOutput)
 	}
+	if h.MissedProposer != "" {
+		buf.WriteString(h.MissedProposer)
+	}
 
 	return buf.Bytes()
 }
@@ -113,16 +119,25 @@ func (h *BlockHeader) SignData() []byte {
 	if len(h.VRFOutput) > 0 {
 		buf.Write(h.VRFOutput)
 	}
+	if h.MissedProposer != "" {
+		buf.WriteString(h.MissedProposer)
+	}
 
 	return buf.Bytes()
 }
 
-// 计算交易Merkle根（简化版，直接哈希所有交易）
+// 计算交易根
+// 激活高度（MerkleTxRootHeight）之前：直接哈希所有交易哈希的拼接（旧格式，保持历史区块可验证）
+// 激活高度之后：二叉Merkle树，支持单笔交易的包含证明
 func (b *Block) CalculateTxRoot() Hash {
 	if len(b.Transactions) == 0 {
 		return Hash{}
 	}
 
+	if b.UsesMerkleTxRoot() {
+		return MerkleRoot(b.TxHashes())
+	}
+
 	buf := new(bytes.Buffer)
 	for _, tx := range b.Transactions {
 		txHash := tx.Hash()
@@ -132,6 +147,20 @@ func (b *Block) CalculateTxRoot() Hash {
 	return CalculateHash(buf.Bytes())
 }
 
+// UsesMerkleTxRoot 区块TxRoot是否为Merkle树根
+func (b *Block) UsesMerkleTxRoot() bool {
+	return MerkleTxRootActive(b.Header.Height)
+}
+
+// TxHashes 区块内所有交易哈希（按区块内顺序）
+func (b *Block) TxHashes() []Hash {
+	hashes := make([]Hash, len(b.Transactions))
+	for i, tx := range b.Transactions {
+		hashes[i] = tx.Hash()
+	}
+	return hashes
+}
+
 // 验证区块
 func (b *Block) Validate(prevBlock *Block) error {
 	return b.ValidateWithOptions(prevBlock, false)
@@ -160,16 +189,16 @@ func (b *Block) ValidateWithOptions(prevBlock *Block, skipTimestampCheck bool) e
 			return fmt.Errorf("invalid timestamp")
 		}
 
-	// 3.1. 检查时间戳不能太超前（防止时间戳攻击）
-	// 同步模式下跳过未来时间检查
-	if !skipTimestampCheck {
-		maxFutureTime := time.Now().UnixMilli() + 60000 // 允许60秒的时间偏差（毫秒级）
-		if b.Header.Timestamp > maxFutureTime {
-			return fmt.Errorf("invalid timestamp: too far in future (block: %d, max: %d)",
-				b.Header.Timestamp, maxFutureTime)
+		// 3.1. 检查时间戳不能太超前（防止时间戳攻击）
+		// 同步模式下跳过未来时间检查
+		if !skipTimestampCheck {
+			maxFutureTime := time.Now().UnixMilli() + 60000 // 允许60秒的时间偏差（毫秒级）
+			if b.Header.Timestamp > maxFutureTime {
+				return fmt.Errorf("invalid timestamp: too far in future (block: %d, max: %d)",
+					b.Header.Timestamp, maxFutureTime)
+			}
 		}
 	}
-	}
 
 	// 4. 验证交易根
 	txRoot := b.CalculateTxRoot()
@@ -177,6 +206,12 @@ func (b *Block) ValidateWithOptions(prevBlock *Block, skipTimestampCheck bool) e
 		return fmt.Errorf("invalid tx root")
 	}
 
+	// 4.1. StateRootActive起区块头必须携带状态根
+	// 这里只做结构检查：状态根与执行结果的比对需要执行区块，由导入路径执行后进行（见executeImportedBlock）
+	if StateRootActive(b.Header.Height) && b.Header.StateRoot == (Hash{}) {
+		return fmt.Errorf("missing state root")
+	}
+
 	// 5. 验证所有交易
 	for i, tx := range b.Transactions {
 		if err := tx.Validate(skipTimestampCheck); err != nil {
@@ -234,3 +269,8 @@ func CreateGenesisBlock() *Block {
 	block.Header.TxRoot = block.CalculateTxRoot()
 	return block
 }
+
+// GenesisHash 创世区块哈希（由创世地址、总供应量和创世时间戳确定，用于识别同一条链）
+func GenesisHash() Hash {
+	return CreateGenesisBlock().Hash()
+}
@@ -56,7 +56,7 @@ func (bc *Blockchain) SetLatestBlockWithHash(block *Block, correctHash Hash) {
 
 	// 创建一个特殊的占位区块，保持正确的hash
 	placeholderBlock := &Block{
-		Header: block.Header,
+		Header:       block.Header,
 		Transactions: block.Transactions,
 		// 注意：这个区块的Hash()方法会返回错误的值
 		// 但我们通过特殊标记来处理这种情况
@@ -193,6 +193,7 @@ func (bc *Blockchain) SetLatestHeightForSync(height uint64) {
 
 // AddBlockForSync 添加区块（用于历史/归档节点同步，放宽验证）
 // 仅验证区块高度是当前高度+1，不验证前置区块hash（因为可能还没同步到）
+// 不执行区块，只检查StateRootActive起区块头携带状态根；执行结果与状态根的比对由执行区块的调用方进行
 func (bc *Blockchain) AddBlockForSync(block *Block) error {
 	bc.mu.Lock()
 	defer bc.mu.Unlock()
@@ -201,6 +202,9 @@ func (bc *Blockchain) AddBlockForSync(block *Block) error {
 	if block.Header.Height != expectedHeight {
 		return fmt.Errorf("invalid height: expected %d, got %d", expectedHeight, block.Header.Height)
 	}
+	if StateRootActive(block.Header.Height) && block.Header.StateRoot == (Hash{}) {
+		return fmt.Errorf("block #%d: missing state root", block.Header.Height)
+	}
 
 	// 更新链状态
 	bc.latestBlock = block
@@ -8,23 +8,25 @@ import (
 )
 
 // ValidatorSnapshot 验证者快照（用于VRF计算一致性）
-// 方案1：精简存储，只保留VRF必需的32字节
+// 方案1：精简存储，只保留VRF必需的33字节压缩公钥
 type ValidatorSnapshot struct {
-	Address    string `json:"addr"`     // 验证者地址 (39字节)
-	Stake      uint64 `json:"stake"`    // 质押量（命格权重） (8字节)
-	VRFPubKey  []byte `json:"vrf_key"`  // VRF公钥精简版 (32字节，状态极简主义)
+	Address   string `json:"addr"`    // 验证者地址 (39字节)
+	Stake     uint64 `json:"stake"`   // 质押量（命格权重） (8字节)
+	VRFPubKey []byte `json:"vrf_key"` // 链上登记的ECVRF公钥 (33字节，未登记为空)
 }
 
 // Checkpoint 检查点结构
 type Checkpoint struct {
-	Height       uint64               `json:"height"`       // 检查点高度
-	BlockHash    Hash                 `json:"block_hash"`   // 该高度区块哈希
-	PreviousHash Hash                 `json:"prev_hash"`    // 前一个区块哈希（长期方案）
-	StateRoot    Hash                 `json:"state_root"`   // 状态树根哈希
-	Timestamp    int64                `json:"timestamp"`    // 时间戳
-	Proposer     string               `json:"proposer"`     // 提议者地址
-	Validators   []ValidatorSnapshot  `json:"validators"`   // 验证者快照（新增）
-	Signature    []byte               `json:"signature"`    // 提议者签名
+	Height       uint64                `json:"height"`               // 检查点高度
+	BlockHash    Hash                  `json:"block_hash"`           // 该高度区块哈希
+	PreviousHash Hash                  `json:"prev_hash"`            // 前一个区块哈希（长期方案）
+	StateRoot    Hash                  `json:"state_root"`           // 状态树根哈希
+	Timestamp    int64                 `json:"timestamp"`            // 时间戳
+	Proposer     string                `json:"proposer"`             // 提议者地址
+	Validators   []ValidatorSnapshot   `json:"validators"`           // 验证者快照（新增）
+	Signature    []byte                `json:"signature"`            // 提议者签名
+	Signatures   []CheckpointSignature `json:"signatures,omitempty"` // 验证者多签（超过1/2质押签名后checkpoint最终确认）
+	VRFOutput    []byte                `json:"vrf_output,omitempty"` // 该高度区块的VRF输出（占位块据此推导下一块的出块种子）
 }
 
 // NewCheckpoint 创建新检查点
@@ -56,6 +58,9 @@ func (cp *Checkpoint) Hash() Hash {
 		cp.Proposer,
 		validatorsData,
 	)
+	if len(cp.VRFOutput) > 0 {
+		data += fmt.Sprintf(":%x", cp.VRFOutput)
+	}
 	return CalculateHash([]byte(data))
 }
 
@@ -99,4 +104,102 @@ func (cp *Checkpoint) Sign(privateKey []byte) error {
 	}
 	cp.Signature = sig
 	return nil
-}
\ No newline at end of file
+}
+
+// CheckpointSignature 验证者对checkpoint哈希的签名
+// 附带ML-DSA公钥，任何节点都能独立验证（地址由公钥推导）
+type CheckpointSignature struct {
+	Validator string `json:"validator"`
+	PublicKey []byte `json:"public_key"`
+	Signature []byte `json:"signature"`
+}
+
+// NewCheckpointSignature 验证者签名checkpoint
+func NewCheckpointSignature(cp *Checkpoint, address string, privateKey, publicKey []byte) (*CheckpointSignature, error) {
+	msgHash := cp.Hash()
+	sig, err := crypto.Sign(privateKey, msgHash.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return &CheckpointSignature{
+		Validator: address,
+		PublicKey: publicKey,
+		Signature: sig,
+	}, nil
+}
+
+// Verify 验证签名：公钥与验证者地址匹配，且签名针对该checkpoint哈希
+func (s *CheckpointSignature) Verify(checkpointHash Hash) error {
+	address, err := AddressFromPublicKey(s.PublicKey)
+	if err != nil {
+		return fmt.Errorf("invalid public key: %v", err)
+	}
+	if address != s.Validator {
+		return fmt.Errorf("public key does not match validator %s", s.Validator)
+	}
+	if !crypto.Verify(s.PublicKey, checkpointHash.Bytes(), s.Signature) {
+		return fmt.Errorf("invalid signature from %s", s.Validator)
+	}
+	return nil
+}
+
+// HasSignature 该验证者是否已签名
+func (cp *Checkpoint) HasSignature(validator string) bool {
+	for _, s := range cp.Signatures {
+		if s.Validator == validator {
+			return true
+		}
+	}
+	return false
+}
+
+// AddSignature 验证并加入验证者签名，返回是否为新签名
+func (cp *Checkpoint) AddSignature(sig *CheckpointSignature) (bool, error) {
+	if cp.HasSignature(sig.Validator) {
+		return false, nil
+	}
+	if err := sig.Verify(cp.Hash()); err != nil {
+		return false, err
+	}
+	cp.Signatures = append(cp.Signatures, *sig)
+	return true, nil
+}
+
+// SignedStake 统计validators中已签名的质押量和总质押量
+// 只计入validators中的验证者，每个验证者只计一次，签名无效的不计入
+func (cp *Checkpoint) SignedStake(validators []ValidatorSnapshot) (signed, total uint64) {
+	stakes := make(map[string]uint64, len(validators))
+	for _, v := range validators {
+		stakes[v.Address] = v.Stake
+		total += v.Stake
+	}
+
+	msgHash := cp.Hash()
+	counted := make(map[string]bool, len(cp.Signatures))
+	for i := range cp.Signatures {
+		s := &cp.Signatures[i]
+		stake, ok := stakes[s.Validator]
+		if !ok || counted[s.Validator] {
+			continue
+		}
+		if s.Verify(msgHash) != nil {
+			continue
+		}
+		counted[s.Validator] = true
+		signed += stake
+	}
+	return signed, total
+}
+
+// VerifyQuorum 验证checkpoint已获得validators中超过1/2质押的签名（最终确认）
+// validators 应为验证方信任的验证者集合，而不是checkpoint自带的快照
+func (cp *Checkpoint) VerifyQuorum(validators []ValidatorSnapshot) error {
+	signed, total := cp.SignedStake(validators)
+	if total == 0 {
+		return fmt.Errorf("no validator stake to verify against")
+	}
+	if signed <= total/2 {
+		return fmt.Errorf("insufficient signatures: %d of %d stake signed", signed, total)
+	}
+	return nil
+}
@@ -0,0 +1,670 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ========== 紧凑二进制编码（P2P传输） ==========
+// 整数用varint，字节串和字符串带长度前缀，哈希定长32字节，可空对象前加1字节存在标记
+// 相比JSON省去字段名和签名/公钥的base64膨胀；格式变化时递增版本号，由P2P握手协商
+
+// BinaryCodecVersion 当前二进制编码版本
+const BinaryCodecVersion uint8 = 1
+
+var errTruncated = errors.New("binary codec: unexpected end of data")
+
+// BinaryWriter 二进制编码器
+type BinaryWriter struct {
+	buf []byte
+}
+
+func NewBinaryWriter() *BinaryWriter {
+	return &BinaryWriter{buf: make([]byte, 0, 256)}
+}
+
+// Bytes 已编码的数据
+func (w *BinaryWriter) Bytes() []byte {
+	return w.buf
+}
+
+func (w *BinaryWriter) WriteUint8(v uint8) {
+	w.buf = append(w.buf, v)
+}
+
+func (w *BinaryWriter) WriteBool(v bool) {
+	if v {
+		w.buf = append(w.buf, 1)
+	} else {
+		w.buf = append(w.buf, 0)
+	}
+}
+
+func (w *BinaryWriter) WriteUvarint(v uint64) {
+	w.buf = binary.AppendUvarint(w.buf, v)
+}
+
+func (w *BinaryWriter) WriteVarint(v int64) {
+	w.buf = binary.AppendVarint(w.buf, v)
+}
+
+// WriteBytes 写入长度前缀 + 字节串
+func (w *BinaryWriter) WriteBytes(b []byte) {
+	w.WriteUvarint(uint64(len(b)))
+	w.buf = append(w.buf, b...)
+}
+
+func (w *BinaryWriter) WriteString(s string) {
+	w.WriteUvarint(uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+func (w *BinaryWriter) WriteHash(h Hash) {
+	w.buf = append(w.buf, h[:]...)
+}
+
+// BinaryReader 二进制解码器
+// 出错后后续读取都返回零值，解码结束时统一检查Err/Finish
+type BinaryReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func NewBinaryReader(data []byte) *BinaryReader {
+	return &BinaryReader{data: data}
+}
+
+// Err 解码过程中的第一个错误
+func (r *BinaryReader) Err() error {
+	return r.err
+}
+
+// Finish 检查解码成功且没有多余数据
+func (r *BinaryReader) Finish() error {
+	if r.err == nil && r.pos != len(r.data) {
+		r.err = fmt.Errorf("binary codec: %d trailing bytes", len(r.data)-r.pos)
+	}
+	return r.err
+}
+
+// Fail 记录解码错误（只保留第一个）
+func (r *BinaryReader) Fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+func (r *BinaryReader) remaining() int {
+	return len(r.data) - r.pos
+}
+
+func (r *BinaryReader) ReadUint8() uint8 {
+	if r.err != nil {
+		return 0
+	}
+	if r.remaining() < 1 {
+		r.Fail(errTruncated)
+		return 0
+	}
+	v := r.data[r.pos]
+	r.pos++
+	return v
+}
+
+func (r *BinaryReader) ReadBool() bool {
+	switch r.ReadUint8() {
+	case 0:
+		return false
+	case 1:
+		return true
+	default:
+		r.Fail(errors.New("binary codec: invalid bool"))
+		return false
+	}
+}
+
+func (r *BinaryReader) ReadUvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		r.Fail(errors.New("binary codec: invalid uvarint"))
+		return 0
+	}
+	r.pos += n
+	return v
+}
+
+func (r *BinaryReader) ReadVarint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.data[r.pos:])
+	if n <= 0 {
+		r.Fail(errors.New("binary codec: invalid varint"))
+		return 0
+	}
+	r.pos += n
+	return v
+}
+
+// readLength 读取长度前缀，不能超过剩余数据
+func (r *BinaryReader) readLength() int {
+	n := r.ReadUvarint()
+	if r.err != nil {
+		return 0
+	}
+	if n > uint64(r.remaining()) {
+		r.Fail(errTruncated)
+		return 0
+	}
+	return int(n)
+}
+
+// ReadBytes 读取长度前缀 + 字节串（复制，不引用输入缓冲区；长度为0时返回nil）
+func (r *BinaryReader) ReadBytes() []byte {
+	n := r.readLength()
+	if r.err != nil || n == 0 {
+		return nil
+	}
+	b := make([]byte, n)
+	copy(b, r.data[r.pos:r.pos+n])
+	r.pos += n
+	return b
+}
+
+func (r *BinaryReader) ReadString() string {
+	n := r.readLength()
+	if r.err != nil || n == 0 {
+		return ""
+	}
+	s := string(r.data[r.pos : r.pos+n])
+	r.pos += n
+	return s
+}
+
+func (r *BinaryReader) ReadHash() Hash {
+	var h Hash
+	if r.err != nil {
+		return h
+	}
+	if r.remaining() < len(h) {
+		r.Fail(errTruncated)
+		return h
+	}
+	copy(h[:], r.data[r.pos:])
+	r.pos += len(h)
+	return h
+}
+
+// ReadCount 读取元素个数；每个元素至少占minSize字节，个数超过剩余数据能容纳的数量即为非法
+// （防止伪造的个数触发超大内存分配）
+func (r *BinaryReader) ReadCount(minSize int) int {
+	n := r.ReadUvarint()
+	if r.err != nil {
+		return 0
+	}
+	if minSize < 1 {
+		minSize = 1
+	}
+	if n > uint64(r.remaining()/minSize) {
+		r.Fail(fmt.Errorf("binary codec: element count %d exceeds data", n))
+		return 0
+	}
+	return int(n)
+}
+
+// ========== 核心类型编码 ==========
+
+// 各类型编码后的最小字节数（校验元素个数用）
+const (
+	minTransactionSize = 12
+	minSnapshotSize    = 3
+	minCheckpointSig   = 3
+	minAccountSize     = 76
+)
+
+// WriteBlockHeader 写入区块头（可为nil）
+func (w *BinaryWriter) WriteBlockHeader(h *BlockHeader) {
+	w.WriteBool(h != nil)
+	if h == nil {
+		return
+	}
+	w.WriteUvarint(h.Height)
+	w.WriteHash(h.PreviousHash)
+	w.WriteVarint(h.Timestamp)
+	w.WriteHash(h.StateRoot)
+	w.WriteHash(h.TxRoot)
+	w.WriteString(h.Proposer)
+	w.WriteBytes(h.VRFProof)
+	w.WriteBytes(h.VRFOutput)
+	w.WriteString(h.MissedProposer)
+	w.WriteBytes(h.Signature)
+}
+
+func (r *BinaryReader) ReadBlockHeader() *BlockHeader {
+	if !r.ReadBool() {
+		return nil
+	}
+	return &BlockHeader{
+		Height:         r.ReadUvarint(),
+		PreviousHash:   r.ReadHash(),
+		Timestamp:      r.ReadVarint(),
+		StateRoot:      r.ReadHash(),
+		TxRoot:         r.ReadHash(),
+		Proposer:       r.ReadString(),
+		VRFProof:       r.ReadBytes(),
+		VRFOutput:      r.ReadBytes(),
+		MissedProposer: r.ReadString(),
+		Signature:      r.ReadBytes(),
+	}
+}
+
+// WriteTransaction 写入交易（可为nil）
+func (w *BinaryWriter) WriteTransaction(tx *Transaction) {
+	w.WriteBool(tx != nil)
+	if tx == nil {
+		return
+	}
+	w.WriteUint8(uint8(tx.Type))
+	w.WriteString(tx.From)
+	w.WriteString(tx.To)
+	w.WriteUvarint(tx.Amount)
+	w.WriteUvarint(tx.GasFee)
+	w.WriteUvarint(tx.Nonce)
+	w.WriteVarint(tx.Timestamp)
+	w.WriteBytes(tx.Data)
+	w.WriteUvarint(tx.GasLimit)
+	w.WriteBytes(tx.Signature)
+	w.WriteBytes(tx.PublicKey)
+}
+
+func (r *BinaryReader) ReadTransaction() *Transaction {
+	if !r.ReadBool() {
+		return nil
+	}
+	return &Transaction{
+		Type:      TxType(r.ReadUint8()),
+		From:      r.ReadString(),
+		To:        r.ReadString(),
+		Amount:    r.ReadUvarint(),
+		GasFee:    r.ReadUvarint(),
+		Nonce:     r.ReadUvarint(),
+		Timestamp: r.ReadVarint(),
+		Data:      r.ReadBytes(),
+		GasLimit:  r.ReadUvarint(),
+		Signature: r.ReadBytes(),
+		PublicKey: r.ReadBytes(),
+	}
+}
+
+// WriteBlock 写入区块（可为nil）
+func (w *BinaryWriter) WriteBlock(b *Block) {
+	w.WriteBool(b != nil)
+	if b == nil {
+		return
+	}
+	w.WriteBlockHeader(b.Header)
+	w.WriteUvarint(uint64(len(b.Transactions)))
+	for _, tx := range b.Transactions {
+		w.WriteTransaction(tx)
+	}
+	w.WriteBytes(b.Data)
+}
+
+// ReadBlock 读取区块：区块头和其中的交易不允许为空
+func (r *BinaryReader) ReadBlock() *Block {
+	if !r.ReadBool() {
+		return nil
+	}
+
+	b := &Block{Header: r.ReadBlockHeader()}
+	if r.err == nil && b.Header == nil {
+		r.Fail(errors.New("binary codec: block without header"))
+	}
+
+	if n := r.ReadCount(minTransactionSize); n > 0 {
+		b.Transactions = make([]*Transaction, 0, n)
+		for i := 0; i < n && r.err == nil; i++ {
+			tx := r.ReadTransaction()
+			if r.err == nil && tx == nil {
+				r.Fail(errors.New("binary codec: nil transaction in block"))
+			}
+			b.Transactions = append(b.Transactions, tx)
+		}
+	}
+	b.Data = r.ReadBytes()
+
+	if r.err != nil {
+		return nil
+	}
+	return b
+}
+
+// WriteCheckpointSignature 写入checkpoint验证者签名（可为nil）
+func (w *BinaryWriter) WriteCheckpointSignature(sig *CheckpointSignature) {
+	w.WriteBool(sig != nil)
+	if sig == nil {
+		return
+	}
+	w.WriteString(sig.Validator)
+	w.WriteBytes(sig.PublicKey)
+	w.WriteBytes(sig.Signature)
+}
+
+func (r *BinaryReader) ReadCheckpointSignature() *CheckpointSignature {
+	if !r.ReadBool() {
+		return nil
+	}
+	return &CheckpointSignature{
+		Validator: r.ReadString(),
+		PublicKey: r.ReadBytes(),
+		Signature: r.ReadBytes(),
+	}
+}
+
+// WriteCheckpoint 写入checkpoint（可为nil）
+func (w *BinaryWriter) WriteCheckpoint(cp *Checkpoint) {
+	w.WriteBool(cp != nil)
+	if cp == nil {
+		return
+	}
+	w.WriteUvarint(cp.Height)
+	w.WriteHash(cp.BlockHash)
+	w.WriteHash(cp.PreviousHash)
+	w.WriteHash(cp.StateRoot)
+	w.WriteVarint(cp.Timestamp)
+	w.WriteString(cp.Proposer)
+
+	w.WriteUvarint(uint64(len(cp.Validators)))
+	for _, v := range cp.Validators {
+		w.WriteString(v.Address)
+		w.WriteUvarint(v.Stake)
+		w.WriteBytes(v.VRFPubKey)
+	}
+
+	w.WriteBytes(cp.Signature)
+
+	w.WriteUvarint(uint64(len(cp.Signatures)))
+	for i := range cp.Signatures {
+		w.WriteString(cp.Signatures[i].Validator)
+		w.WriteBytes(cp.Signatures[i].PublicKey)
+		w.WriteBytes(cp.Signatures[i].Signature)
+	}
+
+	w.WriteBytes(cp.VRFOutput)
+}
+
+func (r *BinaryReader) ReadCheckpoint() *Checkpoint {
+	if !r.ReadBool() {
+		return nil
+	}
+
+	cp := &Checkpoint{
+		Height:       r.ReadUvarint(),
+		BlockHash:    r.ReadHash(),
+		PreviousHash: r.ReadHash(),
+		StateRoot:    r.ReadHash(),
+		Timestamp:    r.ReadVarint(),
+		Proposer:     r.ReadString(),
+	}
+
+	if n := r.ReadCount(minSnapshotSize); n > 0 {
+		cp.Validators = make([]ValidatorSnapshot, n)
+		for i := 0; i < n && r.err == nil; i++ {
+			cp.Validators[i] = ValidatorSnapshot{
+				Address:   r.ReadString(),
+				Stake:     r.ReadUvarint(),
+				VRFPubKey: r.ReadBytes(),
+			}
+		}
+	}
+
+	cp.Signature = r.ReadBytes()
+
+	if n := r.ReadCount(minCheckpointSig); n > 0 {
+		cp.Signatures = make([]CheckpointSignature, n)
+		for i := 0; i < n && r.err == nil; i++ {
+			cp.Signatures[i] = CheckpointSignature{
+				Validator: r.ReadString(),
+				PublicKey: r.ReadBytes(),
+				Signature: r.ReadBytes(),
+			}
+		}
+	}
+
+	cp.VRFOutput = r.ReadBytes()
+
+	if r.err != nil {
+		return nil
+	}
+	return cp
+}
+
+// WriteEvidence 写入双签证据（可为nil）
+func (w *BinaryWriter) WriteEvidence(ev *DoubleSignEvidence) {
+	w.WriteBool(ev != nil)
+	if ev == nil {
+		return
+	}
+	w.WriteBlockHeader(ev.HeaderA)
+	w.WriteBlockHeader(ev.HeaderB)
+	w.WriteBytes(ev.PublicKey)
+}
+
+func (r *BinaryReader) ReadEvidence() *DoubleSignEvidence {
+	if !r.ReadBool() {
+		return nil
+	}
+	return &DoubleSignEvidence{
+		HeaderA:   r.ReadBlockHeader(),
+		HeaderB:   r.ReadBlockHeader(),
+		PublicKey: r.ReadBytes(),
+	}
+}
+
+// WriteAccount 写入账户（不可为nil）
+func (w *BinaryWriter) WriteAccount(acc *Account) {
+	w.WriteString(acc.Address)
+	w.WriteUvarint(acc.AvailableBalance)
+	w.WriteUvarint(acc.StakedBalance)
+	w.WriteUvarint(acc.Nonce)
+	w.WriteUint8(uint8(acc.NodeType))
+	w.WriteString(acc.NodeStatus)
+	w.WriteUvarint(acc.UnbondingBalance)
+	w.WriteUvarint(acc.StakeLockedUntil)
+	w.WriteBytes(acc.VRFPublicKey)
+	w.WriteUvarint(acc.SlashedHeight)
+	w.WriteUvarint(acc.MissedBlocks)
+	w.WriteBool(acc.Jailed)
+	w.WriteUvarint(acc.JailedUntil)
+	w.WriteHash(acc.CodeHash)
+	w.WriteHash(acc.StorageRoot)
+}
+
+func (r *BinaryReader) ReadAccount() *Account {
+	acc := &Account{
+		Address:          r.ReadString(),
+		AvailableBalance: r.ReadUvarint(),
+		StakedBalance:    r.ReadUvarint(),
+		Nonce:            r.ReadUvarint(),
+		NodeType:         NodeType(r.ReadUint8()),
+		NodeStatus:       r.ReadString(),
+		UnbondingBalance: r.ReadUvarint(),
+		StakeLockedUntil: r.ReadUvarint(),
+		VRFPublicKey:     r.ReadBytes(),
+		SlashedHeight:    r.ReadUvarint(),
+		MissedBlocks:     r.ReadUvarint(),
+		Jailed:           r.ReadBool(),
+		JailedUntil:      r.ReadUvarint(),
+		CodeHash:         r.ReadHash(),
+		StorageRoot:      r.ReadHash(),
+	}
+	if r.err != nil {
+		return nil
+	}
+	return acc
+}
+
+// WriteStateChunk 写入状态分片（可为nil）
+func (w *BinaryWriter) WriteStateChunk(c *StateChunk) {
+	w.WriteBool(c != nil)
+	if c == nil {
+		return
+	}
+	w.WriteUvarint(uint64(c.Index))
+	w.WriteUvarint(uint64(len(c.Accounts)))
+	for _, acc := range c.Accounts {
+		w.WriteAccount(acc)
+	}
+	w.WriteUvarint(uint64(len(c.Siblings)))
+	for _, h := range c.Siblings {
+		w.WriteHash(h)
+	}
+	w.WriteBool(c.Other != nil)
+	if c.Other != nil {
+		w.WriteHash(c.Other.Key)
+		w.WriteHash(c.Other.Value)
+	}
+}
+
+func (r *BinaryReader) ReadStateChunk() *StateChunk {
+	if !r.ReadBool() {
+		return nil
+	}
+
+	c := &StateChunk{Index: uint32(r.ReadUvarint())}
+	n := r.ReadCount(minAccountSize)
+	c.Accounts = make([]*Account, 0, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		c.Accounts = append(c.Accounts, r.ReadAccount())
+	}
+	n = r.ReadCount(32)
+	c.Siblings = make([]Hash, 0, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		c.Siblings = append(c.Siblings, r.ReadHash())
+	}
+	if r.ReadBool() {
+		c.Other = &StateLeaf{Key: r.ReadHash(), Value: r.ReadHash()}
+	}
+
+	if r.err != nil {
+		return nil
+	}
+	return c
+}
+
+// ========== 独立编码（带版本号） ==========
+
+// MarshalBinary 编码区块：版本号 + 区块
+func (b *Block) MarshalBinary() ([]byte, error) {
+	w := NewBinaryWriter()
+	w.WriteUint8(BinaryCodecVersion)
+	w.WriteBlock(b)
+	return w.Bytes(), nil
+}
+
+// UnmarshalBinary 解码区块
+func (b *Block) UnmarshalBinary(data []byte) error {
+	r, err := newVersionedReader(data)
+	if err != nil {
+		return err
+	}
+	decoded := r.ReadBlock()
+	if err := r.Finish(); err != nil {
+		return err
+	}
+	if decoded == nil {
+		return errors.New("binary codec: nil block")
+	}
+	*b = *decoded
+	return nil
+}
+
+// MarshalBinary 编码交易：版本号 + 交易
+func (tx *Transaction) MarshalBinary() ([]byte, error) {
+	w := NewBinaryWriter()
+	w.WriteUint8(BinaryCodecVersion)
+	w.WriteTransaction(tx)
+	return w.Bytes(), nil
+}
+
+// UnmarshalBinary 解码交易
+func (tx *Transaction) UnmarshalBinary(data []byte) error {
+	r, err := newVersionedReader(data)
+	if err != nil {
+		return err
+	}
+	decoded := r.ReadTransaction()
+	if err := r.Finish(); err != nil {
+		return err
+	}
+	if decoded == nil {
+		return errors.New("binary codec: nil transaction")
+	}
+	*tx = *decoded
+	return nil
+}
+
+// MarshalBinary 编码checkpoint：版本号 + checkpoint
+func (cp *Checkpoint) MarshalBinary() ([]byte, error) {
+	w := NewBinaryWriter()
+	w.WriteUint8(BinaryCodecVersion)
+	w.WriteCheckpoint(cp)
+	return w.Bytes(), nil
+}
+
+// UnmarshalBinary 解码checkpoint
+func (cp *Checkpoint) UnmarshalBinary(data []byte) error {
+	r, err := newVersionedReader(data)
+	if err != nil {
+		return err
+	}
+	decoded := r.ReadCheckpoint()
+	if err := r.Finish(); err != nil {
+		return err
+	}
+	if decoded == nil {
+		return errors.New("binary codec: nil checkpoint")
+	}
+	*cp = *decoded
+	return nil
+}
+
+// MarshalBinary 编码状态分片：版本号 + 分片
+func (c *StateChunk) MarshalBinary() ([]byte, error) {
+	w := NewBinaryWriter()
+	w.WriteUint8(BinaryCodecVersion)
+	w.WriteStateChunk(c)
+	return w.Bytes(), nil
+}
+
+// UnmarshalBinary 解码状态分片
+func (c *StateChunk) UnmarshalBinary(data []byte) error {
+	r, err := newVersionedReader(data)
+	if err != nil {
+		return err
+	}
+	decoded := r.ReadStateChunk()
+	if err := r.Finish(); err != nil {
+		return err
+	}
+	if decoded == nil {
+		return errors.New("binary codec: nil state chunk")
+	}
+	*c = *decoded
+	return nil
+}
+
+func newVersionedReader(data []byte) (*BinaryReader, error) {
+	r := NewBinaryReader(data)
+	if version := r.ReadUint8(); r.err != nil {
+		return nil, r.err
+	} else if version != BinaryCodecVersion {
+		return nil, fmt.Errorf("binary codec: unsupported version %d", version)
+	}
+	return r, nil
+}
@@ -48,78 +48,96 @@ type ConsensusConfig struct {
 // 链参数
 type ChainParams struct {
 	// TotalSupply 硬编码，不从配置读取（保证总量永不改变）
-	FANUnit        uint64 `json:"fan_unit"`         // 1 FAN = 多少最小单位
-	FANDecimals    int    `json:"fan_decimals"`     // 代币精度
-	GenesisAddress string `json:"genesis_address"`  // 创世地址
-	GenesisTimestamp int64 `json:"genesis_timestamp"` // 创世区块时间戳
+	FANUnit          uint64 `json:"fan_unit"`          // 1 FAN = 多少最小单位
+	FANDecimals      int    `json:"fan_decimals"`      // 代币精度
+	GenesisAddress   string `json:"genesis_address"`   // 创世地址
+	GenesisTimestamp int64  `json:"genesis_timestamp"` // 创世区块时间戳
+	NetworkID        string `json:"network_id"`        // 网络标识（主网/测试网），P2P握手时校验，不参与共识哈希
 }
 
+// 未配置network_id时使用的网络标识
+const DefaultNetworkID = "fan-mainnet"
+
 // 硬编码的总供应量 - 永不改变
 const TotalSupplyHardcoded uint64 = 1400000000000000 // 14亿FAN
 
 // 区块参数
 type BlockParams struct {
-	BlockIntervalSeconds      int    `json:"block_interval_seconds"`        // 出块间隔（秒）
-	FinalityBlocks            int    `json:"finality_blocks"`               // 确认需要的区块数
-	CheckpointInterval        uint64 `json:"checkpoint_interval"`           // Checkpoint生成间隔（区块数）
-	CheckpointKeepCount       int    `json:"checkpoint_keep_count"`         // 保留的checkpoint数量
-	MaxTimestampDrift         int64  `json:"max_timestamp_drift"`           // 最大时间戳偏移（秒）
-	MaxBlockSize              uint64 `json:"max_block_size"`                // 区块最大大小（字节）
+	BlockIntervalSeconds      int    `json:"block_interval_seconds"`       // 出块间隔（秒）
+	FinalityBlocks            int    `json:"finality_blocks"`              // 确认需要的区块数
+	CheckpointInterval        uint64 `json:"checkpoint_interval"`          // Checkpoint生成间隔（区块数）
+	CheckpointKeepCount       int    `json:"checkpoint_keep_count"`        // 保留的checkpoint数量
+	MaxTimestampDrift         int64  `json:"max_timestamp_drift"`          // 最大时间戳偏移（秒）
+	MaxBlockSize              uint64 `json:"max_block_size"`               // 区块最大大小（字节）
 	BlockDataThresholdPercent int    `json:"block_data_threshold_percent"` // Data字段阈值百分比（0-100）
+	MerkleTxRootHeight        uint64 `json:"merkle_tx_root_height"`        // 从此高度起TxRoot使用二叉Merkle树（0=未启用）
+	VRFActivationHeight       uint64 `json:"vrf_activation_height"`        // 从此高度起出块者必须提供ECVRF证明（0=未启用）
+	StateRootActivationHeight uint64 `json:"state_root_activation_height"` // 从此高度起区块头必须携带执行后的状态根（0=未启用）
+	SparseStateRootHeight     uint64 `json:"sparse_state_root_height"`     // 从此高度起状态根使用稀疏Merkle树（0=未启用，沿用按地址排序的二叉Merkle树）
 }
 
 // 经济参数
 type EconomicParams struct {
-	MinGasFee              uint64 `json:"min_gas_fee"`               // 最小手续费
-	MaxGasFee              uint64 `json:"max_gas_fee"`               // 最大手续费
-	BaseBlockReward        uint64 `json:"base_block_reward"`         // 基础出块奖励
-	MinRewardUnit          uint64 `json:"min_reward_unit"`           // 最小奖励单位
-	ValidatorStakeRequired uint64 `json:"validator_stake_required"`  // 验证者最低质押
+	MinGasFee              uint64 `json:"min_gas_fee"`              // 最小手续费
+	MaxGasFee              uint64 `json:"max_gas_fee"`              // 最大手续费
+	BaseBlockReward        uint64 `json:"base_block_reward"`        // 基础出块奖励
+	MinRewardUnit          uint64 `json:"min_reward_unit"`          // 最小奖励单位
+	ValidatorStakeRequired uint64 `json:"validator_stake_required"` // 验证者最低质押
+
+	// 解绑期（硬分叉参数）：激活高度起解押的质押需等待UnbondingBlocks个区块才到账，期间仍可被罚没
+	UnbondingActivationHeight uint64 `json:"unbonding_activation_height"` // 0=未启用（解押立即到账）
+	UnbondingBlocks           uint64 `json:"unbonding_blocks"`            // 解绑等待区块数
 }
 
 // 验证者参数
 type ValidatorParams struct {
-	MaxValidators               int `json:"max_validators"`                 // 最大验证者数量
-	ActiveValidatorSet          int `json:"active_validator_set"`           // 活跃验证者集合大小
-	CheckpointActivationBuffer  int `json:"checkpoint_activation_buffer"`   // checkpoint激活缓冲（距下次checkpoint少于此块数时等待）
+	MaxValidators              int `json:"max_validators"`               // 最大验证者数量
+	ActiveValidatorSet         int `json:"active_validator_set"`         // 活跃验证者集合大小
+	CheckpointActivationBuffer int `json:"checkpoint_activation_buffer"` // checkpoint激活缓冲（距下次checkpoint少于此块数时等待）
 }
 
 // 存储参数
 type StorageParams struct {
-	LedgerRetentionDays        int  `json:"ledger_retention_days"`         // 账本保留天数（0=永久保留）
-	AutoCleanupEnabled         bool `json:"auto_cleanup_enabled"`          // 自动清理开关
-	CleanupCheckIntervalHours  int  `json:"cleanup_check_interval_hours"`  // 清理检查间隔(小时)
-	MinBlocksToKeep            int  `json:"min_blocks_to_keep"`            // 最少保留区块数
-	VerifyBlockHash            int  `json:"verify_block_hash"`             // 读取区块时校验SHA3哈希（1=校验，0=不校验）
+	LedgerRetentionDays       int  `json:"ledger_retention_days"`        // 账本保留天数（0=永久保留）
+	AutoCleanupEnabled        bool `json:"auto_cleanup_enabled"`         // 自动清理开关
+	CleanupCheckIntervalHours int  `json:"cleanup_check_interval_hours"` // 清理检查间隔(小时)
+	MinBlocksToKeep           int  `json:"min_blocks_to_keep"`           // 最少保留区块数
+	VerifyBlockHash           int  `json:"verify_block_hash"`            // 读取区块时校验SHA3哈希（1=校验，0=不校验）
 }
 
 // 交易参数
 type TransactionParams struct {
-	MaxTxSize         uint64 `json:"max_tx_size"`          // 最大交易大小(字节)
-	MaxTxPerBlock     uint64 `json:"max_tx_per_block"`     // 每块最大交易数
-	MinTransferAmount uint64 `json:"min_transfer_amount"`  // 最小转账金额
-	MaxDataSize       uint64 `json:"max_data_size"`        // Data字段最大长度(字节)
-	MemoMaxLength     uint64 `json:"memo_max_length"`      // 备注最大长度(字节)
+	MaxTxSize         uint64 `json:"max_tx_size"`         // 最大交易大小(字节)
+	MaxTxPerBlock     uint64 `json:"max_tx_per_block"`    // 每块最大交易数
+	MinTransferAmount uint64 `json:"min_transfer_amount"` // 最小转账金额
+	MaxDataSize       uint64 `json:"max_data_size"`       // Data字段最大长度(字节)
+	MemoMaxLength     uint64 `json:"memo_max_length"`     // 备注最大长度(字节)
+
+	// 客户端签名nonce激活时间（毫秒时间戳，0=创世起生效）
+	// 时间戳>=此值的用户交易，nonce参与签名和哈希；之前的历史交易保持旧签名格式
+	NonceSigningActivationMs int64  `json:"nonce_signing_activation_ms"`
+	MaxNonceGap              uint64 `json:"max_nonce_gap"` // 交易池允许的最大未来nonce间隔
 }
 
 // 网络参数
 type NetworkParams struct {
-	MaxPeers               int `json:"max_peers"`                 // 最大连接节点数
-	MinPeers               int `json:"min_peers"`                 // 最小连接节点数
-	PeerHandshakeTimeout   int `json:"peer_handshake_timeout"`    // 握手超时(秒)
-	SyncBatchSize          int `json:"sync_batch_size"`           // 同步批次大小(区块数)
-	MaxBlockRequestSize    int `json:"max_block_request_size"`    // 单次请求最大区块数
-	BroadcastRetryInterval int `json:"broadcast_retry_interval"`  // 广播重试间隔(秒)
-	PingInterval           int `json:"ping_interval"`             // 心跳间隔(秒)
+	MaxPeers               int `json:"max_peers"`                // 最大连接节点数
+	MinPeers               int `json:"min_peers"`                // 最小连接节点数
+	PeerHandshakeTimeout   int `json:"peer_handshake_timeout"`   // 握手超时(秒)
+	SyncBatchSize          int `json:"sync_batch_size"`          // 同步批次大小(区块数)
+	MaxBlockRequestSize    int `json:"max_block_request_size"`   // 单次请求最大区块数
+	BroadcastRetryInterval int `json:"broadcast_retry_interval"` // 广播重试间隔(秒)
+	PingInterval           int `json:"ping_interval"`            // 心跳间隔(秒)
 }
 
 // 安全参数
 type SecurityParams struct {
-	MaxReorgDepth    int   `json:"max_reorg_depth"`     // 最大重组深度
-	MinBlockTimeMs   int64 `json:"min_block_time_ms"`   // 最小出块时间(毫秒,含抖动)
-	MaxBlockTimeMs   int64 `json:"max_block_time_ms"`   // 最大出块时间(毫秒,含抖动)
-	DoubleSignSlash  int   `json:"double_sign_slash"`   // 双签惩罚百分比(0-100)
-	OfflineSlashBlks int   `json:"offline_slash_blocks"`// 离线多少块后惩罚
+	MaxReorgDepth    int    `json:"max_reorg_depth"`      // 最大重组深度
+	MinBlockTimeMs   int64  `json:"min_block_time_ms"`    // 最小出块时间(毫秒,含抖动)
+	MaxBlockTimeMs   int64  `json:"max_block_time_ms"`    // 最大出块时间(毫秒,含抖动)
+	DoubleSignSlash  int    `json:"double_sign_slash"`    // 双签惩罚百分比(0-100)
+	OfflineSlashBlks int    `json:"offline_slash_blocks"` // 离线多少块后惩罚
+	JailBlocks       uint64 `json:"jail_blocks"`          // 监禁后至少经过多少个区块才能解除监禁（硬分叉参数，0=不限制）
 }
 
 // 共识配置管理器
@@ -157,26 +175,33 @@ func (m *ConsensusConfigManager) setDefault() {
 		ConsensusVersion: "1.0.0",
 		ConsensusHash:    "", // 自动计算
 		ChainParams: ChainParams{
-			FANUnit:          1000000,           // 1 FAN = 1000000 最小单位
+			FANUnit:          1000000, // 1 FAN = 1000000 最小单位
 			FANDecimals:      6,
 			GenesisAddress:   "F25gxrj3tppc07hunne7hztvde5gkaw78f3xa",
 			GenesisTimestamp: 1700000000, // 2023-11-14 22:13:20 UTC
+			NetworkID:        DefaultNetworkID,
 		},
 		BlockParams: BlockParams{
 			BlockIntervalSeconds:      5,
 			FinalityBlocks:            8,
-			CheckpointInterval:        1,  // 每块一个Checkpoint（5秒确认）- 符合fan.md P1协议
-			CheckpointKeepCount:       1,  // 单点设计，只保留最新
+			CheckpointInterval:        1,       // 每块一个Checkpoint（5秒确认）- 符合fan.md P1协议
+			CheckpointKeepCount:       1,       // 单点设计，只保留最新
 			MaxTimestampDrift:         300,     // 5分钟
 			MaxBlockSize:              1048576, // 1MB
 			BlockDataThresholdPercent: 80,      // 80%
+			MerkleTxRootHeight:        0,       // 激活高度由运维另行设定
+			VRFActivationHeight:       0,       // 验证者需先登记VRF公钥，激活高度由运维另行设定
+			StateRootActivationHeight: 0,       // 激活高度由运维另行设定
+			SparseStateRootHeight:     0,       // 激活高度由运维另行设定
 		},
 		EconomicParams: EconomicParams{
-			MinGasFee:              1,
-			MaxGasFee:              10,
-			BaseBlockReward:        10000000, // 10 FAN
-			MinRewardUnit:          1,
-			ValidatorStakeRequired: 1000000000000, // 1M FAN
+			MinGasFee:                 1,
+			MaxGasFee:                 10,
+			BaseBlockReward:           10000000, // 10 FAN
+			MinRewardUnit:             1,
+			ValidatorStakeRequired:    1000000000000, // 1M FAN
+			UnbondingActivationHeight: 0,             // 激活高度由运维另行设定
+			UnbondingBlocks:           120960,        // 7天（5秒/块）
 		},
 		ValidatorParams: ValidatorParams{
 			MaxValidators:              100,
@@ -184,11 +209,13 @@ func (m *ConsensusConfigManager) setDefault() {
 			CheckpointActivationBuffer: 3, // 距下次checkpoint少于3块时等待
 		},
 		TransactionParams: TransactionParams{
-			MaxTxSize:         10240, // 10KB
-			MaxTxPerBlock:     1000,
-			MinTransferAmount: 1,
-			MaxDataSize:       1024, // 1KB
-			MemoMaxLength:     256,
+			MaxTxSize:                10240, // 10KB
+			MaxTxPerBlock:            1000,
+			MinTransferAmount:        1,
+			MaxDataSize:              1024, // 1KB
+			MemoMaxLength:            256,
+			NonceSigningActivationMs: 0, // 新链从创世起即由客户端签名nonce
+			MaxNonceGap:              64,
 		},
 		NetworkParams: NetworkParams{
 			MaxPeers:               50,
@@ -205,6 +232,7 @@ func (m *ConsensusConfigManager) setDefault() {
 			MaxBlockTimeMs:   5500, // 5.5秒
 			DoubleSignSlash:  100,  // 100%没收
 			OfflineSlashBlks: 1000,
+			JailBlocks:       17280, // 1天（5秒/块）
 		},
 		StorageParams: StorageParams{
 			LedgerRetentionDays:       100,
@@ -232,6 +260,10 @@ func (m *ConsensusConfigManager) Load() error {
 		return err
 	}
 
+	if config.ChainParams.NetworkID == "" {
+		config.ChainParams.NetworkID = DefaultNetworkID
+	}
+
 	// 计算共识哈希
 	config.ConsensusHash = m.calculateConsensusHash(config)
 
@@ -251,10 +283,10 @@ func (m *ConsensusConfigManager) calculateConsensusHash(config *ConsensusConfig)
 	// 构建确定性的字符串表示（排除consensus_hash本身）
 	hashInput := fmt.Sprintf(
 		"v:%s|ts:%d|unit:%d|dec:%d|bi:%d|ci:%d|ckc:%d|mtd:%d|mbs:%d|bdtp:%d|mgf:%d|xgf:%d|br:%d|mru:%d|vsr:%d|mv:%d|avs:%d|"+
-		"txs:%d|txpb:%d|mta:%d|mds:%d|mml:%d|"+
-		"mp:%d|mnp:%d|pht:%d|sbs:%d|mbr:%d|bri:%d|pi:%d|"+
-		"mrd:%d|mbtm:%d|xbtm:%d|dss:%d|osb:%d|"+
-		"lrd:%d|ac:%t|cci:%d|mbk:%d|thr:%d",
+			"txs:%d|txpb:%d|mta:%d|mds:%d|mml:%d|"+
+			"mp:%d|mnp:%d|pht:%d|sbs:%d|mbr:%d|bri:%d|pi:%d|"+
+			"mrd:%d|mbtm:%d|xbtm:%d|dss:%d|osb:%d|"+
+			"lrd:%d|ac:%t|cci:%d|mbk:%d|thr:%d",
 		config.ConsensusVersion,
 		TotalSupplyHardcoded, // 硬编码的总供应量
 		config.ChainParams.FANUnit,
@@ -306,6 +338,31 @@ func (m *ConsensusConfigManager) calculateConsensusHash(config *ConsensusConfig)
 		hashInput += fmt.Sprintf("|%d", threshold.Balance)
 	}
 
+	// Merkle TxRoot激活高度（硬分叉参数）
+	hashInput += fmt.Sprintf("|mtr:%d", config.BlockParams.MerkleTxRootHeight)
+
+	// 客户端签名nonce（硬分叉参数）
+	hashInput += fmt.Sprintf("|nsa:%d|mng:%d",
+		config.TransactionParams.NonceSigningActivationMs,
+		config.TransactionParams.MaxNonceGap)
+
+	// ECVRF激活高度（硬分叉参数）
+	hashInput += fmt.Sprintf("|vrf:%d", config.BlockParams.VRFActivationHeight)
+
+	// 区块状态根激活高度（硬分叉参数）
+	hashInput += fmt.Sprintf("|sr:%d", config.BlockParams.StateRootActivationHeight)
+
+	// 稀疏Merkle状态根激活高度（硬分叉参数）
+	hashInput += fmt.Sprintf("|ssr:%d", config.BlockParams.SparseStateRootHeight)
+
+	// 最短监禁期（硬分叉参数）
+	hashInput += fmt.Sprintf("|jail:%d", config.SecurityParams.JailBlocks)
+
+	// 解绑期（硬分叉参数）
+	hashInput += fmt.Sprintf("|unb:%d:%d",
+		config.EconomicParams.UnbondingActivationHeight,
+		config.EconomicParams.UnbondingBlocks)
+
 	// 计算SHA3-256哈希
 	hash := sha3.Sum256([]byte(hashInput))
 	return hex.EncodeToString(hash[:])
@@ -328,8 +385,8 @@ func (m *ConsensusConfigManager) Save() error {
 func (c *ConsensusConfig) CalculateBlockReward(genesisBalance uint64) uint64 {
 	// 定义关键阈值常量
 	const (
-		Threshold_0_1_Yi = 10000000000000  // 0.1亿 FAN
-		Threshold_0_2_Yi = 20000000000000  // 0.2亿 FAN
+		Threshold_0_1_Yi = 10000000000000 // 0.1亿 FAN
+		Threshold_0_2_Yi = 20000000000000 // 0.2亿 FAN
 	)
 
 	// 特殊区间1: 余额 < 0.1亿，取消所有奖励
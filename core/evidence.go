@@ -0,0 +1,126 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"fan-chain/crypto"
+)
+
+// DoubleSignEvidence 双签证据：同一出块者在同一高度签署了两个不同的区块头
+// 附带出块者ML-DSA公钥，任何节点都能独立验证（地址由公钥推导，签名由公钥验证）
+type DoubleSignEvidence struct {
+	HeaderA   *BlockHeader `json:"header_a"`
+	HeaderB   *BlockHeader `json:"header_b"`
+	PublicKey []byte       `json:"public_key"`
+}
+
+// NewDoubleSignEvidence 创建双签证据
+// 两个区块头按哈希排序，同一对冲突区块无论先收到哪个都得到相同的证据
+func NewDoubleSignEvidence(a, b *BlockHeader, publicKey []byte) *DoubleSignEvidence {
+	hashA, hashB := headerHash(a), headerHash(b)
+	if bytes.Compare(hashA.Bytes(), hashB.Bytes()) > 0 {
+		a, b = b, a
+	}
+	return &DoubleSignEvidence{
+		HeaderA:   a,
+		HeaderB:   b,
+		PublicKey: publicKey,
+	}
+}
+
+// Offender 双签的出块者地址
+func (e *DoubleSignEvidence) Offender() string {
+	return e.HeaderA.Proposer
+}
+
+// Height 双签发生的高度
+func (e *DoubleSignEvidence) Height() uint64 {
+	return e.HeaderA.Height
+}
+
+// Hash 证据哈希（用于去重）
+func (e *DoubleSignEvidence) Hash() Hash {
+	hashA, hashB := headerHash(e.HeaderA), headerHash(e.HeaderB)
+	return CalculateHash(append(hashA.Bytes(), hashB.Bytes()...))
+}
+
+// Verify 验证证据：同一高度、同一出块者、两个不同的区块头，且签名都来自该出块者的公钥
+func (e *DoubleSignEvidence) Verify() error {
+	if e.HeaderA == nil || e.HeaderB == nil {
+		return fmt.Errorf("evidence missing header")
+	}
+	if e.HeaderA.Height != e.HeaderB.Height {
+		return fmt.Errorf("headers at different heights: %d vs %d", e.HeaderA.Height, e.HeaderB.Height)
+	}
+	if e.HeaderA.Proposer != e.HeaderB.Proposer {
+		return fmt.Errorf("headers from different proposers")
+	}
+	if headerHash(e.HeaderA) == headerHash(e.HeaderB) {
+		return fmt.Errorf("headers are identical")
+	}
+
+	address, err := AddressFromPublicKey(e.PublicKey)
+	if err != nil {
+		return fmt.Errorf("invalid public key: %v", err)
+	}
+	if address != e.Offender() {
+		return fmt.Errorf("public key does not match proposer %s", e.Offender())
+	}
+
+	for _, h := range []*BlockHeader{e.HeaderA, e.HeaderB} {
+		if !crypto.Verify(e.PublicKey, h.SignData(), h.Signature) {
+			return fmt.Errorf("invalid signature on header %s", headerHash(h).String()[:16])
+		}
+	}
+	return nil
+}
+
+// DoubleSignSlashAmount 按共识参数计算双签罚没的质押金额
+func DoubleSignSlashAmount(stakedBalance uint64) uint64 {
+	percent := consensusConfig.SecurityParams.DoubleSignSlash
+	if percent <= 0 {
+		return 0
+	}
+	if percent >= 100 {
+		return stakedBalance
+	}
+	return stakedBalance / 100 * uint64(percent)
+}
+
+// NewSlashTx 创建双签惩罚交易（系统交易，由出块者打包）
+// Data携带完整证据，执行时重新验证，罚没金额转入创世地址
+func NewSlashTx(evidence *DoubleSignEvidence, amount uint64) (*Transaction, error) {
+	data, err := json.Marshal(evidence)
+	if err != nil {
+		return nil, err
+	}
+	return &Transaction{
+		Type:      TxSlash,
+		From:      evidence.Offender(),
+		To:        GenesisAddress,
+		Amount:    amount,
+		GasFee:    0,
+		Nonce:     0,
+		Timestamp: CurrentTimestamp(),
+		Data:      data,
+	}, nil
+}
+
+// SlashEvidence 从惩罚交易中解析双签证据
+func (tx *Transaction) SlashEvidence() (*DoubleSignEvidence, error) {
+	if tx.Type != TxSlash {
+		return nil, fmt.Errorf("not a slash transaction")
+	}
+	var evidence DoubleSignEvidence
+	if err := json.Unmarshal(tx.Data, &evidence); err != nil {
+		return nil, fmt.Errorf("invalid slash evidence: %v", err)
+	}
+	return &evidence, nil
+}
+
+// headerHash 区块头哈希（与Block.Hash一致，但不依赖Block缓存）
+func headerHash(h *BlockHeader) Hash {
+	return CalculateHash(h.Bytes())
+}
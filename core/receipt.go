@@ -0,0 +1,48 @@
+package core
+
+// 交易回执状态
+type ReceiptStatus uint8
+
+const (
+	ReceiptFailed  ReceiptStatus = 0 // 交易已上链但未生效（如伪造签名被没收处理）
+	ReceiptSuccess ReceiptStatus = 1 // 交易执行成功
+)
+
+// 交易回执错误码
+const (
+	ReceiptCodeOK              = ""                 // 成功
+	ReceiptCodeForgedSignature = "forged_signature" // 伪造签名，发送者资金被没收
+	ReceiptCodeForgedAddress   = "forged_address"   // 伪造发送者地址，真实地址资金被没收
+)
+
+// StateChange 交易对单个账户的状态变更
+type StateChange struct {
+	Address         string `json:"address"`
+	AvailableBefore uint64 `json:"available_before"`
+	AvailableAfter  uint64 `json:"available_after"`
+	StakedBefore    uint64 `json:"staked_before"`
+	StakedAfter     uint64 `json:"staked_after"`
+	NonceBefore     uint64 `json:"nonce_before"`
+	NonceAfter      uint64 `json:"nonce_after"`
+}
+
+// Receipt 交易回执（随交易索引一起持久化）
+type Receipt struct {
+	TxHash       string        `json:"tx_hash"`
+	Status       ReceiptStatus `json:"status"`
+	ErrorCode    string        `json:"error_code,omitempty"`
+	Error        string        `json:"error,omitempty"`
+	BlockHeight  uint64        `json:"block_height"`
+	BlockHash    string        `json:"block_hash"`
+	TxIndex      int           `json:"tx_index"`
+	GasUsed      uint64        `json:"gas_used"` // 实际扣除的手续费
+	StateChanges []StateChange `json:"state_changes"`
+}
+
+// StatusString 状态字符串
+func (r *Receipt) StatusString() string {
+	if r.Status == ReceiptSuccess {
+		return "success"
+	}
+	return "failed"
+}
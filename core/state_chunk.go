@@ -0,0 +1,255 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+)
+
+// 分片状态同步：按状态树键的高StateChunkBits位把账户分成StateChunkCount个分片（键即sha256(地址)，分布均匀）
+// 每个分片附带从状态根到该前缀子树的兄弟节点，接收方只凭checkpoint的StateRoot即可单独验证每个分片：
+// 分片中的账户必须恰好是该前缀下的全部账户，多一个、少一个、改一个都会导致根不一致
+// 状态树的压缩路径可能在前缀深度之前就终止（该前缀下只有0或1个账户），此时兄弟节点更少，
+// 终止处可能是另一个分片的账户叶子（与AccountProof的非包含证明相同）
+const (
+	StateChunkBits  = 6
+	StateChunkCount = 1 << StateChunkBits
+)
+
+// StateLeaf 状态树叶子（键 + 账户叶子值）
+type StateLeaf struct {
+	Key   Hash `json:"key"`
+	Value Hash `json:"value"`
+}
+
+// StateChunk 状态分片及其证明
+type StateChunk struct {
+	Index    uint32     `json:"index"`           // 键前缀
+	Accounts []*Account `json:"accounts"`        // 该前缀下的全部账户（按键升序）
+	Siblings []Hash     `json:"siblings"`        // 从根到前缀子树（或提前终止处）的兄弟节点
+	Other    *StateLeaf `json:"other,omitempty"` // 路径提前终止于其他分片的账户叶子
+}
+
+// StateChunkIndex 键所属的分片
+func StateChunkIndex(key Hash) uint32 {
+	var index uint32
+	for depth := 0; depth < StateChunkBits; depth++ {
+		index = index<<1 | uint32(StateKeyBit(key, depth))
+	}
+	return index
+}
+
+// stateChunkBit 分片前缀在指定深度的分支位
+func stateChunkBit(index uint32, depth int) int {
+	return int(index>>uint(StateChunkBits-1-depth)) & 1
+}
+
+// StateSubtreeRoot 由按键升序排列的叶子计算位于depth层的子树根（与存储层重建状态树的规则一致）
+func StateSubtreeRoot(leaves []StateLeaf, depth int) Hash {
+	switch len(leaves) {
+	case 0:
+		return Hash{}
+	case 1:
+		return StateLeafNode(leaves[0].Key, leaves[0].Value)
+	}
+
+	mid := sort.Search(len(leaves), func(i int) bool {
+		return StateKeyBit(leaves[i].Key, depth) == 1
+	})
+	return StateInternalNode(StateSubtreeRoot(leaves[:mid], depth+1), StateSubtreeRoot(leaves[mid:], depth+1))
+}
+
+// sortedStateLeaves 账户按键升序排列，返回对应的叶子
+func sortedStateLeaves(accounts []*Account) ([]*Account, []StateLeaf) {
+	sorted := make([]*Account, len(accounts))
+	copy(sorted, accounts)
+	keys := make(map[*Account]Hash, len(sorted))
+	for _, acc := range sorted {
+		keys[acc] = StateKey(acc.Address)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		ki, kj := keys[sorted[i]], keys[sorted[j]]
+		return bytes.Compare(ki[:], kj[:]) < 0
+	})
+
+	leaves := make([]StateLeaf, len(sorted))
+	for i, acc := range sorted {
+		leaves[i] = StateLeaf{Key: keys[acc], Value: AccountLeafHash(acc)}
+	}
+	return sorted, leaves
+}
+
+// StateRootOf 计算账户集合的状态根（稀疏Merkle树）
+func StateRootOf(accounts []*Account) Hash {
+	_, leaves := sortedStateLeaves(accounts)
+	return StateSubtreeRoot(leaves, 0)
+}
+
+// StateRootAt 按高度选择的状态根算法计算账户集合的状态根
+func StateRootAt(height uint64, accounts []*Account) Hash {
+	if SparseStateRootActive(height) {
+		return StateRootOf(accounts)
+	}
+	return LegacyStateRootOf(accounts)
+}
+
+// LegacyStateRootOf 稀疏Merkle树激活前的状态根：
+// 按地址排序的账户叶子构建二叉Merkle树（SHA-256，奇数节点复制最后一个），保持历史checkpoint可验证
+func LegacyStateRootOf(accounts []*Account) Hash {
+	if len(accounts) == 0 {
+		return Hash{}
+	}
+
+	sorted := make([]*Account, len(accounts))
+	copy(sorted, accounts)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Address < sorted[j].Address
+	})
+
+	level := make([]Hash, len(sorted))
+	for i, acc := range sorted {
+		level[i] = AccountLeafHash(acc)
+	}
+
+	for len(level) > 1 {
+		if len(level)%2 != 0 {
+			level = append(level, level[len(level)-1])
+		}
+		parents := make([]Hash, 0, len(level)/2)
+		for i := 0; i < len(level); i += 2 {
+			data := make([]byte, 0, 64)
+			data = append(data, level[i][:]...)
+			data = append(data, level[i+1][:]...)
+			parents = append(parents, sha256.Sum256(data))
+		}
+		level = parents
+	}
+
+	return level[0]
+}
+
+// BuildStateChunks 把快照账户分成StateChunkCount个分片并生成证明，返回状态根和全部分片
+func BuildStateChunks(accounts []*Account) (Hash, []*StateChunk) {
+	sorted, leaves := sortedStateLeaves(accounts)
+
+	chunks := make([]*StateChunk, StateChunkCount)
+	for i := range chunks {
+		chunks[i] = &StateChunk{Index: uint32(i), Accounts: []*Account{}}
+	}
+
+	// build 计算depth层、前缀为prefix的子树根，并填写其下各分片的账户和兄弟节点
+	var build func(lo, hi int, depth int, prefix uint32) Hash
+	build = func(lo, hi int, depth int, prefix uint32) Hash {
+		span := uint32(1) << uint(StateChunkBits-depth)
+		first := prefix * span
+
+		// 到达前缀深度，或路径在此终止（0或1个账户）
+		if depth == StateChunkBits || hi-lo <= 1 {
+			root := StateSubtreeRoot(leaves[lo:hi], depth)
+			for i := first; i < first+span; i++ {
+				chunks[i].Siblings = make([]Hash, depth)
+			}
+			if hi-lo == 1 && depth < StateChunkBits {
+				owner := StateChunkIndex(leaves[lo].Key)
+				for i := first; i < first+span; i++ {
+					if i != owner {
+						leaf := leaves[lo]
+						chunks[i].Other = &leaf
+					}
+				}
+				chunks[owner].Accounts = append(chunks[owner].Accounts, sorted[lo])
+			} else if depth == StateChunkBits {
+				chunks[first].Accounts = append(chunks[first].Accounts, sorted[lo:hi]...)
+			}
+			return root
+		}
+
+		mid := lo + sort.Search(hi-lo, func(i int) bool {
+			return StateKeyBit(leaves[lo+i].Key, depth) == 1
+		})
+		left := build(lo, mid, depth+1, prefix<<1)
+		right := build(mid, hi, depth+1, prefix<<1|1)
+
+		half := span / 2
+		for i := first; i < first+half; i++ {
+			chunks[i].Siblings[depth] = right
+		}
+		for i := first + half; i < first+span; i++ {
+			chunks[i].Siblings[depth] = left
+		}
+		return StateInternalNode(left, right)
+	}
+
+	root := build(0, len(leaves), 0, 0)
+	return root, chunks
+}
+
+// Verify 验证分片能还原出给定状态根，即分片账户恰好是该前缀下的全部账户
+func (c *StateChunk) Verify(stateRoot Hash) error {
+	if c.Index >= StateChunkCount {
+		return fmt.Errorf("invalid chunk index %d", c.Index)
+	}
+	if len(c.Siblings) > StateChunkBits {
+		return fmt.Errorf("chunk proof too long: %d siblings", len(c.Siblings))
+	}
+
+	leaves := make([]StateLeaf, len(c.Accounts))
+	for i, acc := range c.Accounts {
+		if acc == nil {
+			return fmt.Errorf("nil account in chunk %d", c.Index)
+		}
+		key := StateKey(acc.Address)
+		if StateChunkIndex(key) != c.Index {
+			return fmt.Errorf("account %s does not belong to chunk %d", acc.Address, c.Index)
+		}
+		if i > 0 && bytes.Compare(leaves[i-1].Key[:], key[:]) >= 0 {
+			return fmt.Errorf("chunk %d accounts not sorted by key", c.Index)
+		}
+		leaves[i] = StateLeaf{Key: key, Value: AccountLeafHash(acc)}
+	}
+
+	depth := len(c.Siblings)
+	var node Hash
+	switch {
+	case depth == StateChunkBits:
+		if c.Other != nil {
+			return fmt.Errorf("full-depth chunk proof must not carry another leaf")
+		}
+		node = StateSubtreeRoot(leaves, depth)
+
+	case len(leaves) > 1:
+		return fmt.Errorf("chunk proof terminates at depth %d above %d accounts", depth, len(leaves))
+
+	case len(leaves) == 1:
+		if c.Other != nil {
+			return fmt.Errorf("chunk proof carries both an account and another leaf")
+		}
+		node = StateLeafNode(leaves[0].Key, leaves[0].Value)
+
+	case c.Other != nil:
+		// 终点叶子必须在同一路径上且属于其他分片，才能说明该分片为空
+		if StateChunkIndex(c.Other.Key) == c.Index {
+			return fmt.Errorf("other leaf belongs to chunk %d", c.Index)
+		}
+		for i := 0; i < depth; i++ {
+			if StateKeyBit(c.Other.Key, i) != stateChunkBit(c.Index, i) {
+				return fmt.Errorf("other leaf is not on the chunk path at depth %d", i)
+			}
+		}
+		node = StateLeafNode(c.Other.Key, c.Other.Value)
+	}
+
+	for i := depth - 1; i >= 0; i-- {
+		if stateChunkBit(c.Index, i) == 0 {
+			node = StateInternalNode(node, c.Siblings[i])
+		} else {
+			node = StateInternalNode(c.Siblings[i], node)
+		}
+	}
+
+	if node != stateRoot {
+		return fmt.Errorf("chunk %d root mismatch: proof=%s, expected=%s", c.Index, node.String(), stateRoot.String())
+	}
+	return nil
+}
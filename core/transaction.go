@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"time"
+
+	"fan-chain/crypto"
 )
 
 // 交易类型
@@ -16,6 +18,7 @@ const (
 	TxUnstake  TxType = 2 // 取消抵押 - 不收gas fee
 	TxReward   TxType = 3 // 系统奖励 - 不收gas fee
 	TxSlash    TxType = 4 // 惩罚 - 不收gas fee
+	TxUnjail   TxType = 5 // 解除监禁 - 不收gas fee
 )
 
 // RequiresGasFee 判断交易类型是否需要收取gas费
@@ -81,9 +84,18 @@ func (tx *Transaction) Hash() Hash {
 	return CalculateHash(data)
 }
 
+// SignsNonce 交易的nonce是否参与签名
+// 激活时间之后的用户交易由客户端指定nonce并签名，防止签名被换nonce重放
+// 系统交易（奖励/惩罚）无签名，nonce始终不参与
+func (tx *Transaction) SignsNonce() bool {
+	if tx.Type.IsSystemTx() {
+		return false
+	}
+	return tx.Timestamp >= NonceSigningActivationMs()
+}
+
 // 获取签名数据
-// 注意：nonce不参与签名，由节点在SubmitTransaction中自动分配
-// 这样可以防止客户端nonce被篡改，简化客户端逻辑
+// 注意：激活时间之前的历史交易nonce不参与签名（当时由节点自动分配），保持旧格式以便历史区块校验
 func (tx *Transaction) SignData() []byte {
 	buf := new(bytes.Buffer)
 
@@ -92,7 +104,9 @@ func (tx *Transaction) SignData() []byte {
 	buf.WriteString(tx.To)
 	buf.Write(Uint64ToBytes(tx.Amount))
 	buf.Write(Uint64ToBytes(tx.GasFee))
-	// Nonce不参与签名 - 由节点自动分配
+	if tx.SignsNonce() {
+		buf.Write(Uint64ToBytes(tx.Nonce))
+	}
 	buf.Write(Uint64ToBytes(uint64(tx.Timestamp)))
 
 	if len(tx.Data) > 0 {
@@ -125,11 +139,34 @@ func (tx *Transaction) VerifySignature() error {
 		return fmt.Errorf("missing public key")
 	}
 
-	// 3. 验证签名（需要导入crypto包）
-	// 实际验证在state manager中进行
+	// 3. 密码学校验签名
+	// 伪造签名的交易一旦上链会触发没收发送者资金，入池和转发前必须拦截
+	if !crypto.Verify(tx.PublicKey, tx.SignData(), tx.Signature) {
+		return fmt.Errorf("invalid signature")
+	}
+
 	return nil
 }
 
+// VRF公钥登记前缀：质押交易Data = 前缀 || 33字节压缩公钥
+// 金额为0的登记交易只更新公钥，不改变质押和节点类型
+var vrfRegistrationPrefix = []byte("FAN-VRF:")
+
+// VRFRegistrationData 构造VRF公钥登记的Data字段
+func VRFRegistrationData(publicKey []byte) []byte {
+	data := make([]byte, 0, len(vrfRegistrationPrefix)+len(publicKey))
+	data = append(data, vrfRegistrationPrefix...)
+	return append(data, publicKey...)
+}
+
+// VRFRegistrationKey 从质押交易中取出登记的VRF公钥
+func (tx *Transaction) VRFRegistrationKey() ([]byte, bool) {
+	if tx.Type != TxStake || !bytes.HasPrefix(tx.Data, vrfRegistrationPrefix) {
+		return nil, false
+	}
+	return tx.Data[len(vrfRegistrationPrefix):], true
+}
+
 // 验证交易
 // skipTimestampCheck: true表示跳过时间戳验证（用于同步历史区块），false表示严格验证（用于API提交的新交易）
 func (tx *Transaction) Validate(skipTimestampCheck bool) error {
@@ -144,7 +181,7 @@ func (tx *Transaction) Validate(skipTimestampCheck bool) error {
 	}
 
 	// 3. 禁止非创世地址给自己转账（创世地址和质押/取消质押除外）
-	if tx.From == tx.To && tx.From != GenesisAddress && tx.Type != TxStake && tx.Type != TxUnstake {
+	if tx.From == tx.To && tx.From != GenesisAddress && tx.Type != TxStake && tx.Type != TxUnstake && tx.Type != TxUnjail {
 		return fmt.Errorf("cannot transfer to yourself (only genesis address allowed)")
 	}
 
@@ -165,6 +202,11 @@ func (tx *Transaction) Validate(skipTimestampCheck bool) error {
 		}
 	}
 
+	// 4.1 解除监禁交易只能发给自己，不携带金额
+	if tx.Type == TxUnjail && (tx.From != tx.To || tx.Amount != 0) {
+		return fmt.Errorf("unjail transaction must be sent to self with zero amount")
+	}
+
 	// 6. 检查Data字段长度（从共识参数读取）
 	consensusConfig := GetConsensusConfig()
 	if uint64(len(tx.Data)) > consensusConfig.TransactionParams.MaxDataSize {
@@ -172,6 +214,15 @@ func (tx *Transaction) Validate(skipTimestampCheck bool) error {
 			len(tx.Data), consensusConfig.TransactionParams.MaxDataSize)
 	}
 
+	// 6.1 VRF公钥登记必须携带合法公钥
+	if tx.Type == TxStake {
+		if key, ok := tx.VRFRegistrationKey(); ok {
+			if err := crypto.ValidateVRFPublicKey(key); err != nil {
+				return fmt.Errorf("invalid VRF registration: %v", err)
+			}
+		}
+	}
+
 	// 7. 验证时间戳（防止时间戳伪造）
 	// 注意：同步历史区块时跳过此检查，因为历史交易的时间戳可能与当前时间相差很远
 	// 时间戳使用毫秒级
@@ -232,6 +283,8 @@ func (tx *Transaction) TypeString() string {
 		return "Reward"
 	case TxSlash:
 		return "Slash"
+	case TxUnjail:
+		return "Unjail"
 	default:
 		return "Unknown"
 	}
@@ -0,0 +1,189 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+)
+
+// 交易包含证明类型
+const (
+	TxProofMerkle = "merkle" // Merkle路径证明（激活高度之后的区块）
+	TxProofFlat   = "flat"   // 旧格式区块：提供全部交易哈希，验证者重新计算拼接哈希
+)
+
+// TxInclusionProof 交易包含在区块TxRoot中的证明
+type TxInclusionProof struct {
+	Type        string   `json:"type"`
+	TxHash      string   `json:"tx_hash"`
+	TxIndex     int      `json:"tx_index"`
+	BlockHeight uint64   `json:"block_height"`
+	TxRoot      string   `json:"tx_root"`
+	Siblings    []string `json:"siblings,omitempty"` // Merkle证明：从叶子到根的兄弟节点
+	Leaves      []string `json:"leaves,omitempty"`   // 旧格式证明：区块内全部交易哈希
+}
+
+// MerkleRoot 计算二叉Merkle树根（奇数节点复制最后一个，与state/merkle.go一致）
+func MerkleRoot(leaves []Hash) Hash {
+	if len(leaves) == 0 {
+		return Hash{}
+	}
+
+	level := make([]Hash, len(leaves))
+	copy(level, leaves)
+
+	for len(level) > 1 {
+		if len(level)%2 != 0 {
+			level = append(level, level[len(level)-1])
+		}
+		parents := make([]Hash, 0, len(level)/2)
+		for i := 0; i < len(level); i += 2 {
+			parents = append(parents, merkleHashPair(level[i], level[i+1]))
+		}
+		level = parents
+	}
+
+	return level[0]
+}
+
+// MerkleProof 生成第index个叶子的兄弟路径（从叶子到根）
+func MerkleProof(leaves []Hash, index int) ([]Hash, error) {
+	if index < 0 || index >= len(leaves) {
+		return nil, fmt.Errorf("leaf index %d out of range (%d leaves)", index, len(leaves))
+	}
+
+	level := make([]Hash, len(leaves))
+	copy(level, leaves)
+
+	siblings := make([]Hash, 0)
+	for len(level) > 1 {
+		if len(level)%2 != 0 {
+			level = append(level, level[len(level)-1])
+		}
+		siblings = append(siblings, level[index^1])
+
+		parents := make([]Hash, 0, len(level)/2)
+		for i := 0; i < len(level); i += 2 {
+			parents = append(parents, merkleHashPair(level[i], level[i+1]))
+		}
+		level = parents
+		index /= 2
+	}
+
+	return siblings, nil
+}
+
+// VerifyMerkleProof 验证叶子通过兄弟路径能否算出根
+func VerifyMerkleProof(leaf Hash, index int, siblings []Hash, root Hash) bool {
+	if index < 0 {
+		return false
+	}
+	current := leaf
+	for _, sibling := range siblings {
+		if index%2 == 0 {
+			current = merkleHashPair(current, sibling)
+		} else {
+			current = merkleHashPair(sibling, current)
+		}
+		index /= 2
+	}
+	return index == 0 && current == root
+}
+
+func merkleHashPair(left, right Hash) Hash {
+	data := make([]byte, 0, 64)
+	data = append(data, left[:]...)
+	data = append(data, right[:]...)
+	return CalculateHash(data)
+}
+
+// TxProof 生成区块内第index笔交易的包含证明
+func (b *Block) TxProof(index int) (*TxInclusionProof, error) {
+	if index < 0 || index >= len(b.Transactions) {
+		return nil, fmt.Errorf("tx index %d out of range (%d txs)", index, len(b.Transactions))
+	}
+
+	hashes := b.TxHashes()
+	proof := &TxInclusionProof{
+		TxHash:      hashes[index].String(),
+		TxIndex:     index,
+		BlockHeight: b.Header.Height,
+		TxRoot:      b.Header.TxRoot.String(),
+	}
+
+	if b.UsesMerkleTxRoot() {
+		siblings, err := MerkleProof(hashes, index)
+		if err != nil {
+			return nil, err
+		}
+		proof.Type = TxProofMerkle
+		proof.Siblings = make([]string, len(siblings))
+		for i, sibling := range siblings {
+			proof.Siblings[i] = sibling.String()
+		}
+		return proof, nil
+	}
+
+	proof.Type = TxProofFlat
+	proof.Leaves = make([]string, len(hashes))
+	for i, h := range hashes {
+		proof.Leaves[i] = h.String()
+	}
+	return proof, nil
+}
+
+// Verify 验证证明与给定TxRoot一致（TxRoot应来自可信的区块头）
+func (p *TxInclusionProof) Verify(txRoot Hash) error {
+	txHash, err := hexToHash(p.TxHash)
+	if err != nil {
+		return fmt.Errorf("invalid tx hash: %v", err)
+	}
+
+	switch p.Type {
+	case TxProofMerkle:
+		siblings := make([]Hash, len(p.Siblings))
+		for i, s := range p.Siblings {
+			if siblings[i], err = hexToHash(s); err != nil {
+				return fmt.Errorf("invalid sibling %d: %v", i, err)
+			}
+		}
+		if !VerifyMerkleProof(txHash, p.TxIndex, siblings, txRoot) {
+			return fmt.Errorf("merkle proof does not match tx root %s", txRoot.String())
+		}
+		return nil
+
+	case TxProofFlat:
+		if p.TxIndex < 0 || p.TxIndex >= len(p.Leaves) {
+			return fmt.Errorf("tx index %d out of range (%d leaves)", p.TxIndex, len(p.Leaves))
+		}
+		buf := new(bytes.Buffer)
+		for i, leaf := range p.Leaves {
+			h, err := hexToHash(leaf)
+			if err != nil {
+				return fmt.Errorf("invalid leaf %d: %v", i, err)
+			}
+			if i == p.TxIndex && h != txHash {
+				return fmt.Errorf("leaf %d does not match tx hash", i)
+			}
+			buf.Write(h.Bytes())
+		}
+		if CalculateHash(buf.Bytes()) != txRoot {
+			return fmt.Errorf("flat proof does not match tx root %s", txRoot.String())
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown proof type: %s", p.Type)
+	}
+}
+
+func hexToHash(s string) (Hash, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return Hash{}, err
+	}
+	if len(b) != 32 {
+		return Hash{}, fmt.Errorf("expected 32 bytes, got %d", len(b))
+	}
+	return BytesToHash(b), nil
+}
@@ -55,6 +55,61 @@ func BlockReward() uint64 {
 	return consensusConfig.EconomicParams.BaseBlockReward
 }
 
+// 该高度的区块TxRoot是否使用二叉Merkle树
+func MerkleTxRootActive(height uint64) bool {
+	activation := consensusConfig.BlockParams.MerkleTxRootHeight
+	return activation > 0 && height >= activation
+}
+
+// ECVRF激活高度（0表示未启用）
+func VRFActivationHeight() uint64 {
+	return consensusConfig.BlockParams.VRFActivationHeight
+}
+
+// 该高度的区块是否必须携带ECVRF证明
+func VRFActive(height uint64) bool {
+	activation := consensusConfig.BlockParams.VRFActivationHeight
+	return activation > 0 && height >= activation
+}
+
+// 该高度的状态根是否使用稀疏Merkle树
+func SparseStateRootActive(height uint64) bool {
+	activation := consensusConfig.BlockParams.SparseStateRootHeight
+	return activation > 0 && height >= activation
+}
+
+// 该高度的区块头是否必须携带执行后的状态根
+func StateRootActive(height uint64) bool {
+	activation := consensusConfig.BlockParams.StateRootActivationHeight
+	return activation > 0 && height >= activation
+}
+
+// 该高度的解押是否进入解绑期（未启用时解押立即到账）
+func UnbondingActive(height uint64) bool {
+	activation := consensusConfig.EconomicParams.UnbondingActivationHeight
+	return activation > 0 && height >= activation && consensusConfig.EconomicParams.UnbondingBlocks > 0
+}
+
+// 解绑等待区块数
+func UnbondingBlocks() uint64 {
+	return consensusConfig.EconomicParams.UnbondingBlocks
+}
+
+// 监禁后至少经过的区块数（到期前不能解除监禁）
+func JailBlocks() uint64 {
+	return consensusConfig.SecurityParams.JailBlocks
+}
+
+// 客户端签名nonce激活时间（毫秒）
+func NonceSigningActivationMs() int64 {
+	return consensusConfig.TransactionParams.NonceSigningActivationMs
+}
+
+// 交易池允许的最大未来nonce间隔
+func MaxNonceGap() uint64 {
+	return consensusConfig.TransactionParams.MaxNonceGap
+}
+
 // 出块参数
 func BlockInterval() int {
 	return consensusConfig.BlockParams.BlockIntervalSeconds
@@ -86,6 +141,11 @@ func GenesisTimestamp() int64 {
 	return consensusConfig.ChainParams.GenesisTimestamp
 }
 
+// 网络标识
+func NetworkID() string {
+	return consensusConfig.ChainParams.NetworkID
+}
+
 // Hash类型
 type Hash [32]byte
 
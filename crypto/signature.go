@@ -7,7 +7,6 @@ import (
 	"io"
 
 	"github.com/cloudflare/circl/sign/mldsa/mldsa65"
-	"golang.org/x/crypto/sha3"
 )
 
 // ML-DSA-65 签名和验证
@@ -74,66 +73,6 @@ func PrivateKeyToPublic(privateKeyBytes []byte) ([]byte, error) {
 	return nil, fmt.Errorf("please store public key separately")
 }
 
-// VRF相关（简化版：使用签名模拟VRF）
-// 注意：这不是真正的VRF，只是用于MVP阶段
-// 后续需要使用真正的VRF库（如 github.com/coniks-sys/coniks-go/crypto/vrf）
-
-// VRF证明和输出
-type VRFProof struct {
-	Proof  []byte
-	Output []byte
-}
-
-// 计算VRF（MVP：用签名代替）
-func ComputeVRF(privateKeyBytes, seed []byte) (*VRFProof, error) {
-	// 签名种子
-	signature, err := Sign(privateKeyBytes, seed)
-	if err != nil {
-		return nil, err
-	}
-
-	// 输出 = Hash(signature)
-	output := hash256(signature)
-
-	return &VRFProof{
-		Proof:  signature,
-		Output: output,
-	}, nil
-}
-
-// 验证VRF（MVP：验证签名）
-func VerifyVRF(publicKeyBytes, seed []byte, proof *VRFProof) bool {
-	// 验证签名
-	var pub mldsa65.PublicKey
-	if err := pub.UnmarshalBinary(publicKeyBytes); err != nil {
-		return false
-	}
-
-	if !mldsa65.Verify(&pub, seed, nil, proof.Proof) {
-		return false
-	}
-
-	// 验证输出
-	expectedOutput := hash256(proof.Proof)
-	if len(expectedOutput) != len(proof.Output) {
-		return false
-	}
-
-	for i := range expectedOutput {
-		if expectedOutput[i] != proof.Output[i] {
-			return false
-		}
-	}
-
-	return true
-}
-
-// 辅助函数：SHA3-256哈希
-func hash256(data []byte) []byte {
-	hash := sha3.Sum256(data)
-	return hash[:]
-}
-
 func min(a, b int) int {
 	if a < b {
 		return a
@@ -0,0 +1,278 @@
+package crypto
+
+import (
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+)
+
+// ECVRF-P256-SHA256-TAI（RFC 9381, suite 0x01）
+// - 证明 pi = Gamma(33字节压缩点) || c(16字节) || s(32字节)，共81字节
+// - 输出 beta = SHA256(0x01 || 0x03 || Gamma || 0x00)，32字节
+// - nonce按RFC 6979确定性生成：同一私钥和输入只有唯一的证明和输出
+// VRF密钥由节点ML-DSA私钥派生（DeriveVRFKey），公钥通过质押交易登记到链上
+
+const (
+	VRFPublicKeySize = 33
+	VRFProofSize     = 81
+	VRFOutputSize    = 32
+
+	vrfSuite        = 0x01
+	vrfChallengeLen = 16
+	vrfScalarLen    = 32
+)
+
+var vrfCurve = elliptic.P256()
+
+// VRFPrivateKey ECVRF私钥
+type VRFPrivateKey struct {
+	x         *big.Int
+	PublicKey []byte // 压缩格式公钥（33字节）
+}
+
+// NewVRFPrivateKey 从32字节标量创建私钥
+func NewVRFPrivateKey(scalar []byte) (*VRFPrivateKey, error) {
+	n := vrfCurve.Params().N
+	x := new(big.Int).SetBytes(scalar)
+	if x.Sign() == 0 || x.Cmp(n) >= 0 {
+		return nil, fmt.Errorf("invalid VRF private key scalar")
+	}
+
+	px, py := vrfCurve.ScalarBaseMult(padScalar(x))
+	return &VRFPrivateKey{
+		x:         x,
+		PublicKey: elliptic.MarshalCompressed(vrfCurve, px, py),
+	}, nil
+}
+
+// DeriveVRFKey 由ML-DSA私钥确定性派生VRF私钥（节点无需额外保存密钥文件）
+func DeriveVRFKey(signingKey []byte) (*VRFPrivateKey, error) {
+	if len(signingKey) == 0 {
+		return nil, fmt.Errorf("empty private key")
+	}
+
+	n := vrfCurve.Params().N
+	for counter := byte(0); counter < 255; counter++ {
+		h := sha256.New()
+		h.Write([]byte("FAN-ECVRF-P256-KEY"))
+		h.Write(signingKey)
+		h.Write([]byte{counter})
+		scalar := h.Sum(nil)
+
+		x := new(big.Int).SetBytes(scalar)
+		if x.Sign() != 0 && x.Cmp(n) < 0 {
+			return NewVRFPrivateKey(scalar)
+		}
+	}
+	return nil, fmt.Errorf("failed to derive VRF key")
+}
+
+// ValidateVRFPublicKey 检查公钥是否为合法的压缩曲线点
+func ValidateVRFPublicKey(publicKey []byte) error {
+	if len(publicKey) != VRFPublicKeySize {
+		return fmt.Errorf("invalid VRF public key length %d", len(publicKey))
+	}
+	if x, _ := elliptic.UnmarshalCompressed(vrfCurve, publicKey); x == nil {
+		return fmt.Errorf("invalid VRF public key")
+	}
+	return nil
+}
+
+// Prove 计算VRF证明和输出
+func (sk *VRFPrivateKey) Prove(alpha []byte) (proof, output []byte, err error) {
+	hx, hy, err := vrfEncodeToCurve(sk.PublicKey, alpha)
+	if err != nil {
+		return nil, nil, err
+	}
+	hString := elliptic.MarshalCompressed(vrfCurve, hx, hy)
+
+	// Gamma = x*H
+	gx, gy := vrfCurve.ScalarMult(hx, hy, padScalar(sk.x))
+
+	// k = RFC6979(x, SHA256(h_string))
+	digest := sha256.Sum256(hString)
+	k := rfc6979Nonce(sk.x, digest[:])
+
+	ux, uy := vrfCurve.ScalarBaseMult(padScalar(k))
+	vx, vy := vrfCurve.ScalarMult(hx, hy, padScalar(k))
+
+	c := vrfChallenge(sk.PublicKey, hString,
+		elliptic.MarshalCompressed(vrfCurve, gx, gy),
+		elliptic.MarshalCompressed(vrfCurve, ux, uy),
+		elliptic.MarshalCompressed(vrfCurve, vx, vy))
+
+	// s = (k + c*x) mod q
+	n := vrfCurve.Params().N
+	s := new(big.Int).Mul(c, sk.x)
+	s.Add(s, k)
+	s.Mod(s, n)
+
+	proof = make([]byte, 0, VRFProofSize)
+	proof = append(proof, elliptic.MarshalCompressed(vrfCurve, gx, gy)...)
+	proof = append(proof, leftPad(c.Bytes(), vrfChallengeLen)...)
+	proof = append(proof, leftPad(s.Bytes(), vrfScalarLen)...)
+
+	output, err = VRFProofToHash(proof)
+	if err != nil {
+		return nil, nil, err
+	}
+	return proof, output, nil
+}
+
+// VerifyVRFProof 验证VRF证明，成功时返回唯一输出
+func VerifyVRFProof(publicKey, alpha, proof []byte) ([]byte, error) {
+	if err := ValidateVRFPublicKey(publicKey); err != nil {
+		return nil, err
+	}
+	yx, yy := elliptic.UnmarshalCompressed(vrfCurve, publicKey)
+
+	gx, gy, c, s, err := vrfDecodeProof(proof)
+	if err != nil {
+		return nil, err
+	}
+
+	hx, hy, err := vrfEncodeToCurve(publicKey, alpha)
+	if err != nil {
+		return nil, err
+	}
+
+	n := vrfCurve.Params().N
+	negC := new(big.Int).Sub(n, c)
+	negC.Mod(negC, n)
+
+	// U = s*B - c*Y
+	sbx, sby := vrfCurve.ScalarBaseMult(padScalar(s))
+	cyx, cyy := vrfCurve.ScalarMult(yx, yy, padScalar(negC))
+	ux, uy := vrfCurve.Add(sbx, sby, cyx, cyy)
+
+	// V = s*H - c*Gamma
+	shx, shy := vrfCurve.ScalarMult(hx, hy, padScalar(s))
+	cgx, cgy := vrfCurve.ScalarMult(gx, gy, padScalar(negC))
+	vx, vy := vrfCurve.Add(shx, shy, cgx, cgy)
+
+	expected := vrfChallenge(publicKey,
+		elliptic.MarshalCompressed(vrfCurve, hx, hy),
+		proof[:VRFPublicKeySize],
+		elliptic.MarshalCompressed(vrfCurve, ux, uy),
+		elliptic.MarshalCompressed(vrfCurve, vx, vy))
+
+	if expected.Cmp(c) != 0 {
+		return nil, fmt.Errorf("invalid VRF proof")
+	}
+
+	return VRFProofToHash(proof)
+}
+
+// VRFProofToHash 由证明计算输出（P-256余因子为1）
+func VRFProofToHash(proof []byte) ([]byte, error) {
+	if _, _, _, _, err := vrfDecodeProof(proof); err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+	h.Write([]byte{vrfSuite, 0x03})
+	h.Write(proof[:VRFPublicKeySize])
+	h.Write([]byte{0x00})
+	return h.Sum(nil), nil
+}
+
+// vrfDecodeProof 解析 Gamma || c || s
+func vrfDecodeProof(proof []byte) (gx, gy, c, s *big.Int, err error) {
+	if len(proof) != VRFProofSize {
+		return nil, nil, nil, nil, fmt.Errorf("invalid VRF proof length %d", len(proof))
+	}
+
+	gx, gy = elliptic.UnmarshalCompressed(vrfCurve, proof[:VRFPublicKeySize])
+	if gx == nil {
+		return nil, nil, nil, nil, fmt.Errorf("invalid VRF proof point")
+	}
+
+	c = new(big.Int).SetBytes(proof[VRFPublicKeySize : VRFPublicKeySize+vrfChallengeLen])
+	s = new(big.Int).SetBytes(proof[VRFPublicKeySize+vrfChallengeLen:])
+	if s.Cmp(vrfCurve.Params().N) >= 0 {
+		return nil, nil, nil, nil, fmt.Errorf("invalid VRF proof scalar")
+	}
+	return gx, gy, c, s, nil
+}
+
+// vrfEncodeToCurve try-and-increment：哈希到曲线点
+func vrfEncodeToCurve(publicKey, alpha []byte) (*big.Int, *big.Int, error) {
+	for ctr := 0; ctr < 256; ctr++ {
+		h := sha256.New()
+		h.Write([]byte{vrfSuite, 0x01})
+		h.Write(publicKey)
+		h.Write(alpha)
+		h.Write([]byte{byte(ctr), 0x00})
+
+		candidate := append([]byte{0x02}, h.Sum(nil)...)
+		if x, y := elliptic.UnmarshalCompressed(vrfCurve, candidate); x != nil {
+			return x, y, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("VRF encode to curve failed")
+}
+
+// vrfChallenge c = SHA256(suite || 0x02 || Y || H || Gamma || U || V || 0x00) 截断为16字节
+func vrfChallenge(points ...[]byte) *big.Int {
+	h := sha256.New()
+	h.Write([]byte{vrfSuite, 0x02})
+	for _, p := range points {
+		h.Write(p)
+	}
+	h.Write([]byte{0x00})
+	return new(big.Int).SetBytes(h.Sum(nil)[:vrfChallengeLen])
+}
+
+// rfc6979Nonce RFC 6979 第3.2节确定性nonce（HMAC-SHA256, qlen=256）
+func rfc6979Nonce(x *big.Int, digest []byte) *big.Int {
+	n := vrfCurve.Params().N
+
+	xBytes := padScalar(x)
+	h1 := new(big.Int).SetBytes(digest)
+	h1.Mod(h1, n)
+	hBytes := padScalar(h1)
+
+	v := make([]byte, 32)
+	k := make([]byte, 32)
+	for i := range v {
+		v[i] = 0x01
+	}
+
+	mac := func(key []byte, parts ...[]byte) []byte {
+		m := hmac.New(sha256.New, key)
+		for _, p := range parts {
+			m.Write(p)
+		}
+		return m.Sum(nil)
+	}
+
+	k = mac(k, v, []byte{0x00}, xBytes, hBytes)
+	v = mac(k, v)
+	k = mac(k, v, []byte{0x01}, xBytes, hBytes)
+	v = mac(k, v)
+
+	for {
+		v = mac(k, v)
+		candidate := new(big.Int).SetBytes(v)
+		if candidate.Sign() > 0 && candidate.Cmp(n) < 0 {
+			return candidate
+		}
+		k = mac(k, v, []byte{0x00})
+		v = mac(k, v)
+	}
+}
+
+func padScalar(x *big.Int) []byte {
+	return leftPad(x.Bytes(), vrfScalarLen)
+}
+
+func leftPad(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	out := make([]byte, size)
+	copy(out[size-len(b):], b)
+	return out
+}
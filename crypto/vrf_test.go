@@ -0,0 +1,105 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+	"testing"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("bad hex %q: %v", s, err)
+	}
+	return b
+}
+
+// RFC 9381 附录B.1 Example 10（ECVRF-P256-SHA256-TAI）
+func TestECVRFP256Vector(t *testing.T) {
+	sk, err := NewVRFPrivateKey(mustHex(t, "c9afa9d845ba75166b5c215767b1d6934e50c3db36e89b127b8a622b120f6721"))
+	if err != nil {
+		t.Fatalf("new key: %v", err)
+	}
+
+	wantPK := mustHex(t, "0360fed4ba255a9d31c961eb74c6356d68c049b8923b61fa6ce669622e60f29fb6")
+	wantPi := mustHex(t, "035b5c726e8c0e2c488a107c600578ee75cb702343c153cb1eb8dec77f4b5071b4"+
+		"a53f0a46f018bc2c56e58d383f2305e0"+
+		"975972c26feea0eb122fe7893c15af376b33edf7de17c6ea056d4d82de6bc02f")
+	wantBeta := mustHex(t, "a3ad7b0ef73d8fc6655053ea22f9bede8c743f08bbed3d38821f0e16474b505e")
+	alpha := []byte("sample")
+
+	if !bytes.Equal(sk.PublicKey, wantPK) {
+		t.Fatalf("public key = %x, want %x", sk.PublicKey, wantPK)
+	}
+
+	pi, beta, err := sk.Prove(alpha)
+	if err != nil {
+		t.Fatalf("prove: %v", err)
+	}
+	if !bytes.Equal(pi, wantPi) {
+		t.Fatalf("pi = %x, want %x", pi, wantPi)
+	}
+	if !bytes.Equal(beta, wantBeta) {
+		t.Fatalf("beta = %x, want %x", beta, wantBeta)
+	}
+
+	out, err := VerifyVRFProof(wantPK, alpha, wantPi)
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if !bytes.Equal(out, wantBeta) {
+		t.Fatalf("verified output = %x, want %x", out, wantBeta)
+	}
+}
+
+// RFC 6979 附录A.2.5（P-256, SHA-256, "sample"）
+func TestRFC6979Nonce(t *testing.T) {
+	x, _ := new(big.Int).SetString("c9afa9d845ba75166b5c215767b1d6934e50c3db36e89b127b8a622b120f6721", 16)
+	digest := sha256.Sum256([]byte("sample"))
+
+	k := rfc6979Nonce(x, digest[:])
+	want := "a6e3c57dd01abe90086538398355dd4c3b17aa873382b0f24d6129493d8aad60"
+	if hex.EncodeToString(padScalar(k)) != want {
+		t.Fatalf("k = %x, want %s", k, want)
+	}
+}
+
+func TestVRFUniqueAndBound(t *testing.T) {
+	_, signingKey, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("keygen: %v", err)
+	}
+	sk, err := DeriveVRFKey(signingKey)
+	if err != nil {
+		t.Fatalf("derive: %v", err)
+	}
+	again, _ := DeriveVRFKey(signingKey)
+	if !bytes.Equal(sk.PublicKey, again.PublicKey) {
+		t.Fatalf("key derivation is not deterministic")
+	}
+
+	alpha := []byte("block-seed")
+	pi1, out1, _ := sk.Prove(alpha)
+	pi2, out2, _ := sk.Prove(alpha)
+	if !bytes.Equal(pi1, pi2) || !bytes.Equal(out1, out2) {
+		t.Fatalf("VRF is not deterministic")
+	}
+
+	if _, err := VerifyVRFProof(sk.PublicKey, []byte("other-seed"), pi1); err == nil {
+		t.Fatalf("proof accepted for a different input")
+	}
+
+	other, _ := NewVRFPrivateKey(bytes.Repeat([]byte{0x11}, 32))
+	if _, err := VerifyVRFProof(other.PublicKey, alpha, pi1); err == nil {
+		t.Fatalf("proof accepted for a different key")
+	}
+
+	tampered := append([]byte{}, pi1...)
+	tampered[VRFProofSize-1] ^= 0x01
+	if _, err := VerifyVRFProof(sk.PublicKey, alpha, tampered); err == nil {
+		t.Fatalf("tampered proof accepted")
+	}
+}
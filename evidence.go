@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"fan-chain/core"
+)
+
+// HandleEvidence 处理网络层检测到或收到的双签证据：校验可罚没后加入证据池
+func (n *Node) HandleEvidence(evidence *core.DoubleSignEvidence) error {
+	if n.evidencePool.Has(evidence.Hash()) {
+		return fmt.Errorf("evidence already known")
+	}
+
+	if _, err := n.state.SlashableAmount(evidence); err != nil {
+		return err
+	}
+
+	if !n.evidencePool.Add(evidence) {
+		return fmt.Errorf("evidence pool full")
+	}
+
+	log.Printf("🚨 [EVIDENCE] Double sign by %s at height %d added to evidence pool",
+		evidence.Offender(), evidence.Height())
+	return nil
+}
+
+// createSlashTransactions 为证据池中的证据生成惩罚交易
+// 同一出块者每个区块只罚没一次（罚没金额按出块前的质押计算），其余证据留到后续区块
+func (n *Node) createSlashTransactions() []*core.Transaction {
+	txs := make([]*core.Transaction, 0)
+	slashed := make(map[string]bool)
+
+	for _, evidence := range n.evidencePool.Pending() {
+		if slashed[evidence.Offender()] {
+			continue
+		}
+
+		amount, err := n.state.SlashableAmount(evidence)
+		if err != nil {
+			log.Printf("[EVIDENCE] Dropping evidence against %s at height %d: %v",
+				evidence.Offender(), evidence.Height(), err)
+			n.evidencePool.Remove(evidence.Hash())
+			continue
+		}
+
+		tx, err := core.NewSlashTx(evidence, amount)
+		if err != nil {
+			log.Printf("[EVIDENCE] Failed to create slash tx: %v", err)
+			continue
+		}
+
+		txs = append(txs, tx)
+		slashed[evidence.Offender()] = true
+		log.Printf("⚔️  Including slash tx: %s loses %d staked for double sign at height %d",
+			evidence.Offender(), amount, evidence.Height())
+	}
+
+	return txs
+}
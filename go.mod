@@ -5,13 +5,13 @@ go 1.24.0
 toolchain go1.24.10
 
 require (
+	filippo.io/mlkem768 v0.0.0-20250818110517-29047ffe79fb
 	github.com/cloudflare/circl v1.6.1
 	github.com/syndtr/goleveldb v1.0.0
 	golang.org/x/crypto v0.17.0
 )
 
 require (
-	filippo.io/mlkem768 v0.0.0-20250818110517-29047ffe79fb // indirect
 	github.com/golang/snappy v0.0.0-20180518054509-2e65f85255db // indirect
 	golang.org/x/sys v0.15.0 // indirect
 )
@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"time"
@@ -9,27 +10,37 @@ import (
 	"fan-chain/config"
 )
 
+// loadConfig 加载指定配置文件；未指定时使用当前目录的config.json，不存在则使用默认配置
+func loadConfig(path string) (*config.Config, error) {
+	if path != "" {
+		cfg, err := config.LoadConfig(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load config: %v", err)
+		}
+		return cfg, nil
+	}
+	if _, err := os.Stat("config.json"); err == nil {
+		cfg, err := config.LoadConfig("config.json")
+		if err != nil {
+			return nil, fmt.Errorf("failed to load config.json: %v", err)
+		}
+		return cfg, nil
+	}
+	return config.DefaultConfig(), nil
+}
+
 func main() {
+	// 子命令：fan-chain replay ...（重放区块重新推导状态，见replay.go）
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(runReplay(os.Args[2:]))
+	}
+
 	configPath := flag.String("config", "", "Path to config file")
 	flag.Parse()
 
-	var cfg *config.Config
-	var err error
-
-	if *configPath != "" {
-		cfg, err = config.LoadConfig(*configPath)
-		if err != nil {
-			log.Fatalf("Failed to load config: %v", err)
-		}
-	} else {
-		if _, err := os.Stat("config.json"); err == nil {
-			cfg, err = config.LoadConfig("config.json")
-			if err != nil {
-				log.Fatalf("Failed to load config.json: %v", err)
-			}
-		} else {
-			cfg = config.DefaultConfig()
-		}
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("%v", err)
 	}
 
 	node, err := NewNode(cfg)
@@ -0,0 +1,110 @@
+package mempool
+
+import (
+	"sort"
+	"sync"
+
+	"fan-chain/core"
+)
+
+// DefaultMaxEvidence 证据池默认容量
+const DefaultMaxEvidence = 1000
+
+// EvidencePool 待打包的双签证据
+// 证据由网络层检测或gossip收到，校验后入池；出块者打包为惩罚交易，上链后移除
+type EvidencePool struct {
+	mu       sync.Mutex
+	all      map[core.Hash]*core.DoubleSignEvidence
+	included map[core.Hash]bool // 已上链的证据（防止重复入池）
+	maxSize  int
+}
+
+// NewEvidencePool 创建证据池
+func NewEvidencePool(maxSize int) *EvidencePool {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxEvidence
+	}
+	return &EvidencePool{
+		all:      make(map[core.Hash]*core.DoubleSignEvidence),
+		included: make(map[core.Hash]bool),
+		maxSize:  maxSize,
+	}
+}
+
+// Add 加入证据，返回是否为新证据（已存在、已上链或池满时返回false）
+func (p *EvidencePool) Add(evidence *core.DoubleSignEvidence) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	hash := evidence.Hash()
+	if _, ok := p.all[hash]; ok || p.included[hash] {
+		return false
+	}
+	if len(p.all) >= p.maxSize {
+		return false
+	}
+	p.all[hash] = evidence
+	return true
+}
+
+// Has 证据是否已在池中或已上链
+func (p *EvidencePool) Has(hash core.Hash) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.all[hash]
+	return ok || p.included[hash]
+}
+
+// Pending 待打包证据（按高度排序，同一出块者先罚没较早的双签）
+func (p *EvidencePool) Pending() []*core.DoubleSignEvidence {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	list := make([]*core.DoubleSignEvidence, 0, len(p.all))
+	for _, ev := range p.all {
+		list = append(list, ev)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Height() != list[j].Height() {
+			return list[i].Height() < list[j].Height()
+		}
+		return list[i].Offender() < list[j].Offender()
+	})
+	return list
+}
+
+// Remove 移除证据（已失效，如对应质押已被罚没）
+func (p *EvidencePool) Remove(hash core.Hash) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.all, hash)
+}
+
+// RemoveIncluded 区块上链后移除其中惩罚交易携带的证据
+func (p *EvidencePool) RemoveIncluded(txs []*core.Transaction) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, tx := range txs {
+		if tx.Type != core.TxSlash {
+			continue
+		}
+		evidence, err := tx.SlashEvidence()
+		if err != nil {
+			continue
+		}
+		hash := evidence.Hash()
+		delete(p.all, hash)
+		if len(p.included) >= p.maxSize {
+			p.included = make(map[core.Hash]bool)
+		}
+		p.included[hash] = true
+	}
+}
+
+// Len 池中证据数量
+func (p *EvidencePool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.all)
+}
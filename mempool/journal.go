@@ -0,0 +1,186 @@
+package mempool
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"fan-chain/core"
+)
+
+// journal 交易池持久化日志（每行一条JSON记录）
+// 新交易追加一条交易记录；交易上链/清理后追加一条移除记录（一个区块一条，只fsync一次）
+// 记录数超过池内交易数的compactRatio倍（且不少于compactMinRecords）时整体重写压缩（临时文件+rename，保证原子性）
+// 旧版本journal每行是一笔交易，按交易记录读取
+type journal struct {
+	path    string
+	records int // 当前文件中的记录数（压缩时重置为池内交易数）
+	mu      sync.Mutex
+}
+
+const (
+	compactMinRecords = 1000
+	compactRatio      = 2
+)
+
+// journalEntry journal中的一条记录：Tx（加入）或Removed（移除的交易哈希）二选一
+type journalEntry struct {
+	Tx      *core.Transaction `json:"tx,omitempty"`
+	Removed []string          `json:"removed,omitempty"`
+}
+
+func newJournal(path string) *journal {
+	return &journal{path: path}
+}
+
+// load 按顺序重放journal记录，返回仍在池中的交易，损坏的行直接跳过
+func (j *journal) load() ([]*core.Transaction, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	file, err := os.Open(j.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	live := make(map[string]*core.Transaction)
+	order := make([]string, 0)
+	records := 0
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var entry journalEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			continue
+		}
+		if entry.Tx == nil && entry.Removed == nil {
+			// 旧格式：整行是一笔交易
+			var tx core.Transaction
+			if err := json.Unmarshal(line, &tx); err != nil {
+				continue
+			}
+			entry.Tx = &tx
+		}
+		records++
+
+		if entry.Tx != nil {
+			hash := entry.Tx.Hash().String()
+			if _, ok := live[hash]; !ok {
+				order = append(order, hash)
+			}
+			live[hash] = entry.Tx
+		}
+		for _, hash := range entry.Removed {
+			delete(live, hash)
+		}
+	}
+	j.records = records
+
+	txs := make([]*core.Transaction, 0, len(live))
+	for _, hash := range order {
+		if tx, ok := live[hash]; ok {
+			txs = append(txs, tx)
+			delete(live, hash)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return txs, fmt.Errorf("failed to scan journal: %v", err)
+	}
+
+	return txs, nil
+}
+
+// append 追加一笔交易
+func (j *journal) append(tx *core.Transaction) error {
+	return j.write(journalEntry{Tx: tx})
+}
+
+// remove 追加一条移除记录
+func (j *journal) remove(txs []*core.Transaction) error {
+	hashes := make([]string, len(txs))
+	for i, tx := range txs {
+		hashes[i] = tx.Hash().String()
+	}
+	return j.write(journalEntry{Removed: hashes})
+}
+
+func (j *journal) write(entry journalEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(j.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	j.records++
+	return file.Sync()
+}
+
+// needsCompaction 移除记录累积过多（相对池内交易数）时需要重写
+func (j *journal) needsCompaction(poolSize int) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.records >= compactMinRecords && j.records > compactRatio*poolSize
+}
+
+// rotate 用当前池内容重写journal（压缩）
+func (j *journal) rotate(txs []*core.Transaction) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	tmpPath := j.path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	for _, tx := range txs {
+		data, err := json.Marshal(journalEntry{Tx: tx})
+		if err != nil {
+			continue
+		}
+		writer.Write(data)
+		writer.WriteByte('\n')
+	}
+
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	file.Close()
+
+	if err := os.Rename(tmpPath, j.path); err != nil {
+		return err
+	}
+	j.records = len(txs)
+	return nil
+}
@@ -0,0 +1,94 @@
+package mempool
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"fan-chain/core"
+)
+
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Count(string(data), "\n")
+}
+
+// 崩溃后从journal恢复：加入和移除记录按顺序重放，已上链、已替换的交易不恢复
+func TestJournalRecovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "txpool.journal")
+	pool := NewTxPool(100, path)
+
+	included, kept := testTx("F1alice", 0, 1), testTx("F1alice", 1, 1)
+	replaced, replacement := testTx("F1bob", 0, 1), testTx("F1bob", 0, 3)
+	for _, tx := range []*core.Transaction{included, kept, replaced, replacement} {
+		if err := pool.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pool.RemoveIncluded([]*core.Transaction{included})
+
+	// 移除只追加一条记录，不重写整个文件
+	if lines := countLines(t, path); lines != 5 {
+		t.Fatalf("journal has %d records, want 4 adds + 1 removal", lines)
+	}
+
+	// 不调用Close，模拟崩溃
+	restored := NewTxPool(100, path)
+	if err := restored.LoadJournal(); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Len() != 2 || !restored.Has(kept.Hash()) || !restored.Has(replacement.Hash()) {
+		t.Fatalf("restored %d txs, want kept + replacement", restored.Len())
+	}
+	if lines := countLines(t, path); lines != 2 {
+		t.Fatalf("journal not compacted after recovery: %d records", lines)
+	}
+}
+
+// 旧版本journal每行是一笔交易，升级后仍能恢复
+func TestJournalLegacyFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "txpool.journal")
+	tx := testTx("F1alice", 0, 1)
+	data, err := tx.ToJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	pool := NewTxPool(100, path)
+	if err := pool.LoadJournal(); err != nil {
+		t.Fatal(err)
+	}
+	if !pool.Has(tx.Hash()) {
+		t.Fatal("legacy journal entry not restored")
+	}
+}
+
+// 移除记录累积过多时压缩
+func TestJournalCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "txpool.journal")
+	pool := NewTxPool(compactMinRecords, path)
+
+	for nonce := uint64(0); nonce < compactMinRecords/2; nonce++ {
+		tx := testTx("F1alice", nonce, 1)
+		if err := pool.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+		pool.RemoveIncluded([]*core.Transaction{tx})
+	}
+	if err := pool.Add(testTx("F1bob", 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	pool.RemoveIncluded([]*core.Transaction{testTx("F1bob", 0, 1)})
+
+	if lines := countLines(t, path); lines >= compactMinRecords {
+		t.Fatalf("journal not compacted: %d records", lines)
+	}
+}
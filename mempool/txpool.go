@@ -0,0 +1,462 @@
+package mempool
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"fan-chain/core"
+)
+
+// DefaultMaxSize 交易池默认容量（交易数）
+const DefaultMaxSize = 10000
+
+// NonceFunc 查询账户当前链上nonce（由状态层提供，避免mempool依赖state包）
+type NonceFunc func(address string) (uint64, error)
+
+// TxPool 内存交易池
+// 交易状态：
+//   - pending: 从链上nonce开始连续的交易，可立即打包
+//   - queued:  nonce之前有空洞的未来交易，等待空洞填补后自动转为pending
+//
+// 索引结构：
+//   - all:      交易哈希 -> 交易（去重）
+//   - bySender: 发送者 -> nonce -> 交易（按账户+nonce定位）
+//
+// 出块时按GasFee从高到低打包，同一账户内严格按nonce递增顺序
+// 容量满时淘汰GasFee最低的交易（只淘汰各账户nonce最大的那笔，避免制造nonce空洞）
+type TxPool struct {
+	mu sync.RWMutex
+
+	all      map[core.Hash]*core.Transaction
+	bySender map[string]map[uint64]*core.Transaction
+
+	maxSize int
+	journal *journal
+}
+
+// NewTxPool 创建交易池
+// journalPath为空时不落盘（仅内存）
+func NewTxPool(maxSize int, journalPath string) *TxPool {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+
+	pool := &TxPool{
+		all:      make(map[core.Hash]*core.Transaction),
+		bySender: make(map[string]map[uint64]*core.Transaction),
+		maxSize:  maxSize,
+	}
+
+	if journalPath != "" {
+		pool.journal = newJournal(journalPath)
+	}
+
+	return pool
+}
+
+// LoadJournal 从journal恢复交易池（崩溃恢复）
+// 恢复后立即重写journal，去掉已失效的记录
+func (p *TxPool) LoadJournal() error {
+	if p.journal == nil {
+		return nil
+	}
+
+	txs, err := p.journal.load()
+	if err != nil {
+		return fmt.Errorf("failed to load txpool journal: %v", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	restored := 0
+	for _, tx := range txs {
+		if err := p.addLocked(tx); err != nil {
+			continue
+		}
+		restored++
+	}
+
+	if err := p.journal.rotate(p.allLocked()); err != nil {
+		return fmt.Errorf("failed to rotate txpool journal: %v", err)
+	}
+
+	log.Printf("📥 [TX_POOL] Restored %d/%d transactions from journal", restored, len(txs))
+	return nil
+}
+
+// Add 将交易加入交易池
+// 调用方负责签名和格式校验，这里只处理索引、去重和容量
+func (p *TxPool) Add(tx *core.Transaction) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.addLocked(tx); err != nil {
+		return err
+	}
+
+	if p.journal != nil {
+		if err := p.journal.append(tx); err != nil {
+			log.Printf("⚠️  [TX_POOL] Failed to journal transaction %x: %v", tx.Hash().Bytes()[:8], err)
+		}
+	}
+
+	return nil
+}
+
+func (p *TxPool) addLocked(tx *core.Transaction) error {
+	txHash := tx.Hash()
+	if _, exists := p.all[txHash]; exists {
+		return fmt.Errorf("transaction already exists in pool (duplicate hash)")
+	}
+
+	// 同账户同nonce：GasFee更高则替换，否则拒绝
+	if byNonce, ok := p.bySender[tx.From]; ok {
+		if existing, exists := byNonce[tx.Nonce]; exists {
+			if tx.GasFee <= existing.GasFee {
+				return fmt.Errorf("replacement transaction underpriced: nonce %d already in pool with gas fee %d (got %d)",
+					tx.Nonce, existing.GasFee, tx.GasFee)
+			}
+			log.Printf("🔁 [TX_POOL] Replacing tx %x with %x (nonce %d, gas fee %d -> %d)",
+				existing.Hash().Bytes()[:8], txHash.Bytes()[:8], tx.Nonce, existing.GasFee, tx.GasFee)
+			p.removeLocked(existing)
+		}
+	}
+
+	// 容量检查：满了则尝试淘汰GasFee更低的交易
+	if len(p.all) >= p.maxSize {
+		victim := p.lowestFeeTailLocked()
+		if victim == nil || victim.GasFee >= tx.GasFee {
+			return fmt.Errorf("txpool is full (%d txs), gas fee %d too low", len(p.all), tx.GasFee)
+		}
+		log.Printf("🗑️  [TX_POOL] Evicting tx %x (gas fee %d) for higher fee tx (gas fee %d)",
+			victim.Hash().Bytes()[:8], victim.GasFee, tx.GasFee)
+		p.removeLocked(victim)
+	}
+
+	p.all[txHash] = tx
+	if p.bySender[tx.From] == nil {
+		p.bySender[tx.From] = make(map[uint64]*core.Transaction)
+	}
+	p.bySender[tx.From][tx.Nonce] = tx
+
+	return nil
+}
+
+// lowestFeeTailLocked 在各账户nonce最大的交易中找GasFee最低的一笔（淘汰候选）
+func (p *TxPool) lowestFeeTailLocked() *core.Transaction {
+	var victim *core.Transaction
+	for _, byNonce := range p.bySender {
+		var tail *core.Transaction
+		for _, tx := range byNonce {
+			if tail == nil || tx.Nonce > tail.Nonce {
+				tail = tx
+			}
+		}
+		if tail == nil {
+			continue
+		}
+		if victim == nil || tail.GasFee < victim.GasFee ||
+			(tail.GasFee == victim.GasFee && tail.Timestamp > victim.Timestamp) {
+			victim = tail
+		}
+	}
+	return victim
+}
+
+func (p *TxPool) removeLocked(tx *core.Transaction) {
+	delete(p.all, tx.Hash())
+	if byNonce, ok := p.bySender[tx.From]; ok {
+		if existing, ok := byNonce[tx.Nonce]; ok && existing.Hash() == tx.Hash() {
+			delete(byNonce, tx.Nonce)
+		}
+		if len(byNonce) == 0 {
+			delete(p.bySender, tx.From)
+		}
+	}
+}
+
+func (p *TxPool) allLocked() []*core.Transaction {
+	txs := make([]*core.Transaction, 0, len(p.all))
+	for _, tx := range p.all {
+		txs = append(txs, tx)
+	}
+	// journal按账户+nonce排序，恢复时顺序稳定
+	sort.Slice(txs, func(i, j int) bool {
+		if txs[i].From != txs[j].From {
+			return txs[i].From < txs[j].From
+		}
+		return txs[i].Nonce < txs[j].Nonce
+	})
+	return txs
+}
+
+// Has 检查交易是否在池中
+func (p *TxPool) Has(hash core.Hash) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	_, ok := p.all[hash]
+	return ok
+}
+
+// Get 按哈希获取池中交易
+func (p *TxPool) Get(hash core.Hash) *core.Transaction {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.all[hash]
+}
+
+// Len 池中交易总数
+func (p *TxPool) Len() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.all)
+}
+
+// PendingCount 池中指定账户的交易数量
+func (p *TxPool) PendingCount(address string) int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.bySender[address])
+}
+
+// SenderStatus 统计账户在池中的pending（可打包）和queued（等待nonce空洞）交易数
+func (p *TxPool) SenderStatus(address string, stateNonce uint64) (pending int, queued int) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	byNonce := p.bySender[address]
+	for nonce := stateNonce; ; nonce++ {
+		if _, ok := byNonce[nonce]; !ok {
+			break
+		}
+		pending++
+	}
+
+	for nonce := range byNonce {
+		if nonce >= stateNonce {
+			queued++
+		}
+	}
+	queued -= pending
+
+	return pending, queued
+}
+
+// NextNonce 返回账户下一个可用nonce：从链上nonce开始，跳过池中已连续占用的nonce
+func (p *TxPool) NextNonce(address string, stateNonce uint64) uint64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	nonce := stateNonce
+	byNonce := p.bySender[address]
+	for {
+		if _, ok := byNonce[nonce]; !ok {
+			return nonce
+		}
+		nonce++
+	}
+}
+
+// Pending 选出可打包的交易（不从池中移除）
+// 规则：
+//  1. 每个账户只取从链上nonce开始连续的交易
+//  2. 跨账户按GasFee从高到低（同价按时间戳先后）
+//  3. 受maxTx（交易数）和maxBytes（交易JSON总大小）限制
+//
+// 交易在区块成功落盘后通过RemoveIncluded移除，出块失败不会丢交易
+func (p *TxPool) Pending(getNonce NonceFunc, maxTx uint64, maxBytes uint64) []*core.Transaction {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	// 每个账户构建按nonce连续的交易队列
+	queues := make(map[string][]*core.Transaction)
+	for sender, byNonce := range p.bySender {
+		stateNonce, err := getNonce(sender)
+		if err != nil {
+			log.Printf("[TX_POOL] Failed to get nonce for %s: %v", sender, err)
+			continue
+		}
+		var queue []*core.Transaction
+		for nonce := stateNonce; ; nonce++ {
+			tx, ok := byNonce[nonce]
+			if !ok {
+				break
+			}
+			queue = append(queue, tx)
+		}
+		if len(queue) > 0 {
+			queues[sender] = queue
+		}
+	}
+
+	// 各账户队首入堆，按GasFee取最高者
+	h := &feeHeap{}
+	for sender, queue := range queues {
+		heap.Push(h, &feeHeapItem{tx: queue[0], sender: sender})
+		queues[sender] = queue[1:]
+	}
+
+	selected := make([]*core.Transaction, 0)
+	var usedBytes uint64
+	for h.Len() > 0 {
+		if maxTx > 0 && uint64(len(selected)) >= maxTx {
+			break
+		}
+
+		item := heap.Pop(h).(*feeHeapItem)
+		size := txSize(item.tx)
+		if maxBytes > 0 && usedBytes+size > maxBytes {
+			// 该账户后续交易依赖此交易的nonce，整个账户本块跳过
+			continue
+		}
+
+		selected = append(selected, item.tx)
+		usedBytes += size
+
+		if queue := queues[item.sender]; len(queue) > 0 {
+			heap.Push(h, &feeHeapItem{tx: queue[0], sender: item.sender})
+			queues[item.sender] = queue[1:]
+		}
+	}
+
+	return selected
+}
+
+// RemoveIncluded 移除已上链的交易（本地出块或同步区块后调用）
+func (p *TxPool) RemoveIncluded(txs []*core.Transaction) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	removed := make([]*core.Transaction, 0)
+	for _, tx := range txs {
+		if tx.Type.IsSystemTx() {
+			continue
+		}
+		if existing, ok := p.bySender[tx.From][tx.Nonce]; ok {
+			// 同nonce的交易已上链，池中无论是否同一笔都已失效
+			p.removeLocked(existing)
+			removed = append(removed, existing)
+		}
+	}
+
+	p.journalRemovedLocked(removed)
+}
+
+// Remove 按哈希移除交易
+func (p *TxPool) Remove(hash core.Hash) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if tx, ok := p.all[hash]; ok {
+		p.removeLocked(tx)
+		p.journalRemovedLocked([]*core.Transaction{tx})
+	}
+}
+
+// Prune 清理失效交易：nonce已被链上消耗，或时间戳超出允许偏移（出块时会被拒绝）
+func (p *TxPool) Prune(getNonce NonceFunc) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now().UnixMilli()
+	maxDrift := core.MaxTimestampDrift()
+
+	stale := make([]*core.Transaction, 0)
+	for sender, byNonce := range p.bySender {
+		stateNonce, err := getNonce(sender)
+		if err != nil {
+			continue
+		}
+		for nonce, tx := range byNonce {
+			if nonce < stateNonce || now-tx.Timestamp > maxDrift {
+				stale = append(stale, tx)
+			}
+		}
+	}
+
+	for _, tx := range stale {
+		p.removeLocked(tx)
+	}
+
+	if len(stale) > 0 {
+		log.Printf("🧹 [TX_POOL] Pruned %d stale transactions, %d remaining", len(stale), len(p.all))
+		p.journalRemovedLocked(stale)
+	}
+
+	return len(stale)
+}
+
+// journalRemovedLocked 记录移除的交易，记录累积过多时压缩journal
+func (p *TxPool) journalRemovedLocked(removed []*core.Transaction) {
+	if p.journal == nil || len(removed) == 0 {
+		return
+	}
+	if p.journal.needsCompaction(len(p.all)) {
+		p.rotateJournalLocked()
+		return
+	}
+	if err := p.journal.remove(removed); err != nil {
+		log.Printf("⚠️  [TX_POOL] Failed to journal removed transactions: %v", err)
+	}
+}
+
+func (p *TxPool) rotateJournalLocked() {
+	if p.journal == nil {
+		return
+	}
+	if err := p.journal.rotate(p.allLocked()); err != nil {
+		log.Printf("⚠️  [TX_POOL] Failed to rotate journal: %v", err)
+	}
+}
+
+// Close 关闭交易池，落盘最新内容
+func (p *TxPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rotateJournalLocked()
+}
+
+// txSize 交易序列化大小（与calculateBlockSize一致使用JSON长度）
+func txSize(tx *core.Transaction) uint64 {
+	data, err := json.Marshal(tx)
+	if err != nil {
+		return 0
+	}
+	return uint64(len(data))
+}
+
+// feeHeap 按GasFee降序的最大堆
+type feeHeapItem struct {
+	tx     *core.Transaction
+	sender string
+}
+
+type feeHeap []*feeHeapItem
+
+func (h feeHeap) Len() int { return len(h) }
+func (h feeHeap) Less(i, j int) bool {
+	if h[i].tx.GasFee != h[j].tx.GasFee {
+		return h[i].tx.GasFee > h[j].tx.GasFee
+	}
+	if h[i].tx.Timestamp != h[j].tx.Timestamp {
+		return h[i].tx.Timestamp < h[j].tx.Timestamp
+	}
+	return h[i].sender < h[j].sender
+}
+func (h feeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *feeHeap) Push(x interface{}) {
+	*h = append(*h, x.(*feeHeapItem))
+}
+func (h *feeHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
@@ -0,0 +1,133 @@
+package mempool
+
+import (
+	"fmt"
+	"testing"
+
+	"fan-chain/core"
+)
+
+func testTx(from string, nonce, gasFee uint64) *core.Transaction {
+	return &core.Transaction{
+		Type:      core.TxTransfer,
+		From:      from,
+		To:        "F1receiver",
+		Amount:    100,
+		GasFee:    gasFee,
+		Nonce:     nonce,
+		Timestamp: 1700000000000 + int64(nonce),
+	}
+}
+
+func nonces(m map[string]uint64) NonceFunc {
+	return func(address string) (uint64, error) {
+		return m[address], nil
+	}
+}
+
+// 同账户同nonce：更高手续费替换，相同或更低拒绝
+func TestReplaceByHigherFee(t *testing.T) {
+	pool := NewTxPool(10, "")
+
+	original := testTx("F1alice", 0, 2)
+	if err := pool.Add(original); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Add(testTx("F1alice", 0, 2)); err == nil {
+		t.Fatal("duplicate transaction accepted")
+	}
+	same := testTx("F1alice", 0, 2)
+	same.Amount = 200
+	if err := pool.Add(same); err == nil {
+		t.Fatal("replacement with equal fee accepted")
+	}
+
+	better := testTx("F1alice", 0, 5)
+	if err := pool.Add(better); err != nil {
+		t.Fatalf("replacement with higher fee rejected: %v", err)
+	}
+	if pool.Len() != 1 || pool.Has(original.Hash()) || !pool.Has(better.Hash()) {
+		t.Fatalf("pool after replacement: len=%d, has original=%v", pool.Len(), pool.Has(original.Hash()))
+	}
+}
+
+// 池满时淘汰手续费最低的账户尾部交易，不淘汰会制造nonce空洞的交易
+func TestEvictLowestFeeTail(t *testing.T) {
+	pool := NewTxPool(3, "")
+
+	// alice: nonce 0手续费最低，但nonce 1在其后，只能淘汰尾部的nonce 1
+	aliceHead, aliceTail := testTx("F1alice", 0, 1), testTx("F1alice", 1, 4)
+	bob := testTx("F1bob", 0, 3)
+	for _, tx := range []*core.Transaction{aliceHead, aliceTail, bob} {
+		if err := pool.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := pool.Add(testTx("F1carol", 0, 2)); err == nil {
+		t.Fatal("tx cheaper than every evictable tail accepted into full pool")
+	}
+
+	carol := testTx("F1carol", 0, 5)
+	if err := pool.Add(carol); err != nil {
+		t.Fatalf("higher fee tx rejected: %v", err)
+	}
+	if pool.Has(bob.Hash()) || !pool.Has(aliceHead.Hash()) || !pool.Has(aliceTail.Hash()) || !pool.Has(carol.Hash()) {
+		t.Fatal("wrong transaction evicted")
+	}
+}
+
+// Pending：跨账户按手续费降序，同账户按nonce连续，有空洞的交易不打包
+func TestPendingOrdering(t *testing.T) {
+	pool := NewTxPool(100, "")
+	for _, tx := range []*core.Transaction{
+		testTx("F1alice", 5, 1),
+		testTx("F1alice", 6, 9), // 排在alice手续费低的nonce 5之后
+		testTx("F1bob", 0, 5),
+		testTx("F1bob", 2, 8), // nonce 1缺失，queued
+		testTx("F1carol", 3, 3),
+		testTx("F1carol", 1, 7), // 链上nonce为3，已失效
+	} {
+		if err := pool.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := pool.Pending(nonces(map[string]uint64{"F1alice": 5, "F1carol": 3}), 0, 0)
+	want := []string{"F1bob/0", "F1carol/3", "F1alice/5", "F1alice/6"}
+	if len(got) != len(want) {
+		t.Fatalf("pending = %d txs, want %d", len(got), len(want))
+	}
+	for i, tx := range got {
+		if id := fmt.Sprintf("%s/%d", tx.From, tx.Nonce); id != want[i] {
+			t.Fatalf("pending[%d] = %s, want %s", i, id, want[i])
+		}
+	}
+
+	if got := pool.Pending(nonces(map[string]uint64{"F1alice": 5, "F1carol": 3}), 2, 0); len(got) != 2 {
+		t.Fatalf("maxTx=2 selected %d txs", len(got))
+	}
+}
+
+// Pending字节上限：放不下的账户整体跳过，其余账户继续选
+func TestPendingByteLimit(t *testing.T) {
+	pool := NewTxPool(100, "")
+	big := testTx("F1alice", 0, 9)
+	big.Data = make([]byte, 512)
+	small := testTx("F1bob", 0, 1)
+	for _, tx := range []*core.Transaction{big, testTx("F1alice", 1, 9), small} {
+		if err := pool.Add(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := pool.Pending(nonces(nil), 0, txSize(small)+txSize(big)/2)
+	if len(got) != 1 || got[0].Hash() != small.Hash() {
+		t.Fatalf("byte-limited pending = %d txs, want only the small tx", len(got))
+	}
+
+	got = pool.Pending(nonces(nil), 0, txSize(big)+txSize(small))
+	if len(got) != 2 || got[0].Hash() != big.Hash() {
+		t.Fatalf("pending within limit = %d txs", len(got))
+	}
+}
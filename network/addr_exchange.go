@@ -0,0 +1,141 @@
+package network
+
+import (
+	"log"
+	"net"
+	"strconv"
+	"time"
+
+	"fan-chain/core"
+)
+
+const (
+	// 单条地址消息最多携带的地址数
+	maxAddrPerMessage = 100
+	// 向同一peer请求地址的间隔（随心跳触发）
+	addrRefreshInterval = 10 * time.Minute
+	// 同一peer的地址请求/地址消息最短间隔，更频繁视为刷屏
+	addrMessageInterval = time.Minute
+)
+
+// markAddrRequested 距上次向该peer请求地址已超过刷新间隔时记录本次请求并返回true
+func (p *Peer) markAddrRequested() bool {
+	p.addrMu.Lock()
+	defer p.addrMu.Unlock()
+
+	if !p.addrRequestedAt.IsZero() && time.Since(p.addrRequestedAt) < addrRefreshInterval {
+		return false
+	}
+	p.addrRequestedAt = time.Now()
+	return true
+}
+
+// allowAddrMessage 同一peer的地址请求或地址消息限速
+func (p *Peer) allowAddrMessage(last *time.Time) bool {
+	p.addrMu.Lock()
+	defer p.addrMu.Unlock()
+
+	if !last.IsZero() && time.Since(*last) < addrMessageInterval {
+		return false
+	}
+	*last = time.Now()
+	return true
+}
+
+// validDialAddress 校验并规范化节点地址：必须是IP:Port，排除本机、回环、组播等不可拨号地址
+func (s *Server) validDialAddress(addr string) (string, bool) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", false
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return "", false
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsUnspecified() || ip.IsLoopback() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+		return "", false
+	}
+	if s.isSelfAddress(ip.String()) {
+		return "", false
+	}
+	return net.JoinHostPort(ip.String(), portStr), true
+}
+
+// learnListenAddress 由握手消息中的监听端口得到peer的可拨号地址
+func (s *Server) learnListenAddress(peer *Peer, listenPort int) {
+	if listenPort <= 0 {
+		return
+	}
+	addr, ok := s.validDialAddress(net.JoinHostPort(peerIP(peer.host), strconv.Itoa(listenPort)))
+	if !ok {
+		return
+	}
+	s.peerMgr.addLearned([]string{addr})
+}
+
+// requestAddresses 向peer请求已知节点地址
+func (s *Server) requestAddresses(peer *Peer) {
+	if !peer.supports(CapAddrExchange) {
+		return
+	}
+	msg, err := NewMessage(MsgGetAddr, &GetAddrMessage{})
+	if err != nil {
+		log.Printf("Failed to create get addr message: %v", err)
+		return
+	}
+	peer.SendMessage(msg)
+}
+
+// 处理地址请求：回复本节点连接成功过的地址
+func (s *Server) handleGetAddr(peer *Peer, msg *Message) {
+	if !peer.allowAddrMessage(&peer.lastGetAddr) {
+		s.penalizePeer(peer, MisbehaviorSpam)
+		return
+	}
+
+	addrs := s.peerMgr.shareable(maxAddrPerMessage)
+	reply, err := NewMessage(MsgAddr, &AddrMessage{Addresses: addrs})
+	if err != nil {
+		log.Printf("Failed to create addr message: %v", err)
+		return
+	}
+	peer.SendMessage(reply)
+}
+
+// 处理地址消息
+// 流程：限速 -> 数量检查 -> 逐个校验 -> 加入地址表（连接成功前不会再分享给其他节点）
+func (s *Server) handleAddr(peer *Peer, msg *Message) {
+	if !peer.allowAddrMessage(&peer.lastAddr) {
+		s.penalizePeer(peer, MisbehaviorSpam)
+		return
+	}
+
+	var addrMsg AddrMessage
+	if err := msg.ParsePayload(&addrMsg); err != nil {
+		log.Printf("Failed to parse addr from %s: %v", peer.host, err)
+		s.penalizePeer(peer, MisbehaviorInvalidMessage)
+		return
+	}
+	if len(addrMsg.Addresses) > maxAddrPerMessage {
+		log.Printf("⚠️ Peer %s sent %d addresses (max %d)", peer.host, len(addrMsg.Addresses), maxAddrPerMessage)
+		s.penalizePeer(peer, MisbehaviorInvalidMessage)
+		return
+	}
+
+	valid := make([]string, 0, len(addrMsg.Addresses))
+	for _, addr := range addrMsg.Addresses {
+		if normalized, ok := s.validDialAddress(addr); ok {
+			valid = append(valid, normalized)
+		}
+	}
+
+	if added := s.peerMgr.addLearned(valid); added > 0 {
+		log.Printf("📒 Learned %d new peer addresses from %s", added, peer.host)
+		if s.PeerCount() < core.GetConsensusConfig().NetworkParams.MinPeers {
+			go s.maintainPeers()
+		}
+	}
+}
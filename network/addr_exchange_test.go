@@ -0,0 +1,65 @@
+package network
+
+import (
+	"fmt"
+	"testing"
+)
+
+// 地址校验：只接受可拨号的IP:Port
+func TestValidDialAddress(t *testing.T) {
+	s := NewServer("", 9001, nil, "203.0.113.9")
+
+	cases := map[string]bool{
+		"198.51.100.7:9001":  true,
+		"10.0.0.8:9001":      true,
+		"[2001:db8::1]:9001": true,
+		"127.0.0.1:9001":     false,
+		"0.0.0.0:9001":       false,
+		"224.0.0.1:9001":     false,
+		"203.0.113.9:9001":   false, // 本节点公网IP
+		"seed.example:9001":  false,
+		"198.51.100.7":       false,
+		"198.51.100.7:0":     false,
+		"198.51.100.7:70000": false,
+	}
+	for addr, want := range cases {
+		if _, ok := s.validDialAddress(addr); ok != want {
+			t.Errorf("validDialAddress(%q) = %v, want %v", addr, ok, want)
+		}
+	}
+}
+
+// 学到的地址在连接成功前不分享，拨号失败即丢弃；地址表有容量上限
+func TestLearnedAddressesLifecycle(t *testing.T) {
+	store := newMemPeerStore()
+	pm := newPeerManager()
+	pm.load(store)
+
+	if added := pm.addLearned([]string{"198.51.100.1:9001", "198.51.100.2:9001", "198.51.100.1:9001"}); added != 2 {
+		t.Fatalf("added = %d, want 2", added)
+	}
+	if shared := pm.shareable(10); len(shared) != 0 {
+		t.Fatalf("unverified addresses shared: %v", shared)
+	}
+
+	pm.markConnected("198.51.100.1:9001")
+	pm.forgetUnreachable("198.51.100.1:9001")
+	pm.forgetUnreachable("198.51.100.2:9001")
+
+	shared := pm.shareable(10)
+	if len(shared) != 1 || shared[0] != "198.51.100.1:9001" {
+		t.Fatalf("shared = %v, want [198.51.100.1:9001]", shared)
+	}
+	if _, ok := store.peers["198.51.100.2:9001"]; ok {
+		t.Fatalf("unreachable learned address not removed from store")
+	}
+
+	addrs := make([]string, 0, maxKnownPeers+10)
+	for i := 0; i < maxKnownPeers+10; i++ {
+		addrs = append(addrs, fmt.Sprintf("198.51.100.3:%d", 10000+i))
+	}
+	pm.addLearned(addrs)
+	if len(pm.known) > maxKnownPeers {
+		t.Fatalf("known addresses = %d, exceeds cap %d", len(pm.known), maxKnownPeers)
+	}
+}
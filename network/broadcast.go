@@ -9,7 +9,7 @@ import (
 
 // 广播新区块
 func (s *Server) BroadcastBlock(block *core.Block) {
-	msg, err := NewMessage(MsgNewBlock, &NewBlockMessage{Block: block})
+	msg, err := NewMessage(MsgNewBlock, s.newBlockMessage(block))
 	if err != nil {
 		log.Printf("Failed to create new block message: %v", err)
 		return
@@ -33,6 +33,15 @@ func (s *Server) BroadcastBlock(block *core.Block) {
 	log.Printf("Broadcasted block #%d to %d peers", block.Header.Height, peerCount)
 }
 
+// newBlockMessage 构造新区块消息：自己出的块附带公钥，供其他节点验证签名
+func (s *Server) newBlockMessage(block *core.Block) *NewBlockMessage {
+	msg := &NewBlockMessage{Block: block}
+	if block.Header.Proposer == s.address {
+		msg.PublicKey = s.publicKey
+	}
+	return msg
+}
+
 // 监控区块高度并在停滞时重复广播
 // 理论出块速度5-5.5秒，若高度停滞6秒，立即重复广播最新区块
 func (s *Server) monitorAndRetryBroadcast() {
@@ -91,7 +100,7 @@ func (s *Server) checkAndRetryBroadcast() {
 				log.Printf("⚠️  Height stalled at #%d for %.1fs, re-broadcasting block", currentHeight, elapsed.Seconds())
 
 				// 重新广播（不更新lastBroadcastTime，以便下次继续重试直到高度增加）
-				msg, err := NewMessage(MsgNewBlock, &NewBlockMessage{Block: latestBlock})
+				msg, err := NewMessage(MsgNewBlock, s.newBlockMessage(latestBlock))
 				if err != nil {
 					log.Printf("Failed to create retry broadcast message: %v", err)
 					return
@@ -143,8 +152,8 @@ func (s *Server) BroadcastCheckpoint(checkpoint *core.Checkpoint, compressedSnap
 			go func(p *Peer) {
 				// 先发送checkpoint
 				p.SendMessage(msg)
-				// 如果有状态快照，紧接着发送
-				if len(compressedSnapshot) > 0 {
+				// 如果有状态快照，紧接着发送（支持分片同步的peer需要时自行按分片请求）
+				if len(compressedSnapshot) > 0 && !p.capabilities[CapStateChunks] {
 					stateMsg, err := NewMessage(MsgStateData, &StateDataMessage{
 						Height:         checkpoint.Height,
 						CompressedData: compressedSnapshot,
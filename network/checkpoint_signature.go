@@ -0,0 +1,78 @@
+package network
+
+import (
+	"log"
+
+	"fan-chain/core"
+)
+
+// SetHandleCheckpointSignature 设置checkpoint签名处理函数（校验后加入本地checkpoint）
+func (s *Server) SetHandleCheckpointSignature(fn func(height uint64, checkpointHash core.Hash, sig *core.CheckpointSignature) error) {
+	s.handleCheckpointSig = fn
+}
+
+// 处理checkpoint签名广播
+// 流程：去重 -> 本地校验并加入checkpoint -> 转发给其他peer
+func (s *Server) handleCheckpointSignature(peer *Peer, msg *Message) {
+	var sigMsg CheckpointSigMessage
+	if err := msg.ParsePayload(&sigMsg); err != nil {
+		log.Printf("Failed to parse checkpoint signature from %s: %v", peer.host, err)
+		s.penalizePeer(peer, MisbehaviorInvalidMessage)
+		return
+	}
+	if sigMsg.Signature == nil {
+		return
+	}
+
+	s.processCheckpointSignature(&sigMsg)
+}
+
+// processCheckpointSignature 校验签名并转发（同一验证者对同一checkpoint的签名只处理一次）
+func (s *Server) processCheckpointSignature(sigMsg *CheckpointSigMessage) {
+	if s.checkpointSigSeen.markSeen(checkpointSigKey(sigMsg.CheckpointHash, sigMsg.Signature.Validator)) {
+		return
+	}
+
+	if s.handleCheckpointSig != nil {
+		if err := s.handleCheckpointSig(sigMsg.Height, sigMsg.CheckpointHash, sigMsg.Signature); err != nil {
+			log.Printf("[CHECKPOINT] Rejected signature from %s for #%d: %v",
+				sigMsg.Signature.Validator, sigMsg.Height, err)
+			return
+		}
+	}
+
+	s.broadcastCheckpointSigMessage(sigMsg)
+}
+
+// BroadcastCheckpointSignature 广播本节点对checkpoint的签名
+func (s *Server) BroadcastCheckpointSignature(height uint64, checkpointHash core.Hash, sig *core.CheckpointSignature) {
+	sigMsg := &CheckpointSigMessage{
+		Height:         height,
+		CheckpointHash: checkpointHash,
+		Signature:      sig,
+	}
+	s.checkpointSigSeen.markSeen(checkpointSigKey(checkpointHash, sig.Validator))
+
+	s.broadcastCheckpointSigMessage(sigMsg)
+}
+
+func (s *Server) broadcastCheckpointSigMessage(sigMsg *CheckpointSigMessage) {
+	msg, err := NewMessage(MsgCheckpointSig, sigMsg)
+	if err != nil {
+		log.Printf("Failed to create checkpoint signature message: %v", err)
+		return
+	}
+
+	s.peersMu.RLock()
+	for _, peer := range s.peers {
+		if peer.IsConnected() && peer.supports(CapCheckpointSig) {
+			go peer.SendMessage(msg)
+		}
+	}
+	s.peersMu.RUnlock()
+}
+
+// checkpointSigKey 签名去重键：checkpoint哈希 + 验证者地址
+func checkpointSigKey(checkpointHash core.Hash, validator string) core.Hash {
+	return core.CalculateHash(append(checkpointHash.Bytes(), []byte(validator)...))
+}
@@ -122,13 +122,18 @@ func (s *Server) acceptLoop() {
 			}
 		}
 
-		log.Printf("New inbound connection from %s", conn.RemoteAddr())
-		peer := NewPeer(conn, false)
-		s.addPeer(peer)
-		peer.Start()
+		if s.peerMgr.isBanned(conn.RemoteAddr().String()) {
+			conn.Close()
+			continue
+		}
+		if s.peerLimitReached() {
+			log.Printf("Rejecting inbound connection from %s: max peers reached", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
 
-		// 发送Ping
-		go s.sendPing(peer)
+		log.Printf("New inbound connection from %s", conn.RemoteAddr())
+		go s.handleInbound(conn)
 	}
 }
 
@@ -279,25 +284,35 @@ func (s *Server) ConnectToPeer(host string) error {
 	}
 	s.peersMu.RUnlock()
 
+	if s.peerMgr.isBanned(host) {
+		return fmt.Errorf("peer %s is banned", host)
+	}
+	if s.peerLimitReached() {
+		return fmt.Errorf("max peers reached")
+	}
+
 	// 建立连接
 	conn, err := net.DialTimeout("tcp", host, 10*time.Second)
 	if err != nil {
+		s.peerMgr.forgetUnreachable(host)
 		return err
 	}
 
 	log.Printf("Connected to peer %s", host)
-	peer := NewPeer(conn, true)
-	s.addPeer(peer)
-	peer.Start()
-
-	// 发送Ping
-	s.sendPing(peer)
+	if err := s.establishPeer(NewPeer(conn, true)); err != nil {
+		return err
+	}
+	s.peerMgr.markConnected(host)
 
 	return nil
 }
 
 // 添加节点
 func (s *Server) addPeer(peer *Peer) {
+	peer.onMisbehavior = func(reason Misbehavior) {
+		s.penalizePeer(peer, reason)
+	}
+
 	s.peersMu.Lock()
 	defer s.peersMu.Unlock()
 	s.peers[peer.host] = peer
@@ -350,6 +365,7 @@ func (s *Server) sendPing(peer *Peer) {
 		CheckpointTimestamp: checkpointTimestamp,
 		ConsensusVersion:    consensusConfig.ConsensusVersion,
 		ConsensusHash:       consensusConfig.ConsensusHash,
+		ListenPort:          s.port,
 	}
 
 	msg, err := NewMessage(MsgPing, ping)
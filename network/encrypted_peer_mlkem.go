@@ -1,250 +1,317 @@
 package network
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/json"
-	"fan-chain/crypto"
+	"errors"
 	"fmt"
 	"log"
 	"net"
+	"time"
+
+	"fan-chain/core"
+	"fan-chain/crypto"
+
+	"golang.org/x/crypto/sha3"
 )
 
-// MLKEMEncryptedPeer 使用ML-KEM-768的加密对等节点
-type MLKEMEncryptedPeer struct {
-	*Peer
-	session       *crypto.MLKEMEncryptedSession
-	encryptionOn  bool
-	localPrivKey  []byte // ML-DSA-65私钥
-	localPubKey   []byte // ML-DSA-65公钥
-	remotePubKey  []byte // 对方ML-DSA-65公钥
-	kemPrivateKey []byte // 临时ML-KEM-768私钥（仅客户端保存）
-}
+// 所有P2P连接建立后先执行ML-KEM-768 + ML-DSA-65认证握手：
+//  1. 出站方发送 MsgKeyExchange(请求)：临时KEM公钥 + 身份公钥 + 对KEM公钥的签名
+//  2. 入站方验证签名，用对方KEM公钥封装共享密钥，回复 MsgKeyExchange(响应)：密文 + 身份公钥 + 对密文的签名
+//  3. 双方用共享密钥和握手记录（请求、响应原文）派生AES-256-GCM会话密钥，之后每一帧都加密
+// 请求中附带本节点支持的线路编码，响应中给出选定的编码；握手记录参与密钥派生，篡改协商结果会导致会话无法解密
+// 对端节点地址由其身份公钥推导，Ping/Pong中声明的地址必须与之一致
+// 加密通道建立后双方交换Hello（见hello.go），不属于同一条链的节点直接断开
+// 只有对方拥有请求中KEM公钥对应的私钥才能解出会话密钥，重放别人的握手请求无法通信
+
+// 加密帧方向标记（双方共用一个会话密钥，方向不同防止把一方的帧反射回去）
+const (
+	frameDirOutbound byte = 1 // 出站方发出的帧
+	frameDirInbound  byte = 2 // 入站方发出的帧
+)
 
-// NewMLKEMEncryptedPeer 创建ML-KEM加密对等节点
-func NewMLKEMEncryptedPeer(conn net.Conn, outbound bool, privateKey, publicKey []byte) *MLKEMEncryptedPeer {
-	return &MLKEMEncryptedPeer{
-		Peer:         NewPeer(conn, outbound),
-		encryptionOn: false,
-		localPrivKey: privateKey,
-		localPubKey:  publicKey,
-	}
+// keyExchangeRequest 握手请求：ML-KEM请求 + 支持的线路编码
+type keyExchangeRequest struct {
+	crypto.MLKEMKeyExchangeRequest
+	WireCodecs []uint8 `json:"wire_codecs,omitempty"`
 }
 
-// PerformMLKEMHandshake 执行ML-KEM加密握手
-func (ep *MLKEMEncryptedPeer) PerformMLKEMHandshake() error {
-	if ep.outbound {
-		// 作为客户端发起握手
-		return ep.mlkemClientHandshake()
-	} else {
-		// 作为服务端响应握手
-		return ep.mlkemServerHandshake()
-	}
+// keyExchangeResponse 握手响应：ML-KEM响应 + 选定的线路编码
+type keyExchangeResponse struct {
+	crypto.MLKEMKeyExchangeResponse
+	WireCodec uint8 `json:"wire_codec,omitempty"`
 }
 
-// mlkemClientHandshake 客户端ML-KEM握手流程
-func (ep *MLKEMEncryptedPeer) mlkemClientHandshake() error {
-	// 1. 生成ML-KEM密钥交换请求
-	req, kemPrivKey, err := crypto.GenerateMLKEMKeyExchangeRequest(ep.localPrivKey, ep.localPubKey)
-	if err != nil {
-		return fmt.Errorf("failed to generate ML-KEM key exchange request: %v", err)
-	}
+// sessionSecret 会话密钥材料：SHA3-256(共享密钥 || 请求原文 || 响应原文)
+func sessionSecret(sharedSecret []byte, request, response json.RawMessage) []byte {
+	h := sha3.New256()
+	h.Write(sharedSecret)
+	h.Write(request)
+	h.Write(response)
+	return h.Sum(nil)
+}
 
-	// 保存KEM私钥，稍后用于解封装
-	ep.kemPrivateKey = kemPrivKey
+// 同时进行中的入站握手上限（握手期间不占用节点名额，需单独限制）
+const maxPendingHandshakes = 32
+
+// secureChannel 握手后的加密通道：帧明文为 方向(1) + 序号(8) + 消息，
+// 序号严格递增，重放、重排或丢弃的帧都会被拒绝
+type secureChannel struct {
+	session *crypto.MLKEMEncryptedSession
+	sendDir byte
+	recvDir byte
+	sendSeq uint64 // 只在写入协程中使用
+	recvSeq uint64 // 只在读取协程中使用
+}
 
-	// 2. 发送请求
-	reqData, err := json.Marshal(req)
+func newSecureChannel(sharedSecret []byte, outbound bool) (*secureChannel, error) {
+	session, err := crypto.NewMLKEMEncryptedSession(sharedSecret)
 	if err != nil {
-		return fmt.Errorf("failed to marshal request: %v", err)
-	}
-
-	msg := &Message{
-		Type:    MsgKeyExchange,
-		Payload: reqData,
+		return nil, fmt.Errorf("failed to create encrypted session: %v", err)
 	}
 
-	if err := ep.Peer.SendMessage(msg); err != nil {
-		return fmt.Errorf("failed to send ML-KEM key exchange request: %v", err)
+	ch := &secureChannel{session: session, sendDir: frameDirInbound, recvDir: frameDirOutbound}
+	if outbound {
+		ch.sendDir, ch.recvDir = frameDirOutbound, frameDirInbound
 	}
+	return ch, nil
+}
 
-	log.Printf("Client: Sent ML-KEM key exchange request to %s", ep.host)
+// seal 加密一帧
+func (c *secureChannel) seal(data []byte) ([]byte, error) {
+	plain := make([]byte, 9+len(data))
+	plain[0] = c.sendDir
+	binary.BigEndian.PutUint64(plain[1:9], c.sendSeq)
+	copy(plain[9:], data)
 
-	// 3. 接收响应
-	respMsg, err := ep.Peer.ReceiveMessage()
+	frame, err := c.session.Encrypt(plain)
 	if err != nil {
-		return fmt.Errorf("failed to receive ML-KEM key exchange response: %v", err)
+		return nil, err
 	}
+	c.sendSeq++
+	return frame, nil
+}
 
-	if respMsg.Type != MsgKeyExchange {
-		return fmt.Errorf("unexpected message type: %d", respMsg.Type)
-	}
-
-	var resp crypto.MLKEMKeyExchangeResponse
-	if err := json.Unmarshal(respMsg.Payload, &resp); err != nil {
-		return fmt.Errorf("failed to unmarshal response: %v", err)
-	}
-
-	// 4. 验证响应签名
-	if !crypto.VerifyMLKEMKeyExchangeResponse(&resp) {
-		return fmt.Errorf("ML-KEM key exchange response verification failed")
-	}
-
-	ep.remotePubKey = resp.SignaturePublicKey
-
-	// 5. 解封装共享密钥
-	sharedSecret, err := crypto.DecapsulateSharedSecret(ep.kemPrivateKey, resp.Ciphertext)
+// open 解密一帧并校验方向和序号
+func (c *secureChannel) open(frame []byte) ([]byte, error) {
+	plain, err := c.session.Decrypt(frame)
 	if err != nil {
-		return fmt.Errorf("failed to decapsulate shared secret: %v", err)
+		return nil, err
 	}
-
-	// 6. 创建加密会话
-	session, err := crypto.NewMLKEMEncryptedSession(sharedSecret)
-	if err != nil {
-		return fmt.Errorf("failed to create encrypted session: %v", err)
+	if len(plain) < 9 {
+		return nil, fmt.Errorf("encrypted frame too short")
 	}
-
-	ep.session = session
-	ep.encryptionOn = true
-
-	// 清除临时KEM私钥（前向保密）
-	for i := range ep.kemPrivateKey {
-		ep.kemPrivateKey[i] = 0
+	if plain[0] != c.recvDir {
+		return nil, fmt.Errorf("reflected frame")
 	}
-	ep.kemPrivateKey = nil
-
-	log.Printf("Client: ML-KEM handshake completed with peer %s (quantum-safe)", ep.host)
-	return nil
+	if seq := binary.BigEndian.Uint64(plain[1:9]); seq != c.recvSeq {
+		return nil, fmt.Errorf("unexpected frame sequence %d (want %d)", seq, c.recvSeq)
+	}
+	c.recvSeq++
+	return plain[9:], nil
 }
 
-// mlkemServerHandshake 服务端ML-KEM握手流程
-func (ep *MLKEMEncryptedPeer) mlkemServerHandshake() error {
-	// 1. 接收请求
-	reqMsg, err := ep.Peer.ReceiveMessage()
-	if err != nil {
-		return fmt.Errorf("failed to receive ML-KEM key exchange request: %v", err)
-	}
+// SetNodePrivateKey 设置本节点ML-DSA私钥（P2P握手时签名证明身份）
+func (s *Server) SetNodePrivateKey(privateKey []byte) {
+	s.privateKey = privateKey
+}
 
-	if reqMsg.Type != MsgKeyExchange {
-		return fmt.Errorf("unexpected message type: %d", reqMsg.Type)
-	}
+// SetAllowPlaintextPeers 过渡期允许不支持加密握手的旧版本节点以明文连接
+func (s *Server) SetAllowPlaintextPeers(allow bool) {
+	s.allowPlaintext = allow
+}
 
-	var req crypto.MLKEMKeyExchangeRequest
-	if err := json.Unmarshal(reqMsg.Payload, &req); err != nil {
-		return fmt.Errorf("failed to unmarshal request: %v", err)
+// handshakeTimeout 握手超时（consensus.json的peer_handshake_timeout）
+func handshakeTimeout() time.Duration {
+	if seconds := core.GetConsensusConfig().NetworkParams.PeerHandshakeTimeout; seconds > 0 {
+		return time.Duration(seconds) * time.Second
 	}
+	return 30 * time.Second
+}
 
-	// 2. 验证请求签名
-	if !crypto.VerifyMLKEMKeyExchangeRequest(&req) {
-		return fmt.Errorf("ML-KEM key exchange request verification failed")
+// secureHandshake 在peer启动读写循环之前执行认证握手
+// 对方不支持加密握手且允许明文时返回对方发来的第一条消息（需投递给消息处理），否则返回错误
+func (s *Server) secureHandshake(peer *Peer) (*Message, error) {
+	if len(s.privateKey) == 0 || len(s.publicKey) == 0 {
+		return nil, fmt.Errorf("node keys not set")
 	}
 
-	ep.remotePubKey = req.SignaturePublicKey
+	peer.conn.SetDeadline(time.Now().Add(handshakeTimeout()))
+	defer peer.conn.SetDeadline(time.Time{})
 
-	log.Printf("Server: Received ML-KEM key exchange request from %s", ep.host)
-
-	// 3. 生成响应（封装共享密钥）
-	resp, sharedSecret, err := crypto.GenerateMLKEMKeyExchangeResponse(
-		ep.localPrivKey,
-		ep.localPubKey,
-		req.KEMPublicKey,
+	var (
+		remotePubKey []byte
+		sharedSecret []byte
+		codec        uint8
 	)
-	if err != nil {
-		return fmt.Errorf("failed to generate ML-KEM key exchange response: %v", err)
-	}
 
-	// 4. 发送响应
-	respData, err := json.Marshal(resp)
-	if err != nil {
-		return fmt.Errorf("failed to marshal response: %v", err)
+	if peer.outbound {
+		kemReq, kemPrivKey, err := crypto.GenerateMLKEMKeyExchangeRequest(s.privateKey, s.publicKey)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			for i := range kemPrivKey {
+				kemPrivKey[i] = 0 // 临时KEM私钥用完即清除（前向保密）
+			}
+		}()
+
+		reqBody, err := peer.writeHandshakeMessage(&keyExchangeRequest{
+			MLKEMKeyExchangeRequest: *kemReq,
+			WireCodecs:              supportedWireCodecs,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to send key exchange request: %v", err)
+		}
+
+		msg, err := peer.readHandshakeMessage()
+		if err != nil {
+			return nil, fmt.Errorf("failed to receive key exchange response: %v", err)
+		}
+		if msg.Type != MsgKeyExchange {
+			return s.plaintextFallback(peer, msg)
+		}
+
+		var resp keyExchangeResponse
+		if err := json.Unmarshal(msg.Payload, &resp); err != nil {
+			return nil, fmt.Errorf("invalid key exchange response: %v", err)
+		}
+		if !crypto.VerifyMLKEMKeyExchangeResponse(&resp.MLKEMKeyExchangeResponse) {
+			return nil, fmt.Errorf("key exchange response signature invalid")
+		}
+		if resp.WireCodec != WireCodecJSON && negotiateWireCodec([]uint8{resp.WireCodec}) != resp.WireCodec {
+			return nil, fmt.Errorf("peer selected unsupported wire codec %d", resp.WireCodec)
+		}
+
+		secret, err := crypto.DecapsulateSharedSecret(kemPrivKey, resp.Ciphertext)
+		if err != nil {
+			return nil, err
+		}
+		sharedSecret = sessionSecret(secret, reqBody, msg.Payload)
+		remotePubKey = resp.SignaturePublicKey
+		codec = resp.WireCodec
+	} else {
+		msg, err := peer.readHandshakeMessage()
+		if err != nil {
+			return nil, fmt.Errorf("failed to receive key exchange request: %v", err)
+		}
+		if msg.Type != MsgKeyExchange {
+			return s.plaintextFallback(peer, msg)
+		}
+
+		var req keyExchangeRequest
+		if err := json.Unmarshal(msg.Payload, &req); err != nil {
+			return nil, fmt.Errorf("invalid key exchange request: %v", err)
+		}
+		if !crypto.VerifyMLKEMKeyExchangeRequest(&req.MLKEMKeyExchangeRequest) {
+			return nil, fmt.Errorf("key exchange request signature invalid")
+		}
+
+		kemResp, secret, err := crypto.GenerateMLKEMKeyExchangeResponse(s.privateKey, s.publicKey, req.KEMPublicKey)
+		if err != nil {
+			return nil, err
+		}
+		codec = negotiateWireCodec(req.WireCodecs)
+		respBody, err := peer.writeHandshakeMessage(&keyExchangeResponse{
+			MLKEMKeyExchangeResponse: *kemResp,
+			WireCodec:                codec,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to send key exchange response: %v", err)
+		}
+		sharedSecret = sessionSecret(secret, msg.Payload, respBody)
+		remotePubKey = req.SignaturePublicKey
 	}
 
-	msg := &Message{
-		Type:    MsgKeyExchange,
-		Payload: respData,
+	if bytes.Equal(remotePubKey, s.publicKey) {
+		return nil, fmt.Errorf("connected to self")
 	}
-
-	if err := ep.Peer.SendMessage(msg); err != nil {
-		return fmt.Errorf("failed to send ML-KEM key exchange response: %v", err)
+	remoteAddress, err := core.AddressFromPublicKey(remotePubKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote public key: %v", err)
 	}
 
-	log.Printf("Server: Sent ML-KEM key exchange response to %s", ep.host)
-
-	// 5. 创建加密会话
-	session, err := crypto.NewMLKEMEncryptedSession(sharedSecret)
+	channel, err := newSecureChannel(sharedSecret, peer.outbound)
 	if err != nil {
-		return fmt.Errorf("failed to create encrypted session: %v", err)
+		return nil, err
 	}
 
-	ep.session = session
-	ep.encryptionOn = true
-
-	log.Printf("Server: ML-KEM handshake completed with peer %s (quantum-safe)", ep.host)
-	return nil
-}
-
-// SendEncryptedMessage 发送加密消息
-func (ep *MLKEMEncryptedPeer) SendEncryptedMessage(msg *Message) error {
-	if !ep.encryptionOn {
-		return fmt.Errorf("encryption not enabled")
-	}
+	peer.channel = channel
+	peer.remotePubKey = remotePubKey
+	peer.authAddress = remoteAddress
+	peer.codec = codec
+	peer.SetAddress(remoteAddress)
 
-	// 序列化消息
-	data, err := msg.Marshal()
-	if err != nil {
-		return fmt.Errorf("failed to marshal message: %v", err)
-	}
+	log.Printf("🔐 Encrypted session established with %s (%s, wire codec %d)", peer.host, remoteAddress, codec)
 
-	// 加密
-	encrypted, err := ep.session.Encrypt(data)
-	if err != nil {
-		return fmt.Errorf("failed to encrypt message: %v", err)
+	if err := s.exchangeHello(peer); err != nil {
+		return nil, err
 	}
+	return nil, nil
+}
 
-	// 发送加密消息
-	encMsg := &Message{
-		Type:    MsgEncrypted,
-		Payload: encrypted,
+// plaintextFallback 对方第一条消息不是握手：过渡期允许时按明文节点处理
+func (s *Server) plaintextFallback(peer *Peer, first *Message) (*Message, error) {
+	if !s.allowPlaintext {
+		return nil, fmt.Errorf("peer does not support encrypted handshake (got message type %d)", first.Type)
 	}
-
-	return ep.Peer.SendMessage(encMsg)
+	log.Printf("⚠️ Peer %s does not support encryption, accepting plaintext connection (allow_plaintext_peers)", peer.host)
+	return first, nil
 }
 
-// ReceiveEncryptedMessage 接收加密消息
-func (ep *MLKEMEncryptedPeer) ReceiveEncryptedMessage() (*Message, error) {
-	if !ep.encryptionOn {
-		return nil, fmt.Errorf("encryption not enabled")
+// bindPeerAddress Ping/Pong中声明的节点地址必须与握手认证的地址一致
+// 明文节点（过渡期）没有认证地址，沿用声明的地址
+func (s *Server) bindPeerAddress(peer *Peer, claimed string) bool {
+	if peer.authAddress != "" && claimed != peer.authAddress {
+		log.Printf("❌ Peer %s claims address %s but authenticated as %s, disconnecting",
+			peer.host, claimed, peer.authAddress)
+		s.penalizePeer(peer, MisbehaviorHandshakeFailed)
+		s.removePeer(peer.host)
+		return false
 	}
+	peer.SetAddress(claimed)
+	return true
+}
 
-	// 接收加密消息
-	encMsg, err := ep.Peer.ReceiveMessage()
+// establishPeer 握手成功后登记peer并启动读写循环，然后发送Ping
+func (s *Server) establishPeer(peer *Peer) error {
+	first, err := s.secureHandshake(peer)
 	if err != nil {
-		return nil, fmt.Errorf("failed to receive message: %v", err)
+		peer.conn.Close()
+		if errors.Is(err, errChainMismatch) {
+			// 另一条链或共识参数不一致：断开且不再拨号，但不扣分
+			if peer.outbound {
+				s.peerMgr.forget(peer.host)
+			}
+			return fmt.Errorf("peer %s rejected: %v", peer.host, err)
+		}
+		s.peerMgr.penalize(peer.host, MisbehaviorHandshakeFailed)
+		return fmt.Errorf("handshake with %s failed: %v", peer.host, err)
 	}
 
-	if encMsg.Type != MsgEncrypted {
-		return nil, fmt.Errorf("unexpected message type: %d", encMsg.Type)
+	if first != nil {
+		peer.recvChan <- first
 	}
+	s.addPeer(peer)
+	peer.Start()
+	s.sendPing(peer)
+	return nil
+}
 
-	// 解密
-	decrypted, err := ep.session.Decrypt(encMsg.Payload)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decrypt message: %v", err)
+// handleInbound 入站连接握手（在独立协程中执行，慢速握手不阻塞accept）
+func (s *Server) handleInbound(conn net.Conn) {
+	select {
+	case s.handshakeSlots <- struct{}{}:
+		defer func() { <-s.handshakeSlots }()
+	default:
+		log.Printf("Rejecting inbound connection from %s: too many pending handshakes", conn.RemoteAddr())
+		conn.Close()
+		return
 	}
 
-	// 反序列化
-	msg, err := UnmarshalMessage(decrypted)
-	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal message: %v", err)
+	peer := NewPeer(conn, false)
+	if err := s.establishPeer(peer); err != nil {
+		log.Printf("⚠️ %v", err)
 	}
-
-	return msg, nil
-}
-
-// IsEncrypted 是否已加密
-func (ep *MLKEMEncryptedPeer) IsEncrypted() bool {
-	return ep.encryptionOn
-}
-
-// GetRemotePublicKey 获取远程公钥
-func (ep *MLKEMEncryptedPeer) GetRemotePublicKey() []byte {
-	return ep.remotePubKey
 }
@@ -0,0 +1,109 @@
+package network
+
+import (
+	"net"
+	"testing"
+
+	"fan-chain/core"
+	"fan-chain/crypto"
+)
+
+func newKeyedServer(t *testing.T) *Server {
+	t.Helper()
+	pub, priv, err := crypto.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer("", 9001, nil, "")
+	s.SetNodePublicKey(pub)
+	s.SetNodePrivateKey(priv)
+	return s
+}
+
+// 在内存连接两端同时执行握手
+func runHandshake(client, server *Server) (clientPeer, serverPeer *Peer, clientErr, serverErr error) {
+	c, sc := net.Pipe()
+	clientPeer, serverPeer = NewPeer(c, true), NewPeer(sc, false)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := server.secureHandshake(serverPeer)
+		if err != nil {
+			sc.Close()
+		}
+		done <- err
+	}()
+	_, clientErr = client.secureHandshake(clientPeer)
+	if clientErr != nil {
+		c.Close()
+	}
+	serverErr = <-done
+	return
+}
+
+// 握手后双方得到对方公钥推导的地址，帧加密且拒绝重放和反射
+func TestSecureHandshakeAuthenticatesPeers(t *testing.T) {
+	client, server := newKeyedServer(t), newKeyedServer(t)
+	clientPeer, serverPeer, clientErr, serverErr := runHandshake(client, server)
+	if clientErr != nil || serverErr != nil {
+		t.Fatalf("handshake failed: client=%v server=%v", clientErr, serverErr)
+	}
+	defer clientPeer.conn.Close()
+	defer serverPeer.conn.Close()
+
+	clientAddr, _ := core.AddressFromPublicKey(client.publicKey)
+	serverAddr, _ := core.AddressFromPublicKey(server.publicKey)
+	if serverPeer.authAddress != clientAddr || clientPeer.authAddress != serverAddr {
+		t.Fatalf("authenticated addresses = %s/%s, want %s/%s",
+			serverPeer.authAddress, clientPeer.authAddress, clientAddr, serverAddr)
+	}
+
+	frame, err := clientPeer.channel.seal([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := serverPeer.channel.open(frame); err != nil || string(got) != "hello" {
+		t.Fatalf("open = %q, %v", got, err)
+	}
+	if _, err := serverPeer.channel.open(frame); err == nil {
+		t.Fatal("replayed frame accepted")
+	}
+
+	// 把客户端自己发出的帧反射回客户端
+	frame, _ = clientPeer.channel.seal([]byte("ping"))
+	if _, err := clientPeer.channel.open(frame); err == nil {
+		t.Fatal("reflected frame accepted")
+	}
+
+	// 声明的地址必须与认证地址一致
+	if server.bindPeerAddress(serverPeer, serverAddr) {
+		t.Fatal("mismatched claimed address accepted")
+	}
+}
+
+// 默认拒绝不支持握手的明文节点，过渡期开关打开后接受并保留其第一条消息
+func TestSecureHandshakePlaintextPeers(t *testing.T) {
+	for _, allow := range []bool{false, true} {
+		server := newKeyedServer(t)
+		server.SetAllowPlaintextPeers(allow)
+
+		c, sc := net.Pipe()
+		legacy := NewPeer(c, true)
+		go func() {
+			ping, _ := NewMessage(MsgPing, &PingMessage{Address: "legacy"})
+			data, _ := ping.Marshal()
+			legacy.writeFrame(data)
+		}()
+
+		first, err := server.secureHandshake(NewPeer(sc, false))
+		if allow {
+			if err != nil || first == nil || first.Type != MsgPing {
+				t.Fatalf("allow=true: first=%v err=%v", first, err)
+			}
+		} else if err == nil {
+			t.Fatal("allow=false: plaintext peer accepted")
+		}
+		c.Close()
+		sc.Close()
+	}
+}
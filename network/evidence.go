@@ -0,0 +1,148 @@
+package network
+
+import (
+	"bytes"
+	"log"
+
+	"fan-chain/core"
+	"fan-chain/crypto"
+)
+
+// 双签检测参数
+const (
+	signedHeaderWindow = 1000 // 保留最近多少个高度的已签名区块头
+	proposerKeysMax    = 10000
+)
+
+// SetNodePublicKey 设置本节点ML-DSA公钥（广播自己出的块时附带）
+func (s *Server) SetNodePublicKey(publicKey []byte) {
+	s.publicKey = publicKey
+}
+
+// SetHandleReceivedEvidence 设置证据处理函数（校验后加入证据池）
+func (s *Server) SetHandleReceivedEvidence(fn func(*core.DoubleSignEvidence) error) {
+	s.handleReceivedEvidence = fn
+}
+
+// recordSignedHeader 记录收到的已签名区块头
+// 签名验证通过后按 高度 -> 出块者 记录；同一出块者同一高度出现不同区块头即构造双签证据
+// 消息未附带公钥时使用之前验证过的该出块者公钥，两者都没有则无法验证，不记录
+// 公钥与出块者不符或签名无效时返回false（区块是伪造的）
+func (s *Server) recordSignedHeader(header *core.BlockHeader, publicKey []byte) bool {
+	if header == nil || header.Proposer == "" || len(header.Signature) == 0 {
+		return true
+	}
+
+	s.evidenceMu.Lock()
+	if len(publicKey) == 0 {
+		publicKey = s.proposerKeys[header.Proposer]
+	}
+	s.evidenceMu.Unlock()
+	if len(publicKey) == 0 {
+		return true
+	}
+
+	address, err := core.AddressFromPublicKey(publicKey)
+	if err != nil || address != header.Proposer {
+		return false
+	}
+	if !crypto.Verify(publicKey, header.SignData(), header.Signature) {
+		log.Printf("⚠ Block #%d header signature invalid for proposer %s", header.Height, header.Proposer)
+		return false
+	}
+
+	s.evidenceMu.Lock()
+	if _, ok := s.proposerKeys[header.Proposer]; !ok && len(s.proposerKeys) >= proposerKeysMax {
+		s.proposerKeys = make(map[string][]byte)
+	}
+	s.proposerKeys[header.Proposer] = publicKey
+
+	byProposer := s.signedHeaders[header.Height]
+	if byProposer == nil {
+		byProposer = make(map[string]*core.BlockHeader)
+		s.signedHeaders[header.Height] = byProposer
+		s.pruneSignedHeadersLocked(header.Height)
+	}
+
+	previous, seen := byProposer[header.Proposer]
+	if !seen {
+		byProposer[header.Proposer] = header
+	}
+	s.evidenceMu.Unlock()
+
+	if !seen || bytes.Equal(previous.Bytes(), header.Bytes()) {
+		return true
+	}
+
+	evidence := core.NewDoubleSignEvidence(previous, header, publicKey)
+	log.Printf("🚨 DOUBLE SIGN DETECTED: %s signed two blocks at height %d", header.Proposer, header.Height)
+	s.processEvidence(evidence)
+	return true
+}
+
+// pruneSignedHeadersLocked 丢弃窗口之外的旧高度记录
+func (s *Server) pruneSignedHeadersLocked(latest uint64) {
+	if latest <= signedHeaderWindow {
+		return
+	}
+	for height := range s.signedHeaders {
+		if height < latest-signedHeaderWindow {
+			delete(s.signedHeaders, height)
+		}
+	}
+}
+
+// 处理双签证据广播
+// 流程：哈希去重 -> 本地校验入池 -> 转发给其他peer
+func (s *Server) handleEvidence(peer *Peer, msg *Message) {
+	var evMsg EvidenceMessage
+	if err := msg.ParsePayload(&evMsg); err != nil {
+		log.Printf("Failed to parse evidence from %s: %v", peer.host, err)
+		s.penalizePeer(peer, MisbehaviorInvalidMessage)
+		return
+	}
+	if evMsg.Evidence == nil || evMsg.Evidence.HeaderA == nil || evMsg.Evidence.HeaderB == nil {
+		return
+	}
+
+	s.processEvidence(evMsg.Evidence)
+}
+
+// processEvidence 校验证据、加入证据池并广播（同一证据只处理一次）
+func (s *Server) processEvidence(evidence *core.DoubleSignEvidence) {
+	hash := evidence.Hash()
+	if s.evidenceSeen.markSeen(hash) {
+		return
+	}
+
+	if s.handleReceivedEvidence != nil {
+		if err := s.handleReceivedEvidence(evidence); err != nil {
+			log.Printf("[EVIDENCE] Rejected evidence %x: %v", hash.Bytes()[:8], err)
+			return
+		}
+	}
+
+	s.BroadcastEvidence(evidence)
+}
+
+// BroadcastEvidence 广播双签证据给所有peer
+func (s *Server) BroadcastEvidence(evidence *core.DoubleSignEvidence) {
+	msg, err := NewMessage(MsgEvidence, &EvidenceMessage{Evidence: evidence})
+	if err != nil {
+		log.Printf("Failed to create evidence message: %v", err)
+		return
+	}
+
+	s.peersMu.RLock()
+	peerCount := 0
+	for _, peer := range s.peers {
+		if peer.IsConnected() && peer.supports(CapEvidence) {
+			go peer.SendMessage(msg)
+			peerCount++
+		}
+	}
+	s.peersMu.RUnlock()
+
+	log.Printf("📣 [EVIDENCE] Broadcasted double sign evidence against %s (height %d) to %d peers",
+		evidence.Offender(), evidence.Height(), peerCount)
+}
@@ -2,21 +2,59 @@ package network
 
 import (
 	"log"
+	"time"
+
+	"fan-chain/core"
+)
+
+const (
+	// 单次请求最多返回的checkpoint数
+	maxCheckpointsPerRequest = 10
+	// 状态快照缓存时间（多个peer同时同步时不重复读取、序列化快照）
+	stateSnapshotCacheTTL = 30 * time.Second
 )
 
+// stateSnapshotCache 最近一次生成的状态快照
+type stateSnapshotCache struct {
+	height    uint64
+	data      []byte
+	createdAt time.Time
+}
+
+// cachedStateSnapshot 获取状态快照，缓存未过期时直接返回
+func (s *Server) cachedStateSnapshot(height uint64) ([]byte, error) {
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+
+	if c := s.snapshotCache; c != nil && c.height == height && time.Since(c.createdAt) < stateSnapshotCacheTTL {
+		return c.data, nil
+	}
+
+	data, err := s.getStateSnapshot(height)
+	if err != nil {
+		return nil, err
+	}
+	s.snapshotCache = &stateSnapshotCache{height: height, data: data, createdAt: time.Now()}
+	return data, nil
+}
+
 // 处理获取checkpoint请求
 func (s *Server) handleGetCheckpoint(peer *Peer, msg *Message) {
 	var req GetCheckpointMessage
 	if err := msg.ParsePayload(&req); err != nil {
 		log.Printf("Failed to parse get checkpoint from %s: %v", peer.host, err)
+		s.penalizePeer(peer, MisbehaviorInvalidMessage)
 		return
 	}
 
-	// 默认请求3个checkpoint
+	// 默认请求3个checkpoint，最多maxCheckpointsPerRequest个
 	count := int(req.Count)
 	if count == 0 {
 		count = 3
 	}
+	if req.Count > maxCheckpointsPerRequest {
+		count = maxCheckpointsPerRequest
+	}
 
 	log.Printf("Peer %s requesting latest %d checkpoints", peer.host, count)
 
@@ -35,6 +73,7 @@ func (s *Server) handleGetCheckpoint(peer *Peer, msg *Message) {
 	// 发送checkpoint元数据列表
 	checkpointMsg, err := NewMessage(MsgCheckpoint, &CheckpointMessage{
 		Checkpoints: checkpoints,
+		RequestID:   req.RequestID,
 	})
 	if err != nil {
 		log.Printf("Failed to create checkpoint message: %v", err)
@@ -57,6 +96,10 @@ func (s *Server) handleCheckpoint(peer *Peer, msg *Message) {
 	var checkpointMsg CheckpointMessage
 	if err := msg.ParsePayload(&checkpointMsg); err != nil {
 		log.Printf("Failed to parse checkpoint from %s: %v", peer.host, err)
+		s.penalizePeer(peer, MisbehaviorInvalidMessage)
+		return
+	}
+	if !s.acceptResponse(peer, MsgCheckpoint, checkpointMsg.RequestID, true) {
 		return
 	}
 
@@ -82,10 +125,17 @@ func (s *Server) handleCheckpoint(peer *Peer, msg *Message) {
 		}
 		log.Printf("✅ Checkpoint applied at height %d", latestCheckpointInfo.Checkpoint.Height)
 
-		// 如果有状态数据，请求状态快照
-		if latestCheckpointInfo.HasStateData {
+		// 如果有状态数据，请求状态快照：双方都支持分片时按分片从多个peer同步，否则整体请求
+		// 分片证明基于稀疏Merkle树，checkpoint高度早于其激活高度时只能整体请求
+		if latestCheckpointInfo.HasStateData && s.applyStateChunks != nil && peer.capabilities[CapStateChunks] &&
+			core.SparseStateRootActive(latestCheckpointInfo.Checkpoint.Height) {
+			s.startStateSync(peer, latestCheckpointInfo.Checkpoint)
+		} else if latestCheckpointInfo.HasStateData {
 			log.Printf("Requesting state snapshot for height %d", latestCheckpointInfo.Checkpoint.Height)
-			stateReq := &GetStateMessage{Height: latestCheckpointInfo.Checkpoint.Height}
+			stateReq := &GetStateMessage{
+				Height:    latestCheckpointInfo.Checkpoint.Height,
+				RequestID: peer.trackRequest(MsgStateData),
+			}
 			stateReqMsg, err := NewMessage(MsgGetState, stateReq)
 			if err != nil {
 				log.Printf("Failed to create get state message: %v", err)
@@ -101,18 +151,19 @@ func (s *Server) handleGetState(peer *Peer, msg *Message) {
 	var req GetStateMessage
 	if err := msg.ParsePayload(&req); err != nil {
 		log.Printf("Failed to parse get state from %s: %v", peer.host, err)
+		s.penalizePeer(peer, MisbehaviorInvalidMessage)
 		return
 	}
 
 	log.Printf("Peer %s requesting state snapshot at height %d", peer.host, req.Height)
 
-	// 调用回调获取状态快照
+	// 获取状态快照（短时间内的重复请求复用缓存）
 	if s.getStateSnapshot == nil {
 		log.Printf("getStateSnapshot not configured")
 		return
 	}
 
-	compressedData, err := s.getStateSnapshot(req.Height)
+	compressedData, err := s.cachedStateSnapshot(req.Height)
 	if err != nil {
 		log.Printf("Failed to get state snapshot: %v", err)
 		return
@@ -122,6 +173,7 @@ func (s *Server) handleGetState(peer *Peer, msg *Message) {
 	stateMsg, err := NewMessage(MsgStateData, &StateDataMessage{
 		Height:         req.Height,
 		CompressedData: compressedData,
+		RequestID:      req.RequestID,
 	})
 	if err != nil {
 		log.Printf("Failed to create state data message: %v", err)
@@ -137,6 +189,10 @@ func (s *Server) handleStateData(peer *Peer, msg *Message) {
 	var stateMsg StateDataMessage
 	if err := msg.ParsePayload(&stateMsg); err != nil {
 		log.Printf("Failed to parse state data from %s: %v", peer.host, err)
+		s.penalizePeer(peer, MisbehaviorInvalidMessage)
+		return
+	}
+	if !s.acceptResponse(peer, MsgStateData, stateMsg.RequestID, false) {
 		return
 	}
 
@@ -150,51 +206,55 @@ func (s *Server) handleStateData(peer *Peer, msg *Message) {
 			return
 		}
 		log.Printf("✅ State snapshot applied at height %d", stateMsg.Height)
+		s.afterStateSnapshot(peer, stateMsg.Height)
+	}
+}
 
-		// 状态快照应用成功后，从checkpoint前一个周期开始同步
-		if s.getLatestBlock != nil {
-			// 从checkpoint高度往前推12个区块开始同步
-			syncFromHeight := stateMsg.Height
-			if syncFromHeight > 12 {
-				syncFromHeight = syncFromHeight - 12
-			}
+// afterStateSnapshot 状态快照（整体或分片）应用成功后，从checkpoint前一个周期开始同步区块
+func (s *Server) afterStateSnapshot(peer *Peer, height uint64) {
+	// 状态快照应用成功后，从checkpoint前一个周期开始同步
+	if s.getLatestBlock != nil {
+		// 从checkpoint高度往前推12个区块开始同步
+		syncFromHeight := height
+		if syncFromHeight > 12 {
+			syncFromHeight = syncFromHeight - 12
+		}
 
-			// 只在checkpointSyncFrom未设置时才初始化，避免覆盖backfill更新的值
-			s.syncMu.Lock()
-			if s.checkpointSyncFrom == 0 {
-				s.checkpointSyncFrom = syncFromHeight
-				log.Printf("📡 Will sync blocks from height %d (checkpoint - 12)",
-					syncFromHeight)
-			} else {
-				log.Printf("📡 Using existing sync start height %d (already in progress)",
-					s.checkpointSyncFrom)
-			}
-			s.checkpointHeight = stateMsg.Height
-			s.syncMu.Unlock()
+		// 只在checkpointSyncFrom未设置时才初始化，避免覆盖backfill更新的值
+		s.syncMu.Lock()
+		if s.checkpointSyncFrom == 0 {
+			s.checkpointSyncFrom = syncFromHeight
+			log.Printf("📡 Will sync blocks from height %d (checkpoint - 12)",
+				syncFromHeight)
+		} else {
+			log.Printf("📡 Using existing sync start height %d (already in progress)",
+				s.checkpointSyncFrom)
+		}
+		s.checkpointHeight = height
+		s.syncMu.Unlock()
 
-			// 先询问对方的最新高度
-			latestMsg, err := NewMessage(MsgGetLatest, nil)
-			if err == nil {
-				peer.SendMessage(latestMsg)
-				log.Printf("📡 Requesting latest height from peer to sync blocks after checkpoint %d", stateMsg.Height)
-			}
+		// 先询问对方的最新高度
+		latestMsg, err := NewMessage(MsgGetLatest, nil)
+		if err == nil {
+			peer.SendMessage(latestMsg)
+			log.Printf("📡 Requesting latest height from peer to sync blocks after checkpoint %d", height)
+		}
+
+		// 【P2协议-缓冲期修复】只有在backfill未进行时才启动新的backfill
+		// 防止每次收到新checkpoint都重复触发backfill请求
+		s.syncMu.Lock()
+		backfillInProgress := s.backfillInProgress
+		s.syncMu.Unlock()
 
-			// 【P2协议-缓冲期修复】只有在backfill未进行时才启动新的backfill
-			// 防止每次收到新checkpoint都重复触发backfill请求
-			s.syncMu.Lock()
-			backfillInProgress := s.backfillInProgress
-			s.syncMu.Unlock()
-
-			if !backfillInProgress {
-				// 【P2协议】询问大哥的最早区块高度，启动向下同步
-				log.Printf("📡 【P2】Requesting big brother's earliest block height for backfill sync...")
-				earliestMsg, err := NewMessage(MsgGetEarliestHeight, &GetEarliestHeightMessage{})
-				if err == nil {
-					peer.SendMessage(earliestMsg)
-				}
-			} else {
-				log.Printf("📡 【P2】Backfill already in progress, skipping new backfill request")
+		if !backfillInProgress {
+			// 【P2协议】询问大哥的最早区块高度，启动向下同步
+			log.Printf("📡 【P2】Requesting big brother's earliest block height for backfill sync...")
+			earliestMsg, err := NewMessage(MsgGetEarliestHeight, &GetEarliestHeightMessage{})
+			if err == nil {
+				peer.SendMessage(earliestMsg)
 			}
+		} else {
+			log.Printf("📡 【P2】Backfill already in progress, skipping new backfill request")
 		}
 	}
 }
@@ -0,0 +1,146 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"fan-chain/core"
+)
+
+// P2P协议版本
+// 1: 明文JSON，只靠Ping/Pong校验共识参数
+// 2: 加密握手 + Hello（链标识、协议版本、能力协商）
+// 3: 请求/响应带请求ID（二进制编码格式变化，不兼容2）
+const (
+	ProtocolVersion    uint32 = 3
+	MinProtocolVersion uint32 = 3
+)
+
+// 可选功能（双方都支持才使用）
+const (
+	CapAddrExchange  = "addr"       // 节点地址交换（GetAddr/Addr）
+	CapEvidence      = "evidence"   // 双签证据广播
+	CapCheckpointSig = "cpsig"      // checkpoint验证者签名广播
+	CapStateChunks   = "statechunk" // 分片状态同步
+)
+
+// 本节点支持的可选功能
+var localCapabilities = []string{CapAddrExchange, CapEvidence, CapCheckpointSig, CapStateChunks}
+
+var genesisHash = sync.OnceValue(core.GenesisHash)
+
+// errChainMismatch 对方属于另一条链或使用不兼容的共识参数/协议版本
+// 这不是对方作恶（也可能是本节点的consensus.json过期），只断开不扣分
+var errChainMismatch = errors.New("chain mismatch")
+
+// localHello 本节点的Hello
+func localHello() *HelloMessage {
+	config := core.GetConsensusConfig()
+	return &HelloMessage{
+		NetworkID:        config.ChainParams.NetworkID,
+		GenesisHash:      genesisHash(),
+		ProtocolVersion:  ProtocolVersion,
+		ConsensusVersion: config.ConsensusVersion,
+		ConsensusHash:    config.ConsensusHash,
+		Capabilities:     localCapabilities,
+	}
+}
+
+// checkHello 校验对方的Hello与本节点属于同一条链、同一套共识参数
+func checkHello(local, remote *HelloMessage) error {
+	switch {
+	case remote.NetworkID != local.NetworkID:
+		return fmt.Errorf("%w: network %q (local %q)", errChainMismatch, remote.NetworkID, local.NetworkID)
+	case remote.GenesisHash != local.GenesisHash:
+		return fmt.Errorf("%w: genesis %s (local %s)", errChainMismatch, remote.GenesisHash, local.GenesisHash)
+	case remote.ProtocolVersion < MinProtocolVersion:
+		return fmt.Errorf("%w: protocol version %d (min %d)", errChainMismatch, remote.ProtocolVersion, MinProtocolVersion)
+	case remote.ConsensusVersion != local.ConsensusVersion || remote.ConsensusHash != local.ConsensusHash:
+		return fmt.Errorf("%w: consensus v%s %.16s (local v%s %.16s)", errChainMismatch,
+			remote.ConsensusVersion, remote.ConsensusHash, local.ConsensusVersion, local.ConsensusHash)
+	}
+	return nil
+}
+
+// negotiateCapabilities 双方都支持的可选功能
+func negotiateCapabilities(local, remote []string) map[string]bool {
+	offered := make(map[string]bool, len(remote))
+	for _, c := range remote {
+		offered[c] = true
+	}
+	caps := make(map[string]bool)
+	for _, c := range local {
+		if offered[c] {
+			caps[c] = true
+		}
+	}
+	return caps
+}
+
+// exchangeHello 加密通道建立后交换Hello（出站方先发，入站方校验后回复）
+func (s *Server) exchangeHello(peer *Peer) error {
+	local := localHello()
+	helloMsg, err := NewMessage(MsgHello, local)
+	if err != nil {
+		return err
+	}
+
+	if peer.outbound {
+		if err := peer.writeMessage(helloMsg); err != nil {
+			return fmt.Errorf("failed to send hello: %v", err)
+		}
+	}
+
+	remote, err := peer.readHello()
+	if err != nil {
+		return err
+	}
+	if err := checkHello(local, remote); err != nil {
+		return err
+	}
+
+	if !peer.outbound {
+		if err := peer.writeMessage(helloMsg); err != nil {
+			return fmt.Errorf("failed to send hello: %v", err)
+		}
+	}
+
+	peer.capabilities = negotiateCapabilities(local.Capabilities, remote.Capabilities)
+	log.Printf("🤝 Hello from %s: protocol v%d, capabilities %v", peer.host, remote.ProtocolVersion, remote.Capabilities)
+	return nil
+}
+
+// readHello 读取握手阶段的Hello（读写循环尚未启动）
+func (p *Peer) readHello() (*HelloMessage, error) {
+	frame, err := p.readFrame()
+	if err != nil {
+		return nil, fmt.Errorf("failed to receive hello: %v", err)
+	}
+	data, err := p.channel.open(frame)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt hello: %v", err)
+	}
+	msg, err := decodeMessage(p.codec, data)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Type != MsgHello {
+		return nil, fmt.Errorf("expected hello, got message type %d", msg.Type)
+	}
+
+	var hello HelloMessage
+	if err := msg.ParsePayload(&hello); err != nil {
+		return nil, fmt.Errorf("invalid hello: %v", err)
+	}
+	return &hello, nil
+}
+
+// supports peer是否支持某个可选功能（过渡期的明文节点没有Hello，按旧行为全部发送）
+func (p *Peer) supports(capability string) bool {
+	if p.capabilities == nil {
+		return true
+	}
+	return p.capabilities[capability]
+}
@@ -0,0 +1,73 @@
+package network
+
+import (
+	"errors"
+	"testing"
+
+	"fan-chain/core"
+)
+
+// 链标识、创世区块、协议版本、共识参数任一不一致都判定为不同链
+func TestCheckHelloRejectsMismatch(t *testing.T) {
+	local := localHello()
+	if err := checkHello(local, localHello()); err != nil {
+		t.Fatalf("identical hello rejected: %v", err)
+	}
+
+	cases := map[string]func(h *HelloMessage){
+		"network":   func(h *HelloMessage) { h.NetworkID = "fan-testnet" },
+		"genesis":   func(h *HelloMessage) { h.GenesisHash = core.Hash{1} },
+		"protocol":  func(h *HelloMessage) { h.ProtocolVersion = MinProtocolVersion - 1 },
+		"version":   func(h *HelloMessage) { h.ConsensusVersion = "0.0.0" },
+		"consensus": func(h *HelloMessage) { h.ConsensusHash = "stale" },
+	}
+	for name, mutate := range cases {
+		remote := localHello()
+		mutate(remote)
+		if err := checkHello(local, remote); !errors.Is(err, errChainMismatch) {
+			t.Errorf("%s: err = %v, want chain mismatch", name, err)
+		}
+	}
+
+	// 更高的协议版本可以连接（由能力协商决定使用哪些功能）
+	newer := localHello()
+	newer.ProtocolVersion = ProtocolVersion + 1
+	newer.Capabilities = append([]string{"future"}, newer.Capabilities...)
+	if err := checkHello(local, newer); err != nil {
+		t.Fatalf("newer protocol rejected: %v", err)
+	}
+}
+
+func TestNegotiateCapabilities(t *testing.T) {
+	caps := negotiateCapabilities(localCapabilities, []string{CapEvidence, "future"})
+	if len(caps) != 1 || !caps[CapEvidence] {
+		t.Fatalf("capabilities = %v, want only %s", caps, CapEvidence)
+	}
+
+	peer := &Peer{capabilities: caps}
+	if !peer.supports(CapEvidence) || peer.supports(CapAddrExchange) || peer.supports("future") {
+		t.Fatalf("supports mismatch for %v", caps)
+	}
+
+	// 明文节点没有Hello，保持旧行为
+	if legacy := (&Peer{}); !legacy.supports(CapAddrExchange) {
+		t.Fatal("legacy peer should support all capabilities")
+	}
+}
+
+// 握手完成后双方都得到协商的能力
+func TestHandshakeExchangesHello(t *testing.T) {
+	client, server := newKeyedServer(t), newKeyedServer(t)
+	clientPeer, serverPeer, clientErr, serverErr := runHandshake(client, server)
+	if clientErr != nil || serverErr != nil {
+		t.Fatalf("handshake failed: client=%v server=%v", clientErr, serverErr)
+	}
+	defer clientPeer.conn.Close()
+	defer serverPeer.conn.Close()
+
+	for _, peer := range []*Peer{clientPeer, serverPeer} {
+		if len(peer.capabilities) != len(localCapabilities) {
+			t.Fatalf("negotiated capabilities = %v, want %v", peer.capabilities, localCapabilities)
+		}
+	}
+}
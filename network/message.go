@@ -3,69 +3,101 @@ package network
 import (
 	"encoding/json"
 	"fan-chain/core"
+	"fmt"
+	"sync"
 )
 
 // 消息类型
 type MessageType uint8
 
 const (
-	MsgPing          MessageType = 0  // Ping
-	MsgPong          MessageType = 1  // Pong
-	MsgGetBlocks     MessageType = 2  // 请求区块
-	MsgBlocks        MessageType = 3  // 区块数据
-	MsgGetLatest     MessageType = 4  // 请求最新区块高度
-	MsgLatestHeight  MessageType = 5  // 最新区块高度
-	MsgNewBlock      MessageType = 6  // 新区块广播
-	MsgTransaction   MessageType = 7  // 交易广播
-	MsgKeyExchange   MessageType = 8  // 密钥交换
-	MsgEncrypted     MessageType = 9  // 加密消息
+	MsgPing              MessageType = 0  // Ping
+	MsgPong              MessageType = 1  // Pong
+	MsgGetBlocks         MessageType = 2  // 请求区块
+	MsgBlocks            MessageType = 3  // 区块数据
+	MsgGetLatest         MessageType = 4  // 请求最新区块高度
+	MsgLatestHeight      MessageType = 5  // 最新区块高度
+	MsgNewBlock          MessageType = 6  // 新区块广播
+	MsgTransaction       MessageType = 7  // 交易广播
+	MsgKeyExchange       MessageType = 8  // 密钥交换
+	MsgEncrypted         MessageType = 9  // 加密消息
 	MsgGetCheckpoint     MessageType = 10 // 请求最新checkpoint
 	MsgCheckpoint        MessageType = 11 // checkpoint数据
 	MsgGetState          MessageType = 12 // 请求状态快照
 	MsgStateData         MessageType = 13 // 状态快照数据
 	MsgGetEarliestHeight MessageType = 14 // 【P2协议】请求最早区块高度
 	MsgEarliestHeight    MessageType = 15 // 【P2协议】最早区块高度响应
+	MsgEvidence          MessageType = 16 // 双签证据广播
+	MsgCheckpointSig     MessageType = 17 // checkpoint验证者签名
+	MsgGetAddr           MessageType = 18 // 请求已知节点地址
+	MsgAddr              MessageType = 19 // 已知节点地址
+	MsgHello             MessageType = 20 // 握手：链标识、协议版本、能力
+	MsgGetStateChunk     MessageType = 21 // 请求状态分片
+	MsgStateChunk        MessageType = 22 // 状态分片数据
 )
 
 // 消息结构
+// 本地创建的消息保存原始内容，发送时按对方协商的编码序列化（同一消息广播给多个peer时复用编码结果）
+// 收到的消息保存对应编码的原始数据，由ParsePayload解码
 type Message struct {
 	Type    MessageType     `json:"type"`
 	Payload json.RawMessage `json:"payload"`
+
+	value  interface{} // 本地创建的消息内容
+	binary []byte      // 二进制编码收到的消息内容
+	codec  uint8       // 收到消息时的编码
+
+	encodeMu    sync.Mutex
+	binaryFrame []byte // 二进制编码结果缓存
+}
+
+// Hello消息（加密握手后双方交换的第一条消息，不一致即断开）
+type HelloMessage struct {
+	NetworkID        string    `json:"network_id"`        // 网络标识
+	GenesisHash      core.Hash `json:"genesis_hash"`      // 创世区块哈希
+	ProtocolVersion  uint32    `json:"protocol_version"`  // P2P协议版本
+	ConsensusVersion string    `json:"consensus_version"` // 共识版本
+	ConsensusHash    string    `json:"consensus_hash"`    // 共识参数哈希
+	Capabilities     []string  `json:"capabilities"`      // 支持的可选功能
 }
 
 // Ping消息
 type PingMessage struct {
-	Address             string `json:"address"`              // 节点地址
-	Height              uint64 `json:"height"`               // 当前高度
-	LatestBlockHash     string `json:"latest_block_hash"`    // 最新区块哈希（用于分叉检测）
-	CheckpointHeight    uint64 `json:"checkpoint_height"`    // 最新checkpoint高度
-	CheckpointHash      string `json:"checkpoint_hash"`      // 最新checkpoint区块哈希
-	CheckpointTimestamp int64  `json:"checkpoint_timestamp"` // 最新checkpoint时间戳（用于分叉选择）
-	ConsensusVersion    string `json:"consensus_version"`    // 共识版本
-	ConsensusHash       string `json:"consensus_hash"`       // 共识哈希
+	Address             string `json:"address"`               // 节点地址
+	Height              uint64 `json:"height"`                // 当前高度
+	LatestBlockHash     string `json:"latest_block_hash"`     // 最新区块哈希（用于分叉检测）
+	CheckpointHeight    uint64 `json:"checkpoint_height"`     // 最新checkpoint高度
+	CheckpointHash      string `json:"checkpoint_hash"`       // 最新checkpoint区块哈希
+	CheckpointTimestamp int64  `json:"checkpoint_timestamp"`  // 最新checkpoint时间戳（用于分叉选择）
+	ConsensusVersion    string `json:"consensus_version"`     // 共识版本
+	ConsensusHash       string `json:"consensus_hash"`        // 共识哈希
+	ListenPort          int    `json:"listen_port,omitempty"` // P2P监听端口（入站连接的来源端口是临时的，靠它得到可拨号地址）
 }
 
 // Pong消息
 type PongMessage struct {
-	Address             string `json:"address"`              // 节点地址
-	Height              uint64 `json:"height"`               // 当前高度
-	LatestBlockHash     string `json:"latest_block_hash"`    // 最新区块哈希（用于分叉检测）
-	CheckpointHeight    uint64 `json:"checkpoint_height"`    // 最新checkpoint高度
-	CheckpointHash      string `json:"checkpoint_hash"`      // 最新checkpoint区块哈希
-	CheckpointTimestamp int64  `json:"checkpoint_timestamp"` // 最新checkpoint时间戳（用于分叉选择）
-	ConsensusVersion    string `json:"consensus_version"`    // 共识版本
-	ConsensusHash       string `json:"consensus_hash"`       // 共识哈希
+	Address             string `json:"address"`               // 节点地址
+	Height              uint64 `json:"height"`                // 当前高度
+	LatestBlockHash     string `json:"latest_block_hash"`     // 最新区块哈希（用于分叉检测）
+	CheckpointHeight    uint64 `json:"checkpoint_height"`     // 最新checkpoint高度
+	CheckpointHash      string `json:"checkpoint_hash"`       // 最新checkpoint区块哈希
+	CheckpointTimestamp int64  `json:"checkpoint_timestamp"`  // 最新checkpoint时间戳（用于分叉选择）
+	ConsensusVersion    string `json:"consensus_version"`     // 共识版本
+	ConsensusHash       string `json:"consensus_hash"`        // 共识哈希
+	ListenPort          int    `json:"listen_port,omitempty"` // P2P监听端口
 }
 
 // 请求区块消息
 type GetBlocksMessage struct {
-	FromHeight uint64 `json:"from_height"` // 起始高度
-	ToHeight   uint64 `json:"to_height"`   // 结束高度
+	FromHeight uint64 `json:"from_height"`          // 起始高度
+	ToHeight   uint64 `json:"to_height"`            // 结束高度
+	RequestID  uint64 `json:"request_id,omitempty"` // 请求ID（响应中原样带回）
 }
 
 // 区块数据消息
 type BlocksMessage struct {
-	Blocks []*core.Block `json:"blocks"`
+	Blocks    []*core.Block `json:"blocks"`
+	RequestID uint64        `json:"request_id,omitempty"` // 对应的请求ID
 }
 
 // 最新高度消息
@@ -75,7 +107,8 @@ type LatestHeightMessage struct {
 
 // 新区块广播消息
 type NewBlockMessage struct {
-	Block *core.Block `json:"block"`
+	Block     *core.Block `json:"block"`
+	PublicKey []byte      `json:"public_key,omitempty"` // 出块者ML-DSA公钥（验证区块头签名，构造双签证据）
 }
 
 // 交易广播消息
@@ -83,14 +116,37 @@ type TransactionMessage struct {
 	Transaction *core.Transaction `json:"transaction"`
 }
 
+// 双签证据广播消息
+type EvidenceMessage struct {
+	Evidence *core.DoubleSignEvidence `json:"evidence"`
+}
+
+// checkpoint验证者签名消息
+type CheckpointSigMessage struct {
+	Height         uint64                    `json:"height"`          // checkpoint高度
+	CheckpointHash core.Hash                 `json:"checkpoint_hash"` // 被签名的checkpoint哈希
+	Signature      *core.CheckpointSignature `json:"signature"`
+}
+
+// 请求已知节点地址消息
+type GetAddrMessage struct {
+}
+
+// 已知节点地址消息（IP:Port）
+type AddrMessage struct {
+	Addresses []string `json:"addresses"`
+}
+
 // 请求checkpoint消息
 type GetCheckpointMessage struct {
-	Count uint64 `json:"count"` // 请求最新N个checkpoint（默认3）
+	Count     uint64 `json:"count"`                // 请求最新N个checkpoint（默认3）
+	RequestID uint64 `json:"request_id,omitempty"` // 请求ID（响应中原样带回）
 }
 
 // checkpoint数据消息（现在可以包含多个checkpoint）
 type CheckpointMessage struct {
-	Checkpoints []CheckpointInfo `json:"checkpoints"` // checkpoint列表（从新到旧）
+	Checkpoints []CheckpointInfo `json:"checkpoints"`          // checkpoint列表（从新到旧）
+	RequestID   uint64           `json:"request_id,omitempty"` // 对应的请求ID（广播为0）
 }
 
 // 单个checkpoint信息
@@ -102,13 +158,29 @@ type CheckpointInfo struct {
 
 // 请求状态快照消息
 type GetStateMessage struct {
-	Height uint64 `json:"height"` // 请求指定高度的状态快照
+	Height    uint64 `json:"height"`               // 请求指定高度的状态快照
+	RequestID uint64 `json:"request_id,omitempty"` // 请求ID（响应中原样带回）
 }
 
 // 状态快照数据消息
 type StateDataMessage struct {
-	Height         uint64 `json:"height"`          // 快照高度
-	CompressedData []byte `json:"compressed_data"` // 压缩后的状态数据
+	Height         uint64 `json:"height"`               // 快照高度
+	CompressedData []byte `json:"compressed_data"`      // 压缩后的状态数据
+	RequestID      uint64 `json:"request_id,omitempty"` // 对应的请求ID（广播为0）
+}
+
+// 请求状态分片消息
+type GetStateChunkMessage struct {
+	Height    uint64 `json:"height"`               // checkpoint高度
+	Index     uint32 `json:"index"`                // 分片序号（键前缀）
+	RequestID uint64 `json:"request_id,omitempty"` // 请求ID（响应中原样带回）
+}
+
+// 状态分片数据消息（对方没有该高度的快照时Chunk为空）
+type StateChunkMessage struct {
+	Height    uint64           `json:"height"`               // checkpoint高度
+	Chunk     *core.StateChunk `json:"chunk,omitempty"`      // 分片账户及证明
+	RequestID uint64           `json:"request_id,omitempty"` // 对应的请求ID
 }
 
 // 【P2协议】请求最早区块高度消息
@@ -120,27 +192,58 @@ type EarliestHeightMessage struct {
 	Height uint64 `json:"height"` // 本节点最早的区块高度
 }
 
-// 创建消息
+// 创建消息（消息内容必须支持二进制编码，nil表示无内容）
 func NewMessage(msgType MessageType, payload interface{}) (*Message, error) {
-	data, err := json.Marshal(payload)
-	if err != nil {
-		return nil, err
+	if payload != nil {
+		if _, ok := payload.(wireEncoder); !ok {
+			return nil, fmt.Errorf("message type %d: payload %T has no binary encoding", msgType, payload)
+		}
 	}
 
 	return &Message{
-		Type:    msgType,
-		Payload: data,
+		Type:  msgType,
+		value: payload,
 	}, nil
 }
 
 // 解析消息
 func (m *Message) ParsePayload(v interface{}) error {
-	return json.Unmarshal(m.Payload, v)
+	if m.codec == WireCodecBinary {
+		return decodeWirePayload(m.binary, v)
+	}
+
+	payload, err := m.jsonPayload()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(payload, v)
+}
+
+// jsonPayload 消息内容的JSON编码（本地创建的消息首次使用时序列化）
+func (m *Message) jsonPayload() (json.RawMessage, error) {
+	m.encodeMu.Lock()
+	defer m.encodeMu.Unlock()
+
+	if m.Payload == nil {
+		if m.codec == WireCodecBinary {
+			return nil, fmt.Errorf("message received in binary encoding has no JSON payload")
+		}
+		data, err := json.Marshal(m.value)
+		if err != nil {
+			return nil, err
+		}
+		m.Payload = data
+	}
+	return m.Payload, nil
 }
 
-// 序列化消息
+// 序列化消息（JSON编码）
 func (m *Message) Marshal() ([]byte, error) {
-	return json.Marshal(m)
+	payload, err := m.jsonPayload()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&Message{Type: m.Type, Payload: payload})
 }
 
 // 反序列化消息
@@ -38,11 +38,15 @@ func (s *Server) processMessages() {
 			continue
 		}
 
-		// 非阻塞接收消息
-		select {
-		case msg := <-peer.recvChan:
-			s.handleMessage(peer, msg)
-		default:
+		// 非阻塞接收消息（每轮每个peer最多处理maxMessagesPerTick条）
+	drain:
+		for i := 0; i < maxMessagesPerTick; i++ {
+			select {
+			case msg := <-peer.recvChan:
+				s.handleMessage(peer, msg)
+			default:
+				break drain
+			}
 		}
 	}
 }
@@ -74,10 +78,26 @@ func (s *Server) handleMessage(peer *Peer, msg *Message) {
 		s.handleGetState(peer, msg)
 	case MsgStateData:
 		s.handleStateData(peer, msg)
+	case MsgGetStateChunk:
+		s.handleGetStateChunk(peer, msg)
+	case MsgStateChunk:
+		s.handleStateChunk(peer, msg)
 	case MsgGetEarliestHeight:
 		s.handleGetEarliestHeight(peer, msg)
 	case MsgEarliestHeight:
 		s.handleEarliestHeight(peer, msg)
+	case MsgEvidence:
+		s.handleEvidence(peer, msg)
+	case MsgCheckpointSig:
+		s.handleCheckpointSignature(peer, msg)
+	case MsgGetAddr:
+		s.handleGetAddr(peer, msg)
+	case MsgAddr:
+		s.handleAddr(peer, msg)
+	case MsgHello:
+		// Hello只在握手阶段交换，之后重复发送视为异常消息
+		log.Printf("Unexpected hello from %s after handshake", peer.host)
+		s.penalizePeer(peer, MisbehaviorInvalidMessage)
 	default:
 		log.Printf("Unknown message type from %s: %d", peer.host, msg.Type)
 	}
@@ -88,6 +108,7 @@ func (s *Server) handlePing(peer *Peer, msg *Message) {
 	var ping PingMessage
 	if err := msg.ParsePayload(&ping); err != nil {
 		log.Printf("Failed to parse ping from %s: %v", peer.host, err)
+		s.penalizePeer(peer, MisbehaviorInvalidMessage)
 		return
 	}
 
@@ -98,12 +119,16 @@ func (s *Server) handlePing(peer *Peer, msg *Message) {
 		log.Printf("   Local:  v%s hash=%s...", consensusConfig.ConsensusVersion, consensusConfig.ConsensusHash[:16])
 		log.Printf("   Remote: v%s hash=%s...", ping.ConsensusVersion, ping.ConsensusHash[:16])
 		log.Printf("   ⚠️  Disconnecting incompatible peer (different blockchain network)")
+		s.penalizePeer(peer, MisbehaviorHandshakeFailed)
 		s.removePeer(peer.host)
 		return
 	}
 
 	log.Printf("Received ping from %s (height: %d, consensus: OK)", ping.Address, ping.Height)
-	peer.SetAddress(ping.Address)
+	if !s.bindPeerAddress(peer, ping.Address) {
+		return
+	}
+	s.learnListenAddress(peer, ping.ListenPort)
 
 	// 回复Pong（包含共识信息和checkpoint信息）
 	var height uint64
@@ -138,6 +163,7 @@ func (s *Server) handlePing(peer *Peer, msg *Message) {
 		CheckpointTimestamp: checkpointTimestamp,
 		ConsensusVersion:    consensusConfig.ConsensusVersion,
 		ConsensusHash:       consensusConfig.ConsensusHash,
+		ListenPort:          s.port,
 	}
 
 	pongMsg, err := NewMessage(MsgPong, pong)
@@ -154,6 +180,7 @@ func (s *Server) handlePong(peer *Peer, msg *Message) {
 	var pong PongMessage
 	if err := msg.ParsePayload(&pong); err != nil {
 		log.Printf("Failed to parse pong from %s: %v", peer.host, err)
+		s.penalizePeer(peer, MisbehaviorInvalidMessage)
 		return
 	}
 
@@ -164,14 +191,23 @@ func (s *Server) handlePong(peer *Peer, msg *Message) {
 		log.Printf("   Local:  v%s hash=%s...", consensusConfig.ConsensusVersion, consensusConfig.ConsensusHash[:16])
 		log.Printf("   Remote: v%s hash=%s...", pong.ConsensusVersion, pong.ConsensusHash[:16])
 		log.Printf("   ⚠️  Disconnecting incompatible peer (different blockchain network)")
+		s.penalizePeer(peer, MisbehaviorHandshakeFailed)
 		s.removePeer(peer.host)
 		return
 	}
 
 	log.Printf("Received pong from %s (height: %d, consensus: OK)", pong.Address, pong.Height)
-	peer.SetAddress(pong.Address)
-	peer.UpdateHeartbeat() // 更新心跳时间
+	if !s.bindPeerAddress(peer, pong.Address) {
+		return
+	}
+	peer.UpdateHeartbeat()      // 更新心跳时间
 	peer.SetHeight(pong.Height) // 【家长制】更新peer高度
+	s.learnListenAddress(peer, pong.ListenPort)
+
+	// 握手完成后向对方请求已知节点地址，之后随心跳定期刷新
+	if peer.markAddrRequested() {
+		s.requestAddresses(peer)
+	}
 
 	// 🔍 分叉检测：基于Checkpoint的分叉检测和解决（谁快认谁做大哥）
 	if s.detectAndResolveFork != nil {
@@ -205,6 +241,7 @@ func (s *Server) handleLatestHeight(peer *Peer, msg *Message) {
 	var latestHeight LatestHeightMessage
 	if err := msg.ParsePayload(&latestHeight); err != nil {
 		log.Printf("Failed to parse latest height from %s: %v", peer.host, err)
+		s.penalizePeer(peer, MisbehaviorInvalidMessage)
 		return
 	}
 
@@ -216,9 +253,20 @@ func (s *Server) handleGetBlocks(peer *Peer, msg *Message) {
 	var req GetBlocksMessage
 	if err := msg.ParsePayload(&req); err != nil {
 		log.Printf("Failed to parse get blocks from %s: %v", peer.host, err)
+		s.penalizePeer(peer, MisbehaviorInvalidMessage)
 		return
 	}
 
+	// 单次最多返回MaxBlockRequestSize个区块，超出部分截断（请求方按收到的最高高度继续请求）
+	if req.ToHeight < req.FromHeight {
+		log.Printf("Invalid block range %d-%d from %s", req.FromHeight, req.ToHeight, peer.host)
+		s.penalizePeer(peer, MisbehaviorInvalidMessage)
+		return
+	}
+	if maxBlocks := uint64(core.GetConsensusConfig().NetworkParams.MaxBlockRequestSize); maxBlocks > 0 && req.ToHeight-req.FromHeight >= maxBlocks {
+		req.ToHeight = req.FromHeight + maxBlocks - 1
+	}
+
 	log.Printf("Peer %s requesting blocks %d-%d", peer.host, req.FromHeight, req.ToHeight)
 
 	// 从数据库获取区块
@@ -239,7 +287,7 @@ func (s *Server) handleGetBlocks(peer *Peer, msg *Message) {
 	}
 
 	// 发送区块
-	blocksMsg, err := NewMessage(MsgBlocks, &BlocksMessage{Blocks: blocks})
+	blocksMsg, err := NewMessage(MsgBlocks, &BlocksMessage{Blocks: blocks, RequestID: req.RequestID})
 	if err != nil {
 		log.Printf("Failed to create blocks message: %v", err)
 		return
@@ -257,6 +305,9 @@ func (s *Server) handleBlocks(peer *Peer, msg *Message) {
 		s.finishSync()
 		return
 	}
+	if !s.acceptResponse(peer, MsgBlocks, blocks.RequestID, false) {
+		return
+	}
 
 	if len(blocks.Blocks) == 0 {
 		log.Printf("Received 0 blocks from %s", peer.host)
@@ -384,7 +435,7 @@ func (s *Server) handleBlocks(peer *Peer, msg *Message) {
 			dbBlocks, err := s.getBlockRange(h, h)
 			if err != nil || len(dbBlocks) == 0 {
 				// 缺区块了，停止应用，等下次收齐
-				if h == currentLocalHeight + 1 {
+				if h == currentLocalHeight+1 {
 					log.Printf("📦 区块#%d不在数据库，继续请求...", h)
 				}
 				break
@@ -586,11 +637,18 @@ func (s *Server) handleNewBlock(peer *Peer, msg *Message) {
 	var newBlock NewBlockMessage
 	if err := msg.ParsePayload(&newBlock); err != nil {
 		log.Printf("Failed to parse new block from %s: %v", peer.host, err)
+		s.penalizePeer(peer, MisbehaviorInvalidMessage)
 		return
 	}
 
 	log.Printf("Received new block #%d from %s", newBlock.Block.Header.Height, peer.host)
 
+	// 记录已签名区块头，同一出块者同一高度出现两个不同区块即为双签
+	if !s.recordSignedHeader(newBlock.Block.Header, newBlock.PublicKey) {
+		s.penalizePeer(peer, MisbehaviorInvalidBlock)
+		return
+	}
+
 	// 获取当前高度
 	var currentHeight uint64
 	if s.getLatestBlock != nil {
@@ -621,27 +679,13 @@ func (s *Server) handleNewBlock(peer *Peer, msg *Message) {
 					log.Printf("Failed to verify proposer for block #%d: %v", incomingHeight, err)
 					return
 				}
-				if newBlock.Block.Header.Proposer != expectedProposer {
-					log.Printf("⚠ FORK PREVENTED: Block #%d from %s rejected (VRF selected: %s)",
-						incomingHeight, newBlock.Block.Header.Proposer, expectedProposer)
-
-					// 【简单多数认输】记录被拒绝的proposer，检查是否应该认输
-					shouldSurrender, winningProposer := s.RecordRejectedProposer(incomingHeight, newBlock.Block.Header.Proposer)
-					if shouldSurrender {
-						log.Printf("🏳️ 【简单多数认输】Surrendering at height %d, accepting %s's block",
-							incomingHeight, winningProposer[:10])
-						// 直接接受这个区块
-						if s.addBlock != nil {
-							if err := s.addBlock(newBlock.Block); err != nil {
-								log.Printf("Failed to add surrendered block #%d: %v", incomingHeight, err)
-							} else {
-								log.Printf("✓ Accepted surrendered block #%d from %s", incomingHeight, winningProposer[:10])
-								// 清理旧记录
-								s.CleanupRejectedProposers(incomingHeight)
-							}
-						}
+				// 出块名次、时间槽和VRF证明一并验证：只接受VRF选中者或时间槽已开启的备用出块者
+				if s.verifyBlockVRF != nil {
+					if err := s.verifyBlockVRF(newBlock.Block, latestBlock); err != nil {
+						log.Printf("⚠ FORK PREVENTED: Block #%d from %s rejected (VRF selected: %s): %v",
+							incomingHeight, newBlock.Block.Header.Proposer, expectedProposer, err)
+						return
 					}
-					return
 				}
 			}
 		}
@@ -681,6 +725,12 @@ func (s *Server) handleNewBlock(peer *Peer, msg *Message) {
 				log.Printf("Failed to verify proposer for reorg at #%d: %v", currentHeight, err)
 				return
 			}
+			if s.verifyBlockVRF != nil {
+				if err := s.verifyBlockVRF(newBlock.Block, prevBlock[0]); err != nil {
+					log.Printf("⚠ Block #%d from %s rejected: %v", currentHeight, newBlock.Block.Header.Proposer, err)
+					return
+				}
+			}
 
 			// 获取当前高度的本地区块
 			localBlocks, err := s.getBlockRange(currentHeight, currentHeight)
@@ -693,10 +743,14 @@ func (s *Server) handleNewBlock(peer *Peer, msg *Message) {
 			incomingProposer := newBlock.Block.Header.Proposer
 			localProposer := localBlock.Header.Proposer
 
-			// 情况A: 接收到的区块来自正确proposer，本地区块不是 -> 执行reorg
-			if incomingProposer == expectedProposer && localProposer != expectedProposer {
-				log.Printf("⚠ CHAIN REORGANIZATION: Local block #%d from wrong proposer %s, accepting correct block from %s",
-					currentHeight, localProposer[:10], expectedProposer[:10])
+			// 按出块时间表比较：名次小的出块者优先，-1表示不是合法出块者
+			incomingRank := s.scheduledRank(newBlock.Block, prevBlock[0], expectedProposer)
+			localRank := s.scheduledRank(localBlock, prevBlock[0], expectedProposer)
+
+			// 情况A: 接收到的区块名次更靠前（或本地区块不合法） -> 执行reorg
+			if incomingRank >= 0 && (localRank < 0 || incomingRank < localRank) {
+				log.Printf("⚠ CHAIN REORGANIZATION: Local block #%d from %s (rank %d), accepting block from %s (rank %d)",
+					currentHeight, localProposer[:10], localRank, incomingProposer[:10], incomingRank)
 
 				if s.performReorg != nil {
 					if err := s.performReorg(currentHeight-1, newBlock.Block); err != nil {
@@ -710,81 +764,26 @@ func (s *Server) handleNewBlock(peer *Peer, msg *Message) {
 				return
 			}
 
-			// 情况B: 接收到的区块来自错误proposer，本地区块是正确的 -> 拒绝
-			if incomingProposer != expectedProposer && localProposer == expectedProposer {
-				log.Printf("⚠ FORK PREVENTED: Rejecting block #%d from wrong proposer %s (local has correct: %s)",
-					currentHeight, incomingProposer[:10], expectedProposer[:10])
-
-				// 【简单多数认输】记录被拒绝的proposer，检查是否应该认输（针对相同高度的区块）
-				shouldSurrender, winningProposer := s.RecordRejectedProposer(currentHeight, incomingProposer)
-				if shouldSurrender {
-					log.Printf("🏳️ 【简单多数认输】Height %d: my VRF says %s but majority says %s, need REORG!",
-						currentHeight, expectedProposer[:10], winningProposer[:10])
-					// 执行reorg接受多数派的区块
-					if s.performReorg != nil {
-						if err := s.performReorg(currentHeight-1, newBlock.Block); err != nil {
-							log.Printf("❌ SURRENDER REORG FAILED: %v", err)
-						} else {
-							log.Printf("✅ SURRENDER REORG SUCCESS: Accepted majority block at height %d", currentHeight)
-							s.CleanupRejectedProposers(currentHeight)
-						}
-					}
-				}
-				return
-			}
+			// 情况B: 本地区块名次不落后 -> 拒绝
+			log.Printf("⚠ FORK PREVENTED: Rejecting block #%d from %s (rank %d), local has %s (rank %d)",
+				currentHeight, incomingProposer[:10], incomingRank, localProposer[:10], localRank)
 
-			// 情况C: 两个都不是VRF选中的正确proposer -> 使用质押权重选择
-			if incomingProposer != expectedProposer && localProposer != expectedProposer {
-				log.Printf("⚠ FORK DETECTED: Neither proposer matches VRF selection at height %d", currentHeight)
-				log.Printf("   Local: %s, Incoming: %s, Expected: %s",
-					localProposer[:10], incomingProposer[:10], expectedProposer[:10])
-
-				// 使用质押权重选择链
-				if s.getProposerStake != nil {
-					localStake := s.getProposerStake(localProposer)
-					incomingStake := s.getProposerStake(incomingProposer)
-
-					log.Printf("   Stake comparison: Local=%d FAN, Incoming=%d FAN",
-						localStake/1000000, incomingStake/1000000)
-
-					// 接收质押更高的链
-					if incomingStake > localStake {
-						log.Printf("⚠ STAKE-WEIGHTED REORG: Accepting block from higher-stake proposer")
-						if s.performReorg != nil {
-							if err := s.performReorg(currentHeight-1, newBlock.Block); err != nil {
-								log.Printf("❌ REORG FAILED: %v", err)
-								return
-							}
-							log.Printf("✅ REORG SUCCESS: Switched to higher-stake chain")
-						}
-						return
-					} else if incomingStake < localStake {
-						log.Printf("✓ FORK RESOLVED: Keeping local block from higher-stake proposer")
-						return
+			// 【简单多数认输】记录被拒绝的proposer，检查是否应该认输（针对相同高度的区块）
+			shouldSurrender, winningProposer := s.RecordRejectedProposer(currentHeight, incomingProposer)
+			if shouldSurrender {
+				log.Printf("🏳️ 【简单多数认输】Height %d: my VRF says %s but majority says %s, need REORG!",
+					currentHeight, expectedProposer[:10], winningProposer[:10])
+				// 执行reorg接受多数派的区块
+				if s.performReorg != nil {
+					if err := s.performReorg(currentHeight-1, newBlock.Block); err != nil {
+						log.Printf("❌ SURRENDER REORG FAILED: %v", err)
 					} else {
-						// 质押相同，使用VRF输出作为tie-breaker
-						log.Printf("   Stakes equal, using VRF output as tie-breaker")
-						// 比较proposer地址的字典序
-						if incomingProposer > localProposer {
-							log.Printf("⚠ TIE-BREAKER REORG: Accepting block based on VRF comparison")
-							if s.performReorg != nil {
-								if err := s.performReorg(currentHeight-1, newBlock.Block); err != nil {
-									log.Printf("❌ REORG FAILED: %v", err)
-									return
-								}
-								log.Printf("✅ REORG SUCCESS: Tie-breaker resolved")
-							}
-							return
-						} else {
-							log.Printf("✓ TIE-BREAKER: Keeping local block based on VRF comparison")
-							return
-						}
+						log.Printf("✅ SURRENDER REORG SUCCESS: Accepted majority block at height %d", currentHeight)
+						s.CleanupRejectedProposers(currentHeight)
 					}
-				} else {
-					log.Printf("⚠ Cannot resolve fork: getProposerStake callback not set")
 				}
-				return
 			}
+			return
 		}
 
 		// 其他情况：忽略相同高度的区块
@@ -851,25 +850,6 @@ func (s *Server) handleNewBlock(peer *Peer, msg *Message) {
 	}
 }
 
-// 处理交易广播
-func (s *Server) handleTransaction(peer *Peer, msg *Message) {
-	var txMsg TransactionMessage
-	if err := msg.ParsePayload(&txMsg); err != nil {
-		log.Printf("Failed to parse transaction from %s: %v", peer.host, err)
-		return
-	}
-
-	tx := txMsg.Transaction
-	log.Printf("Received transaction %x from %s", tx.Hash().Bytes()[:8], peer.host)
-
-	// 提交到本地节点处理
-	if s.handleReceivedTransaction != nil {
-		if err := s.handleReceivedTransaction(tx); err != nil {
-			log.Printf("Failed to handle received transaction: %v", err)
-		}
-	}
-}
-
 // 【P2协议】处理获取最早区块高度请求
 func (s *Server) handleGetEarliestHeight(peer *Peer, msg *Message) {
 	log.Printf("📡 【P2】Peer %s requesting earliest block height", peer.host)
@@ -899,6 +879,7 @@ func (s *Server) handleEarliestHeight(peer *Peer, msg *Message) {
 	var earliest EarliestHeightMessage
 	if err := msg.ParsePayload(&earliest); err != nil {
 		log.Printf("Failed to parse earliest height from %s: %v", peer.host, err)
+		s.penalizePeer(peer, MisbehaviorInvalidMessage)
 		return
 	}
 
@@ -962,6 +943,7 @@ func (s *Server) requestBackfillBatch(peer *Peer, fromHeight, targetHeight uint6
 	req := &GetBlocksMessage{
 		FromHeight: batchStart,
 		ToHeight:   fromHeight,
+		RequestID:  peer.trackRequest(MsgBlocks),
 	}
 
 	reqMsg, err := NewMessage(MsgGetBlocks, req)
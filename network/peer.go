@@ -3,12 +3,16 @@ package network
 import (
 	"bufio"
 	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"sync"
 	"time"
+
+	"fan-chain/core"
 )
 
 // 对等节点
@@ -23,8 +27,8 @@ type Peer struct {
 	mu        sync.Mutex
 
 	// 消息通道
-	sendChan chan *Message
-	recvChan chan *Message
+	sendChan  chan *Message
+	recvChan  chan *Message
 	closeChan chan struct{}
 
 	// 心跳检测
@@ -32,8 +36,38 @@ type Peer struct {
 	heartbeatMu   sync.RWMutex
 
 	// 【家长制】peer高度跟踪（用于Failover决策）
-	height   uint64    // peer报告的高度
+	height   uint64 // peer报告的高度
 	heightMu sync.RWMutex
+
+	// 交易gossip：peer已知交易集合
+	knownTxs map[core.Hash]struct{}
+	txMu     sync.Mutex
+
+	// 按消息类型的令牌桶限速（只在读取协程中使用）
+	limiters map[MessageType]*tokenBucket
+
+	// 本节点发出的未完成请求（请求ID -> 期望的响应），用于校验响应
+	requests      map[uint64]pendingRequest
+	nextRequestID uint64
+	requestsMu    sync.Mutex
+
+	// 不良行为上报（由Server在addPeer时设置，用于评分和封禁）
+	onMisbehavior func(reason Misbehavior)
+
+	// 地址交换：上次向对方请求地址、响应对方请求、收到对方地址的时间（限速）
+	addrRequestedAt time.Time
+	lastGetAddr     time.Time
+	lastAddr        time.Time
+	addrMu          sync.Mutex
+
+	// 加密通道：握手成功后设置，之后所有帧加密传输（过渡期的明文节点为nil）
+	channel      *secureChannel
+	remotePubKey []byte // 握手认证的对方ML-DSA公钥
+	authAddress  string // 由对方公钥推导的节点地址
+	codec        uint8  // 握手协商的线路编码（明文节点为JSON）
+
+	// Hello协商的双方都支持的可选功能（过渡期的明文节点为nil）
+	capabilities map[string]bool
 }
 
 // 创建对等节点
@@ -72,46 +106,57 @@ func (p *Peer) readLoop() {
 		// 设置读取超时
 		p.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 
-		// 读取消息长度（4字节）
-		var length uint32
-		if err := binary.Read(p.reader, binary.BigEndian, &length); err != nil {
+		data, err := p.readFrame()
+		if err != nil {
 			if err != io.EOF {
-				log.Printf("Peer %s read length error: %v", p.host, err)
+				log.Printf("Peer %s read error: %v", p.host, err)
+			}
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				p.reportMisbehavior(MisbehaviorTimeout)
+			} else if err == errFrameTooLarge {
+				p.reportMisbehavior(MisbehaviorSpam)
 			}
 			return
 		}
 
-		// 限制消息大小（最大10MB）
-		if length > 10*1024*1024 {
-			log.Printf("Peer %s message too large: %d bytes", p.host, length)
-			return
-		}
-
-		// 读取消息内容
-		data := make([]byte, length)
-		if _, err := io.ReadFull(p.reader, data); err != nil {
-			log.Printf("Peer %s read data error: %v", p.host, err)
-			return
+		// 解密（加密通道上解密失败说明帧被篡改、重放或对方不持有会话密钥，断开连接）
+		if p.channel != nil {
+			if data, err = p.channel.open(data); err != nil {
+				log.Printf("Peer %s decrypt error: %v", p.host, err)
+				p.reportMisbehavior(MisbehaviorInvalidMessage)
+				return
+			}
 		}
 
 		// 反序列化消息
-		msg, err := UnmarshalMessage(data)
+		msg, err := decodeMessage(p.codec, data)
 		if err != nil {
 			log.Printf("Peer %s unmarshal error: %v", p.host, err)
+			p.reportMisbehavior(MisbehaviorInvalidMessage)
 			continue
 		}
 
-		// 发送到接收通道
-		select {
-		case p.recvChan <- msg:
-		case <-p.closeChan:
+		// 按消息类型限速
+		if !p.allowMessage(msg.Type) {
+			log.Printf("⚠️ Peer %s exceeded rate limit for message type %d, dropping", p.host, msg.Type)
+			p.reportMisbehavior(MisbehaviorSpam)
+			continue
+		}
+
+		// 发送到接收通道（队列满时暂停读取，长时间满则断开）
+		if !p.enqueue(msg) {
 			return
-		default:
-			log.Printf("Peer %s receive channel full, dropping message", p.host)
 		}
 	}
 }
 
+// reportMisbehavior 上报不良行为
+func (p *Peer) reportMisbehavior(reason Misbehavior) {
+	if p.onMisbehavior != nil {
+		p.onMisbehavior(reason)
+	}
+}
+
 // 写入循环
 func (p *Peer) writeLoop() {
 	defer p.Close()
@@ -132,26 +177,76 @@ func (p *Peer) writeLoop() {
 // 写入消息
 func (p *Peer) writeMessage(msg *Message) error {
 	// 序列化消息
-	data, err := msg.Marshal()
+	data, err := msg.encode(p.codec)
 	if err != nil {
 		return err
 	}
 
-	// 写入消息长度
-	length := uint32(len(data))
-	if err := binary.Write(p.writer, binary.BigEndian, length); err != nil {
-		return err
+	// 加密
+	if p.channel != nil {
+		if data, err = p.channel.seal(data); err != nil {
+			return err
+		}
 	}
 
-	// 写入消息内容
+	return p.writeFrame(data)
+}
+
+// 最大帧长度（10MB）
+const maxFrameSize = 10 * 1024 * 1024
+
+var errFrameTooLarge = errors.New("frame too large")
+
+// readFrame 读取一帧：4字节长度 + 内容
+func (p *Peer) readFrame() ([]byte, error) {
+	var length uint32
+	if err := binary.Read(p.reader, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	if length > maxFrameSize {
+		return nil, errFrameTooLarge
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(p.reader, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// writeFrame 写入一帧：4字节长度 + 内容
+func (p *Peer) writeFrame(data []byte) error {
+	if err := binary.Write(p.writer, binary.BigEndian, uint32(len(data))); err != nil {
+		return err
+	}
 	if _, err := p.writer.Write(data); err != nil {
 		return err
 	}
-
-	// 刷新缓冲区
 	return p.writer.Flush()
 }
 
+// writeHandshakeMessage 握手阶段直接写连接（读写循环尚未启动），返回写出的消息内容（计入握手记录）
+func (p *Peer) writeHandshakeMessage(payload interface{}) (json.RawMessage, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(&Message{Type: MsgKeyExchange, Payload: body})
+	if err != nil {
+		return nil, err
+	}
+	return body, p.writeFrame(data)
+}
+
+// readHandshakeMessage 握手阶段直接读连接
+func (p *Peer) readHandshakeMessage() (*Message, error) {
+	data, err := p.readFrame()
+	if err != nil {
+		return nil, err
+	}
+	return UnmarshalMessage(data)
+}
+
 // 发送消息
 func (p *Peer) SendMessage(msg *Message) error {
 	p.mu.Lock()
@@ -0,0 +1,380 @@
+package network
+
+import (
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"fan-chain/core"
+)
+
+// Misbehavior 节点不良行为类型
+type Misbehavior int
+
+const (
+	MisbehaviorInvalidMessage  Misbehavior = iota // 无法解析的消息
+	MisbehaviorInvalidBlock                       // 区块签名或VRF证明无效
+	MisbehaviorHandshakeFailed                    // 握手失败（加密握手失败、共识版本不一致、地址与公钥不符）
+	MisbehaviorTimeout                            // 读取超时
+	MisbehaviorSpam                               // 超出限速、消息过大
+)
+
+func (m Misbehavior) String() string {
+	switch m {
+	case MisbehaviorInvalidMessage:
+		return "invalid message"
+	case MisbehaviorInvalidBlock:
+		return "invalid block"
+	case MisbehaviorHandshakeFailed:
+		return "handshake failed"
+	case MisbehaviorTimeout:
+		return "timeout"
+	case MisbehaviorSpam:
+		return "spam"
+	default:
+		return "unknown"
+	}
+}
+
+// 各类不良行为的扣分
+var misbehaviorPenalty = map[Misbehavior]int{
+	MisbehaviorInvalidMessage:  20,
+	MisbehaviorInvalidBlock:    50,
+	MisbehaviorHandshakeFailed: 10,
+	MisbehaviorTimeout:         5,
+	MisbehaviorSpam:            10,
+}
+
+const (
+	// 评分低于该值时封禁
+	peerBanThreshold = -100
+	// 评分每隔该时间恢复1分（最多恢复到0），偶发超时不会累积成封禁
+	peerScoreRecovery = time.Minute
+	// 默认封禁时长
+	DefaultPeerBanDuration = 24 * time.Hour
+	// 每轮最多主动拨号的已知地址数
+	maxDialsPerRound = 8
+	// 地址表容量
+	maxKnownPeers = 1000
+)
+
+// PeerStore 节点地址和封禁的持久化存储（storage.Database实现）
+type PeerStore interface {
+	SavePeer(address string, lastSeen int64) error
+	DeletePeer(address string) error
+	GetAllPeers() (map[string]int64, error)
+	SavePeerBan(host string, bannedUntil int64) error
+	DeletePeerBan(host string) error
+	GetAllPeerBans() (map[string]int64, error)
+}
+
+// peerScore 按IP记录的行为评分（入站连接端口是临时的，不能按IP:Port记分）
+type peerScore struct {
+	score     int
+	updatedAt time.Time
+}
+
+// peerManager 已知节点地址、行为评分和封禁
+type peerManager struct {
+	mu          sync.Mutex
+	store       PeerStore
+	known       map[string]int64 // 可拨号地址(IP:Port) -> 最近连接成功时间
+	scores      map[string]*peerScore
+	bans        map[string]int64 // IP -> 封禁到期时间（Unix秒）
+	banDuration time.Duration
+}
+
+func newPeerManager() *peerManager {
+	return &peerManager{
+		known:       make(map[string]int64),
+		scores:      make(map[string]*peerScore),
+		bans:        make(map[string]int64),
+		banDuration: DefaultPeerBanDuration,
+	}
+}
+
+// peerIP 从IP:Port中取出IP
+func peerIP(host string) string {
+	ip, _, err := net.SplitHostPort(host)
+	if err != nil {
+		return host
+	}
+	return ip
+}
+
+// load 从持久化存储恢复已知地址和未到期的封禁
+func (pm *peerManager) load(store PeerStore) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	pm.store = store
+	if store == nil {
+		return
+	}
+
+	if peers, err := store.GetAllPeers(); err != nil {
+		log.Printf("⚠️ Failed to load known peers: %v", err)
+	} else {
+		for addr, lastSeen := range peers {
+			pm.known[addr] = lastSeen
+		}
+	}
+
+	now := time.Now().Unix()
+	if bans, err := store.GetAllPeerBans(); err != nil {
+		log.Printf("⚠️ Failed to load peer bans: %v", err)
+	} else {
+		for ip, until := range bans {
+			if until > now {
+				pm.bans[ip] = until
+			} else {
+				store.DeletePeerBan(ip)
+			}
+		}
+	}
+
+	log.Printf("📒 Peer store loaded: %d known addresses, %d banned", len(pm.known), len(pm.bans))
+}
+
+// markConnected 出站连接成功：记录并持久化该地址，供重启或掉线后重新拨号
+func (pm *peerManager) markConnected(addr string) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	now := time.Now().Unix()
+	pm.known[addr] = now
+	if pm.store != nil {
+		if err := pm.store.SavePeer(addr, now); err != nil {
+			log.Printf("⚠️ Failed to save peer %s: %v", addr, err)
+		}
+	}
+}
+
+// addLearned 记录从其他节点得知的地址（尚未连接成功，最近连接时间记为0），返回新增数量
+func (pm *peerManager) addLearned(addrs []string) int {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	added := 0
+	for _, addr := range addrs {
+		if _, ok := pm.known[addr]; ok {
+			continue
+		}
+		if len(pm.known) >= maxKnownPeers {
+			break
+		}
+		pm.known[addr] = 0
+		if pm.store != nil {
+			pm.store.SavePeer(addr, 0)
+		}
+		added++
+	}
+	return added
+}
+
+// forgetUnreachable 从未连接成功过的地址拨号失败后丢弃（防止伪造地址占满地址表）
+func (pm *peerManager) forgetUnreachable(addr string) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	if lastSeen, ok := pm.known[addr]; !ok || lastSeen > 0 {
+		return
+	}
+	delete(pm.known, addr)
+	if pm.store != nil {
+		pm.store.DeletePeer(addr)
+	}
+}
+
+// forget 从地址表中移除（对方属于另一条链，重复拨号没有意义）
+func (pm *peerManager) forget(addr string) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	if _, ok := pm.known[addr]; !ok {
+		return
+	}
+	delete(pm.known, addr)
+	if pm.store != nil {
+		pm.store.DeletePeer(addr)
+	}
+}
+
+// shareable 可分享给其他节点的地址：本节点连接成功过且未被封禁的，最近连接成功的优先
+func (pm *peerManager) shareable(limit int) []string {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	addrs := make([]string, 0, limit)
+	for addr, lastSeen := range pm.known {
+		if lastSeen > 0 && !pm.isBannedLocked(peerIP(addr)) {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	sort.Slice(addrs, func(i, j int) bool {
+		if pm.known[addrs[i]] != pm.known[addrs[j]] {
+			return pm.known[addrs[i]] > pm.known[addrs[j]]
+		}
+		return addrs[i] < addrs[j]
+	})
+
+	if len(addrs) > limit {
+		addrs = addrs[:limit]
+	}
+	return addrs
+}
+
+// isBanned IP是否处于封禁期（到期的封禁顺带清除）
+func (pm *peerManager) isBanned(host string) bool {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	return pm.isBannedLocked(peerIP(host))
+}
+
+func (pm *peerManager) isBannedLocked(ip string) bool {
+	until, ok := pm.bans[ip]
+	if !ok {
+		return false
+	}
+	if until > time.Now().Unix() {
+		return true
+	}
+
+	delete(pm.bans, ip)
+	delete(pm.scores, ip)
+	if pm.store != nil {
+		pm.store.DeletePeerBan(ip)
+	}
+	return false
+}
+
+// penalize 记录不良行为，评分低于阈值时封禁该IP，返回评分和封禁时长（未封禁为0）
+func (pm *peerManager) penalize(host string, reason Misbehavior) (score int, bannedFor time.Duration) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	ip := peerIP(host)
+	now := time.Now()
+
+	ps := pm.scores[ip]
+	if ps == nil {
+		ps = &peerScore{updatedAt: now}
+		pm.scores[ip] = ps
+	}
+	if recovered := int(now.Sub(ps.updatedAt) / peerScoreRecovery); recovered > 0 {
+		ps.score += recovered
+		if ps.score > 0 {
+			ps.score = 0
+		}
+	}
+	ps.score -= misbehaviorPenalty[reason]
+	ps.updatedAt = now
+
+	if ps.score > peerBanThreshold || pm.isBannedLocked(ip) {
+		return ps.score, 0
+	}
+
+	until := now.Add(pm.banDuration).Unix()
+	pm.bans[ip] = until
+	if pm.store != nil {
+		if err := pm.store.SavePeerBan(ip, until); err != nil {
+			log.Printf("⚠️ Failed to save ban for %s: %v", ip, err)
+		}
+	}
+	return ps.score, pm.banDuration
+}
+
+// dialCandidates 可拨号的已知地址：跳过已连接（地址或IP）和被封禁的，最近连接成功的优先
+func (pm *peerManager) dialCandidates(connected map[string]bool, limit int) []string {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	candidates := make([]string, 0, len(pm.known))
+	for addr := range pm.known {
+		if connected[addr] || connected[peerIP(addr)] || pm.isBannedLocked(peerIP(addr)) {
+			continue
+		}
+		candidates = append(candidates, addr)
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if pm.known[candidates[i]] != pm.known[candidates[j]] {
+			return pm.known[candidates[i]] > pm.known[candidates[j]]
+		}
+		return candidates[i] < candidates[j]
+	})
+
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+	return candidates
+}
+
+// SetPeerStore 设置节点地址持久化存储并加载已知地址和封禁
+func (s *Server) SetPeerStore(store PeerStore) {
+	s.peerMgr.load(store)
+}
+
+// SetPeerBanDuration 设置不良节点封禁时长
+func (s *Server) SetPeerBanDuration(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	s.peerMgr.mu.Lock()
+	s.peerMgr.banDuration = d
+	s.peerMgr.mu.Unlock()
+}
+
+// penalizePeer 记录peer的不良行为，评分过低时封禁并断开连接
+func (s *Server) penalizePeer(peer *Peer, reason Misbehavior) {
+	score, bannedFor := s.peerMgr.penalize(peer.host, reason)
+	if bannedFor == 0 {
+		log.Printf("⚠️ Peer %s misbehaved (%s), score=%d", peer.host, reason, score)
+		return
+	}
+
+	log.Printf("🚫 Peer %s banned for %v (%s, score=%d)", peer.host, bannedFor, reason, score)
+	s.removePeer(peer.host)
+}
+
+// maintainPeers 连接数低于MaxPeers时从已知地址中拨号补充
+func (s *Server) maintainPeers() {
+	params := core.GetConsensusConfig().NetworkParams
+
+	s.peersMu.RLock()
+	count := len(s.peers)
+	connected := make(map[string]bool, count)
+	for host := range s.peers {
+		connected[host] = true
+		connected[peerIP(host)] = true // 入站连接的来源端口是临时的，按IP排除避免重复连接
+	}
+	s.peersMu.RUnlock()
+
+	if params.MaxPeers > 0 && count >= params.MaxPeers {
+		return
+	}
+	if count < params.MinPeers {
+		log.Printf("⚠️ Only %d peers connected (min %d), dialing known peers", count, params.MinPeers)
+	}
+
+	limit := maxDialsPerRound
+	if params.MaxPeers > 0 && params.MaxPeers-count < limit {
+		limit = params.MaxPeers - count
+	}
+	for _, addr := range s.peerMgr.dialCandidates(connected, limit) {
+		go func(addr string) {
+			if err := s.ConnectToPeer(addr); err != nil {
+				log.Printf("Failed to dial known peer %s: %v", addr, err)
+			}
+		}(addr)
+	}
+}
+
+// peerLimitReached 连接数是否已达到MaxPeers
+func (s *Server) peerLimitReached() bool {
+	maxPeers := core.GetConsensusConfig().NetworkParams.MaxPeers
+	return maxPeers > 0 && s.PeerCount() >= maxPeers
+}
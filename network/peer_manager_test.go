@@ -0,0 +1,112 @@
+package network
+
+import (
+	"testing"
+	"time"
+)
+
+// memPeerStore 内存实现的PeerStore
+type memPeerStore struct {
+	peers map[string]int64
+	bans  map[string]int64
+}
+
+func newMemPeerStore() *memPeerStore {
+	return &memPeerStore{peers: make(map[string]int64), bans: make(map[string]int64)}
+}
+
+func (m *memPeerStore) SavePeer(address string, lastSeen int64) error {
+	m.peers[address] = lastSeen
+	return nil
+}
+
+func (m *memPeerStore) DeletePeer(address string) error {
+	delete(m.peers, address)
+	return nil
+}
+
+func (m *memPeerStore) GetAllPeers() (map[string]int64, error) {
+	return m.peers, nil
+}
+
+func (m *memPeerStore) SavePeerBan(host string, bannedUntil int64) error {
+	m.bans[host] = bannedUntil
+	return nil
+}
+
+func (m *memPeerStore) DeletePeerBan(host string) error {
+	delete(m.bans, host)
+	return nil
+}
+
+func (m *memPeerStore) GetAllPeerBans() (map[string]int64, error) {
+	return m.bans, nil
+}
+
+// 不良行为累计到阈值后按IP封禁（不同端口的入站连接同样被拒），封禁持久化后重启仍生效
+func TestPeerManagerBansMisbehavingPeer(t *testing.T) {
+	store := newMemPeerStore()
+	pm := newPeerManager()
+	pm.load(store)
+
+	for i := 0; i < 4; i++ {
+		if _, bannedFor := pm.penalize("10.0.0.1:40001", MisbehaviorInvalidMessage); bannedFor != 0 {
+			t.Fatalf("banned after %d invalid messages", i+1)
+		}
+	}
+	if _, bannedFor := pm.penalize("10.0.0.1:40002", MisbehaviorInvalidMessage); bannedFor != DefaultPeerBanDuration {
+		t.Fatalf("not banned after reaching threshold")
+	}
+
+	if !pm.isBanned("10.0.0.1:9001") {
+		t.Fatalf("ban should apply to every port of the IP")
+	}
+	if pm.isBanned("10.0.0.2:9001") {
+		t.Fatalf("unrelated IP banned")
+	}
+
+	restarted := newPeerManager()
+	restarted.load(store)
+	if !restarted.isBanned("10.0.0.1:9001") {
+		t.Fatalf("ban not restored from store")
+	}
+}
+
+// 封禁到期后自动解除，评分清零
+func TestPeerManagerBanExpires(t *testing.T) {
+	store := newMemPeerStore()
+	store.bans["10.0.0.3"] = time.Now().Add(-time.Minute).Unix()
+
+	pm := newPeerManager()
+	pm.load(store)
+	if pm.isBanned("10.0.0.3:9001") {
+		t.Fatalf("expired ban still active")
+	}
+	if _, ok := store.bans["10.0.0.3"]; ok {
+		t.Fatalf("expired ban not removed from store")
+	}
+}
+
+// 拨号候选：跳过已连接和被封禁的地址，最近连接成功的优先，数量受限
+func TestPeerManagerDialCandidates(t *testing.T) {
+	store := newMemPeerStore()
+	now := time.Now().Unix()
+	store.peers["10.0.0.1:9001"] = now - 30
+	store.peers["10.0.0.2:9001"] = now - 10
+	store.peers["10.0.0.3:9001"] = now - 20
+	store.peers["10.0.0.4:9001"] = now
+	store.bans["10.0.0.4"] = now + 3600
+
+	pm := newPeerManager()
+	pm.load(store)
+
+	got := pm.dialCandidates(map[string]bool{"10.0.0.2:9001": true}, 1)
+	if len(got) != 1 || got[0] != "10.0.0.3:9001" {
+		t.Fatalf("candidates = %v, want [10.0.0.3:9001]", got)
+	}
+
+	pm.markConnected("10.0.0.5:9001")
+	if _, ok := store.peers["10.0.0.5:9001"]; !ok {
+		t.Fatalf("connected peer not persisted")
+	}
+}
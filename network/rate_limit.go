@@ -0,0 +1,104 @@
+package network
+
+import (
+	"log"
+	"time"
+)
+
+// rateLimit 令牌桶参数：每秒补充的令牌数、突发上限
+type rateLimit struct {
+	rate  float64
+	burst float64
+}
+
+// 每个peer按消息类型限速（在读取协程中入队前检查），超出的消息丢弃并按Spam扣分，持续超出会被封禁断开
+// 请求类消息需要本节点读库、压缩后回复，限速远低于普通消息
+var messageRateLimits = map[MessageType]rateLimit{
+	MsgGetBlocks:         {rate: 5, burst: 20},
+	MsgGetState:          {rate: 1.0 / 30, burst: 2}, // 状态快照体积大、序列化开销高
+	MsgGetStateChunk:     {rate: 4, burst: 32},
+	MsgGetCheckpoint:     {rate: 0.5, burst: 5},
+	MsgGetLatest:         {rate: 2, burst: 10},
+	MsgGetEarliestHeight: {rate: 1, burst: 5},
+	MsgPing:              {rate: 1, burst: 10},
+	MsgPong:              {rate: 1, burst: 10},
+	MsgTransaction:       {rate: txRateLimit, burst: txRateBurst},
+}
+
+// 未单独配置的消息类型
+var defaultRateLimit = rateLimit{rate: 50, burst: 500}
+
+const (
+	// 接收队列满时暂停读取（TCP背压）的最长时间，超过说明对方持续超出本节点处理能力，断开连接
+	recvBackpressureTimeout = 10 * time.Second
+	// 消息处理循环每轮从每个peer最多处理的消息数（轮询公平，单个peer不能占满处理循环）
+	maxMessagesPerTick = 100
+)
+
+// tokenBucket 令牌桶
+type tokenBucket struct {
+	limit  rateLimit
+	tokens float64
+	at     time.Time
+}
+
+// allow 消耗一个令牌，令牌不足返回false
+func (b *tokenBucket) allow(now time.Time) bool {
+	if b.at.IsZero() {
+		b.tokens = b.limit.burst
+	} else {
+		b.tokens += now.Sub(b.at).Seconds() * b.limit.rate
+		if b.tokens > b.limit.burst {
+			b.tokens = b.limit.burst
+		}
+	}
+	b.at = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// allowMessage 按消息类型限速（只在读取协程中使用）
+func (p *Peer) allowMessage(msgType MessageType) bool {
+	if p.limiters == nil {
+		p.limiters = make(map[MessageType]*tokenBucket)
+	}
+	bucket, ok := p.limiters[msgType]
+	if !ok {
+		limit, ok := messageRateLimits[msgType]
+		if !ok {
+			limit = defaultRateLimit
+		}
+		bucket = &tokenBucket{limit: limit}
+		p.limiters[msgType] = bucket
+	}
+	return bucket.allow(time.Now())
+}
+
+// enqueue 投递到接收队列；队列满时阻塞读取，超时或连接关闭返回false
+func (p *Peer) enqueue(msg *Message) bool {
+	select {
+	case p.recvChan <- msg:
+		return true
+	case <-p.closeChan:
+		return false
+	default:
+	}
+
+	timer := time.NewTimer(recvBackpressureTimeout)
+	defer timer.Stop()
+
+	select {
+	case p.recvChan <- msg:
+		return true
+	case <-p.closeChan:
+		return false
+	case <-timer.C:
+		log.Printf("🚫 Peer %s receive queue full for %v, disconnecting", p.host, recvBackpressureTimeout)
+		p.reportMisbehavior(MisbehaviorSpam)
+		return false
+	}
+}
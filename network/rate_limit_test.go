@@ -0,0 +1,119 @@
+package network
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"fan-chain/core"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := &tokenBucket{limit: rateLimit{rate: 1, burst: 3}}
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if !b.allow(now) {
+			t.Fatalf("burst token %d rejected", i)
+		}
+	}
+	if b.allow(now) {
+		t.Fatal("token allowed beyond burst")
+	}
+	if !b.allow(now.Add(time.Second)) {
+		t.Fatal("token not refilled after 1s")
+	}
+	// 长时间空闲后最多恢复到突发上限
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		b.allow(now)
+	}
+	if b.allow(now) {
+		t.Fatal("refill exceeded burst")
+	}
+}
+
+// 各消息类型独立限速：GetState用完不影响其他消息
+func TestAllowMessagePerType(t *testing.T) {
+	p := &Peer{}
+	burst := int(messageRateLimits[MsgGetState].burst)
+	for i := 0; i < burst; i++ {
+		if !p.allowMessage(MsgGetState) {
+			t.Fatalf("get state %d rejected within burst", i)
+		}
+	}
+	if p.allowMessage(MsgGetState) {
+		t.Fatal("get state allowed beyond burst")
+	}
+	if !p.allowMessage(MsgGetBlocks) || !p.allowMessage(MsgNewBlock) {
+		t.Fatal("other message types throttled by get state limit")
+	}
+}
+
+// 响应必须对应未完成的请求，且只能使用一次
+func TestRequestCorrelation(t *testing.T) {
+	s := NewServer("", 9001, nil, "")
+	p := &Peer{host: "198.51.100.1:9001", capabilities: map[string]bool{}}
+
+	id := p.trackRequest(MsgBlocks)
+	if p.completeRequest(id, MsgStateData) {
+		t.Fatal("response of wrong type accepted")
+	}
+	if !s.acceptResponse(p, MsgBlocks, id, false) {
+		t.Fatal("matching response rejected")
+	}
+	if s.acceptResponse(p, MsgBlocks, id, false) {
+		t.Fatal("duplicate response accepted")
+	}
+	if s.acceptResponse(p, MsgBlocks, 0, false) {
+		t.Fatal("response without request ID accepted from upgraded peer")
+	}
+	if !s.acceptResponse(p, MsgCheckpoint, 0, true) {
+		t.Fatal("checkpoint broadcast rejected")
+	}
+
+	// 明文节点不支持请求ID
+	legacy := &Peer{host: "198.51.100.2:9001"}
+	if !s.acceptResponse(legacy, MsgBlocks, 0, false) {
+		t.Fatal("legacy response rejected")
+	}
+
+	// 未完成请求数有上限，超出时丢弃最早的
+	for i := 0; i < maxPendingRequests+1; i++ {
+		p.trackRequest(MsgBlocks)
+	}
+	if len(p.requests) != maxPendingRequests {
+		t.Fatalf("pending requests = %d, want %d", len(p.requests), maxPendingRequests)
+	}
+}
+
+// 区块请求范围截断到MaxBlockRequestSize，响应带回请求ID
+func TestGetBlocksBounded(t *testing.T) {
+	s := NewServer("", 9001, nil, "")
+	var gotFrom, gotTo uint64
+	s.getBlockRange = func(from, to uint64) ([]*core.Block, error) {
+		gotFrom, gotTo = from, to
+		return []*core.Block{core.CreateGenesisBlock()}, nil
+	}
+
+	c, sc := net.Pipe()
+	defer c.Close()
+	defer sc.Close()
+	peer := NewPeer(sc, false)
+
+	msg, _ := NewMessage(MsgGetBlocks, &GetBlocksMessage{FromHeight: 1, ToHeight: 1 << 40, RequestID: 7})
+	s.handleGetBlocks(peer, msg)
+
+	max := uint64(core.GetConsensusConfig().NetworkParams.MaxBlockRequestSize)
+	if gotFrom != 1 || gotTo != max {
+		t.Fatalf("served range %d-%d, want 1-%d", gotFrom, gotTo, max)
+	}
+
+	reply := <-peer.sendChan
+	var blocks BlocksMessage
+	if err := reply.ParsePayload(&blocks); err != nil {
+		t.Fatal(err)
+	}
+	if blocks.RequestID != 7 {
+		t.Fatalf("request ID = %d, want 7", blocks.RequestID)
+	}
+}
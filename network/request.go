@@ -0,0 +1,76 @@
+package network
+
+import (
+	"log"
+	"time"
+)
+
+// 请求/响应关联：本节点发出GetBlocks、GetState、GetCheckpoint时分配请求ID，对方在响应中原样带回，
+// 不对应任何未完成请求的响应视为垃圾消息丢弃
+const (
+	// 每个peer最多同时等待的请求数（超过时丢弃最早的）
+	maxPendingRequests = 64
+	// 请求超过该时间未响应则不再等待
+	requestTimeout = 2 * time.Minute
+)
+
+// pendingRequest 未完成的请求
+type pendingRequest struct {
+	response MessageType
+	sentAt   time.Time
+}
+
+// trackRequest 登记一个期望指定类型响应的请求，返回请求ID
+func (p *Peer) trackRequest(response MessageType) uint64 {
+	p.requestsMu.Lock()
+	defer p.requestsMu.Unlock()
+
+	now := time.Now()
+	if p.requests == nil {
+		p.requests = make(map[uint64]pendingRequest)
+	}
+	var oldestID uint64
+	for id, req := range p.requests {
+		if now.Sub(req.sentAt) > requestTimeout {
+			delete(p.requests, id)
+		} else if oldestID == 0 || id < oldestID {
+			oldestID = id
+		}
+	}
+	if len(p.requests) >= maxPendingRequests {
+		delete(p.requests, oldestID)
+	}
+
+	p.nextRequestID++
+	p.requests[p.nextRequestID] = pendingRequest{response: response, sentAt: now}
+	return p.nextRequestID
+}
+
+// completeRequest 响应对应未完成的请求时移除该请求并返回true
+func (p *Peer) completeRequest(id uint64, response MessageType) bool {
+	p.requestsMu.Lock()
+	defer p.requestsMu.Unlock()
+
+	req, ok := p.requests[id]
+	if !ok || req.response != response {
+		return false
+	}
+	delete(p.requests, id)
+	return true
+}
+
+// acceptResponse 校验响应的请求ID
+// pushAllowed: 该类型也会被主动推送（checkpoint广播），请求ID为0时按推送处理
+// 明文节点（过渡期）不支持请求ID，请求ID为0时沿用旧行为
+func (s *Server) acceptResponse(peer *Peer, msgType MessageType, requestID uint64, pushAllowed bool) bool {
+	if requestID == 0 && (pushAllowed || peer.capabilities == nil) {
+		return true
+	}
+	if peer.completeRequest(requestID, msgType) {
+		return true
+	}
+
+	log.Printf("⚠️ Unsolicited response type %d from %s (request %d), dropping", msgType, peer.host, requestID)
+	s.penalizePeer(peer, MisbehaviorSpam)
+	return false
+}
@@ -24,21 +24,22 @@ type Server struct {
 	seedPeers []string
 
 	// 区块链接口
-	blockchain          *core.Blockchain
-	getLatestBlock      func() *core.Block
-	addBlock            func(*core.Block) error
-	addBlockSkipTimestamp func(*core.Block) error                                  // 添加区块（跳过时间戳检查，用于同步历史区块）
-	getBlockRange       func(uint64, uint64) ([]*core.Block, error)
-	verifyProposer      func(height uint64, prevBlock *core.Block) (string, error) // VRF验证proposer
-	performReorg        func(rollbackHeight uint64, correctBlock *core.Block) error // 执行链重组
-	getProposerStake    func(address string) uint64                                 // 获取proposer的质押
-	getLatestCheckpoints     func(count int) []CheckpointInfo                            // 获取最新N个checkpoint
-	getLatestCheckpoint      func() (*core.Checkpoint, error)                            // 获取最新checkpoint
-	applyCheckpoint          func(*core.Checkpoint) error                                // 应用checkpoint
-	getStateSnapshot         func(uint64) ([]byte, error)                                // 获取状态快照
-	applyStateSnapshot       func(uint64, []byte) error                                  // 应用状态快照
-	handleReceivedTransaction func(*core.Transaction) error                              // 处理接收到的交易
-	detectAndResolveFork     func(peerHeight uint64, peerBlockHash string, peerCheckpointHeight uint64, peerCheckpointHash string, peerCheckpointTimestamp int64) error // 检测并解决分叉（谁快认谁做大哥）
+	blockchain                *core.Blockchain
+	getLatestBlock            func() *core.Block
+	addBlock                  func(*core.Block) error
+	addBlockSkipTimestamp     func(*core.Block) error // 添加区块（跳过时间戳检查，用于同步历史区块）
+	getBlockRange             func(uint64, uint64) ([]*core.Block, error)
+	verifyProposer            func(height uint64, prevBlock *core.Block) (string, error)                                                                                 // VRF验证proposer
+	verifyBlockVRF            func(block, prevBlock *core.Block) error                                                                                                   // 验证区块的VRF证明
+	proposerRank              func(block, prevBlock *core.Block) int                                                                                                     // 出块者名次（-1表示不是合法出块者）
+	performReorg              func(rollbackHeight uint64, correctBlock *core.Block) error                                                                                // 执行链重组
+	getLatestCheckpoints      func(count int) []CheckpointInfo                                                                                                           // 获取最新N个checkpoint
+	getLatestCheckpoint       func() (*core.Checkpoint, error)                                                                                                           // 获取最新checkpoint
+	applyCheckpoint           func(*core.Checkpoint) error                                                                                                               // 应用checkpoint
+	getStateSnapshot          func(uint64) ([]byte, error)                                                                                                               // 获取状态快照
+	applyStateSnapshot        func(uint64, []byte) error                                                                                                                 // 应用状态快照
+	handleReceivedTransaction func(*core.Transaction) error                                                                                                              // 处理接收到的交易
+	detectAndResolveFork      func(peerHeight uint64, peerBlockHash string, peerCheckpointHeight uint64, peerCheckpointHash string, peerCheckpointTimestamp int64) error // 检测并解决分叉（谁快认谁做大哥）
 
 	// 控制通道
 	closeChan chan struct{}
@@ -48,20 +49,20 @@ type Server struct {
 	// 同步状态
 	syncing            bool
 	syncMu             sync.Mutex
-	syncTargetHeight   uint64    // 目标同步高度
-	syncPeer           *Peer     // 同步来源节点
-	syncStartTime      time.Time // 同步开始时间
-	lastSyncHeight     uint64    // 上次同步到的高度
-	progressiveSkip    uint64    // 渐进式跳过数量(0→1000→10000→100000)
-	checkpointSyncFrom uint64    // checkpoint同步起点（checkpoint高度-一个周期）
-	checkpointHeight   uint64    // checkpoint高度（用于判断是否需要回填历史区块）
+	syncTargetHeight   uint64                  // 目标同步高度
+	syncPeer           *Peer                   // 同步来源节点
+	syncStartTime      time.Time               // 同步开始时间
+	lastSyncHeight     uint64                  // 上次同步到的高度
+	progressiveSkip    uint64                  // 渐进式跳过数量(0→1000→10000→100000)
+	checkpointSyncFrom uint64                  // checkpoint同步起点（checkpoint高度-一个周期）
+	checkpointHeight   uint64                  // checkpoint高度（用于判断是否需要回填历史区块）
 	saveBlockOnly      func(*core.Block) error // 只保存区块（用于回填历史）
 	replaceForkedBlock func(*core.Block) error // 【家规】替换分叉区块（强制用大哥的覆盖本地的）
 
 	// 【P2协议】向下同步（backfill）状态
-	backfillInProgress   bool      // 是否正在向下同步
-	backfillTargetHeight uint64    // 向下同步目标高度（大哥的最早区块）
-	backfillCurrentFrom  uint64    // 当前向下同步的起始高度
+	backfillInProgress   bool          // 是否正在向下同步
+	backfillTargetHeight uint64        // 向下同步目标高度（大哥的最早区块）
+	backfillCurrentFrom  uint64        // 当前向下同步的起始高度
 	getEarliestHeight    func() uint64 // 获取本节点最早区块高度
 
 	// 区块广播重试机制
@@ -77,6 +78,42 @@ type Server struct {
 	// 【家长制优化】验证者判断回调（只有验证者的高度才影响出块决策）
 	// 解决：非验证者节点（如History节点）的高度不应阻塞验证者出块
 	isValidator func(address string) bool
+
+	// 交易gossip：全局已见交易（按哈希去重）
+	txSeen *txSeenCache
+
+	// 双签检测：本节点公钥、已验证的出块者公钥、按高度记录的已签名区块头
+	publicKey              []byte
+	evidenceMu             sync.Mutex
+	proposerKeys           map[string][]byte
+	signedHeaders          map[uint64]map[string]*core.BlockHeader
+	evidenceSeen           *txSeenCache
+	handleReceivedEvidence func(*core.DoubleSignEvidence) error // 校验证据并加入证据池
+
+	// checkpoint多签：已见签名（去重）、签名处理回调
+	checkpointSigSeen   *txSeenCache
+	handleCheckpointSig func(height uint64, checkpointHash core.Hash, sig *core.CheckpointSignature) error
+
+	// 节点管理：已知地址持久化、行为评分、封禁
+	peerMgr *peerManager
+
+	// 加密握手：本节点ML-DSA私钥、是否允许明文节点（过渡期）、进行中的入站握手名额
+	privateKey     []byte
+	allowPlaintext bool
+	handshakeSlots chan struct{}
+
+	// 状态快照缓存（限制GetState请求的开销）
+	snapshotCache *stateSnapshotCache
+	chunkCache    *stateChunkCache
+	snapshotMu    sync.Mutex
+
+	// 分片状态同步：进行中的同步、已验证分片的存储、生成/应用分片的回调
+	stateSync        *stateSync
+	stateSyncMu      sync.Mutex
+	chunkStore       StateChunkStore
+	getStateChunks   func(height uint64) ([]*core.StateChunk, error)
+	getStateRoot     func(uint64) (core.Hash, error)
+	applyStateChunks func(checkpoint *core.Checkpoint, accounts []*core.Account) error
 }
 
 // 创建P2P服务器
@@ -89,6 +126,13 @@ func NewServer(address string, port int, seedPeers []string, publicIP string) *S
 		peers:             make(map[string]*Peer),
 		closeChan:         make(chan struct{}),
 		rejectedProposers: make(map[uint64]map[string]int), // 初始化简单多数计数器
+		txSeen:            newTxSeenCache(),
+		proposerKeys:      make(map[string][]byte),
+		signedHeaders:     make(map[uint64]map[string]*core.BlockHeader),
+		evidenceSeen:      newTxSeenCache(),
+		checkpointSigSeen: newTxSeenCache(),
+		peerMgr:           newPeerManager(),
+		handshakeSlots:    make(chan struct{}, maxPendingHandshakes),
 	}
 }
 
@@ -118,16 +162,32 @@ func (s *Server) SetVerifyProposer(fn func(height uint64, prevBlock *core.Block)
 	s.verifyProposer = fn
 }
 
+// 设置区块VRF证明验证函数（拒绝证明无效的区块）
+func (s *Server) SetVerifyBlockVRF(fn func(block, prevBlock *core.Block) error) {
+	s.verifyBlockVRF = fn
+}
+
+// 设置出块者名次函数（时间槽已开启的备用出块者区块同样接受）
+func (s *Server) SetProposerRank(fn func(block, prevBlock *core.Block) int) {
+	s.proposerRank = fn
+}
+
+// scheduledRank 区块出块者的名次：未设置名次函数时只认VRF选中的出块者
+func (s *Server) scheduledRank(block, prevBlock *core.Block, expectedProposer string) int {
+	if s.proposerRank != nil {
+		return s.proposerRank(block, prevBlock)
+	}
+	if block.Header.Proposer == expectedProposer {
+		return 0
+	}
+	return -1
+}
+
 // 设置链重组函数（用于自动修复分叉）
 func (s *Server) SetPerformReorg(fn func(rollbackHeight uint64, correctBlock *core.Block) error) {
 	s.performReorg = fn
 }
 
-// 设置获取proposer质押的函数（用于质押权重链选择）
-func (s *Server) SetGetProposerStake(fn func(address string) uint64) {
-	s.getProposerStake = fn
-}
-
 // 设置获取最新N个checkpoint的函数
 func (s *Server) SetGetLatestCheckpoints(fn func(count int) []CheckpointInfo) {
 	s.getLatestCheckpoints = fn
@@ -229,6 +289,20 @@ func (s *Server) Start() error {
 		}
 	}()
 
+	// 启动已知节点拨号循环（每30秒检查一次，连接数不足MaxPeers时补充）
+	go func() {
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.maintainPeers()
+			case <-s.closeChan:
+				return
+			}
+		}
+	}()
+
 	// 启动心跳循环（每30秒发送一次心跳）
 	go func() {
 		ticker := time.NewTicker(30 * time.Second)
@@ -251,6 +325,7 @@ func (s *Server) Start() error {
 			select {
 			case <-ticker.C:
 				s.checkSyncTimeout()
+				s.scheduleStateChunks()
 			case <-s.closeChan:
 				return
 			}
@@ -332,7 +407,10 @@ func (s *Server) RequestCheckpointFromPeers(count uint64) {
 	for _, peer := range s.peers {
 		if peer.IsConnected() {
 			log.Printf("Requesting %d checkpoints from peer %s", count, peer.host)
-			msg, err := NewMessage(MsgGetCheckpoint, &GetCheckpointMessage{Count: count})
+			msg, err := NewMessage(MsgGetCheckpoint, &GetCheckpointMessage{
+				Count:     count,
+				RequestID: peer.trackRequest(MsgCheckpoint),
+			})
 			if err != nil {
 				log.Printf("Failed to create checkpoint request: %v", err)
 				continue
@@ -344,7 +422,6 @@ func (s *Server) RequestCheckpointFromPeers(count uint64) {
 	}
 }
 
-
 // 【P6】GetPeerCount 返回当前连接的peer数量
 func (s *Server) GetPeerCount() int {
 	return s.PeerCount()
@@ -370,7 +447,10 @@ func (s *Server) RequestCheckpointFromBestPeer() {
 
 	if bestPeer != nil {
 		log.Printf("【P6】向大哥 %s (高度 %d) 请求checkpoint", bestPeer.host, bestHeight)
-		msg, err := NewMessage(MsgGetCheckpoint, &GetCheckpointMessage{Count: 1})
+		msg, err := NewMessage(MsgGetCheckpoint, &GetCheckpointMessage{
+			Count:     1,
+			RequestID: bestPeer.trackRequest(MsgCheckpoint),
+		})
 		if err != nil {
 			log.Printf("Failed to create checkpoint request: %v", err)
 			return
@@ -384,31 +464,19 @@ func (s *Server) SetHandleReceivedTransaction(fn func(*core.Transaction) error)
 	s.handleReceivedTransaction = fn
 }
 
-// 广播交易到所有节点
+// 广播交易到所有节点（本地提交的交易）
+// 已知该交易的peer不重复发送
 func (s *Server) BroadcastTransaction(tx *core.Transaction) {
-	s.peersMu.RLock()
-	defer s.peersMu.RUnlock()
-
-	if len(s.peers) == 0 {
-		log.Printf("No peers to broadcast transaction")
-		return
-	}
+	txHash := tx.Hash()
+	s.txSeen.markSeen(txGossipKey(tx))
 
-	msg, err := NewMessage(MsgTransaction, &TransactionMessage{Transaction: tx})
-	if err != nil {
-		log.Printf("Failed to create transaction message: %v", err)
+	sent := s.relayTransaction(tx, txGossipKey(tx))
+	if sent == 0 {
+		log.Printf("No peers to broadcast transaction %x", txHash.Bytes()[:8])
 		return
 	}
 
-	broadcastCount := 0
-	for _, peer := range s.peers {
-		if peer.IsConnected() {
-			peer.SendMessage(msg)
-			broadcastCount++
-		}
-	}
-
-	log.Printf("Broadcasted transaction %x to %d peers", tx.Hash().Bytes()[:8], broadcastCount)
+	log.Printf("Broadcasted transaction %x to %d peers", txHash.Bytes()[:8], sent)
 }
 
 // 【简单多数认输机制】记录拒绝的proposer，返回是否应该认输
@@ -0,0 +1,387 @@
+package network
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"fan-chain/core"
+)
+
+// 分片状态同步：收到带状态的checkpoint后，按core.StateChunkCount个键前缀分片并行向多个peer请求，
+// 每个分片单独按checkpoint的StateRoot验证后持久化（重启后从已保存的分片继续），
+// 全部分片到齐且整体状态根一致后才替换本地状态
+const (
+	// 每个peer同时进行的分片请求数
+	maxChunkRequestsPerPeer = 4
+	// 分片请求超过该时间未响应则改向其他peer请求
+	chunkRequestTimeout = 30 * time.Second
+	// 已生成分片的缓存时间（同步方会在短时间内请求同一高度的全部分片）
+	stateChunkCacheTTL = 2 * time.Minute
+)
+
+// StateChunkStore 已验证状态分片的持久化接口（由storage.Database实现）
+type StateChunkStore interface {
+	SaveStateChunk(stateRoot core.Hash, chunk *core.StateChunk) error
+	GetStateChunks(stateRoot core.Hash) ([]*core.StateChunk, error)
+	ClearStateChunks() error
+}
+
+// chunkRequest 进行中的分片请求
+type chunkRequest struct {
+	peer      *Peer
+	requestID uint64
+	sentAt    time.Time
+}
+
+// stateSync 进行中的分片同步
+type stateSync struct {
+	checkpoint *core.Checkpoint
+	chunks     map[uint32]*core.StateChunk // 已验证的分片
+	inflight   map[uint32]*chunkRequest
+	lacking    map[string]bool // 没有该高度快照的peer
+	peer       *Peer           // 提供checkpoint的peer（同步完成后从它同步区块）
+	applying   bool
+}
+
+// stateChunkCache 最近一次生成的状态分片
+type stateChunkCache struct {
+	height    uint64
+	chunks    []*core.StateChunk
+	createdAt time.Time
+}
+
+// 设置状态分片存储
+func (s *Server) SetStateChunkStore(store StateChunkStore) {
+	s.chunkStore = store
+}
+
+// 设置生成状态分片的函数（返回指定checkpoint高度的全部分片）
+func (s *Server) SetGetStateChunks(fn func(height uint64) ([]*core.StateChunk, error)) {
+	s.getStateChunks = fn
+}
+
+// 设置获取本地状态根的函数（按height高度的状态根算法计算）
+func (s *Server) SetGetStateRoot(fn func(height uint64) (core.Hash, error)) {
+	s.getStateRoot = fn
+}
+
+// 设置应用分片同步状态的函数
+func (s *Server) SetApplyStateChunks(fn func(checkpoint *core.Checkpoint, accounts []*core.Account) error) {
+	s.applyStateChunks = fn
+}
+
+// cachedStateChunks 获取指定高度的状态分片，缓存未过期时直接返回
+func (s *Server) cachedStateChunks(height uint64) ([]*core.StateChunk, error) {
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+
+	if c := s.chunkCache; c != nil && c.height == height && time.Since(c.createdAt) < stateChunkCacheTTL {
+		return c.chunks, nil
+	}
+
+	chunks, err := s.getStateChunks(height)
+	if err != nil {
+		return nil, err
+	}
+	s.chunkCache = &stateChunkCache{height: height, chunks: chunks, createdAt: time.Now()}
+	return chunks, nil
+}
+
+// startStateSync 开始（或继续）同步checkpoint的状态，已保存的分片重新验证后直接使用
+// 本地已越过checkpoint高度或本地状态根与checkpoint一致时不需要同步
+func (s *Server) startStateSync(peer *Peer, checkpoint *core.Checkpoint) {
+	if s.getLatestBlock != nil {
+		if latest := s.getLatestBlock(); latest != nil && latest.Header.Height > checkpoint.Height {
+			return
+		}
+	}
+	if s.getStateRoot != nil {
+		if root, err := s.getStateRoot(checkpoint.Height); err == nil && root == checkpoint.StateRoot {
+			return
+		}
+	}
+
+	s.stateSyncMu.Lock()
+	if cur := s.stateSync; cur != nil {
+		if checkpoint.Height == cur.checkpoint.Height && !cur.applying {
+			// 同一checkpoint再次到来：发送方刚声明有状态数据，可以重新向它请求
+			delete(cur.lacking, peer.host)
+			s.stateSyncMu.Unlock()
+			s.scheduleStateChunks()
+			return
+		}
+		// checkpoint周期很短，只要还有peer能提供分片就继续当前同步；
+		// 没有进行中的请求且已有peer回复没有该高度快照时，改为同步更新的checkpoint
+		stalled := len(cur.inflight) == 0 && len(cur.lacking) > 0
+		if cur.applying || checkpoint.Height < cur.checkpoint.Height || !stalled {
+			s.stateSyncMu.Unlock()
+			return
+		}
+		log.Printf("📦 Newer checkpoint %d replaces state sync at %d", checkpoint.Height, cur.checkpoint.Height)
+	}
+
+	ss := &stateSync{
+		checkpoint: checkpoint,
+		chunks:     make(map[uint32]*core.StateChunk),
+		inflight:   make(map[uint32]*chunkRequest),
+		lacking:    make(map[string]bool),
+		peer:       peer,
+	}
+	if s.chunkStore != nil {
+		saved, err := s.chunkStore.GetStateChunks(checkpoint.StateRoot)
+		if err != nil {
+			log.Printf("Failed to load saved state chunks: %v", err)
+		}
+		for _, chunk := range saved {
+			if err := chunk.Verify(checkpoint.StateRoot); err != nil {
+				log.Printf("⚠️ Discarding saved state chunk %d: %v", chunk.Index, err)
+				continue
+			}
+			ss.chunks[chunk.Index] = chunk
+		}
+		if len(ss.chunks) == 0 {
+			// 旧checkpoint留下的分片不再有用
+			if err := s.chunkStore.ClearStateChunks(); err != nil {
+				log.Printf("Failed to clear state chunks: %v", err)
+			}
+		}
+	}
+	s.stateSync = ss
+	s.stateSyncMu.Unlock()
+
+	log.Printf("📦 State sync for checkpoint %d started (%d/%d chunks already saved)",
+		checkpoint.Height, len(ss.chunks), core.StateChunkCount)
+
+	if len(ss.chunks) == core.StateChunkCount {
+		s.finishStateSync()
+		return
+	}
+	s.scheduleStateChunks()
+}
+
+// scheduleStateChunks 把缺少的分片分配给负载最低的peer（超时的请求改派其他peer）
+func (s *Server) scheduleStateChunks() {
+	s.peersMu.RLock()
+	peers := make([]*Peer, 0, len(s.peers))
+	for _, peer := range s.peers {
+		// 明文节点（capabilities为nil）不支持分片
+		if peer.IsConnected() && peer.capabilities[CapStateChunks] {
+			peers = append(peers, peer)
+		}
+	}
+	s.peersMu.RUnlock()
+
+	s.stateSyncMu.Lock()
+	defer s.stateSyncMu.Unlock()
+
+	ss := s.stateSync
+	if ss == nil || ss.applying {
+		return
+	}
+
+	now := time.Now()
+	load := make(map[*Peer]int)
+	for index, req := range ss.inflight {
+		if now.Sub(req.sentAt) > chunkRequestTimeout || !req.peer.IsConnected() {
+			log.Printf("⏱️ State chunk %d request to %s timed out", index, req.peer.host)
+			delete(ss.inflight, index)
+			continue
+		}
+		load[req.peer]++
+	}
+
+	candidates := peers[:0]
+	for _, peer := range peers {
+		if !ss.lacking[peer.host] {
+			candidates = append(candidates, peer)
+		}
+	}
+	if len(candidates) == 0 {
+		return
+	}
+
+	for index := uint32(0); index < core.StateChunkCount; index++ {
+		if ss.chunks[index] != nil || ss.inflight[index] != nil {
+			continue
+		}
+
+		sort.Slice(candidates, func(i, j int) bool { return load[candidates[i]] < load[candidates[j]] })
+		peer := candidates[0]
+		if load[peer] >= maxChunkRequestsPerPeer {
+			return
+		}
+
+		req := &chunkRequest{peer: peer, requestID: peer.trackRequest(MsgStateChunk), sentAt: now}
+		msg, err := NewMessage(MsgGetStateChunk, &GetStateChunkMessage{
+			Height:    ss.checkpoint.Height,
+			Index:     index,
+			RequestID: req.requestID,
+		})
+		if err != nil {
+			log.Printf("Failed to create get state chunk message: %v", err)
+			return
+		}
+		ss.inflight[index] = req
+		load[peer]++
+		go peer.SendMessage(msg)
+	}
+}
+
+// 处理获取状态分片请求
+func (s *Server) handleGetStateChunk(peer *Peer, msg *Message) {
+	var req GetStateChunkMessage
+	if err := msg.ParsePayload(&req); err != nil || req.Index >= core.StateChunkCount {
+		log.Printf("Invalid get state chunk from %s: %v", peer.host, err)
+		s.penalizePeer(peer, MisbehaviorInvalidMessage)
+		return
+	}
+
+	reply := &StateChunkMessage{Height: req.Height, RequestID: req.RequestID}
+	if s.getStateChunks != nil {
+		chunks, err := s.cachedStateChunks(req.Height)
+		if err != nil {
+			log.Printf("Cannot serve state chunk %d at height %d to %s: %v", req.Index, req.Height, peer.host, err)
+		} else if int(req.Index) < len(chunks) {
+			reply.Chunk = chunks[req.Index]
+		}
+	}
+
+	// 没有该高度的分片时也回复（Chunk为空），对方可立即改向其他peer请求
+	chunkMsg, err := NewMessage(MsgStateChunk, reply)
+	if err != nil {
+		log.Printf("Failed to create state chunk message: %v", err)
+		return
+	}
+	peer.SendMessage(chunkMsg)
+}
+
+// 处理状态分片数据
+func (s *Server) handleStateChunk(peer *Peer, msg *Message) {
+	var chunkMsg StateChunkMessage
+	if err := msg.ParsePayload(&chunkMsg); err != nil {
+		log.Printf("Failed to parse state chunk from %s: %v", peer.host, err)
+		s.penalizePeer(peer, MisbehaviorInvalidMessage)
+		return
+	}
+	if !s.acceptResponse(peer, MsgStateChunk, chunkMsg.RequestID, false) {
+		return
+	}
+
+	s.stateSyncMu.Lock()
+	ss := s.stateSync
+	if ss == nil || ss.applying || chunkMsg.Height != ss.checkpoint.Height {
+		s.stateSyncMu.Unlock()
+		return
+	}
+
+	// 找到对应的请求（按请求ID，响应中的分片序号不可信）
+	var index uint32
+	var req *chunkRequest
+	for i, r := range ss.inflight {
+		if r.peer == peer && r.requestID == chunkMsg.RequestID {
+			index, req = i, r
+			break
+		}
+	}
+	if req != nil {
+		delete(ss.inflight, index)
+	}
+
+	if chunkMsg.Chunk == nil {
+		log.Printf("Peer %s has no state chunks for height %d", peer.host, chunkMsg.Height)
+		ss.lacking[peer.host] = true
+		s.stateSyncMu.Unlock()
+		s.scheduleStateChunks()
+		return
+	}
+
+	chunk := chunkMsg.Chunk
+	if req != nil && chunk.Index != index {
+		log.Printf("⚠️ Peer %s answered chunk %d with chunk %d", peer.host, index, chunk.Index)
+		s.stateSyncMu.Unlock()
+		s.penalizePeer(peer, MisbehaviorInvalidMessage)
+		return
+	}
+	if err := chunk.Verify(ss.checkpoint.StateRoot); err != nil {
+		log.Printf("⚠️ Invalid state chunk %d from %s: %v", chunk.Index, peer.host, err)
+		s.stateSyncMu.Unlock()
+		s.penalizePeer(peer, MisbehaviorInvalidMessage)
+		return
+	}
+
+	if ss.chunks[chunk.Index] == nil {
+		ss.chunks[chunk.Index] = chunk
+		if s.chunkStore != nil {
+			if err := s.chunkStore.SaveStateChunk(ss.checkpoint.StateRoot, chunk); err != nil {
+				log.Printf("Failed to save state chunk %d: %v", chunk.Index, err)
+			}
+		}
+	}
+	complete := len(ss.chunks) == core.StateChunkCount
+	s.stateSyncMu.Unlock()
+
+	if complete {
+		s.finishStateSync()
+	} else {
+		s.scheduleStateChunks()
+	}
+}
+
+// finishStateSync 全部分片到齐后校验整体状态根并应用
+func (s *Server) finishStateSync() {
+	s.stateSyncMu.Lock()
+	ss := s.stateSync
+	if ss == nil || ss.applying {
+		s.stateSyncMu.Unlock()
+		return
+	}
+	ss.applying = true
+	s.stateSyncMu.Unlock()
+
+	var accounts []*core.Account
+	for index := uint32(0); index < core.StateChunkCount; index++ {
+		accounts = append(accounts, ss.chunks[index].Accounts...)
+	}
+
+	cp := ss.checkpoint
+	err := func() error {
+		if root := core.StateRootOf(accounts); root != cp.StateRoot {
+			return fmt.Errorf("state root mismatch: chunks=%s, checkpoint=%s", root.String(), cp.StateRoot.String())
+		}
+		return s.applyStateChunks(cp, accounts)
+	}()
+
+	s.stateSyncMu.Lock()
+	if s.stateSync == ss {
+		s.stateSync = nil
+	}
+	s.stateSyncMu.Unlock()
+
+	if err != nil {
+		// 分片已逐个验证，整体失败说明本地应用出错；保留分片，下次收到checkpoint时重试
+		log.Printf("❌ Failed to apply chunked state at height %d: %v", cp.Height, err)
+		return
+	}
+	if s.chunkStore != nil {
+		if err := s.chunkStore.ClearStateChunks(); err != nil {
+			log.Printf("Failed to clear state chunks: %v", err)
+		}
+	}
+
+	log.Printf("✅ Chunked state applied at height %d (%d accounts)", cp.Height, len(accounts))
+	peer := ss.peer
+	if !peer.IsConnected() {
+		// 原peer已断开，改从任一已连接的peer同步区块
+		s.peersMu.RLock()
+		for _, p := range s.peers {
+			if p.IsConnected() {
+				peer = p
+				break
+			}
+		}
+		s.peersMu.RUnlock()
+	}
+	s.afterStateSnapshot(peer, cp.Height)
+}
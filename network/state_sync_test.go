@@ -0,0 +1,208 @@
+package network
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"fan-chain/core"
+)
+
+// memChunkStore 内存中的分片存储
+type memChunkStore struct {
+	chunks map[core.Hash]map[uint32]*core.StateChunk
+}
+
+func newMemChunkStore() *memChunkStore {
+	return &memChunkStore{chunks: make(map[core.Hash]map[uint32]*core.StateChunk)}
+}
+
+func (m *memChunkStore) SaveStateChunk(stateRoot core.Hash, chunk *core.StateChunk) error {
+	if m.chunks[stateRoot] == nil {
+		m.chunks[stateRoot] = make(map[uint32]*core.StateChunk)
+	}
+	m.chunks[stateRoot][chunk.Index] = chunk
+	return nil
+}
+
+func (m *memChunkStore) GetStateChunks(stateRoot core.Hash) ([]*core.StateChunk, error) {
+	var chunks []*core.StateChunk
+	for _, chunk := range m.chunks[stateRoot] {
+		chunks = append(chunks, chunk)
+	}
+	return chunks, nil
+}
+
+func (m *memChunkStore) ClearStateChunks() error {
+	m.chunks = make(map[core.Hash]map[uint32]*core.StateChunk)
+	return nil
+}
+
+func newChunkPeer(host string) *Peer {
+	return &Peer{
+		host:         host,
+		connected:    true,
+		sendChan:     make(chan *Message, 100),
+		closeChan:    make(chan struct{}),
+		capabilities: map[string]bool{CapStateChunks: true},
+	}
+}
+
+// nextChunkRequest 读取peer收到的下一个分片请求
+func nextChunkRequest(t *testing.T, peer *Peer) *GetStateChunkMessage {
+	t.Helper()
+	select {
+	case msg := <-peer.sendChan:
+		var req GetStateChunkMessage
+		if msg.Type != MsgGetStateChunk || msg.ParsePayload(&req) != nil {
+			t.Fatalf("unexpected message type %d to %s", msg.Type, peer.host)
+		}
+		return &req
+	case <-time.After(time.Second):
+		return nil
+	}
+}
+
+func chunkReply(t *testing.T, req *GetStateChunkMessage, chunk *core.StateChunk) *Message {
+	t.Helper()
+	msg, err := NewMessage(MsgStateChunk, &StateChunkMessage{Height: req.Height, Chunk: chunk, RequestID: req.RequestID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func testChunkAccounts(n int) []*core.Account {
+	accounts := make([]*core.Account, n)
+	for i := range accounts {
+		accounts[i] = core.NewAccount(fmt.Sprintf("F1account%d", i))
+		accounts[i].AvailableBalance = uint64(i + 1)
+	}
+	return accounts
+}
+
+// 分片分配给多个peer，篡改的分片被拒绝并改向其他peer请求，全部到齐后整体应用
+func TestChunkedStateSync(t *testing.T) {
+	accounts := testChunkAccounts(300)
+	root, chunks := core.BuildStateChunks(accounts)
+	cp := &core.Checkpoint{Height: 100, StateRoot: root}
+
+	s := NewServer("", 9001, nil, "")
+	store := newMemChunkStore()
+	s.SetStateChunkStore(store)
+	var applied []*core.Account
+	s.SetApplyStateChunks(func(got *core.Checkpoint, accs []*core.Account) error {
+		applied = accs
+		return nil
+	})
+
+	honest, liar := newChunkPeer("198.51.100.1:9001"), newChunkPeer("198.51.100.2:9001")
+	s.peers[honest.host] = honest
+	s.peers[liar.host] = liar
+
+	s.startStateSync(honest, cp)
+
+	// liar篡改第一个分片的余额，之后的请求都收不到它的回复
+	lied := false
+	for applied == nil {
+		served := false
+		for _, peer := range []*Peer{honest, liar} {
+			req := nextChunkRequest(t, peer)
+			if req == nil {
+				continue
+			}
+			served = true
+			if peer == liar && lied {
+				// liar不再回复，请求超时后改派
+				s.stateSyncMu.Lock()
+				s.stateSync.inflight[req.Index].sentAt = time.Now().Add(-2 * chunkRequestTimeout)
+				s.stateSyncMu.Unlock()
+				s.scheduleStateChunks()
+				continue
+			}
+			chunk := chunks[req.Index]
+			if peer == liar {
+				forged := *chunk
+				forged.Accounts = append([]*core.Account{}, chunk.Accounts...)
+				if len(forged.Accounts) > 0 {
+					acc := *forged.Accounts[0]
+					acc.AvailableBalance += 1000
+					forged.Accounts[0] = &acc
+				}
+				chunk, lied = &forged, true
+			}
+			s.handleStateChunk(peer, chunkReply(t, req, chunk))
+		}
+		if !served {
+			t.Fatal("state sync stalled")
+		}
+	}
+
+	if s.peerMgr.scores[peerIP(liar.host)] == nil {
+		t.Fatal("peer serving a forged chunk was not penalized")
+	}
+	if core.StateRootOf(applied) != root || len(applied) != len(accounts) {
+		t.Fatalf("applied %d accounts with wrong root", len(applied))
+	}
+	if len(store.chunks) != 0 || s.stateSync != nil {
+		t.Fatal("state sync not cleaned up after apply")
+	}
+}
+
+// 重启后从已保存的分片继续，只请求缺少的分片
+func TestChunkedStateSyncResumes(t *testing.T) {
+	accounts := testChunkAccounts(200)
+	root, chunks := core.BuildStateChunks(accounts)
+	cp := &core.Checkpoint{Height: 100, StateRoot: root}
+
+	store := newMemChunkStore()
+	for _, chunk := range chunks[:core.StateChunkCount-2] {
+		store.SaveStateChunk(root, chunk)
+	}
+	// 另一个状态根下的分片不应被使用
+	store.SaveStateChunk(core.Hash{1}, chunks[core.StateChunkCount-1])
+
+	s := NewServer("", 9001, nil, "")
+	s.SetStateChunkStore(store)
+	done := false
+	s.SetApplyStateChunks(func(*core.Checkpoint, []*core.Account) error {
+		done = true
+		return nil
+	})
+	peer := newChunkPeer("198.51.100.1:9001")
+	s.peers[peer.host] = peer
+
+	s.startStateSync(peer, cp)
+
+	var requested []uint32
+	for req := nextChunkRequest(t, peer); req != nil; req = nextChunkRequest(t, peer) {
+		requested = append(requested, req.Index)
+		s.handleStateChunk(peer, chunkReply(t, req, chunks[req.Index]))
+	}
+	if len(requested) != 2 {
+		t.Fatalf("requested chunks %v, want only the 2 missing ones", requested)
+	}
+	if !done {
+		t.Fatal("state not applied after resuming")
+	}
+}
+
+// 对方没有该高度的快照时回复空分片
+func TestGetStateChunkWithoutSnapshot(t *testing.T) {
+	s := NewServer("", 9001, nil, "")
+	s.SetGetStateChunks(func(height uint64) ([]*core.StateChunk, error) {
+		return nil, fmt.Errorf("no snapshot at %d", height)
+	})
+	peer := newChunkPeer("198.51.100.1:9001")
+
+	msg, _ := NewMessage(MsgGetStateChunk, &GetStateChunkMessage{Height: 7, Index: 3, RequestID: 9})
+	s.handleGetStateChunk(peer, msg)
+
+	var reply StateChunkMessage
+	if err := (<-peer.sendChan).ParsePayload(&reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Chunk != nil || reply.RequestID != 9 {
+		t.Fatalf("reply = %+v, want empty chunk for request 9", reply)
+	}
+}
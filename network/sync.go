@@ -44,6 +44,7 @@ func (s *Server) requestNextBatch(peer *Peer, fromHeight uint64) {
 	req := &GetBlocksMessage{
 		FromHeight: fromHeight,
 		ToHeight:   batchEnd,
+		RequestID:  peer.trackRequest(MsgBlocks),
 	}
 
 	reqMsg, err := NewMessage(MsgGetBlocks, req)
@@ -140,6 +141,7 @@ func (s *Server) RequestBlocksDirect(fromHeight, toHeight uint64) error {
 	req := &GetBlocksMessage{
 		FromHeight: fromHeight,
 		ToHeight:   toHeight,
+		RequestID:  selectedPeer.trackRequest(MsgBlocks),
 	}
 
 	reqMsg, err := NewMessage(MsgGetBlocks, req)
@@ -0,0 +1,174 @@
+package network
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"fan-chain/core"
+)
+
+// 交易gossip参数
+const (
+	txSeenTTL       = 10 * time.Minute // 全局已见交易保留时间（超过交易时间戳允许偏移即可）
+	txSeenMaxSize   = 50000            // 全局已见交易最大数量
+	peerKnownTxsMax = 10000            // 每个peer已知交易集合上限（超过后清空重建）
+	txRateLimit     = 20.0             // 每个peer每秒允许的交易消息数（见messageRateLimits）
+	txRateBurst     = 200.0            // 每个peer允许的突发交易消息数
+)
+
+// txSeenCache 全局已见交易集合（按交易哈希去重，防止重复处理和转发风暴）
+type txSeenCache struct {
+	mu      sync.Mutex
+	entries map[core.Hash]time.Time
+}
+
+func newTxSeenCache() *txSeenCache {
+	return &txSeenCache{
+		entries: make(map[core.Hash]time.Time),
+	}
+}
+
+// markSeen 标记交易已见，返回此前是否已见过
+func (c *txSeenCache) markSeen(hash core.Hash) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if seenAt, ok := c.entries[hash]; ok && now.Sub(seenAt) < txSeenTTL {
+		return true
+	}
+
+	if len(c.entries) >= txSeenMaxSize {
+		c.expireLocked(now)
+	}
+	c.entries[hash] = now
+	return false
+}
+
+// seen 是否已见过该交易（只读，不记录）
+func (c *txSeenCache) seen(hash core.Hash) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	seenAt, ok := c.entries[hash]
+	return ok && time.Since(seenAt) < txSeenTTL
+}
+
+// expireLocked 清理过期记录；仍然超限时整体清空
+func (c *txSeenCache) expireLocked(now time.Time) {
+	for hash, seenAt := range c.entries {
+		if now.Sub(seenAt) >= txSeenTTL {
+			delete(c.entries, hash)
+		}
+	}
+	if len(c.entries) >= txSeenMaxSize {
+		c.entries = make(map[core.Hash]time.Time)
+	}
+}
+
+// txGossipKey 交易的gossip去重键
+// 旧格式交易的nonce不参与签名和哈希，中继可以改写nonce后以相同哈希抢先转发，
+// 使真实交易被当作已见丢弃；因此旧格式交易按哈希+nonce去重
+func txGossipKey(tx *core.Transaction) core.Hash {
+	txHash := tx.Hash()
+	if tx.SignsNonce() {
+		return txHash
+	}
+	return core.CalculateHash(append(txHash.Bytes(), core.Uint64ToBytes(tx.Nonce)...))
+}
+
+// markTxKnown 记录peer已知该交易（收到或已发送过），之后不再向其转发
+func (p *Peer) markTxKnown(hash core.Hash) {
+	p.txMu.Lock()
+	defer p.txMu.Unlock()
+
+	if p.knownTxs == nil || len(p.knownTxs) >= peerKnownTxsMax {
+		p.knownTxs = make(map[core.Hash]struct{})
+	}
+	p.knownTxs[hash] = struct{}{}
+}
+
+// knowsTx peer是否已知该交易
+func (p *Peer) knowsTx(hash core.Hash) bool {
+	p.txMu.Lock()
+	defer p.txMu.Unlock()
+	_, ok := p.knownTxs[hash]
+	return ok
+}
+
+// 处理交易广播
+// 流程：去重 -> 本地校验入池 -> 标记已见 -> 转发给未见过该交易的peer（限速在读取时按消息类型进行，见rate_limit.go）
+// 校验失败的交易不转发也不标记已见，避免无效副本抢占去重记录、挡住随后到达的有效交易
+func (s *Server) handleTransaction(peer *Peer, msg *Message) {
+	var txMsg TransactionMessage
+	if err := msg.ParsePayload(&txMsg); err != nil {
+		log.Printf("Failed to parse transaction from %s: %v", peer.host, err)
+		s.penalizePeer(peer, MisbehaviorInvalidMessage)
+		return
+	}
+
+	tx := txMsg.Transaction
+	if tx == nil {
+		return
+	}
+
+	txHash := tx.Hash()
+	gossipKey := txGossipKey(tx)
+	peer.markTxKnown(gossipKey)
+
+	if s.txSeen.seen(gossipKey) {
+		return
+	}
+
+	if s.handleReceivedTransaction == nil {
+		return
+	}
+
+	if err := s.handleReceivedTransaction(tx); err != nil {
+		log.Printf("[TX_GOSSIP] Rejected tx %x from %s: %v", txHash.Bytes()[:8], peer.host, err)
+		return
+	}
+
+	if s.txSeen.markSeen(gossipKey) {
+		return
+	}
+
+	log.Printf("📨 [TX_GOSSIP] Accepted tx %x from %s, relaying", txHash.Bytes()[:8], peer.host)
+	s.relayTransaction(tx, gossipKey)
+}
+
+// relayTransaction 转发交易给所有未知该交易的peer
+// gossipKey: 交易的gossip去重键（见txGossipKey）
+func (s *Server) relayTransaction(tx *core.Transaction, gossipKey core.Hash) int {
+	s.peersMu.RLock()
+	targets := make([]*Peer, 0, len(s.peers))
+	for _, peer := range s.peers {
+		if peer.IsConnected() && !peer.knowsTx(gossipKey) {
+			targets = append(targets, peer)
+		}
+	}
+	s.peersMu.RUnlock()
+
+	if len(targets) == 0 {
+		return 0
+	}
+
+	msg, err := NewMessage(MsgTransaction, &TransactionMessage{Transaction: tx})
+	if err != nil {
+		log.Printf("Failed to create transaction message: %v", err)
+		return 0
+	}
+
+	sent := 0
+	for _, peer := range targets {
+		peer.markTxKnown(gossipKey)
+		if err := peer.SendMessage(msg); err != nil {
+			log.Printf("[TX_GOSSIP] Failed to send tx %x to %s: %v", gossipKey.Bytes()[:8], peer.host, err)
+			continue
+		}
+		sent++
+	}
+
+	return sent
+}
@@ -0,0 +1,49 @@
+package network
+
+import (
+	"testing"
+
+	"fan-chain/core"
+)
+
+func TestTxGossipKeyLegacyNonce(t *testing.T) {
+	params := &core.GetConsensusConfig().TransactionParams
+	saved := params.NonceSigningActivationMs
+	params.NonceSigningActivationMs = 2000
+	defer func() { params.NonceSigningActivationMs = saved }()
+
+	// 旧格式交易：nonce不参与哈希，改写nonce后哈希不变但去重键不同
+	legacy := &core.Transaction{Type: core.TxTransfer, From: "a", To: "b", Amount: 1, Nonce: 1, Timestamp: 1000}
+	rewritten := *legacy
+	rewritten.Nonce = 9
+	if legacy.Hash() != rewritten.Hash() {
+		t.Fatal("legacy tx hash should not cover nonce")
+	}
+	if txGossipKey(legacy) == txGossipKey(&rewritten) {
+		t.Fatal("rewritten-nonce copy shares gossip key with original")
+	}
+
+	// 新格式交易：哈希已覆盖nonce，直接按哈希去重
+	signed := &core.Transaction{Type: core.TxTransfer, From: "a", To: "b", Amount: 1, Nonce: 1, Timestamp: 3000}
+	if txGossipKey(signed) != signed.Hash() {
+		t.Fatal("nonce-signed tx should use its hash as gossip key")
+	}
+}
+
+func TestTxSeenCacheSeenDoesNotMark(t *testing.T) {
+	c := newTxSeenCache()
+	key := core.CalculateHash([]byte("tx"))
+
+	if c.seen(key) {
+		t.Fatal("unknown key reported as seen")
+	}
+	if c.seen(key) {
+		t.Fatal("seen() must not record the key")
+	}
+	if c.markSeen(key) {
+		t.Fatal("first markSeen reported as already seen")
+	}
+	if !c.seen(key) || !c.markSeen(key) {
+		t.Fatal("marked key not reported as seen")
+	}
+}
@@ -0,0 +1,338 @@
+package network
+
+import (
+	"fmt"
+
+	"fan-chain/core"
+)
+
+// 线路编码：加密握手时协商，双方都支持二进制编码时使用二进制，否则（旧版本节点、明文节点）使用JSON
+// 二进制帧格式：消息类型(1字节) + 消息内容（core.BinaryWriter编码）
+const (
+	WireCodecJSON   uint8 = 0
+	WireCodecBinary uint8 = core.BinaryCodecVersion
+)
+
+// 本节点支持的线路编码（按优先级从高到低，JSON始终可用不需列出）
+var supportedWireCodecs = []uint8{WireCodecBinary}
+
+// negotiateWireCodec 入站方从对方支持的编码中选择本节点优先级最高的
+func negotiateWireCodec(offered []uint8) uint8 {
+	for _, codec := range supportedWireCodecs {
+		for _, c := range offered {
+			if c == codec {
+				return codec
+			}
+		}
+	}
+	return WireCodecJSON
+}
+
+// wireEncoder 支持二进制编码的消息内容
+type wireEncoder interface {
+	encodeWire(w *core.BinaryWriter)
+}
+
+// wireDecoder 支持二进制解码的消息内容
+type wireDecoder interface {
+	decodeWire(r *core.BinaryReader)
+}
+
+// encode 按编码序列化消息
+func (m *Message) encode(codec uint8) ([]byte, error) {
+	if codec != WireCodecBinary {
+		return m.Marshal()
+	}
+
+	m.encodeMu.Lock()
+	defer m.encodeMu.Unlock()
+
+	if m.binaryFrame == nil {
+		if m.value == nil && m.Payload != nil {
+			return nil, fmt.Errorf("message type %d has no binary payload", m.Type)
+		}
+
+		w := core.NewBinaryWriter()
+		w.WriteUint8(uint8(m.Type))
+		if m.value != nil {
+			m.value.(wireEncoder).encodeWire(w)
+		}
+		m.binaryFrame = w.Bytes()
+	}
+	return m.binaryFrame, nil
+}
+
+// decodeMessage 按编码反序列化消息
+func decodeMessage(codec uint8, data []byte) (*Message, error) {
+	if codec != WireCodecBinary {
+		return UnmarshalMessage(data)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty binary message")
+	}
+	return &Message{
+		Type:   MessageType(data[0]),
+		binary: data[1:],
+		codec:  WireCodecBinary,
+	}, nil
+}
+
+// decodeWirePayload 二进制解码消息内容，必须恰好用完所有数据
+func decodeWirePayload(data []byte, v interface{}) error {
+	d, ok := v.(wireDecoder)
+	if !ok {
+		return fmt.Errorf("%T has no binary decoding", v)
+	}
+	r := core.NewBinaryReader(data)
+	d.decodeWire(r)
+	return r.Finish()
+}
+
+// ========== 各消息的二进制编码 ==========
+
+func (m HelloMessage) encodeWire(w *core.BinaryWriter) {
+	w.WriteString(m.NetworkID)
+	w.WriteHash(m.GenesisHash)
+	w.WriteUvarint(uint64(m.ProtocolVersion))
+	w.WriteString(m.ConsensusVersion)
+	w.WriteString(m.ConsensusHash)
+	w.WriteUvarint(uint64(len(m.Capabilities)))
+	for _, c := range m.Capabilities {
+		w.WriteString(c)
+	}
+}
+
+func (m *HelloMessage) decodeWire(r *core.BinaryReader) {
+	m.NetworkID = r.ReadString()
+	m.GenesisHash = r.ReadHash()
+	m.ProtocolVersion = uint32(r.ReadUvarint())
+	m.ConsensusVersion = r.ReadString()
+	m.ConsensusHash = r.ReadString()
+	n := r.ReadCount(1)
+	m.Capabilities = make([]string, 0, n)
+	for i := 0; i < n && r.Err() == nil; i++ {
+		m.Capabilities = append(m.Capabilities, r.ReadString())
+	}
+}
+
+func (m PingMessage) encodeWire(w *core.BinaryWriter) {
+	w.WriteString(m.Address)
+	w.WriteUvarint(m.Height)
+	w.WriteString(m.LatestBlockHash)
+	w.WriteUvarint(m.CheckpointHeight)
+	w.WriteString(m.CheckpointHash)
+	w.WriteVarint(m.CheckpointTimestamp)
+	w.WriteString(m.ConsensusVersion)
+	w.WriteString(m.ConsensusHash)
+	w.WriteVarint(int64(m.ListenPort))
+}
+
+func (m *PingMessage) decodeWire(r *core.BinaryReader) {
+	m.Address = r.ReadString()
+	m.Height = r.ReadUvarint()
+	m.LatestBlockHash = r.ReadString()
+	m.CheckpointHeight = r.ReadUvarint()
+	m.CheckpointHash = r.ReadString()
+	m.CheckpointTimestamp = r.ReadVarint()
+	m.ConsensusVersion = r.ReadString()
+	m.ConsensusHash = r.ReadString()
+	m.ListenPort = int(r.ReadVarint())
+}
+
+func (m PongMessage) encodeWire(w *core.BinaryWriter) {
+	PingMessage(m).encodeWire(w)
+}
+
+func (m *PongMessage) decodeWire(r *core.BinaryReader) {
+	(*PingMessage)(m).decodeWire(r)
+}
+
+func (m GetBlocksMessage) encodeWire(w *core.BinaryWriter) {
+	w.WriteUvarint(m.FromHeight)
+	w.WriteUvarint(m.ToHeight)
+	w.WriteUvarint(m.RequestID)
+}
+
+func (m *GetBlocksMessage) decodeWire(r *core.BinaryReader) {
+	m.FromHeight = r.ReadUvarint()
+	m.ToHeight = r.ReadUvarint()
+	m.RequestID = r.ReadUvarint()
+}
+
+func (m BlocksMessage) encodeWire(w *core.BinaryWriter) {
+	w.WriteUvarint(uint64(len(m.Blocks)))
+	for _, b := range m.Blocks {
+		w.WriteBlock(b)
+	}
+	w.WriteUvarint(m.RequestID)
+}
+
+// 同步批次中不允许空区块（处理时直接访问区块头）
+func (m *BlocksMessage) decodeWire(r *core.BinaryReader) {
+	n := r.ReadCount(1)
+	m.Blocks = make([]*core.Block, 0, n)
+	for i := 0; i < n && r.Err() == nil; i++ {
+		b := r.ReadBlock()
+		if r.Err() == nil && b == nil {
+			r.Fail(fmt.Errorf("nil block in batch"))
+		}
+		m.Blocks = append(m.Blocks, b)
+	}
+	m.RequestID = r.ReadUvarint()
+}
+
+func (m LatestHeightMessage) encodeWire(w *core.BinaryWriter) {
+	w.WriteUvarint(m.Height)
+}
+
+func (m *LatestHeightMessage) decodeWire(r *core.BinaryReader) {
+	m.Height = r.ReadUvarint()
+}
+
+func (m NewBlockMessage) encodeWire(w *core.BinaryWriter) {
+	w.WriteBlock(m.Block)
+	w.WriteBytes(m.PublicKey)
+}
+
+func (m *NewBlockMessage) decodeWire(r *core.BinaryReader) {
+	m.Block = r.ReadBlock()
+	m.PublicKey = r.ReadBytes()
+}
+
+func (m TransactionMessage) encodeWire(w *core.BinaryWriter) {
+	w.WriteTransaction(m.Transaction)
+}
+
+func (m *TransactionMessage) decodeWire(r *core.BinaryReader) {
+	m.Transaction = r.ReadTransaction()
+}
+
+func (m EvidenceMessage) encodeWire(w *core.BinaryWriter) {
+	w.WriteEvidence(m.Evidence)
+}
+
+func (m *EvidenceMessage) decodeWire(r *core.BinaryReader) {
+	m.Evidence = r.ReadEvidence()
+}
+
+func (m CheckpointSigMessage) encodeWire(w *core.BinaryWriter) {
+	w.WriteUvarint(m.Height)
+	w.WriteHash(m.CheckpointHash)
+	w.WriteCheckpointSignature(m.Signature)
+}
+
+func (m *CheckpointSigMessage) decodeWire(r *core.BinaryReader) {
+	m.Height = r.ReadUvarint()
+	m.CheckpointHash = r.ReadHash()
+	m.Signature = r.ReadCheckpointSignature()
+}
+
+func (m GetAddrMessage) encodeWire(w *core.BinaryWriter) {}
+
+func (m *GetAddrMessage) decodeWire(r *core.BinaryReader) {}
+
+func (m AddrMessage) encodeWire(w *core.BinaryWriter) {
+	w.WriteUvarint(uint64(len(m.Addresses)))
+	for _, addr := range m.Addresses {
+		w.WriteString(addr)
+	}
+}
+
+func (m *AddrMessage) decodeWire(r *core.BinaryReader) {
+	n := r.ReadCount(1)
+	m.Addresses = make([]string, 0, n)
+	for i := 0; i < n && r.Err() == nil; i++ {
+		m.Addresses = append(m.Addresses, r.ReadString())
+	}
+}
+
+func (m GetCheckpointMessage) encodeWire(w *core.BinaryWriter) {
+	w.WriteUvarint(m.Count)
+	w.WriteUvarint(m.RequestID)
+}
+
+func (m *GetCheckpointMessage) decodeWire(r *core.BinaryReader) {
+	m.Count = r.ReadUvarint()
+	m.RequestID = r.ReadUvarint()
+}
+
+func (m CheckpointMessage) encodeWire(w *core.BinaryWriter) {
+	w.WriteUvarint(uint64(len(m.Checkpoints)))
+	for _, info := range m.Checkpoints {
+		w.WriteCheckpoint(info.Checkpoint)
+		w.WriteBool(info.HasStateData)
+		w.WriteUvarint(info.CompressedSize)
+	}
+	w.WriteUvarint(m.RequestID)
+}
+
+func (m *CheckpointMessage) decodeWire(r *core.BinaryReader) {
+	n := r.ReadCount(3)
+	m.Checkpoints = make([]CheckpointInfo, n)
+	for i := 0; i < n && r.Err() == nil; i++ {
+		m.Checkpoints[i] = CheckpointInfo{
+			Checkpoint:     r.ReadCheckpoint(),
+			HasStateData:   r.ReadBool(),
+			CompressedSize: r.ReadUvarint(),
+		}
+	}
+	m.RequestID = r.ReadUvarint()
+}
+
+func (m GetStateMessage) encodeWire(w *core.BinaryWriter) {
+	w.WriteUvarint(m.Height)
+	w.WriteUvarint(m.RequestID)
+}
+
+func (m *GetStateMessage) decodeWire(r *core.BinaryReader) {
+	m.Height = r.ReadUvarint()
+	m.RequestID = r.ReadUvarint()
+}
+
+func (m StateDataMessage) encodeWire(w *core.BinaryWriter) {
+	w.WriteUvarint(m.Height)
+	w.WriteBytes(m.CompressedData)
+	w.WriteUvarint(m.RequestID)
+}
+
+func (m *StateDataMessage) decodeWire(r *core.BinaryReader) {
+	m.Height = r.ReadUvarint()
+	m.CompressedData = r.ReadBytes()
+	m.RequestID = r.ReadUvarint()
+}
+
+func (m GetStateChunkMessage) encodeWire(w *core.BinaryWriter) {
+	w.WriteUvarint(m.Height)
+	w.WriteUvarint(uint64(m.Index))
+	w.WriteUvarint(m.RequestID)
+}
+
+func (m *GetStateChunkMessage) decodeWire(r *core.BinaryReader) {
+	m.Height = r.ReadUvarint()
+	m.Index = uint32(r.ReadUvarint())
+	m.RequestID = r.ReadUvarint()
+}
+
+func (m StateChunkMessage) encodeWire(w *core.BinaryWriter) {
+	w.WriteUvarint(m.Height)
+	w.WriteStateChunk(m.Chunk)
+	w.WriteUvarint(m.RequestID)
+}
+
+func (m *StateChunkMessage) decodeWire(r *core.BinaryReader) {
+	m.Height = r.ReadUvarint()
+	m.Chunk = r.ReadStateChunk()
+	m.RequestID = r.ReadUvarint()
+}
+
+func (m GetEarliestHeightMessage) encodeWire(w *core.BinaryWriter) {}
+
+func (m *GetEarliestHeightMessage) decodeWire(r *core.BinaryReader) {}
+
+func (m EarliestHeightMessage) encodeWire(w *core.BinaryWriter) {
+	w.WriteUvarint(m.Height)
+}
+
+func (m *EarliestHeightMessage) decodeWire(r *core.BinaryReader) {
+	m.Height = r.ReadUvarint()
+}
@@ -0,0 +1,315 @@
+package network
+
+import (
+	"bytes"
+	"testing"
+
+	"fan-chain/core"
+)
+
+// 各消息类型对应的解码目标
+var wirePayloadTypes = map[MessageType]func() wireDecoder{
+	MsgPing:              func() wireDecoder { return &PingMessage{} },
+	MsgPong:              func() wireDecoder { return &PongMessage{} },
+	MsgGetBlocks:         func() wireDecoder { return &GetBlocksMessage{} },
+	MsgBlocks:            func() wireDecoder { return &BlocksMessage{} },
+	MsgLatestHeight:      func() wireDecoder { return &LatestHeightMessage{} },
+	MsgNewBlock:          func() wireDecoder { return &NewBlockMessage{} },
+	MsgTransaction:       func() wireDecoder { return &TransactionMessage{} },
+	MsgGetCheckpoint:     func() wireDecoder { return &GetCheckpointMessage{} },
+	MsgCheckpoint:        func() wireDecoder { return &CheckpointMessage{} },
+	MsgGetState:          func() wireDecoder { return &GetStateMessage{} },
+	MsgStateData:         func() wireDecoder { return &StateDataMessage{} },
+	MsgGetEarliestHeight: func() wireDecoder { return &GetEarliestHeightMessage{} },
+	MsgEarliestHeight:    func() wireDecoder { return &EarliestHeightMessage{} },
+	MsgEvidence:          func() wireDecoder { return &EvidenceMessage{} },
+	MsgCheckpointSig:     func() wireDecoder { return &CheckpointSigMessage{} },
+	MsgGetAddr:           func() wireDecoder { return &GetAddrMessage{} },
+	MsgAddr:              func() wireDecoder { return &AddrMessage{} },
+	MsgHello:             func() wireDecoder { return &HelloMessage{} },
+	MsgGetStateChunk:     func() wireDecoder { return &GetStateChunkMessage{} },
+	MsgStateChunk:        func() wireDecoder { return &StateChunkMessage{} },
+}
+
+func filled(n int, b byte) []byte {
+	return bytes.Repeat([]byte{b}, n)
+}
+
+// 带ML-DSA尺寸签名和公钥的测试区块
+func testWireBlock(height uint64) *core.Block {
+	tx := &core.Transaction{
+		Type:      core.TxTransfer,
+		From:      "F1sender",
+		To:        "F1receiver",
+		Amount:    1500000,
+		GasFee:    1,
+		Nonce:     height,
+		Timestamp: 1700000000000 + int64(height),
+		Signature: filled(3309, 0xA1),
+		PublicKey: filled(1952, 0xB2),
+	}
+	block := &core.Block{
+		Header: &core.BlockHeader{
+			Height:       height,
+			PreviousHash: core.Hash{1, 2, 3},
+			Timestamp:    1700000000000 + int64(height)*5000,
+			StateRoot:    core.Hash{4, 5, 6},
+			Proposer:     "F1proposer",
+			VRFProof:     filled(80, 0xC3),
+			VRFOutput:    filled(32, 0xD4),
+			Signature:    filled(3309, 0xE5),
+		},
+		Transactions: []*core.Transaction{tx, core.NewRewardTx("F1proposer", 10)},
+	}
+	block.Header.TxRoot = block.CalculateTxRoot()
+	return block
+}
+
+func testWireCheckpoint() *core.Checkpoint {
+	return &core.Checkpoint{
+		Height:     42,
+		BlockHash:  core.Hash{9},
+		StateRoot:  core.Hash{8},
+		Timestamp:  1700000210000,
+		Proposer:   "F1proposer",
+		Validators: []core.ValidatorSnapshot{{Address: "F1proposer", Stake: 1000, VRFPubKey: filled(33, 2)}},
+		Signature:  filled(3309, 3),
+		Signatures: []core.CheckpointSignature{{Validator: "F1proposer", PublicKey: filled(1952, 4), Signature: filled(3309, 5)}},
+		VRFOutput:  filled(32, 6),
+	}
+}
+
+func testWireStateChunk() *core.StateChunk {
+	accounts := []*core.Account{core.NewAccount("F1a"), core.NewAccount("F1b"), core.NewAccount("F1c")}
+	accounts[0].AvailableBalance = 100
+	_, chunks := core.BuildStateChunks(accounts)
+	chunk := chunks[core.StateChunkIndex(core.StateKey("F1a"))]
+	chunk.Other = &core.StateLeaf{Key: core.Hash{1}, Value: core.Hash{2}}
+	return chunk
+}
+
+// 一组覆盖所有消息类型的样例（也作为模糊测试的种子）
+func sampleWireMessages(t testing.TB) []*Message {
+	samples := []struct {
+		msgType MessageType
+		payload interface{}
+	}{
+		{MsgPing, &PingMessage{Address: "F1a", Height: 7, ConsensusVersion: "1.4.0", ListenPort: 9001}},
+		{MsgPong, &PongMessage{Address: "F1b", Height: 8, CheckpointTimestamp: -1}},
+		{MsgGetBlocks, &GetBlocksMessage{FromHeight: 1, ToHeight: 100}},
+		{MsgBlocks, &BlocksMessage{Blocks: []*core.Block{testWireBlock(1), testWireBlock(2)}}},
+		{MsgLatestHeight, &LatestHeightMessage{Height: 99}},
+		{MsgNewBlock, &NewBlockMessage{Block: testWireBlock(3), PublicKey: filled(1952, 7)}},
+		{MsgTransaction, &TransactionMessage{Transaction: testWireBlock(4).Transactions[0]}},
+		{MsgGetCheckpoint, &GetCheckpointMessage{Count: 3}},
+		{MsgCheckpoint, &CheckpointMessage{Checkpoints: []CheckpointInfo{{Checkpoint: testWireCheckpoint(), HasStateData: true, CompressedSize: 77}}}},
+		{MsgGetState, &GetStateMessage{Height: 42}},
+		{MsgStateData, &StateDataMessage{Height: 42, CompressedData: filled(100, 8)}},
+		{MsgGetEarliestHeight, &GetEarliestHeightMessage{}},
+		{MsgEarliestHeight, &EarliestHeightMessage{Height: 1}},
+		{MsgEvidence, &EvidenceMessage{Evidence: &core.DoubleSignEvidence{HeaderA: testWireBlock(5).Header, HeaderB: testWireBlock(6).Header, PublicKey: filled(1952, 9)}}},
+		{MsgCheckpointSig, &CheckpointSigMessage{Height: 42, CheckpointHash: core.Hash{7}, Signature: &testWireCheckpoint().Signatures[0]}},
+		{MsgGetAddr, &GetAddrMessage{}},
+		{MsgAddr, &AddrMessage{Addresses: []string{"198.51.100.1:9001", "[2001:db8::1]:9001"}}},
+		{MsgHello, localHello()},
+		{MsgGetStateChunk, &GetStateChunkMessage{Height: 42, Index: 63, RequestID: 5}},
+		{MsgStateChunk, &StateChunkMessage{Height: 42, Chunk: testWireStateChunk(), RequestID: 5}},
+		{MsgStateChunk, &StateChunkMessage{Height: 42, RequestID: 6}},
+	}
+
+	msgs := make([]*Message, 0, len(samples))
+	for _, sample := range samples {
+		msg, err := NewMessage(sample.msgType, sample.payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+// 二进制编码往返：解码结果与JSON编码解码结果一致，区块和交易哈希不变
+func TestWireCodecRoundTrip(t *testing.T) {
+	for _, msg := range sampleWireMessages(t) {
+		frame, err := msg.encode(WireCodecBinary)
+		if err != nil {
+			t.Fatalf("type %d: encode: %v", msg.Type, err)
+		}
+		decoded, err := decodeMessage(WireCodecBinary, frame)
+		if err != nil || decoded.Type != msg.Type {
+			t.Fatalf("type %d: decode: %v", msg.Type, err)
+		}
+
+		fromBinary := wirePayloadTypes[msg.Type]()
+		if err := decoded.ParsePayload(fromBinary); err != nil {
+			t.Fatalf("type %d: parse: %v", msg.Type, err)
+		}
+		fromJSON := wirePayloadTypes[msg.Type]()
+		if err := msg.ParsePayload(fromJSON); err != nil {
+			t.Fatalf("type %d: parse json: %v", msg.Type, err)
+		}
+
+		// 两种解码结果重新编码后必须完全相同
+		a, b := core.NewBinaryWriter(), core.NewBinaryWriter()
+		fromBinary.(wireEncoder).encodeWire(a)
+		fromJSON.(wireEncoder).encodeWire(b)
+		if !bytes.Equal(a.Bytes(), b.Bytes()) {
+			t.Errorf("type %d: binary and JSON decoding differ", msg.Type)
+		}
+	}
+
+	block := testWireBlock(10)
+	data, _ := block.MarshalBinary()
+	var decoded core.Block
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Hash() != block.Hash() || decoded.Transactions[0].Hash() != block.Transactions[0].Hash() {
+		t.Fatal("block hash changed after binary round trip")
+	}
+	if decoded.CalculateTxRoot() != block.Header.TxRoot {
+		t.Fatal("tx root changed after binary round trip")
+	}
+}
+
+// 同步批次的二进制编码明显小于JSON
+func TestWireCodecSmallerThanJSON(t *testing.T) {
+	blocks := make([]*core.Block, 0, 20)
+	for h := uint64(1); h <= 20; h++ {
+		blocks = append(blocks, testWireBlock(h))
+	}
+	msg, _ := NewMessage(MsgBlocks, &BlocksMessage{Blocks: blocks})
+
+	jsonFrame, err := msg.encode(WireCodecJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binaryFrame, err := msg.encode(WireCodecBinary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(binaryFrame)*4 > len(jsonFrame)*3 {
+		t.Fatalf("binary %d bytes vs JSON %d bytes", len(binaryFrame), len(jsonFrame))
+	}
+}
+
+// 解码被截断或伪造的数据只返回错误
+func TestWireCodecRejectsMalformed(t *testing.T) {
+	msg, _ := NewMessage(MsgBlocks, &BlocksMessage{Blocks: []*core.Block{testWireBlock(1)}})
+	frame, _ := msg.encode(WireCodecBinary)
+
+	for _, cut := range []int{2, len(frame) / 2, len(frame) - 1} {
+		decoded, _ := decodeMessage(WireCodecBinary, frame[:cut])
+		if err := decoded.ParsePayload(&BlocksMessage{}); err == nil {
+			t.Errorf("truncated frame (%d bytes) accepted", cut)
+		}
+	}
+
+	// 声称有大量区块的批次
+	huge := []byte{byte(MsgBlocks), 0xff, 0xff, 0xff, 0xff, 0x0f}
+	decoded, _ := decodeMessage(WireCodecBinary, huge)
+	if err := decoded.ParsePayload(&BlocksMessage{}); err == nil {
+		t.Error("oversized element count accepted")
+	}
+
+	// 多余数据
+	decoded, _ = decodeMessage(WireCodecBinary, append(append([]byte{}, frame...), 0))
+	if err := decoded.ParsePayload(&BlocksMessage{}); err == nil {
+		t.Error("trailing bytes accepted")
+	}
+}
+
+// 加密握手协商二进制编码，之后的消息按二进制编码加密传输
+func TestHandshakeNegotiatesBinaryCodec(t *testing.T) {
+	client, server := newKeyedServer(t), newKeyedServer(t)
+	clientPeer, serverPeer, clientErr, serverErr := runHandshake(client, server)
+	if clientErr != nil || serverErr != nil {
+		t.Fatalf("handshake failed: client=%v server=%v", clientErr, serverErr)
+	}
+	defer clientPeer.conn.Close()
+	defer serverPeer.conn.Close()
+
+	if clientPeer.codec != WireCodecBinary || serverPeer.codec != WireCodecBinary {
+		t.Fatalf("codecs = %d/%d, want binary", clientPeer.codec, serverPeer.codec)
+	}
+
+	sent, _ := NewMessage(MsgNewBlock, &NewBlockMessage{Block: testWireBlock(7)})
+	go clientPeer.writeMessage(sent)
+
+	frame, err := serverPeer.readFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := serverPeer.channel.open(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received, err := decodeMessage(serverPeer.codec, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var newBlock NewBlockMessage
+	if err := received.ParsePayload(&newBlock); err != nil {
+		t.Fatal(err)
+	}
+	if newBlock.Block.Hash() != testWireBlock(7).Hash() {
+		t.Fatal("block changed in transit")
+	}
+}
+
+// 解码任意输入不能panic；解码成功的内容重新编码后能再次解码
+func FuzzDecodeWireMessage(f *testing.F) {
+	for _, msg := range sampleWireMessages(f) {
+		frame, err := msg.encode(WireCodecBinary)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(frame)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg, err := decodeMessage(WireCodecBinary, data)
+		if err != nil {
+			return
+		}
+		newPayload, ok := wirePayloadTypes[msg.Type]
+		if !ok {
+			return
+		}
+		payload := newPayload()
+		if err := msg.ParsePayload(payload); err != nil {
+			return
+		}
+
+		w := core.NewBinaryWriter()
+		payload.(wireEncoder).encodeWire(w)
+		if err := decodeWirePayload(w.Bytes(), newPayload()); err != nil {
+			t.Fatalf("type %d: re-encoded payload does not decode: %v", msg.Type, err)
+		}
+	})
+}
+
+// 区块、交易、checkpoint的独立编码（带版本号）解码任意输入不能panic
+func FuzzUnmarshalBinary(f *testing.F) {
+	block, _ := testWireBlock(1).MarshalBinary()
+	tx, _ := testWireBlock(2).Transactions[0].MarshalBinary()
+	cp, _ := testWireCheckpoint().MarshalBinary()
+	f.Add(block)
+	f.Add(tx)
+	f.Add(cp)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var b core.Block
+		if b.UnmarshalBinary(data) == nil {
+			b.Hash()
+		}
+		var tx core.Transaction
+		if tx.UnmarshalBinary(data) == nil {
+			tx.Hash()
+		}
+		var cp core.Checkpoint
+		if cp.UnmarshalBinary(data) == nil {
+			cp.Hash()
+		}
+	})
+}
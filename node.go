@@ -3,14 +3,15 @@ package main
 import (
 	"fmt"
 	"log"
-	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"fan-chain/api"
 	"fan-chain/config"
 	"fan-chain/consensus"
 	"fan-chain/core"
+	"fan-chain/mempool"
 	"fan-chain/network"
 	"fan-chain/state"
 	"fan-chain/storage"
@@ -25,10 +26,20 @@ type Node struct {
 	p2pServer *network.Server
 	apiServer *api.Server
 
-	address      string
-	privateKey   []byte
-	publicKey    []byte
-	pendingTxDir string
+	address    string
+	privateKey []byte
+	publicKey  []byte
+
+	// 交易池（内存索引 + journal落盘）
+	txPool *mempool.TxPool
+
+	// 双签证据池（待打包为惩罚交易）
+	evidencePool *mempool.EvidencePool
+
+	// checkpoint多签：签名合并互斥、checkpoint到达前先收到的签名、最近最终确认的高度
+	checkpointSigMu       sync.Mutex
+	pendingCheckpointSigs map[core.Hash][]*core.CheckpointSignature
+	finalizedCheckpoint   uint64
 
 	// 验证者激活状态（安全机制：防止未同步节点出块）
 	validatorActivated bool
@@ -52,25 +63,36 @@ func NewNode(cfg *config.Config) (*Node, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %v", err)
 	}
+	db.SetArchiveMode(cfg.Archive)
 
 	stateManager := state.NewStateManager(db)
 	consensusEngine := consensus.NewConsensusEngine(stateManager)
 	blockchain := core.NewBlockchain()
 
-	pendingTxDir := filepath.Join(cfg.DataDir, "pending_txs")
-	if err := os.MkdirAll(pendingTxDir, 0700); err != nil {
-		return nil, fmt.Errorf("failed to create pending_txs dir: %v", err)
+	txPool := mempool.NewTxPool(cfg.MempoolMaxSize, cfg.MempoolJournalPath())
+	if err := txPool.LoadJournal(); err != nil {
+		log.Printf("⚠️  [TX_POOL] %v", err)
 	}
 
 	node := &Node{
-		config:       cfg,
-		db:           db,
-		chain:        blockchain,
-		state:        stateManager,
-		consensus:    consensusEngine,
-		pendingTxDir: pendingTxDir,
+		config:    cfg,
+		db:        db,
+		chain:     blockchain,
+		state:     stateManager,
+		consensus: consensusEngine,
+		txPool:    txPool,
+
+		evidencePool: mempool.NewEvidencePool(mempool.DefaultMaxEvidence),
+
+		pendingCheckpointSigs: make(map[core.Hash][]*core.CheckpointSignature),
 	}
 
+	// 【迁移】旧版本pending_txs目录中的交易导入交易池
+	node.migrateLegacyPendingTxs(filepath.Join(cfg.DataDir, "pending_txs"))
+
+	// 【迁移】单点设计遗留的state_latest.dat.gz按高度改名，升级后回滚仍可使用
+	node.migrateLegacyStateSnapshot()
+
 	// 设置验证者变更回调：当质押/解押导致验证者集合变化时，实时更新共识层
 	stateManager.SetValidatorCallbacks(
 		// onValidatorAdded: 新验证者加入
@@ -117,6 +139,10 @@ func (n *Node) InitializeAPI() error {
 		},
 	)
 
+	n.apiServer.SetGetLatestCheckpoint(func() (*core.Checkpoint, error) {
+		return n.db.GetLatestCheckpoint(n.config.DataDir)
+	})
+
 	go func() {
 		if err := n.apiServer.Start(); err != nil {
 			log.Fatalf("API server failed: %v", err)
@@ -127,6 +153,9 @@ func (n *Node) InitializeAPI() error {
 }
 
 func (n *Node) Close() {
+	if n.txPool != nil {
+		n.txPool.Close()
+	}
 	if n.db != nil {
 		n.db.Close()
 	}
@@ -207,12 +236,11 @@ func (n *Node) RecoverStateIfNeeded() error {
 
 		log.Printf("  🔄 Replaying block #%d (%d txs)", height, len(block.Transactions))
 
-		// 执行区块中的交易
-		for _, tx := range block.Transactions {
-			if err := n.state.ExecuteTransaction(tx, true); err != nil {
-				log.Printf("  ⚠️  Warning: tx execution error in replay: %v", err)
-				// 在恢复模式下继续，不中断
-			}
+		// 与同步区块走同一执行路径：出块缺席记录、解绑到期释放、区块头状态根校验都按区块高度进行
+		// 区块已经上链，任一交易执行失败说明本地状态与链不一致，不能跳过继续
+		receipts, err := n.executeImportedBlock(block)
+		if err != nil {
+			return fmt.Errorf("failed to replay block %d: %v", height, err)
 		}
 
 		// 提交状态并更新state_height
@@ -220,6 +248,10 @@ func (n *Node) RecoverStateIfNeeded() error {
 			return fmt.Errorf("failed to commit state at height %d: %v", height, err)
 		}
 
+		if err := n.db.SaveReceipts(receipts); err != nil {
+			log.Printf("  ⚠️  Warning: failed to save receipts for block #%d: %v", height, err)
+		}
+
 		log.Printf("  ✓ Block #%d replayed successfully", height)
 	}
 
@@ -20,6 +20,11 @@ func (n *Node) InitializeBlockchain() error {
 			log.Printf("✓ Loaded %d validators from checkpoint", len(checkpoint.Validators))
 		}
 
+		// 恢复最终确认高度（本地checkpoint已带足够签名时重启后仍不可回滚）
+		n.checkpointSigMu.Lock()
+		n.checkFinalityLocked(checkpoint)
+		n.checkpointSigMu.Unlock()
+
 		// 加载状态快照
 		stateData, err := n.db.LoadStateSnapshot(checkpoint.Height, n.config.DataDir)
 		if err == nil && len(stateData) > 0 {
@@ -39,6 +44,7 @@ func (n *Node) InitializeBlockchain() error {
 					Timestamp:    checkpoint.Timestamp,
 					StateRoot:    checkpoint.StateRoot,
 					PreviousHash: checkpoint.PreviousHash, // 使用checkpoint中的PreviousHash
+					VRFOutput:    checkpoint.VRFOutput,    // 下一块的出块种子由此推导
 				},
 				Transactions: []*core.Transaction{},
 			}
@@ -95,6 +101,12 @@ func (n *Node) InitializeValidators() error {
 func (n *Node) PerformChainReorganization(rollbackHeight uint64, correctBlock *core.Block) error {
 	log.Printf("🔄 CHAIN REORG: Starting reorganization to height %d", rollbackHeight)
 
+	// 分叉选择限制：不回滚已最终确认的checkpoint，深度不超过MaxReorgDepth
+	if err := n.checkReorgAllowed(rollbackHeight); err != nil {
+		log.Printf("⛔ REORG REFUSED: %v", err)
+		return err
+	}
+
 	// 1. 获取回滚目标区块
 	targetBlock, err := n.db.GetBlockByHeight(rollbackHeight)
 	if err != nil {
@@ -120,6 +132,13 @@ func (n *Node) PerformChainReorganization(rollbackHeight uint64, correctBlock *c
 	}
 	log.Printf("✓ REORG: Hash validation passed - local #%d matches correct block's prev hash", rollbackHeight)
 
+	// 删除任何数据之前先取得回滚点的状态快照，没有可用快照时拒绝重组
+	snapshot, stateRoot, err := n.loadRollbackSnapshot(rollbackHeight)
+	if err != nil {
+		log.Printf("⛔ REORG REFUSED: %v", err)
+		return err
+	}
+
 	// 2. 删除数据库中错误的区块
 	if err := n.db.DeleteBlocksAboveHeight(rollbackHeight); err != nil {
 		return fmt.Errorf("failed to delete incorrect blocks: %v", err)
@@ -130,9 +149,9 @@ func (n *Node) PerformChainReorganization(rollbackHeight uint64, correctBlock *c
 		return fmt.Errorf("failed to rollback blockchain: %v", err)
 	}
 
-	// 4. 重新加载状态
-	if err := n.state.ReloadStateFromHeight(n.db, rollbackHeight); err != nil {
-		return fmt.Errorf("failed to reload state: %v", err)
+	// 4. 恢复回滚点的状态（快照 + 重放到回滚点）
+	if err := n.restoreStateAt(rollbackHeight, snapshot, stateRoot); err != nil {
+		return fmt.Errorf("failed to restore state: %v", err)
 	}
 
 	log.Printf("✓ REORG: Rolled back to height %d", rollbackHeight)
@@ -140,11 +159,10 @@ func (n *Node) PerformChainReorganization(rollbackHeight uint64, correctBlock *c
 	// 5. 添加正确的区块
 	log.Printf("🔄 REORG: Adding correct block #%d from proposer %s", correctBlock.Header.Height, correctBlock.Header.Proposer[:10])
 
-	// 执行区块中的交易
-	for _, tx := range correctBlock.Transactions {
-		if err := n.state.ExecuteTransaction(tx, true); err != nil {
-			return fmt.Errorf("failed to execute tx in correct block: %v", err)
-		}
+	// 执行区块中的交易并验证执行后的状态根
+	receipts, err := n.executeImportedBlock(correctBlock)
+	if err != nil {
+		return fmt.Errorf("failed to execute correct block: %v", err)
 	}
 
 	// 【P0原子性】使用带P0验证的提交
@@ -161,7 +179,37 @@ func (n *Node) PerformChainReorganization(rollbackHeight uint64, correctBlock *c
 	if err := n.db.SaveBlock(correctBlock); err != nil {
 		return fmt.Errorf("failed to save correct block: %v", err)
 	}
+	if err := n.db.SaveReceipts(receipts); err != nil {
+		log.Printf("Warning: failed to save receipts for block #%d: %v", correctBlock.Header.Height, err)
+	}
 
 	log.Printf("✅ REORG COMPLETE: Chain reorganized to height %d with correct block", correctBlock.Header.Height)
 	return nil
 }
+
+// executeImportedBlock 执行导入的区块：从其他节点收到的区块，或启动恢复时重放的本地区块（跳过时间戳验证）
+// StateRootActive起执行后的状态根必须与区块头一致，否则撤销本次执行并拒绝该区块，状态分歧在发生的区块就被发现
+func (n *Node) executeImportedBlock(block *core.Block) ([]*core.Receipt, error) {
+	snapshot := n.state.CreateSnapshot()
+
+	receipts, err := n.state.ExecuteBlock(block, true)
+	if err != nil {
+		n.state.RestoreSnapshot(snapshot)
+		return nil, err
+	}
+
+	if core.StateRootActive(block.Header.Height) {
+		stateRoot, err := n.state.CalculateStateRoot(block.Header.Height)
+		if err != nil {
+			n.state.RestoreSnapshot(snapshot)
+			return nil, err
+		}
+		if stateRoot != block.Header.StateRoot {
+			n.state.RestoreSnapshot(snapshot)
+			return nil, fmt.Errorf("state root mismatch at block #%d: header=%s, executed=%s",
+				block.Header.Height, block.Header.StateRoot.String(), stateRoot.String())
+		}
+	}
+
+	return receipts, nil
+}
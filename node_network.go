@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
@@ -16,6 +15,13 @@ import (
 
 func (n *Node) InitializeP2P() error {
 	n.p2pServer = network.NewServer(n.address, n.config.P2PPort, n.config.SeedPeers, n.config.PublicIP)
+
+	// 已知节点地址和封禁持久化到数据库，重启后继续使用
+	n.p2pServer.SetPeerStore(n.db)
+	n.p2pServer.SetPeerBanDuration(time.Duration(n.config.PeerBanMinutes) * time.Minute)
+	// P2P连接全部经过ML-KEM加密握手，用本节点ML-DSA私钥证明身份
+	n.p2pServer.SetNodePrivateKey(n.privateKey)
+	n.p2pServer.SetAllowPlaintextPeers(n.config.AllowPlaintextPeers)
 	n.p2pServer.SetBlockchainInterface(
 		func() *core.Block {
 			return n.chain.GetLatestBlock()
@@ -44,11 +50,10 @@ func (n *Node) InitializeP2P() error {
 			// VRF proposer验证只在P2P网络层对"实时新区�?进行(network/server.go)
 			// 历史区块的正确性已经由链上大多数节点共识保�?
 
-			// 执行同步的历史区块交易（跳过时间戳验证）
-			for _, tx := range block.Transactions {
-				if err := n.state.ExecuteTransaction(tx, true); err != nil {
-					return err
-				}
+			// 执行同步的历史区块交易（跳过时间戳验证），并验证执行后的状态根
+			receipts, err := n.executeImportedBlock(block)
+			if err != nil {
+				return err
 			}
 
 			// 【P0原子性】使用带P0验证的提交
@@ -58,7 +63,6 @@ func (n *Node) InitializeP2P() error {
 
 			log.Printf("区块 #%d 同步完成 (P0验证通过)", block.Header.Height)
 
-
 			// 添加区块到区块链
 			if err := n.chain.AddBlock(block); err != nil {
 				return err
@@ -69,6 +73,14 @@ func (n *Node) InitializeP2P() error {
 				return err
 			}
 
+			if err := n.db.SaveReceipts(receipts); err != nil {
+				log.Printf("Warning: failed to save receipts for block #%d: %v", block.Header.Height, err)
+			}
+
+			// 其他节点已打包的交易从本地交易池移除
+			n.txPool.RemoveIncluded(block.Transactions)
+			n.evidencePool.RemoveIncluded(block.Transactions)
+
 			return nil
 		},
 		func(fromHeight, toHeight uint64) ([]*core.Block, error) {
@@ -85,11 +97,10 @@ func (n *Node) InitializeP2P() error {
 		}
 
 		// 【关键】跳过时间戳验证，用于同步历史区�?
-		// 执行同步的历史区块交易（跳过时间戳验证）
-		for _, tx := range block.Transactions {
-			if err := n.state.ExecuteTransaction(tx, true); err != nil {
-				return err
-			}
+		// 执行同步的历史区块交易（跳过时间戳验证），并验证执行后的状态根
+		receipts, err := n.executeImportedBlock(block)
+		if err != nil {
+			return err
 		}
 
 		// 【P0原子性】使用带P0验证的提交
@@ -99,7 +110,6 @@ func (n *Node) InitializeP2P() error {
 
 		log.Printf("历史区块 #%d 同步完成 (P0验证通过)", block.Header.Height)
 
-
 		// 添加区块到区块链（跳过时间戳验证�?
 		if err := n.chain.AddBlockWithOptions(block, true); err != nil {
 			return err
@@ -110,6 +120,13 @@ func (n *Node) InitializeP2P() error {
 			return err
 		}
 
+		if err := n.db.SaveReceipts(receipts); err != nil {
+			log.Printf("Warning: failed to save receipts for block #%d: %v", block.Header.Height, err)
+		}
+
+		n.txPool.RemoveIncluded(block.Transactions)
+		n.evidencePool.RemoveIncluded(block.Transactions)
+
 		return nil
 	})
 
@@ -145,6 +162,11 @@ func (n *Node) InitializeP2P() error {
 			return nil
 		}
 
+		// 已最终确认的区块不可替换
+		if finalized := n.finalizedHeight(); block.Header.Height <= finalized {
+			return fmt.Errorf("block #%d is at or below finalized checkpoint #%d", block.Header.Height, finalized)
+		}
+
 		// 哈希不同，需要替换
 		log.Printf("🔧 【家规】Replacing block #%d: local hash %s -> big brother's hash %s",
 			block.Header.Height, localBlock.Hash().String()[:16], block.Hash().String()[:16])
@@ -171,28 +193,28 @@ func (n *Node) InitializeP2P() error {
 		}
 
 		// 使用VRF选择该高度的合法proposer
-		proposer, err := n.consensus.SelectProposer(height, prevBlock.Hash())
+		proposer, err := n.consensus.SelectProposer(height, prevBlock)
 		if err != nil {
 			return "", err
 		}
 		return proposer, nil
 	})
 
+	// 设置VRF证明验证函数：ECVRF激活后出块者必须用链上登记的公钥证明
+	n.p2pServer.SetVerifyBlockVRF(func(block, prevBlock *core.Block) error {
+		return n.consensus.VerifyProposer(block, prevBlock)
+	})
+
+	// 设置出块者名次函数：时间槽已开启的备用出块者区块同样合法
+	n.p2pServer.SetProposerRank(func(block, prevBlock *core.Block) int {
+		return n.consensus.ScheduledRank(block, prevBlock)
+	})
+
 	// 设置链重组函数用于自动修复分�?
 	n.p2pServer.SetPerformReorg(func(rollbackHeight uint64, correctBlock *core.Block) error {
 		return n.PerformChainReorganization(rollbackHeight, correctBlock)
 	})
 
-	// 设置获取proposer质押的函数（用于质押权重链选择�?
-	n.p2pServer.SetGetProposerStake(func(address string) uint64 {
-		acc, err := n.state.GetAccount(address)
-		if err != nil {
-			log.Printf("�?Failed to get stake for %s: %v", address[:10], err)
-			return 0
-		}
-		return acc.StakedBalance
-	})
-
 	// 设置获取最新N个checkpoint的函�?
 	n.p2pServer.SetGetLatestCheckpoints(func(count int) []network.CheckpointInfo {
 		return n.getLatestCheckpoints(count)
@@ -212,7 +234,7 @@ func (n *Node) InitializeP2P() error {
 		return n.applyCheckpoint(checkpoint)
 	})
 
-	// 设置分叉检测和解决函数（谁快认谁做大哥）
+	// 设置分叉检测函数（只发现冲突，跟随与否由验证者多签确认的checkpoint决定）
 	n.p2pServer.SetDetectAndResolveFork(func(peerHeight uint64, peerBlockHash string, peerCheckpointHeight uint64, peerCheckpointHash string, peerCheckpointTimestamp int64) error {
 		return n.DetectAndResolveFork(peerHeight, peerBlockHash, peerCheckpointHeight, peerCheckpointHash, peerCheckpointTimestamp)
 	})
@@ -227,11 +249,32 @@ func (n *Node) InitializeP2P() error {
 		return n.applyStateSnapshot(height, data)
 	})
 
+	// 设置分片状态同步：已验证分片持久化到数据库（重启后继续），全部到齐后导入
+	n.p2pServer.SetStateChunkStore(n.db)
+	n.p2pServer.SetGetStateChunks(func(height uint64) ([]*core.StateChunk, error) {
+		return n.getStateChunks(height)
+	})
+	n.p2pServer.SetGetStateRoot(func(height uint64) (core.Hash, error) {
+		return n.state.CalculateStateRoot(height)
+	})
+	n.p2pServer.SetApplyStateChunks(func(checkpoint *core.Checkpoint, accounts []*core.Account) error {
+		return n.applyStateChunks(checkpoint, accounts)
+	})
+
 	// 设置交易处理回调
 	n.p2pServer.SetHandleReceivedTransaction(func(tx *core.Transaction) error {
 		return n.HandleReceivedTransaction(tx)
 	})
 
+	// 设置双签证据处理回调：广播自己出的块时附带公钥，收到的证据校验后入池
+	n.p2pServer.SetNodePublicKey(n.publicKey)
+	n.p2pServer.SetHandleReceivedEvidence(func(evidence *core.DoubleSignEvidence) error {
+		return n.HandleEvidence(evidence)
+	})
+
+	// 设置checkpoint签名处理回调：验证者签名加入本地checkpoint，超过1/2质押后最终确认
+	n.p2pServer.SetHandleCheckpointSignature(n.HandleCheckpointSignature)
+
 	// 【P2协议】设置获取最早区块高度的回调
 	n.p2pServer.SetGetEarliestHeight(func() uint64 {
 		return n.db.GetEarliestHeight()
@@ -247,8 +290,6 @@ func (n *Node) InitializeP2P() error {
 		return fmt.Errorf("failed to start P2P server: %v", err)
 	}
 
-	n.consensus.SetOnlinePeersFunction(n.p2pServer.GetOnlinePeerAddresses)
-
 	return nil
 }
 
@@ -456,15 +497,8 @@ func (n *Node) getLatestCheckpoints(count int) []network.CheckpointInfo {
 			continue
 		}
 
-		// 检查是否有对应的状态文件（使用latest文件名，因为采用单点设计�?
-		stateFile := fmt.Sprintf("%s/checkpoints/state_latest.dat.gz", n.config.DataDir)
-		hasState := false
-		var compressedSize uint64 = 0
-
-		if fileInfo, err := os.Stat(stateFile); err == nil {
-			hasState = true
-			compressedSize = uint64(fileInfo.Size())
-		}
+		// 检查是否保存了该高度的状态快照
+		compressedSize, hasState := n.db.StateSnapshotSize(height, n.config.DataDir)
 
 		result = append(result, network.CheckpointInfo{
 			Checkpoint:     checkpoint,
@@ -500,30 +534,22 @@ func (n *Node) applyCheckpoint(checkpoint *core.Checkpoint) error {
 	}
 
 	// 【分叉处理】如果高度相同但hash不同，说明发生了分叉
-	// 按毫秒级时间戳裁决：时间戳小的赢（先出块的是大哥）
+	// 分叉选择：只跟随获得验证者多签确认的checkpoint，且不回滚已最终确认的checkpoint
 	if checkpoint.Height == localCheckpointHeight && localCheckpointHeight > 0 {
 		if checkpoint.BlockHash != localCheckpointHash {
-			// 获取本地区块的时间戳
-			localBlock := n.chain.GetLatestBlock()
-			localTimestamp := localBlock.Header.Timestamp
-			peerTimestamp := checkpoint.Timestamp
-
 			log.Printf("⚠️  FORK DETECTED at height %d: local hash=%x, network hash=%x",
 				checkpoint.Height, localCheckpointHash.Bytes()[:8], checkpoint.BlockHash.Bytes()[:8])
-			log.Printf("⏱️  Timestamp comparison: local=%d, peer=%d", localTimestamp, peerTimestamp)
 
-			if peerTimestamp < localTimestamp {
-				// 对方时间戳更小（先出块），我应该回滚追随对方
-				log.Printf("🔄 Peer wins (earlier timestamp), I will reorg to follow peer's chain")
-				// 继续处理，强制使用网络的checkpoint
-			} else {
-				// 我的时间戳更小或相等，保持自己的链，拒绝对方
-				log.Printf("✓ I win (earlier or equal timestamp), rejecting peer's checkpoint")
+			if err := n.ResolveForkWithCheckpoint(checkpoint); err != nil {
+				log.Printf("✓ Keeping local chain, rejecting peer's checkpoint: %v", err)
 				return nil
 			}
+			log.Printf("🔄 Peer's checkpoint is final, reorged to follow peer's chain")
+			// 继续处理，使用网络的checkpoint
 		} else {
-			// 相同高度相同hash，无需更新
+			// 相同高度相同hash，无需更新，只合并对方已收集的签名
 			log.Printf("✓ Checkpoint at height %d already up to date (same hash)", checkpoint.Height)
+			n.mergeCheckpointSignatures(checkpoint)
 			return nil
 		}
 	} else if checkpoint.Height < localCheckpointHeight {
@@ -536,9 +562,20 @@ func (n *Node) applyCheckpoint(checkpoint *core.Checkpoint) error {
 	log.Printf("✓ Checkpoint height check passed: %d >= local checkpoint %d (block height: %d)",
 		checkpoint.Height, localCheckpointHeight, localHeight)
 
-	// TODO: 验证checkpoint签名需要proposer的公�?
-	// 暂时跳过验证，因为新节点还没有完整的账户公钥信息
-	// 在生产环境中，应该通过硬编码的验证者公钥列表验�?
+	// 【多签共识】本节点已执行到该高度且区块哈希、状态根、验证者快照一致时直接接受并签名；
+	// 否则（新节点同步、分叉切换）必须已有超过1/2质押的验证者签名，防止任意peer推送伪造的StateRoot
+	locallyVerified := n.verifyCheckpointLocally(checkpoint)
+	if !locallyVerified {
+		if err := checkpoint.VerifyQuorum(n.trustedCheckpointValidators(checkpoint)); err != nil {
+			return fmt.Errorf("checkpoint #%d not final: %v", checkpoint.Height, err)
+		}
+	}
+
+	// 签名者是产出该区块时的验证者集合，必须在恢复checkpoint的验证者集合之前签名
+	var ownSig *core.CheckpointSignature
+	if locallyVerified {
+		ownSig = n.addOwnCheckpointSignature(checkpoint)
+	}
 
 	// 恢复验证者集合（确保VRF计算一致性）
 	if len(checkpoint.Validators) > 0 {
@@ -548,9 +585,12 @@ func (n *Node) applyCheckpoint(checkpoint *core.Checkpoint) error {
 	}
 
 	// 保存checkpoint到本�?
-	if err := n.db.SaveCheckpoint(checkpoint, n.config.DataDir); err != nil {
+	if err := n.saveCheckpoint(checkpoint); err != nil {
 		return fmt.Errorf("failed to save checkpoint: %v", err)
 	}
+	if ownSig != nil && n.p2pServer != nil {
+		n.p2pServer.BroadcastCheckpointSignature(checkpoint.Height, checkpoint.Hash(), ownSig)
+	}
 
 	// 【Ephemeral核心修复】先设置高度，再尝试获取真实区块
 	// 这确保即使没有真实区块，节点高度也能正确设置为checkpoint高度
@@ -565,6 +605,7 @@ func (n *Node) applyCheckpoint(checkpoint *core.Checkpoint) error {
 				StateRoot:    checkpoint.StateRoot,
 				PreviousHash: checkpoint.PreviousHash,
 				Proposer:     checkpoint.Proposer,
+				VRFOutput:    checkpoint.VRFOutput,
 			},
 			Transactions: []*core.Transaction{},
 		}
@@ -636,22 +677,26 @@ func (n *Node) applyCheckpoint(checkpoint *core.Checkpoint) error {
 
 // getStateSnapshot 获取指定高度的状态快�?
 func (n *Node) getStateSnapshot(height uint64) ([]byte, error) {
-	// 使用latest文件名，因为采用单点设计
-	stateFile := fmt.Sprintf("%s/checkpoints/state_latest.dat.gz", n.config.DataDir)
+	// 快照文件即压缩后的传输格式，直接发送
+	return n.db.LoadStateSnapshot(height, n.config.DataDir)
+}
 
-	// 读取压缩的状态文�?
-	snapshot, err := state.LoadCheckpointSnapshotFromFile(stateFile)
+// getStateChunks 把本地checkpoint状态快照分成分片（快照高度必须与请求一致）
+func (n *Node) getStateChunks(height uint64) ([]*core.StateChunk, error) {
+	data, err := n.db.LoadStateSnapshot(height, n.config.DataDir)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load snapshot file: %v", err)
+		return nil, err
 	}
-
-	// 序列化为字节传输
-	data, err := snapshot.Serialize()
+	snapshot, err := state.DeserializeCheckpointSnapshot(data)
 	if err != nil {
-		return nil, fmt.Errorf("failed to serialize snapshot: %v", err)
+		return nil, fmt.Errorf("failed to deserialize snapshot: %v", err)
+	}
+	if snapshot.Height != height {
+		return nil, fmt.Errorf("snapshot at height %d, requested %d", snapshot.Height, height)
 	}
 
-	return data, nil
+	_, chunks := core.BuildStateChunks(snapshot.Accounts)
+	return chunks, nil
 }
 
 // applyStateSnapshot 应用状态快�?
@@ -664,20 +709,86 @@ func (n *Node) applyStateSnapshot(height uint64, compressedData []byte) error {
 		return fmt.Errorf("failed to deserialize snapshot: %v", err)
 	}
 
-	// 应用快照到状态管理器
-	if err := n.state.ApplyCheckpointSnapshot(snapshot); err != nil {
-		return fmt.Errorf("failed to apply snapshot: %v", err)
+	if snapshot.Height != height {
+		return fmt.Errorf("snapshot at height %d, expected %d", snapshot.Height, height)
 	}
 
-	// 保存状态快照到本地（同时保存到 state_{height}.dat.gz 和 state_latest.dat.gz）
-	stateFile := fmt.Sprintf("%s/checkpoints/state_%d.dat.gz", n.config.DataDir, height)
-	if err := snapshot.SaveToFile(stateFile); err != nil {
-		log.Printf("Warning: failed to save state snapshot locally: %v", err)
+	// 快照必须对应本地已保存且获得验证者多签的checkpoint，导入后的状态根须与其StateRoot一致
+	checkpoint, err := n.db.GetLatestCheckpoint(n.config.DataDir)
+	if err != nil || checkpoint == nil || checkpoint.Height != height {
+		return fmt.Errorf("no local checkpoint at height %d to verify snapshot against", height)
 	}
-	// 【修复】同时保存到 state_latest.dat.gz，确保后续 getLatestCheckpoints 能正确识别 HasStateData
-	latestStateFile := fmt.Sprintf("%s/checkpoints/state_latest.dat.gz", n.config.DataDir)
-	if err := snapshot.SaveToFile(latestStateFile); err != nil {
-		log.Printf("Warning: failed to save state_latest.dat.gz: %v", err)
+	if err := checkpoint.VerifyQuorum(n.trustedCheckpointValidators(checkpoint)); err != nil {
+		return fmt.Errorf("checkpoint #%d not final: %v", height, err)
+	}
+
+	if err := n.state.ImportSnapshot(height, snapshot.Accounts, checkpoint.StateRoot); err != nil {
+		return fmt.Errorf("failed to import snapshot: %v", err)
+	}
+
+	return n.finishStateSnapshot(snapshot)
+}
+
+// migrateLegacyStateSnapshot 将单点设计遗留的state_latest.dat.gz按快照记录的高度改名为state_<height>.dat.gz
+// 快照无法解析时删除
+func (n *Node) migrateLegacyStateSnapshot() {
+	data, err := n.db.LoadLegacyStateSnapshot(n.config.DataDir)
+	if err != nil {
+		log.Printf("⚠️  Failed to read legacy state snapshot: %v", err)
+		return
+	}
+	if data == nil {
+		return
+	}
+
+	var height uint64
+	if snapshot, err := state.DeserializeCheckpointSnapshot(data); err != nil {
+		log.Printf("⚠️  Legacy state snapshot is unreadable, removing it: %v", err)
+	} else {
+		height = snapshot.Height
+	}
+
+	if err := n.db.MigrateLegacyStateSnapshot(n.config.DataDir, height); err != nil {
+		log.Printf("⚠️  Failed to migrate legacy state snapshot: %v", err)
+		return
+	}
+	if height > 0 {
+		log.Printf("📥 Migrated legacy state snapshot to height %d", height)
+	}
+}
+
+// saveStateSnapshot 保存指定高度的压缩状态快照，只保留最新的CheckpointKeepCount个
+func (n *Node) saveStateSnapshot(height uint64, compressedData []byte) error {
+	if err := n.db.SaveStateSnapshot(height, compressedData, n.config.DataDir); err != nil {
+		return err
+	}
+	keepCount := core.GetConsensusConfig().BlockParams.CheckpointKeepCount
+	if err := n.db.CleanOldCheckpoints(n.config.DataDir, keepCount); err != nil {
+		log.Printf("Warning: failed to clean old state snapshots: %v", err)
+	}
+	return nil
+}
+
+// applyStateChunks 应用分片同步得到的状态（全部分片已按checkpoint的StateRoot验证）
+func (n *Node) applyStateChunks(checkpoint *core.Checkpoint, accounts []*core.Account) error {
+	log.Printf("Applying chunked state at height %d (%d accounts)", checkpoint.Height, len(accounts))
+
+	if err := n.state.ImportSnapshot(checkpoint.Height, accounts, checkpoint.StateRoot); err != nil {
+		return fmt.Errorf("failed to import snapshot: %v", err)
+	}
+
+	return n.finishStateSnapshot(&state.CheckpointSnapshot{Height: checkpoint.Height, Accounts: accounts})
+}
+
+// finishStateSnapshot 状态快照应用后保存到本地，并补上checkpoint区块
+func (n *Node) finishStateSnapshot(snapshot *state.CheckpointSnapshot) error {
+	height := snapshot.Height
+
+	// 保存状态快照到本地（按高度保留，之后可以向其他节点提供、回滚时使用）
+	if data, err := snapshot.Compress(); err != nil {
+		log.Printf("Warning: failed to compress state snapshot: %v", err)
+	} else if err := n.saveStateSnapshot(height, data); err != nil {
+		log.Printf("Warning: failed to save state snapshot locally: %v", err)
 	}
 
 	log.Printf("�?State snapshot applied: %d accounts at height %d", len(snapshot.Accounts), height)
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -9,6 +10,7 @@ import (
 	"time"
 
 	"fan-chain/core"
+	"fan-chain/crypto"
 )
 
 // 【P6协议】小弟启动即跟随
@@ -26,6 +28,7 @@ func (n *Node) RequestValidatorActivation() error {
 		n.validatorActivated = true
 		n.syncedHeight = n.chain.GetLatestHeight()
 		log.Printf("✓ Genesis validator activated at height %d", n.syncedHeight)
+		n.ensureVRFKeyRegistered()
 		return nil
 	}
 
@@ -161,10 +164,51 @@ func (n *Node) RequestValidatorActivation() error {
 	n.validatorActivated = true
 	n.syncedHeight = myHeight
 	log.Printf("✓ 【P6】小弟就位! 高度=%d (大哥=%d)，准备竞争出块", n.syncedHeight, bestPeerHeight)
+	n.ensureVRFKeyRegistered()
 
 	return nil
 }
 
+// ensureVRFKeyRegistered 链上未登记本节点VRF公钥时，提交金额为0的质押交易登记
+// ECVRF激活后未登记公钥的验证者出的块会被其他节点拒绝
+func (n *Node) ensureVRFKeyRegistered() {
+	vrfKey := n.consensus.VRFPublicKey()
+	if len(vrfKey) == 0 {
+		return
+	}
+
+	account, err := n.state.GetAccount(n.address)
+	if err != nil {
+		log.Printf("⚠️ VRF登记检查失败: %v", err)
+		return
+	}
+	if bytes.Equal(account.VRFPublicKey, vrfKey) {
+		return
+	}
+
+	tx := &core.Transaction{
+		Type:      core.TxStake,
+		From:      n.address,
+		To:        n.address, // 质押交易to=from
+		Nonce:     account.Nonce,
+		Timestamp: core.CurrentTimestamp(),
+		Data:      core.VRFRegistrationData(vrfKey),
+		PublicKey: n.publicKey,
+	}
+	signature, err := crypto.Sign(n.privateKey, tx.SignData())
+	if err != nil {
+		log.Printf("⚠️ VRF登记交易签名失败: %v", err)
+		return
+	}
+	tx.Signature = signature
+
+	if err := n.SubmitTransaction(tx); err != nil {
+		log.Printf("⚠️ VRF登记交易提交失败: %v", err)
+		return
+	}
+	log.Printf("🎲 已提交VRF公钥登记交易: %x", vrfKey[:8])
+}
+
 // 【P6.10】回退到指定高度（删除分叉区块）
 func (n *Node) rollbackToHeight(targetHeight uint64) error {
 	currentHeight := n.chain.GetLatestHeight()
@@ -0,0 +1,459 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+
+	"fan-chain/config"
+	"fan-chain/core"
+	"fan-chain/state"
+	"fan-chain/storage"
+)
+
+// replay 子命令：用本地保存的区块从创世（或某个checkpoint状态快照）重新执行，推导出的状态逐块与链上记录比对
+// 比对项：区块链接、区块头StateRoot（StateRootActive起为执行后的状态根）、交易回执的账户变更、
+// 各checkpoint的状态根（最新checkpoint和保留的状态快照），重放到节点当前状态高度时还与本地状态比对
+// 状态写入独立的临时数据库，不修改节点数据；LevelDB是独占锁，需要先停止节点
+//
+// 用法: fan-chain replay [-config config.json] [-from 快照高度] [-to 高度] [-workdir 目录] [-verbose]
+
+// replayDivergence 重放结果与链上记录不一致
+type replayDivergence struct {
+	Height  uint64
+	Reason  string
+	Address string // 第一个不一致的账户（能定位时）
+	Got     string // 重放得到的值
+	Want    string // 链上记录的值
+}
+
+func (d *replayDivergence) Error() string {
+	msg := fmt.Sprintf("divergence at height %d: %s", d.Height, d.Reason)
+	if d.Address != "" {
+		msg += fmt.Sprintf("\n   account:  %s\n   replayed: %s\n   recorded: %s", d.Address, d.Got, d.Want)
+	}
+	return msg
+}
+
+// replayer 重放状态
+type replayer struct {
+	cfg     *config.Config
+	src     *storage.Database   // 节点数据库（只读使用）
+	scratch *storage.Database   // 重放状态写入的临时数据库
+	state   *state.StateManager // 基于临时数据库的状态机
+
+	checkpoint *core.Checkpoint // 本地最新checkpoint
+	snapshots  map[uint64]bool  // 保留了状态快照的高度
+
+	prevHash core.Hash // 上一个区块的哈希（havePrev为false时不检查链接）
+	havePrev bool
+
+	out *log.Logger
+}
+
+// runReplay 执行replay子命令，返回进程退出码：0一致，1发现不一致，2无法完成重放
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	configPath := fs.String("config", "", "Path to config file")
+	from := fs.Uint64("from", 0, "Start from the state snapshot at this checkpoint height (0 = genesis)")
+	to := fs.Uint64("to", 0, "Last height to replay (0 = latest stored block)")
+	workDir := fs.String("workdir", "", "Directory for the replayed state (default: temporary, removed afterwards)")
+	verbose := fs.Bool("verbose", false, "Show state machine logs while replaying")
+	fs.Parse(args)
+
+	out := log.New(os.Stderr, "", log.LstdFlags)
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		out.Printf("❌ %v", err)
+		return 2
+	}
+
+	src, err := storage.OpenDatabase(cfg.DBPath())
+	if err != nil {
+		out.Printf("❌ Failed to open node database (is the node still running?): %v", err)
+		return 2
+	}
+	defer src.Close()
+
+	dir := *workDir
+	if dir == "" {
+		dir, err = os.MkdirTemp("", "fan-replay-")
+		if err != nil {
+			out.Printf("❌ Failed to create work directory: %v", err)
+			return 2
+		}
+		defer os.RemoveAll(dir)
+	}
+	scratch, err := storage.OpenDatabase(dir)
+	if err != nil {
+		out.Printf("❌ Failed to open replay database: %v", err)
+		return 2
+	}
+	defer scratch.Close()
+
+	r := &replayer{
+		cfg:       cfg,
+		src:       src,
+		scratch:   scratch,
+		state:     state.NewStateManager(scratch),
+		snapshots: make(map[uint64]bool),
+		out:       out,
+	}
+	if err := r.run(*from, *to); err != nil {
+		if d, ok := err.(*replayDivergence); ok {
+			out.Printf("🚨 %v", d)
+			return 1
+		}
+		out.Printf("❌ Replay failed: %v", err)
+		return 2
+	}
+	return 0
+}
+
+// run 从from重放到to
+func (r *replayer) run(from, to uint64) error {
+	checkpoint, err := r.src.GetLatestCheckpoint(r.cfg.DataDir)
+	if err != nil {
+		return fmt.Errorf("failed to load checkpoint: %v", err)
+	}
+	r.checkpoint = checkpoint
+
+	heights, err := r.src.ListStateSnapshots(r.cfg.DataDir)
+	if err != nil {
+		return fmt.Errorf("failed to list state snapshots: %v", err)
+	}
+	for _, h := range heights {
+		r.snapshots[h] = true
+	}
+
+	if to == 0 {
+		if to, err = r.src.GetLatestHeight(); err != nil {
+			return fmt.Errorf("failed to get latest height: %v", err)
+		}
+	}
+	if to < from {
+		return fmt.Errorf("nothing to replay: -to %d is below -from %d", to, from)
+	}
+
+	if from == 0 {
+		if err := r.startFromGenesis(); err != nil {
+			return err
+		}
+	} else if err := r.startFromSnapshot(from); err != nil {
+		return err
+	}
+	r.out.Printf("🔄 Replaying blocks %d-%d", from+1, to)
+
+	for height := from + 1; height <= to; height++ {
+		if err := r.replayBlock(height); err != nil {
+			return err
+		}
+		if height%1000 == 0 {
+			r.out.Printf("  ✓ Height %d", height)
+		}
+	}
+
+	if err := r.verifyLiveState(to); err != nil {
+		return err
+	}
+	r.out.Printf("✅ Replayed to height %d, state root %s", to, r.scratch.StateRoot().String())
+	return nil
+}
+
+// startFromGenesis 执行创世区块（创世交易给创世地址分配全部代币）
+// 节点不保存创世区块，第一个区块的PreviousHash无从比对
+func (r *replayer) startFromGenesis() error {
+	if _, err := r.src.GetBlockByHeight(1); err != nil {
+		return fmt.Errorf("block 1 is not stored (pruned?), replay from a state snapshot with -from (available: %v)", r.snapshotHeights())
+	}
+
+	if _, err := r.state.ExecuteBlock(core.CreateGenesisBlock(), true); err != nil {
+		return fmt.Errorf("failed to execute genesis block: %v", err)
+	}
+	if err := r.state.CommitWithP0Verify(0); err != nil {
+		return fmt.Errorf("failed to commit genesis state: %v", err)
+	}
+	r.out.Printf("📦 Starting from genesis")
+	return nil
+}
+
+// startFromSnapshot 导入checkpoint状态快照作为起点
+// 快照高度是最新checkpoint时用其StateRoot验证，否则快照只能自证（状态根由账户计算）
+func (r *replayer) startFromSnapshot(height uint64) error {
+	accounts, err := r.loadSnapshot(height)
+	if err != nil {
+		return fmt.Errorf("%v (available: %v)", err, r.snapshotHeights())
+	}
+
+	expectedRoot := core.StateRootAt(height, accounts)
+	if r.checkpoint != nil && r.checkpoint.Height == height {
+		expectedRoot = r.checkpoint.StateRoot
+		r.prevHash, r.havePrev = r.checkpoint.BlockHash, true
+	} else if block, err := r.src.GetBlockByHeight(height); err == nil {
+		r.prevHash, r.havePrev = block.Hash(), true
+	}
+
+	if err := r.state.ImportSnapshot(height, accounts, expectedRoot); err != nil {
+		return &replayDivergence{Height: height, Reason: fmt.Sprintf("state snapshot does not match checkpoint: %v", err)}
+	}
+	r.out.Printf("📦 Starting from state snapshot at height %d (%d accounts)", height, len(accounts))
+	return nil
+}
+
+// replayBlock 执行并验证一个区块
+func (r *replayer) replayBlock(height uint64) error {
+	block, err := r.src.GetBlockByHeight(height)
+	if err != nil {
+		return fmt.Errorf("block %d is not stored: %v", height, err)
+	}
+
+	if r.havePrev && block.Header.PreviousHash != r.prevHash {
+		return &replayDivergence{
+			Height: height,
+			Reason: fmt.Sprintf("block does not link to block %d: previous_hash=%s, expected %s",
+				height-1, block.Header.PreviousHash.String(), r.prevHash.String()),
+		}
+	}
+
+	receipts, err := r.state.ExecuteBlock(block, true)
+	if err != nil {
+		return &replayDivergence{Height: height, Reason: fmt.Sprintf("block failed to execute: %v", err)}
+	}
+	if err := r.state.CommitWithP0Verify(height); err != nil {
+		return &replayDivergence{Height: height, Reason: err.Error()}
+	}
+
+	if core.StateRootActive(height) {
+		root, err := r.state.CalculateStateRoot(height)
+		if err != nil {
+			return fmt.Errorf("failed to calculate state root at height %d: %v", height, err)
+		}
+		if root != block.Header.StateRoot {
+			d := &replayDivergence{
+				Height: height,
+				Reason: fmt.Sprintf("block state_root %s, replayed %s", block.Header.StateRoot.String(), root.String()),
+			}
+			// 回执能定位到具体账户时优先报告
+			if err := r.verifyReceipts(block, receipts); err != nil {
+				return err
+			}
+			return d
+		}
+	}
+
+	if err := r.verifyReceipts(block, receipts); err != nil {
+		return err
+	}
+	if err := r.verifyCheckpoint(height); err != nil {
+		return err
+	}
+
+	r.prevHash, r.havePrev = block.Hash(), true
+	return nil
+}
+
+// verifyReceipts 与节点保存的回执比对交易结果和账户变更（回执缺失的交易跳过）
+func (r *replayer) verifyReceipts(block *core.Block, receipts []*core.Receipt) error {
+	height := block.Header.Height
+	for i, got := range receipts {
+		want, err := r.src.GetReceipt(block.Transactions[i].Hash())
+		if err != nil {
+			continue
+		}
+
+		if got.Status != want.Status || got.ErrorCode != want.ErrorCode {
+			return &replayDivergence{
+				Height: height,
+				Reason: fmt.Sprintf("tx %d (%s) result differs", got.TxIndex, got.TxHash),
+				Got:    fmt.Sprintf("status=%s code=%s", got.StatusString(), got.ErrorCode),
+				Want:   fmt.Sprintf("status=%s code=%s", want.StatusString(), want.ErrorCode),
+			}
+		}
+
+		wantChanges := make(map[string]core.StateChange, len(want.StateChanges))
+		for _, c := range want.StateChanges {
+			wantChanges[c.Address] = c
+		}
+		for _, c := range got.StateChanges {
+			w, ok := wantChanges[c.Address]
+			if !ok || w != c {
+				return &replayDivergence{
+					Height:  height,
+					Reason:  fmt.Sprintf("tx %d (%s) changed account differently", got.TxIndex, got.TxHash),
+					Address: c.Address,
+					Got:     describeStateChange(&c),
+					Want:    describeStateChange(lookupChange(wantChanges, c.Address)),
+				}
+			}
+			delete(wantChanges, c.Address)
+		}
+		for _, w := range wantChanges {
+			return &replayDivergence{
+				Height:  height,
+				Reason:  fmt.Sprintf("tx %d (%s) changed account differently", got.TxIndex, got.TxHash),
+				Address: w.Address,
+				Got:     describeStateChange(nil),
+				Want:    describeStateChange(&w),
+			}
+		}
+	}
+	return nil
+}
+
+// verifyCheckpoint 该高度有checkpoint或状态快照时比对状态根，不一致时用快照定位第一个不一致的账户
+func (r *replayer) verifyCheckpoint(height uint64) error {
+	atCheckpoint := r.checkpoint != nil && r.checkpoint.Height == height
+	if !atCheckpoint && !r.snapshots[height] {
+		return nil
+	}
+	root, err := r.state.CalculateStateRoot(height)
+	if err != nil {
+		return fmt.Errorf("failed to calculate state root at height %d: %v", height, err)
+	}
+
+	if atCheckpoint && r.checkpoint.StateRoot != root {
+		d := &replayDivergence{
+			Height: height,
+			Reason: fmt.Sprintf("checkpoint state_root %s, replayed %s", r.checkpoint.StateRoot.String(), root.String()),
+		}
+		if r.snapshots[height] {
+			if accounts, err := r.loadSnapshot(height); err == nil {
+				r.locateAccount(d, accounts)
+			}
+		}
+		return d
+	}
+
+	if !r.snapshots[height] {
+		return nil
+	}
+	accounts, err := r.loadSnapshot(height)
+	if err != nil {
+		r.out.Printf("⚠️  Skipping state snapshot at height %d: %v", height, err)
+		return nil
+	}
+	if want := core.StateRootAt(height, accounts); want != root {
+		d := &replayDivergence{
+			Height: height,
+			Reason: fmt.Sprintf("state snapshot root %s, replayed %s", want.String(), root.String()),
+		}
+		r.locateAccount(d, accounts)
+		return d
+	}
+	r.out.Printf("  ✓ Checkpoint state at height %d matches", height)
+	return nil
+}
+
+// verifyLiveState 重放到节点的状态高度时与节点当前状态比对
+func (r *replayer) verifyLiveState(height uint64) error {
+	stateHeight, err := r.src.GetStateHeight()
+	if err != nil || stateHeight != height {
+		return nil
+	}
+
+	want, root := r.src.StateRoot(), r.scratch.StateRoot()
+	if want == root {
+		r.out.Printf("  ✓ Node state at height %d matches", height)
+		return nil
+	}
+
+	d := &replayDivergence{
+		Height: height,
+		Reason: fmt.Sprintf("node state root %s, replayed %s", want.String(), root.String()),
+	}
+	if accounts, err := r.src.GetAllAccounts(); err == nil {
+		r.locateAccount(d, accounts)
+	}
+	return d
+}
+
+// locateAccount 在重放状态与参考账户集合之间找出地址最小的不一致账户
+func (r *replayer) locateAccount(d *replayDivergence, want []*core.Account) {
+	got, err := r.scratch.GetAllAccounts()
+	if err != nil {
+		return
+	}
+
+	gotByAddr := make(map[string]*core.Account, len(got))
+	for _, acc := range got {
+		gotByAddr[acc.Address] = acc
+	}
+	wantByAddr := make(map[string]*core.Account, len(want))
+	for _, acc := range want {
+		wantByAddr[acc.Address] = acc
+	}
+
+	addresses := make([]string, 0, len(gotByAddr)+len(wantByAddr))
+	for addr := range gotByAddr {
+		addresses = append(addresses, addr)
+	}
+	for addr := range wantByAddr {
+		if _, ok := gotByAddr[addr]; !ok {
+			addresses = append(addresses, addr)
+		}
+	}
+	sort.Strings(addresses)
+
+	for _, addr := range addresses {
+		g, w := gotByAddr[addr], wantByAddr[addr]
+		if g != nil && w != nil && core.AccountLeafHash(g) == core.AccountLeafHash(w) {
+			continue
+		}
+		d.Address, d.Got, d.Want = addr, describeAccount(g), describeAccount(w)
+		return
+	}
+}
+
+// loadSnapshot 读取节点保存的状态快照
+func (r *replayer) loadSnapshot(height uint64) ([]*core.Account, error) {
+	data, err := r.src.LoadStateSnapshot(height, r.cfg.DataDir)
+	if err != nil {
+		return nil, fmt.Errorf("no state snapshot at height %d: %v", height, err)
+	}
+	snapshot, err := state.DeserializeCheckpointSnapshot(data)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt state snapshot at height %d: %v", height, err)
+	}
+	return snapshot.Accounts, nil
+}
+
+// snapshotHeights 保留了状态快照的高度（升序，用于提示-from的可选值）
+func (r *replayer) snapshotHeights() []uint64 {
+	heights := make([]uint64, 0, len(r.snapshots))
+	for h := range r.snapshots {
+		heights = append(heights, h)
+	}
+	sort.Slice(heights, func(i, j int) bool { return heights[i] < heights[j] })
+	return heights
+}
+
+func lookupChange(changes map[string]core.StateChange, address string) *core.StateChange {
+	if c, ok := changes[address]; ok {
+		return &c
+	}
+	return nil
+}
+
+func describeStateChange(c *core.StateChange) string {
+	if c == nil {
+		return "<not changed>"
+	}
+	return fmt.Sprintf("available %d->%d, staked %d->%d, nonce %d->%d",
+		c.AvailableBefore, c.AvailableAfter, c.StakedBefore, c.StakedAfter, c.NonceBefore, c.NonceAfter)
+}
+
+func describeAccount(acc *core.Account) string {
+	if acc == nil {
+		return "<absent>"
+	}
+	return fmt.Sprintf("available=%d staked=%d unbonding=%d nonce=%d locked_until=%d missed=%d jailed=%v slashed_height=%d",
+		acc.AvailableBalance, acc.StakedBalance, acc.UnbondingBalance, acc.Nonce,
+		acc.StakeLockedUntil, acc.MissedBlocks, acc.Jailed, acc.SlashedHeight)
+}
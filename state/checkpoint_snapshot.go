@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"os"
 
 	"fan-chain/core"
@@ -127,41 +126,6 @@ func LoadCheckpointSnapshotFromFile(filepath string) (*CheckpointSnapshot, error
 	return &snapshot, nil
 }
 
-// ApplyCheckpointSnapshot 应用快照到状态管理器
-func (sm *StateManager) ApplyCheckpointSnapshot(snapshot *CheckpointSnapshot) error {
-	// P0: 应用快照前验证总量
-	var totalSupply uint64
-	for _, acc := range snapshot.Accounts {
-		totalSupply += acc.AvailableBalance + acc.StakedBalance
-	}
-
-	expectedSupply := uint64(1400000000000000)
-	if totalSupply != expectedSupply {
-		log.Printf("🚨 P0违反：快照总量不正确！实际=%d，预期=%d", totalSupply, expectedSupply)
-		log.Printf("⛔ 拒绝同步，快照总量必须正确！")
-		return fmt.Errorf("snapshot total supply mismatch: got %d, expected %d", totalSupply, expectedSupply)
-	}
-	log.Printf("✅ P0验证通过：快照总量正确 = %d", totalSupply)
-
-	// 清空现有状态
-	if err := sm.db.ClearAllAccounts(); err != nil {
-		return fmt.Errorf("failed to clear accounts: %v", err)
-	}
-
-	// 导入所有账户
-	for _, acc := range snapshot.Accounts {
-		if err := sm.db.SaveAccount(acc); err != nil {
-			return fmt.Errorf("failed to save account %s: %v", acc.Address, err)
-		}
-	}
-
-	// 清空缓存，确保数据一致性
-	sm.accountCache = make(map[string]*core.Account)
-	sm.dirtyAccounts = make(map[string]bool)
-
-	return nil
-}
-
 // Serialize 序列化快照为字节（用于P2P传输）
 func (snapshot *CheckpointSnapshot) Serialize() ([]byte, error) {
 	data, err := json.Marshal(snapshot)
@@ -0,0 +1,135 @@
+package state
+
+import (
+	"fmt"
+
+	"fan-chain/core"
+)
+
+// ExecuteBlock 执行区块中的全部交易并生成回执
+// 任一交易执行失败返回*TxExecutionError（与逐笔ExecuteTransaction语义一致），调用方负责回滚状态
+func (sm *StateManager) ExecuteBlock(block *core.Block, skipTimestampCheck bool) ([]*core.Receipt, error) {
+	blockHash := block.Hash().String()
+	receipts := make([]*core.Receipt, 0, len(block.Transactions))
+
+	sm.blockHeight = block.Header.Height
+
+	if err := sm.applyLiveness(block.Header); err != nil {
+		return nil, fmt.Errorf("liveness accounting failed: %v", err)
+	}
+	if err := sm.releaseUnbonding(block.Header.Height); err != nil {
+		return nil, fmt.Errorf("unbonding release failed: %v", err)
+	}
+
+	for i, tx := range block.Transactions {
+		receipt, err := sm.ExecuteTransactionWithReceipt(tx, skipTimestampCheck)
+		if err != nil {
+			return nil, &TxExecutionError{Index: i, Tx: tx, Err: err}
+		}
+		receipt.BlockHeight = block.Header.Height
+		receipt.BlockHash = blockHash
+		receipt.TxIndex = i
+		receipts = append(receipts, receipt)
+	}
+
+	return receipts, nil
+}
+
+// TxExecutionError 区块内某笔交易执行失败，出块者据此剔除该交易
+type TxExecutionError struct {
+	Index int
+	Tx    *core.Transaction
+	Err   error
+}
+
+func (e *TxExecutionError) Error() string {
+	return fmt.Sprintf("tx %d (%s) failed: %v", e.Index, e.Tx.Hash().String()[:16], e.Err)
+}
+
+// ExecuteTransactionWithReceipt 执行交易并记录回执（状态、实际手续费、账户变更）
+func (sm *StateManager) ExecuteTransactionWithReceipt(tx *core.Transaction, skipTimestampCheck bool) (*core.Receipt, error) {
+	addresses := touchedAddresses(tx)
+	before := make(map[string]core.Account, len(addresses))
+	for _, addr := range addresses {
+		acc, err := sm.GetAccount(addr)
+		if err != nil {
+			return nil, err
+		}
+		before[addr] = *acc
+	}
+
+	sm.lastExecCode = core.ReceiptCodeOK
+	if err := sm.ExecuteTransaction(tx, skipTimestampCheck); err != nil {
+		return nil, err
+	}
+
+	receipt := &core.Receipt{
+		TxHash:       tx.Hash().String(),
+		Status:       core.ReceiptSuccess,
+		StateChanges: make([]core.StateChange, 0, len(addresses)),
+	}
+
+	switch sm.lastExecCode {
+	case core.ReceiptCodeOK:
+		if tx.Type == core.TxTransfer {
+			receipt.GasUsed = tx.GasFee
+		}
+	case core.ReceiptCodeForgedSignature:
+		receipt.Status = core.ReceiptFailed
+		receipt.ErrorCode = sm.lastExecCode
+		receipt.Error = "signature verification failed, sender funds confiscated"
+	case core.ReceiptCodeForgedAddress:
+		receipt.Status = core.ReceiptFailed
+		receipt.ErrorCode = sm.lastExecCode
+		receipt.Error = "public key does not match sender, signer funds confiscated"
+	}
+	sm.lastExecCode = core.ReceiptCodeOK
+
+	for _, addr := range addresses {
+		acc, err := sm.GetAccount(addr)
+		if err != nil {
+			return nil, err
+		}
+		prev := before[addr]
+		if prev.AvailableBalance == acc.AvailableBalance &&
+			prev.StakedBalance == acc.StakedBalance &&
+			prev.Nonce == acc.Nonce {
+			continue
+		}
+		receipt.StateChanges = append(receipt.StateChanges, core.StateChange{
+			Address:         addr,
+			AvailableBefore: prev.AvailableBalance,
+			AvailableAfter:  acc.AvailableBalance,
+			StakedBefore:    prev.StakedBalance,
+			StakedAfter:     acc.StakedBalance,
+			NonceBefore:     prev.Nonce,
+			NonceAfter:      acc.Nonce,
+		})
+	}
+
+	return receipt, nil
+}
+
+// touchedAddresses 交易可能修改的账户（发送者、接收者、创世地址、公钥对应的真实地址）
+func touchedAddresses(tx *core.Transaction) []string {
+	seen := make(map[string]bool)
+	addresses := make([]string, 0, 4)
+	add := func(addr string) {
+		if addr == "" || seen[addr] {
+			return
+		}
+		seen[addr] = true
+		addresses = append(addresses, addr)
+	}
+
+	add(tx.From)
+	add(tx.To)
+	add(core.GenesisAddress)
+	if len(tx.PublicKey) > 0 {
+		if derived, err := core.AddressFromPublicKey(tx.PublicKey); err == nil {
+			add(derived)
+		}
+	}
+
+	return addresses
+}
@@ -3,6 +3,7 @@ package state
 import (
 	"fmt"
 	"log"
+	"sort"
 
 	"fan-chain/core"
 	"fan-chain/crypto"
@@ -26,6 +27,16 @@ type StateManager struct {
 	// 验证者变更回调：当质押状态变化导致验证者集合变更时调用
 	onValidatorAdded   func(address string, stakedAmount uint64)
 	onValidatorRemoved func(address string)
+
+	// 最近一次执行交易的回执错误码（伪造签名/地址被没收时设置，交易本身返回nil）
+	lastExecCode string
+
+	// 正在执行的区块高度（解押到期高度按此计算）
+	blockHeight uint64
+
+	// 有解绑余额的账户（由账户状态推导，首次使用时从数据库重建；只增不减，提交后剔除已释放的账户）
+	unbondingAccounts map[string]bool
+	unbondingLoaded   bool
 }
 
 // 创建状态管理器
@@ -64,7 +75,7 @@ func (sm *StateManager) InitializeTotalSupplyTracker() error {
 
 	var totalSupply uint64
 	for _, acc := range accounts {
-		totalSupply += acc.AvailableBalance + acc.StakedBalance
+		totalSupply += acc.TotalBalance()
 	}
 
 	// 验证初始总量是否正确
@@ -126,16 +137,13 @@ func (sm *StateManager) Commit() error {
 	}
 
 	// 收集所有脏账户
-	accounts := make([]*core.Account, 0, len(sm.dirtyAccounts))
-	for address := range sm.dirtyAccounts {
-		acc := sm.accountCache[address]
-		accounts = append(accounts, acc)
-	}
+	accounts := sm.dirtyAccountList()
 
 	// 使用 WriteBatch 原子写入所有账户
 	if err := sm.db.SaveAccountsBatch(accounts); err != nil {
 		return fmt.Errorf("failed to batch save accounts: %v", err)
 	}
+	sm.pruneUnbondingAccounts(accounts)
 
 	// 清空脏标记
 	sm.dirtyAccounts = make(map[string]bool)
@@ -148,26 +156,11 @@ func (sm *StateManager) Commit() error {
 // 返回: error（如果P0验证失败或写入失败）
 func (sm *StateManager) CommitWithP0Verify(height uint64) error {
 	// 【P0验证】在写入前计算新的总量
-	// 合并数据库账户和缓存账户
-	dbAccounts, err := sm.db.GetAllAccounts()
+	// 状态树增量维护已提交总量，只需叠加脏账户的变化：O(变更账户数)
+	accounts := sm.dirtyAccountList()
+	_, totalSupply, err := sm.db.PreviewState(accounts)
 	if err != nil {
-		return fmt.Errorf("P0验证失败: 无法获取数据库账户: %v", err)
-	}
-
-	// 构建合并的账户映射
-	accountMap := make(map[string]*core.Account)
-	for _, acc := range dbAccounts {
-		accountMap[acc.Address] = acc
-	}
-	// 用缓存（脏账户）覆盖
-	for addr, acc := range sm.accountCache {
-		accountMap[addr] = acc
-	}
-
-	// 计算总量
-	var totalSupply uint64
-	for _, acc := range accountMap {
-		totalSupply += acc.AvailableBalance + acc.StakedBalance
+		return fmt.Errorf("P0验证失败: 无法计算总量: %v", err)
 	}
 
 	// 验证 P0
@@ -188,18 +181,13 @@ func (sm *StateManager) CommitWithP0Verify(height uint64) error {
 			totalSupply, TOTAL_SUPPLY, int64(totalSupply)-int64(TOTAL_SUPPLY))
 	}
 
-	// P0验证通过，收集脏账户
-	accounts := make([]*core.Account, 0, len(sm.dirtyAccounts))
-	for address := range sm.dirtyAccounts {
-		acc := sm.accountCache[address]
-		accounts = append(accounts, acc)
-	}
-
 	// 【原子性】写入账户并更新state高度
 	if err := sm.db.SaveAccountsBatchWithHeight(accounts, height); err != nil {
 		return fmt.Errorf("failed to batch save accounts with height: %v", err)
 	}
 
+	sm.pruneUnbondingAccounts(accounts)
+
 	// 更新追踪器
 	sm.totalSupplyTracker = totalSupply
 
@@ -226,8 +214,8 @@ func (sm *StateManager) confiscateAllFunds(attackerAddr string, reason string) e
 		return err
 	}
 
-	// 计算所有资产（可用余额 + 抵押余额）
-	totalFunds := attacker.AvailableBalance + attacker.StakedBalance
+	// 计算所有资产（可用余额 + 抵押余额 + 解绑余额）
+	totalFunds := attacker.TotalBalance()
 
 	if totalFunds == 0 {
 		log.Printf("攻击者 %s 账户余额为0，无需没收", attackerAddr)
@@ -244,6 +232,8 @@ func (sm *StateManager) confiscateAllFunds(attackerAddr string, reason string) e
 	genesis.AddBalance(totalFunds)
 	attacker.AvailableBalance = 0
 	attacker.StakedBalance = 0
+	attacker.UnbondingBalance = 0
+	attacker.StakeLockedUntil = 0
 	attacker.NodeType = core.NodeRegular // 降级为普通节点
 
 	sm.UpdateAccount(attacker)
@@ -296,6 +286,7 @@ func (sm *StateManager) ExecuteTransaction(tx *core.Transaction, skipTimestampCh
 		log.Printf("     PublicKey长度=%d, SignData长度=%d, Signature长度=%d",
 			len(tx.PublicKey), len(signData), len(tx.Signature))
 		// 伪造签名是严重攻击，没收所有资金
+		sm.lastExecCode = core.ReceiptCodeForgedSignature
 		if err := sm.confiscateAllFunds(tx.From, "伪造交易签名"); err != nil {
 			log.Printf("❌ 没收资金失败: %v，返回错误停止区块处理", err)
 			return fmt.Errorf("signature verification failed and punishment failed: %v", err)
@@ -314,6 +305,7 @@ func (sm *StateManager) ExecuteTransaction(tx *core.Transaction, skipTimestampCh
 	if derivedAddress != tx.From {
 		log.Printf("🚨 检测到地址伪造！Claimed: %s, Actual: %s", tx.From, derivedAddress)
 		// 地址伪造是严重攻击，没收真实地址的所有资金
+		sm.lastExecCode = core.ReceiptCodeForgedAddress
 		if err := sm.confiscateAllFunds(derivedAddress, fmt.Sprintf("伪造发送者地址 (claimed=%s)", tx.From)); err != nil {
 			log.Printf("❌ 没收资金失败: %v，返回错误停止区块处理", err)
 			return fmt.Errorf("address forgery detected and punishment failed: %v", err)
@@ -365,6 +357,8 @@ func (sm *StateManager) ExecuteTransaction(tx *core.Transaction, skipTimestampCh
 		return sm.executeStake(tx)
 	case core.TxUnstake:
 		return sm.executeUnstake(tx)
+	case core.TxUnjail:
+		return sm.executeUnjail(tx)
 	default:
 		return fmt.Errorf("unknown transaction type: %d", tx.Type)
 	}
@@ -420,8 +414,20 @@ func (sm *StateManager) executeStake(tx *core.Transaction) error {
 	// 记录质押前的状态
 	wasValidator := account.IsValidator()
 
-	if err := account.Stake(tx.Amount); err != nil {
-		return err
+	// VRF公钥登记（金额为0时只登记公钥）
+	vrfKey, registersVRF := tx.VRFRegistrationKey()
+	if registersVRF {
+		if err := crypto.ValidateVRFPublicKey(vrfKey); err != nil {
+			return fmt.Errorf("invalid VRF registration: %v", err)
+		}
+		account.VRFPublicKey = append([]byte{}, vrfKey...)
+		log.Printf("🎲 VRF公钥已登记: %s (%x)", tx.From, vrfKey[:8])
+	}
+
+	if !registersVRF || tx.Amount > 0 {
+		if err := account.Stake(tx.Amount); err != nil {
+			return err
+		}
 	}
 
 	account.Nonce++
@@ -447,7 +453,19 @@ func (sm *StateManager) executeUnstake(tx *core.Transaction) error {
 	// 记录解押前的状态
 	wasValidator := account.IsValidator()
 
-	if err := account.Unstake(tx.Amount); err != nil {
+	if core.UnbondingActive(sm.blockHeight) {
+		// 解绑期内质押仍可被罚没，到期后由releaseUnbonding释放到可用余额
+		if err := sm.loadUnbondingAccounts(); err != nil {
+			return err
+		}
+		matureHeight := sm.blockHeight + core.UnbondingBlocks()
+		if err := account.BeginUnbonding(tx.Amount, matureHeight); err != nil {
+			return err
+		}
+		sm.unbondingAccounts[tx.From] = true
+		log.Printf("⏳ 解押进入解绑期: %s (%.6f FAN，高度 %d 到账)",
+			tx.From, float64(tx.Amount)/1000000.0, matureHeight)
+	} else if err := account.Unstake(tx.Amount); err != nil {
 		return err
 	}
 
@@ -464,6 +482,142 @@ func (sm *StateManager) executeUnstake(tx *core.Transaction) error {
 	return nil
 }
 
+// releaseUnbonding 释放到期的解绑余额到可用余额
+// 在执行区块交易之前调用，只依赖账户状态和区块高度，所有节点结果一致
+func (sm *StateManager) releaseUnbonding(height uint64) error {
+	if err := sm.loadUnbondingAccounts(); err != nil {
+		return err
+	}
+
+	addresses := make([]string, 0, len(sm.unbondingAccounts))
+	for addr := range sm.unbondingAccounts {
+		addresses = append(addresses, addr)
+	}
+	sort.Strings(addresses)
+
+	for _, addr := range addresses {
+		account, err := sm.GetAccount(addr)
+		if err != nil {
+			return err
+		}
+		if amount := account.ReleaseUnbonding(height); amount > 0 {
+			sm.UpdateAccount(account)
+			log.Printf("🔓 解绑到期: %s 释放 %.6f FAN", addr, float64(amount)/1000000.0)
+		}
+	}
+
+	return nil
+}
+
+// loadUnbondingAccounts 首次使用时扫描全部账户重建解绑索引（缓存中未提交的账户优先）
+func (sm *StateManager) loadUnbondingAccounts() error {
+	if sm.unbondingLoaded {
+		return nil
+	}
+
+	accounts, err := sm.db.GetAllAccounts()
+	if err != nil {
+		return fmt.Errorf("failed to load unbonding accounts: %v", err)
+	}
+
+	sm.unbondingAccounts = make(map[string]bool)
+	for _, acc := range accounts {
+		if acc.UnbondingBalance > 0 {
+			sm.unbondingAccounts[acc.Address] = true
+		}
+	}
+	for addr, acc := range sm.accountCache {
+		if acc.UnbondingBalance > 0 {
+			sm.unbondingAccounts[addr] = true
+		}
+	}
+
+	sm.unbondingLoaded = true
+	return nil
+}
+
+// pruneUnbondingAccounts 提交后从索引剔除解绑余额已释放的账户
+func (sm *StateManager) pruneUnbondingAccounts(committed []*core.Account) {
+	if !sm.unbondingLoaded {
+		return
+	}
+	for _, acc := range committed {
+		if acc.UnbondingBalance == 0 {
+			delete(sm.unbondingAccounts, acc.Address)
+		}
+	}
+}
+
+// 执行解除监禁：监禁期满后清零缺席计数，重新成为验证者候选
+func (sm *StateManager) executeUnjail(tx *core.Transaction) error {
+	account, err := sm.GetAccount(tx.From)
+	if err != nil {
+		return err
+	}
+	if !account.Jailed {
+		return fmt.Errorf("account %s is not jailed", tx.From)
+	}
+	if sm.blockHeight < account.JailedUntil {
+		return fmt.Errorf("account %s is jailed until height %d (current %d)", tx.From, account.JailedUntil, sm.blockHeight)
+	}
+
+	account.Jailed = false
+	account.JailedUntil = 0
+	account.MissedBlocks = 0
+	account.Nonce++
+	sm.UpdateAccount(account)
+
+	log.Printf("🔓 验证者解除监禁: %s", tx.From)
+	if account.IsValidator() && sm.onValidatorAdded != nil {
+		sm.onValidatorAdded(tx.From, account.StakedBalance)
+	}
+
+	return nil
+}
+
+// applyLiveness 根据区块头记录链上出块缺席
+// 出块者缺席计数清零；接管出块时被跳过的VRF选中者计数+1，连续缺席达到OfflineSlashBlks后监禁
+// 只依赖区块内容，所有节点（包括同步历史区块的节点）结果一致
+func (sm *StateManager) applyLiveness(header *core.BlockHeader) error {
+	if !core.VRFActive(header.Height) {
+		return nil
+	}
+
+	proposer, err := sm.GetAccount(header.Proposer)
+	if err != nil {
+		return err
+	}
+	if proposer.MissedBlocks > 0 {
+		proposer.MissedBlocks = 0
+		sm.UpdateAccount(proposer)
+	}
+
+	if header.MissedProposer == "" || header.MissedProposer == header.Proposer {
+		return nil
+	}
+
+	missed, err := sm.GetAccount(header.MissedProposer)
+	if err != nil {
+		return err
+	}
+	wasValidator := missed.IsValidator()
+
+	missed.MissedBlocks++
+	threshold := uint64(core.GetConsensusConfig().SecurityParams.OfflineSlashBlks)
+	if threshold > 0 && missed.MissedBlocks >= threshold && !missed.Jailed {
+		missed.Jailed = true
+		missed.JailedUntil = header.Height + core.JailBlocks()
+		log.Printf("🔒 验证者 %s 连续 %d 轮未出块，已监禁至高度 %d", header.MissedProposer, missed.MissedBlocks, missed.JailedUntil)
+	}
+	sm.UpdateAccount(missed)
+
+	if wasValidator && !missed.IsValidator() && sm.onValidatorRemoved != nil {
+		sm.onValidatorRemoved(header.MissedProposer)
+	}
+
+	return nil
+}
+
 // 执行系统交易
 func (sm *StateManager) executeSystemTx(tx *core.Transaction) error {
 	// 特殊处理：创世地址与自己的系统交易(奖励/惩罚)不执行任何操作（保持总量不变）
@@ -505,25 +659,80 @@ func (sm *StateManager) executeSystemTx(tx *core.Transaction) error {
 		sm.UpdateAccount(receiver)
 	}
 
-	// 惩罚交易：从被惩罚者扣除，转入创世地址
+	// 惩罚交易：验证双签证据后罚没质押，转入创世地址
 	if tx.Type == core.TxSlash {
-		// 1. 从被惩罚者扣除
-		slashed, err := sm.GetAccount(tx.From)
-		if err != nil {
-			return err
-		}
-		if err := slashed.SubBalance(tx.Amount); err != nil {
-			return fmt.Errorf("slashed account insufficient balance: %v", err)
-		}
-		sm.UpdateAccount(slashed)
+		return sm.executeDoubleSignSlash(tx)
+	}
 
-		// 2. 转入创世地址
-		genesis, err := sm.GetAccount(core.GenesisAddress)
-		if err != nil {
-			return err
-		}
-		genesis.AddBalance(tx.Amount)
-		sm.UpdateAccount(genesis)
+	return nil
+}
+
+// SlashableAmount 检查双签证据是否可罚没，返回按当前质押（含解绑期中的质押）计算的罚没金额
+// 证据无效、已罚没过该高度或之后的双签、无质押可罚时返回错误
+func (sm *StateManager) SlashableAmount(evidence *core.DoubleSignEvidence) (uint64, error) {
+	if err := evidence.Verify(); err != nil {
+		return 0, err
+	}
+
+	offender, err := sm.GetAccount(evidence.Offender())
+	if err != nil {
+		return 0, err
+	}
+	if evidence.Height() <= offender.SlashedHeight {
+		return 0, fmt.Errorf("double sign at height %d already slashed (last slashed height %d)",
+			evidence.Height(), offender.SlashedHeight)
+	}
+
+	amount := core.DoubleSignSlashAmount(offender.SlashableStake())
+	if amount == 0 {
+		return 0, fmt.Errorf("offender %s has no stake to slash", evidence.Offender())
+	}
+	return amount, nil
+}
+
+// 执行双签惩罚：罚没质押（按DoubleSignSlash百分比）转入创世地址
+// 证据在执行时重新验证，出块者无法伪造惩罚交易没收他人资金
+func (sm *StateManager) executeDoubleSignSlash(tx *core.Transaction) error {
+	evidence, err := tx.SlashEvidence()
+	if err != nil {
+		return err
+	}
+	if evidence.Offender() != tx.From || tx.To != core.GenesisAddress {
+		return fmt.Errorf("slash transaction does not match evidence")
+	}
+
+	amount, err := sm.SlashableAmount(evidence)
+	if err != nil {
+		return fmt.Errorf("invalid double sign evidence: %v", err)
+	}
+	if tx.Amount != amount {
+		return fmt.Errorf("invalid slash amount: got %d, expected %d", tx.Amount, amount)
+	}
+
+	offender, err := sm.GetAccount(tx.From)
+	if err != nil {
+		return err
+	}
+	wasValidator := offender.IsValidator()
+
+	if err := offender.SlashStake(amount); err != nil {
+		return err
+	}
+	offender.SlashedHeight = evidence.Height()
+	sm.UpdateAccount(offender)
+
+	genesis, err := sm.GetAccount(core.GenesisAddress)
+	if err != nil {
+		return err
+	}
+	genesis.AddBalance(amount)
+	sm.UpdateAccount(genesis)
+
+	log.Printf("🚨 双签惩罚: %s 在高度 %d 签署了两个区块，罚没质押 %.6f FAN",
+		tx.From, evidence.Height(), float64(amount)/1000000.0)
+
+	if wasValidator && !offender.IsValidator() && sm.onValidatorRemoved != nil {
+		sm.onValidatorRemoved(tx.From)
 	}
 
 	return nil
@@ -583,43 +792,35 @@ func (sm *StateManager) RestoreSnapshot(snapshot *StateSnapshot) {
 	}
 }
 
-// 导入状态快照
-func (sm *StateManager) ImportSnapshot(accounts []*core.Account) error {
+// ImportSnapshot 用分片同步得到的账户整体替换当前状态（height为快照对应的区块高度）
+// 调用方已用checkpoint的StateRoot验证过全部分片；导入后重新计算状态根，与stateRoot不一致说明本地写入出错
+func (sm *StateManager) ImportSnapshot(height uint64, accounts []*core.Account, stateRoot core.Hash) error {
 	log.Printf("Importing state snapshot with %d accounts", len(accounts))
 
+	var totalSupply uint64
 	for _, acc := range accounts {
-		sm.UpdateAccount(acc)
+		totalSupply += acc.TotalBalance()
 	}
-
-	if err := sm.Commit(); err != nil {
-		return fmt.Errorf("failed to commit snapshot: %v", err)
+	if totalSupply != TOTAL_SUPPLY {
+		return fmt.Errorf("snapshot total supply mismatch: got %d, expected %d", totalSupply, TOTAL_SUPPLY)
 	}
 
-	log.Printf("✓ Successfully imported %d accounts", len(accounts))
-	return nil
-}
-
-// ReloadStateFromHeight 从指定高度重新加载状态
-// 用于链重组：从数据库重新加载账户状态
-func (sm *StateManager) ReloadStateFromHeight(db *storage.Database, targetHeight uint64) error {
-	log.Printf("⚠️  STATE RELOAD: Reloading state from height %d", targetHeight)
+	if err := sm.db.ClearAllAccounts(); err != nil {
+		return fmt.Errorf("failed to clear accounts: %v", err)
+	}
+	if err := sm.db.SaveAccountsBatch(accounts); err != nil {
+		return fmt.Errorf("failed to save snapshot accounts: %v", err)
+	}
 
-	// 1. 清空当前缓存
 	sm.accountCache = make(map[string]*core.Account)
 	sm.dirtyAccounts = make(map[string]bool)
+	sm.unbondingLoaded = false
 
-	// 2. 从数据库重新加载所有账户
-	accounts, err := db.GetAllAccounts()
-	if err != nil {
-		return fmt.Errorf("failed to reload accounts: %v", err)
-	}
-
-	// 3. 重新构建缓存
-	for _, acc := range accounts {
-		sm.accountCache[acc.Address] = acc
+	if err := sm.VerifyStateRoot(height, stateRoot); err != nil {
+		return fmt.Errorf("imported snapshot: %v", err)
 	}
 
-	log.Printf("✓ STATE RELOAD: Reloaded %d accounts", len(accounts))
+	log.Printf("✓ Successfully imported %d accounts", len(accounts))
 	return nil
 }
 
@@ -627,6 +828,7 @@ func (sm *StateManager) ReloadStateFromHeight(db *storage.Database, targetHeight
 func (sm *StateManager) ClearCache() {
 	sm.accountCache = make(map[string]*core.Account)
 	sm.dirtyAccounts = make(map[string]bool)
+	sm.unbondingLoaded = false
 	log.Printf("✓ State cache cleared")
 }
 
@@ -637,7 +839,7 @@ func (sm *StateManager) VerifyTotalSupplyFast() (uint64, bool, error) {
 	// 只计算缓存中账户的总量
 	var cacheTotal uint64
 	for _, acc := range sm.accountCache {
-		cacheTotal += acc.AvailableBalance + acc.StakedBalance
+		cacheTotal += acc.TotalBalance()
 	}
 
 	// 如果追踪器已初始化，验证追踪器值
@@ -652,32 +854,13 @@ func (sm *StateManager) VerifyTotalSupplyFast() (uint64, bool, error) {
 	return cacheTotal, true, nil
 }
 
-// VerifyTotalSupply 验证总供应量是否等于14亿FAN（完整验证）
+// VerifyTotalSupply 验证总供应量是否等于14亿FAN
+// 已提交总量由状态树增量维护，叠加脏账户变化即可：O(变更账户数)
 // 返回: (总供应量, 是否正确, error)
 func (sm *StateManager) VerifyTotalSupply() (uint64, bool, error) {
-	// 获取所有账户（包括数据库和缓存）
-	accounts, err := sm.db.GetAllAccounts()
+	_, totalSupply, err := sm.db.PreviewState(sm.dirtyAccountList())
 	if err != nil {
-		return 0, false, fmt.Errorf("failed to get accounts: %v", err)
-	}
-
-	// 创建账户映射，优先使用缓存中的版本
-	accountMap := make(map[string]*core.Account)
-
-	// 先添加数据库中的账户
-	for _, acc := range accounts {
-		accountMap[acc.Address] = acc
-	}
-
-	// 用缓存中的账户覆盖（包含最新的修改）
-	for addr, acc := range sm.accountCache {
-		accountMap[addr] = acc
-	}
-
-	// 计算总供应量 = 所有账户的(可用余额 + 质押余额)
-	var totalSupply uint64
-	for _, acc := range accountMap {
-		totalSupply += acc.AvailableBalance + acc.StakedBalance
+		return 0, false, fmt.Errorf("failed to calculate total supply: %v", err)
 	}
 
 	// 【增量验证同步】更新追踪器值为实际计算值
@@ -694,12 +877,16 @@ func (sm *StateManager) VerifyTotalSupply() (uint64, bool, error) {
 		log.Printf("🚨🚨🚨 P0违反：总供应量异常！预期: %d, 实际: %d, 差值: %d",
 			TOTAL_SUPPLY, totalSupply, int64(totalSupply)-int64(TOTAL_SUPPLY))
 
-		// 打印详细的账户信息用于调试
+		// 打印详细的账户信息用于调试（仅异常时全量扫描）
 		log.Printf("账户详情：")
-		for addr, acc := range accountMap {
+		accounts, err := sm.GetAllAccountsMerged()
+		if err != nil {
+			return totalSupply, false, fmt.Errorf("failed to get accounts: %v", err)
+		}
+		for _, acc := range accounts {
 			if acc.AvailableBalance > 0 || acc.StakedBalance > 0 {
 				log.Printf("  %s: 可用=%d, 质押=%d",
-					addr, acc.AvailableBalance, acc.StakedBalance)
+					acc.Address, acc.AvailableBalance, acc.StakedBalance)
 			}
 		}
 	}
@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"fan-chain/core"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// 归档模式：每次按高度提交状态时记录变更账户的新值（键 = 'h' + 地址 + 0x00 + 高度），
+// 查询高度H时取该账户不晚于H的最后一个版本
+// 开启归档或整体替换状态（checkpoint快照、回滚）后的第一次提交写入全部账户作为基线
+var (
+	historyPrefix    = []byte("h")
+	archiveFromKey   = []byte("meta:archive_from")   // 历史完整的起始高度
+	archiveLatestKey = []byte("meta:archive_latest") // 最后记录的高度
+	archiveRebaseKey = []byte("meta:archive_rebase") // 状态被整体替换，下次提交需要写入基线
+)
+
+// ErrHistoryUnavailable 请求的高度不在归档范围内
+var ErrHistoryUnavailable = errors.New("height not covered by archive")
+
+// SetArchiveMode 开启/关闭归档模式（本地策略，不参与共识）
+func (d *Database) SetArchiveMode(enabled bool) {
+	d.archive = enabled
+}
+
+// ArchiveMode 是否开启归档模式
+func (d *Database) ArchiveMode() bool {
+	return d.archive
+}
+
+// historyKey 账户历史版本键（地址只含字母数字，0x00分隔避免前缀重叠）
+func historyKey(address string, height uint64) []byte {
+	key := make([]byte, 0, len(historyPrefix)+len(address)+1+8)
+	key = append(key, historyPrefix...)
+	key = append(key, address...)
+	key = append(key, 0)
+	return binary.BigEndian.AppendUint64(key, height)
+}
+
+// ArchiveRange 归档覆盖的高度范围，尚未记录时ok为false
+func (d *Database) ArchiveRange() (from, latest uint64, ok bool) {
+	fromData, err := d.db.Get(archiveFromKey, nil)
+	if err != nil || len(fromData) != 8 {
+		return 0, 0, false
+	}
+	latestData, err := d.db.Get(archiveLatestKey, nil)
+	if err != nil || len(latestData) != 8 {
+		return 0, 0, false
+	}
+	return binary.BigEndian.Uint64(fromData), binary.BigEndian.Uint64(latestData), true
+}
+
+// recordAccountHistory 记录高度height提交的账户（在写入状态之前调用，重放同一高度会覆盖）
+func (d *Database) recordAccountHistory(accounts []*core.Account, height uint64) error {
+	from, latest, ok := d.ArchiveRange()
+	rebase := !ok
+	if has, err := d.db.Has(archiveRebaseKey, nil); err == nil && has {
+		rebase = true
+	}
+
+	batch := new(leveldb.Batch)
+
+	// 重新提交已记录的高度（重组、崩溃恢复重放）：之后的历史属于被放弃的链
+	if ok && height <= latest {
+		if err := d.deleteHistoryFrom(height, batch); err != nil {
+			return err
+		}
+	}
+
+	entries := accounts
+	if rebase {
+		all, err := d.stateStore.GetAllAccounts()
+		if err != nil {
+			return fmt.Errorf("failed to load accounts for archive baseline: %v", err)
+		}
+		merged := make(map[string]*core.Account, len(all)+len(accounts))
+		for _, acc := range all {
+			merged[acc.Address] = acc
+		}
+		for _, acc := range accounts {
+			merged[acc.Address] = acc
+		}
+		entries = make([]*core.Account, 0, len(merged))
+		for _, acc := range merged {
+			entries = append(entries, acc)
+		}
+
+		// 与已有历史不连续（状态跳到了更高的checkpoint）时，从基线高度重新开始
+		if !ok || height > latest+1 {
+			from = height
+		}
+		batch.Delete(archiveRebaseKey)
+	}
+
+	for _, acc := range entries {
+		data, err := acc.ToJSON()
+		if err != nil {
+			return fmt.Errorf("failed to serialize account %s: %v", acc.Address, err)
+		}
+		batch.Put(historyKey(acc.Address, height), data)
+	}
+
+	batch.Put(archiveFromKey, binary.BigEndian.AppendUint64(nil, from))
+	batch.Put(archiveLatestKey, binary.BigEndian.AppendUint64(nil, height))
+	return d.db.Write(batch, nil)
+}
+
+// deleteHistoryFrom 删除不低于height的全部历史版本（只在重组或状态替换后发生）
+func (d *Database) deleteHistoryFrom(height uint64, batch *leveldb.Batch) error {
+	iter := d.db.NewIterator(util.BytesPrefix(historyPrefix), nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		key := iter.Key()
+		if len(key) < len(historyPrefix)+1+8 {
+			continue
+		}
+		if binary.BigEndian.Uint64(key[len(key)-8:]) >= height {
+			batch.Delete(append([]byte{}, key...))
+		}
+	}
+	return iter.Error()
+}
+
+// markArchiveRebase 状态被整体替换，下次提交写入基线
+func (d *Database) markArchiveRebase() error {
+	return d.db.Put(archiveRebaseKey, []byte{1}, nil)
+}
+
+// GetAccountAtHeight 查询账户在指定高度（该高度区块执行后）的状态，账户当时不存在时返回nil
+func (d *Database) GetAccountAtHeight(address string, height uint64) (*core.Account, error) {
+	if !d.archive {
+		return nil, fmt.Errorf("%w: archive mode disabled", ErrHistoryUnavailable)
+	}
+	from, latest, ok := d.ArchiveRange()
+	if !ok || height < from || height > latest {
+		return nil, fmt.Errorf("%w: height %d, archive covers %d-%d", ErrHistoryUnavailable, height, from, latest)
+	}
+
+	iter := d.db.NewIterator(&util.Range{
+		Start: historyKey(address, 0),
+		Limit: historyKey(address, height+1),
+	}, nil)
+	defer iter.Release()
+
+	if !iter.Last() {
+		return nil, iter.Error()
+	}
+	account := new(core.Account)
+	if err := account.FromJSON(iter.Value()); err != nil {
+		return nil, fmt.Errorf("failed to deserialize account history: %v", err)
+	}
+	return account, nil
+}
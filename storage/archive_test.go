@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	"fan-chain/core"
+)
+
+func balanceAt(t *testing.T, db *Database, index int, height uint64) uint64 {
+	t.Helper()
+	acc, err := db.GetAccountAtHeight(testAccount(index, 0).Address, height)
+	if err != nil {
+		t.Fatalf("account %d at height %d: %v", index, height, err)
+	}
+	if acc == nil {
+		return 0
+	}
+	return acc.AvailableBalance
+}
+
+// 归档记录每个高度的账户变更，按高度查询得到当时的余额
+func TestArchiveHistoricalBalances(t *testing.T) {
+	db, err := OpenDatabase(t.TempDir())
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	defer db.Close()
+
+	// 开启归档前已有的账户进入第一次提交的基线
+	if err := db.SaveAccountsBatch([]*core.Account{testAccount(1, 100)}); err != nil {
+		t.Fatal(err)
+	}
+	db.SetArchiveMode(true)
+
+	commits := [][]*core.Account{
+		{testAccount(2, 50)},                     // 高度1
+		{testAccount(1, 80), testAccount(2, 70)}, // 高度2
+		{},                                       // 高度3：无变更
+		{testAccount(1, 60)},                     // 高度4
+	}
+	for i, accounts := range commits {
+		if err := db.SaveAccountsBatchWithHeight(accounts, uint64(i+1)); err != nil {
+			t.Fatalf("commit %d: %v", i+1, err)
+		}
+	}
+
+	want := map[uint64][2]uint64{1: {100, 50}, 2: {80, 70}, 3: {80, 70}, 4: {60, 70}}
+	for height, balances := range want {
+		if got := balanceAt(t, db, 1, height); got != balances[0] {
+			t.Errorf("account 1 at %d = %d, want %d", height, got, balances[0])
+		}
+		if got := balanceAt(t, db, 2, height); got != balances[1] {
+			t.Errorf("account 2 at %d = %d, want %d", height, got, balances[1])
+		}
+	}
+	if acc, err := db.GetAccountAtHeight(testAccount(3, 0).Address, 4); err != nil || acc != nil {
+		t.Fatalf("unknown account = %v, %v", acc, err)
+	}
+	if _, err := db.GetAccountAtHeight(testAccount(1, 0).Address, 5); !errors.Is(err, ErrHistoryUnavailable) {
+		t.Fatalf("height above archive: err = %v", err)
+	}
+
+	// 回滚到高度2后重新提交高度3：被放弃的高度3、4的历史删除
+	if err := db.SaveAccountsBatchWithHeight([]*core.Account{testAccount(2, 10)}, 3); err != nil {
+		t.Fatal(err)
+	}
+	if got := balanceAt(t, db, 1, 3); got != 80 {
+		t.Fatalf("account 1 after reorg = %d, want 80", got)
+	}
+	if got := balanceAt(t, db, 2, 3); got != 10 {
+		t.Fatalf("account 2 after reorg = %d, want 10", got)
+	}
+
+	// 状态整体替换并跳到更高的checkpoint：之前的历史与之不连续，归档从新基线开始
+	if err := db.ClearAllAccounts(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveAccountsBatch([]*core.Account{testAccount(1, 5), testAccount(2, 6)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveAccountsBatchWithHeight(nil, 10); err != nil {
+		t.Fatal(err)
+	}
+	if from, latest, ok := db.ArchiveRange(); !ok || from != 10 || latest != 10 {
+		t.Fatalf("archive range = %d-%d (%v), want 10-10", from, latest, ok)
+	}
+	if got := balanceAt(t, db, 1, 10); got != 5 {
+		t.Fatalf("account 1 at new baseline = %d, want 5", got)
+	}
+	if _, err := db.GetAccountAtHeight(testAccount(1, 0).Address, 3); !errors.Is(err, ErrHistoryUnavailable) {
+		t.Fatalf("height before baseline: err = %v", err)
+	}
+}
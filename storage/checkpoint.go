@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 
 	"fan-chain/core"
@@ -53,14 +55,12 @@ func (db *Database) SaveCheckpoint(checkpoint *core.Checkpoint, dataDir string)
 	}
 
 	// 强制单点：删除所有历史checkpoint文件
-	// 只保留checkpoint_latest.dat
+	// 只保留checkpoint_latest.dat（状态快照按高度保留，由CleanOldCheckpoints清理）
 	entries, _ := os.ReadDir(checkpointDir)
 	for _, entry := range entries {
 		if !entry.IsDir() {
 			name := entry.Name()
-			// 删除所有非latest的checkpoint和state文件
-			if name != "checkpoint_latest.dat" && name != "state_latest.dat.gz" &&
-			   (strings.HasPrefix(name, "checkpoint_") || strings.HasPrefix(name, "state_")) {
+			if name != "checkpoint_latest.dat" && strings.HasPrefix(name, "checkpoint_") {
 				os.Remove(filepath.Join(checkpointDir, name))
 			}
 		}
@@ -147,36 +147,121 @@ func (db *Database) ListCheckpoints(dataDir string) ([]uint64, error) {
 	return []uint64{checkpoint.Height}, nil
 }
 
-// CleanOldCheckpoints 清理旧检查点，只保留最新的N个
-func (db *Database) CleanOldCheckpoints(dataDir string, keepCount int) error {
-	heights, err := db.ListCheckpoints(dataDir)
+// stateSnapshotFile 指定高度的状态快照文件
+func stateSnapshotFile(checkpointDir string, height uint64) string {
+	return filepath.Join(checkpointDir, fmt.Sprintf("state_%d.dat.gz", height))
+}
+
+// legacyStateSnapshotFile 单点设计遗留的状态快照文件（文件名没有高度信息）
+func legacyStateSnapshotFile(checkpointDir string) string {
+	return filepath.Join(checkpointDir, "state_latest.dat.gz")
+}
+
+// LoadLegacyStateSnapshot 读取单点设计遗留的state_latest.dat.gz，不存在时返回nil
+func (db *Database) LoadLegacyStateSnapshot(dataDir string) ([]byte, error) {
+	data, err := os.ReadFile(legacyStateSnapshotFile(filepath.Join(dataDir, "checkpoints")))
 	if err != nil {
-		return err
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read legacy state snapshot: %v", err)
+	}
+	return data, nil
+}
+
+// MigrateLegacyStateSnapshot 将遗留的state_latest.dat.gz改名为state_<height>.dat.gz
+// 该高度已有快照时保留已有快照；height为0（快照内容无法识别）时直接删除遗留文件
+func (db *Database) MigrateLegacyStateSnapshot(dataDir string, height uint64) error {
+	checkpointDir := filepath.Join(dataDir, "checkpoints")
+	legacyFile := legacyStateSnapshotFile(checkpointDir)
+
+	if height > 0 {
+		if _, err := os.Stat(stateSnapshotFile(checkpointDir, height)); os.IsNotExist(err) {
+			if err := os.Rename(legacyFile, stateSnapshotFile(checkpointDir, height)); err != nil {
+				return fmt.Errorf("failed to migrate legacy state snapshot: %v", err)
+			}
+			return nil
+		}
+	}
+
+	if err := os.Remove(legacyFile); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove legacy state snapshot: %v", err)
+	}
+	return nil
+}
+
+// ListStateSnapshots 列出本地保存的状态快照高度（按高度降序）
+func (db *Database) ListStateSnapshots(dataDir string) ([]uint64, error) {
+	entries, err := os.ReadDir(filepath.Join(dataDir, "checkpoints"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read checkpoint dir: %v", err)
+	}
+
+	var heights []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, "state_") || !strings.HasSuffix(name, ".dat.gz") {
+			continue
+		}
+		height, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, "state_"), ".dat.gz"), 10, 64)
+		if err != nil {
+			continue // state_latest等旧文件
+		}
+		heights = append(heights, height)
+	}
+
+	sort.Slice(heights, func(i, j int) bool { return heights[i] > heights[j] })
+	return heights, nil
+}
+
+// CleanOldCheckpoints 清理旧状态快照，只保留最新的N个
+// 单点设计遗留的state_latest.dat.gz不在此删除，启动时由MigrateLegacyStateSnapshot按高度改名
+func (db *Database) CleanOldCheckpoints(dataDir string, keepCount int) error {
+	if keepCount < 1 {
+		keepCount = 1
 	}
 
-	if len(heights) <= keepCount {
-		return nil // 无需清理
+	heights, err := db.ListStateSnapshots(dataDir)
+	if err != nil {
+		return err
 	}
 
 	checkpointDir := filepath.Join(dataDir, "checkpoints")
 
-	// 删除多余的检查点
+	// 删除多余的状态快照
 	for i := keepCount; i < len(heights); i++ {
-		height := heights[i]
+		if err := os.Remove(stateSnapshotFile(checkpointDir, heights[i])); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove state snapshot %d: %v", heights[i], err)
+		}
+	}
+
+	return nil
+}
 
-		// 删除checkpoint文件
-		checkpointFile := filepath.Join(checkpointDir, fmt.Sprintf("checkpoint_%d.dat", height))
-		os.Remove(checkpointFile)
+// DeleteStateSnapshotsAbove 删除高于指定高度的状态快照（回滚后这些快照属于被放弃的链）
+func (db *Database) DeleteStateSnapshotsAbove(height uint64, dataDir string) error {
+	heights, err := db.ListStateSnapshots(dataDir)
+	if err != nil {
+		return err
+	}
 
-		// 删除对应的state文件
-		stateFile := filepath.Join(checkpointDir, fmt.Sprintf("state_%d.dat.gz", height))
-		os.Remove(stateFile)
+	checkpointDir := filepath.Join(dataDir, "checkpoints")
+	for _, h := range heights {
+		if h <= height {
+			break
+		}
+		if err := os.Remove(stateSnapshotFile(checkpointDir, h)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove state snapshot %d: %v", h, err)
+		}
 	}
 
 	return nil
 }
 
-// SaveStateSnapshot 保存状态快照到文件（强制单点）
+// SaveStateSnapshot 保存指定高度的状态快照（先写临时文件再重命名，不会留下不完整的快照）
 func (db *Database) SaveStateSnapshot(height uint64, snapshotData []byte, dataDir string) error {
 	checkpointDir := filepath.Join(dataDir, "checkpoints")
 
@@ -190,36 +275,30 @@ func (db *Database) SaveStateSnapshot(height uint64, snapshotData []byte, dataDi
 		return fmt.Errorf("failed to write temp state snapshot: %v", err)
 	}
 
-	// 获取当前的state文件
-	latestFile := filepath.Join(checkpointDir, "state_latest.dat.gz")
-
-	// D步骤：删除旧的state文件（如果存在）
-	if _, err := os.Stat(latestFile); err == nil {
-		if err := os.Remove(latestFile); err != nil {
-			os.Remove(tempFile)
-			return fmt.Errorf("failed to remove old state snapshot: %v", err)
-		}
-	}
-
-	// C步骤：原子性重命名
-	if err := os.Rename(tempFile, latestFile); err != nil {
+	// C步骤：原子性重命名（同一高度的旧快照直接被替换）
+	if err := os.Rename(tempFile, stateSnapshotFile(checkpointDir, height)); err != nil {
+		os.Remove(tempFile)
 		return fmt.Errorf("failed to commit state snapshot: %v", err)
 	}
 
 	return nil
 }
 
-// LoadStateSnapshot 加载状态快照（单点设计，忽略height参数）
+// LoadStateSnapshot 加载指定高度的状态快照
 func (db *Database) LoadStateSnapshot(height uint64, dataDir string) ([]byte, error) {
-	checkpointDir := filepath.Join(dataDir, "checkpoints")
-
-	// 总是加载唯一的latest状态快照
-	latestFile := filepath.Join(checkpointDir, "state_latest.dat.gz")
-
-	data, err := os.ReadFile(latestFile)
+	data, err := os.ReadFile(stateSnapshotFile(filepath.Join(dataDir, "checkpoints"), height))
 	if err != nil {
-		return nil, fmt.Errorf("failed to read state snapshot: %v", err)
+		return nil, fmt.Errorf("failed to read state snapshot %d: %v", height, err)
 	}
 
 	return data, nil
 }
+
+// StateSnapshotSize 指定高度的状态快照大小，没有该快照时返回false
+func (db *Database) StateSnapshotSize(height uint64, dataDir string) (uint64, bool) {
+	info, err := os.Stat(stateSnapshotFile(filepath.Join(dataDir, "checkpoints"), height))
+	if err != nil {
+		return 0, false
+	}
+	return uint64(info.Size()), true
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// 状态快照按高度保存，只保留最新的N个，回滚时删除回滚点之后的快照
+func TestStateSnapshotRetention(t *testing.T) {
+	db := &Database{}
+	dataDir := t.TempDir()
+
+	for height := uint64(1); height <= 5; height++ {
+		if err := db.SaveStateSnapshot(height, []byte{byte(height)}, dataDir); err != nil {
+			t.Fatalf("save snapshot %d: %v", height, err)
+		}
+		if err := db.CleanOldCheckpoints(dataDir, 3); err != nil {
+			t.Fatalf("clean: %v", err)
+		}
+	}
+
+	heights, err := db.ListStateSnapshots(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(heights, []uint64{5, 4, 3}) {
+		t.Fatalf("retained snapshots = %v, want [5 4 3]", heights)
+	}
+
+	data, err := db.LoadStateSnapshot(4, dataDir)
+	if err != nil || len(data) != 1 || data[0] != 4 {
+		t.Fatalf("load snapshot 4 = %v, %v", data, err)
+	}
+	if _, err := db.LoadStateSnapshot(2, dataDir); err == nil {
+		t.Fatal("pruned snapshot still loadable")
+	}
+	if size, ok := db.StateSnapshotSize(5, dataDir); !ok || size != 1 {
+		t.Fatalf("snapshot 5 size = %d, %v", size, ok)
+	}
+
+	if err := db.DeleteStateSnapshotsAbove(3, dataDir); err != nil {
+		t.Fatal(err)
+	}
+	if heights, _ := db.ListStateSnapshots(dataDir); !reflect.DeepEqual(heights, []uint64{3}) {
+		t.Fatalf("snapshots after rollback = %v, want [3]", heights)
+	}
+}
+
+// 单点设计遗留的state_latest.dat.gz按快照高度改名后继续可用，该高度已有快照时删除遗留文件
+func TestLegacyStateSnapshotMigration(t *testing.T) {
+	db := &Database{}
+	dataDir := t.TempDir()
+	checkpointDir := filepath.Join(dataDir, "checkpoints")
+	if err := os.MkdirAll(checkpointDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	legacyFile := filepath.Join(checkpointDir, "state_latest.dat.gz")
+
+	if data, err := db.LoadLegacyStateSnapshot(dataDir); data != nil || err != nil {
+		t.Fatalf("missing legacy snapshot = %v, %v", data, err)
+	}
+
+	if err := os.WriteFile(legacyFile, []byte{7}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := db.LoadLegacyStateSnapshot(dataDir); err != nil || len(data) != 1 || data[0] != 7 {
+		t.Fatalf("legacy snapshot = %v, %v", data, err)
+	}
+	if err := db.MigrateLegacyStateSnapshot(dataDir, 7); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if data, err := db.LoadStateSnapshot(7, dataDir); err != nil || len(data) != 1 || data[0] != 7 {
+		t.Fatalf("migrated snapshot = %v, %v", data, err)
+	}
+	if _, err := os.Stat(legacyFile); !os.IsNotExist(err) {
+		t.Fatal("legacy snapshot left behind after migration")
+	}
+
+	// 同一高度已有快照：保留已有快照
+	if err := os.WriteFile(legacyFile, []byte{1}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.MigrateLegacyStateSnapshot(dataDir, 7); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if data, _ := db.LoadStateSnapshot(7, dataDir); len(data) != 1 || data[0] != 7 {
+		t.Fatalf("existing snapshot overwritten: %v", data)
+	}
+	if _, err := os.Stat(legacyFile); !os.IsNotExist(err) {
+		t.Fatal("legacy snapshot not removed")
+	}
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"path/filepath"
@@ -21,6 +22,7 @@ import (
 var (
 	txPrefix       = []byte("t") // 交易索引
 	transferPrefix = []byte("x") // 转账索引 (Type=0)
+	receiptPrefix  = []byte("r") // 交易回执
 )
 
 // Database 数据库
@@ -29,6 +31,7 @@ type Database struct {
 	stateStore *ShardedStateStore // 36分片：账户状态
 	blockStore *BlockStore        // Flat File：区块数据
 	dataDir    string
+	archive    bool // 归档模式：按高度记录账户历史
 }
 
 // OpenDatabase 打开数据库
@@ -247,14 +250,20 @@ func (d *Database) GetBlockStore() *BlockStore {
 
 // SaveAccount 保存账户
 func (d *Database) SaveAccount(account *core.Account) error {
-	return d.stateStore.SaveAccount(account)
+	return d.SaveAccountsBatch([]*core.Account{account})
 }
 
 // SaveAccountsBatch 批量保存账户
+// 不带高度的写入不进入账户历史，归档模式下随后的第一次提交重新写入基线
 func (d *Database) SaveAccountsBatch(accounts []*core.Account) error {
 	if len(accounts) == 0 {
 		return nil
 	}
+	if d.archive {
+		if err := d.markArchiveRebase(); err != nil {
+			return fmt.Errorf("failed to mark archive rebase: %v", err)
+		}
+	}
 	return d.stateStore.SaveAccountsBatch(accounts)
 }
 
@@ -268,8 +277,13 @@ func (d *Database) GetAllAccounts() ([]*core.Account, error) {
 	return d.stateStore.GetAllAccounts()
 }
 
-// ClearAllAccounts 清空所有账户
+// ClearAllAccounts 清空所有账户（归档模式下随后的第一次提交重新写入基线）
 func (d *Database) ClearAllAccounts() error {
+	if d.archive {
+		if err := d.markArchiveRebase(); err != nil {
+			return fmt.Errorf("failed to mark archive rebase: %v", err)
+		}
+	}
 	return d.stateStore.ClearAllAccounts()
 }
 
@@ -288,11 +302,36 @@ func (d *Database) GetStateHeight() (uint64, error) {
 	return d.stateStore.GetStateHeight()
 }
 
-// SaveAccountsBatchWithHeight 批量保存账户并更新高度
+// SaveAccountsBatchWithHeight 批量保存账户并更新高度（归档模式下先记录账户历史）
 func (d *Database) SaveAccountsBatchWithHeight(accounts []*core.Account, height uint64) error {
+	if d.archive {
+		if err := d.recordAccountHistory(accounts, height); err != nil {
+			return fmt.Errorf("failed to record account history: %v", err)
+		}
+	}
 	return d.stateStore.SaveAccountsBatchWithHeight(accounts, height)
 }
 
+// StateRoot 已提交状态的状态根
+func (d *Database) StateRoot() core.Hash {
+	return d.stateStore.StateRoot()
+}
+
+// StateTotalSupply 已提交状态的总供应量
+func (d *Database) StateTotalSupply() uint64 {
+	return d.stateStore.TotalSupply()
+}
+
+// PreviewState 计算写入这些账户后的状态根和总供应量（不落盘）
+func (d *Database) PreviewState(accounts []*core.Account) (core.Hash, uint64, error) {
+	return d.stateStore.PreviewState(accounts)
+}
+
+// GetAccountProof 获取账户状态证明
+func (d *Database) GetAccountProof(address string) (*core.AccountProof, error) {
+	return d.stateStore.GetAccountProof(address)
+}
+
 // ========== 交易存储 ==========
 
 func makeTxKey(hash core.Hash) []byte {
@@ -322,6 +361,48 @@ func (d *Database) GetTransaction(hash core.Hash) (*core.Transaction, error) {
 	return &tx, nil
 }
 
+// ========== 交易回执 ==========
+
+func makeReceiptKey(txHash []byte) []byte {
+	return append(append([]byte{}, receiptPrefix...), txHash...)
+}
+
+// SaveReceipts 批量保存区块内交易回执（与交易索引同键，前缀不同）
+func (d *Database) SaveReceipts(receipts []*core.Receipt) error {
+	if len(receipts) == 0 {
+		return nil
+	}
+
+	batch := new(leveldb.Batch)
+	for _, receipt := range receipts {
+		txHash, err := hex.DecodeString(receipt.TxHash)
+		if err != nil {
+			return fmt.Errorf("invalid receipt tx hash %s: %v", receipt.TxHash, err)
+		}
+		data, err := json.Marshal(receipt)
+		if err != nil {
+			return err
+		}
+		batch.Put(makeReceiptKey(txHash), data)
+	}
+
+	return d.db.Write(batch, nil)
+}
+
+// GetReceipt 获取交易回执
+func (d *Database) GetReceipt(hash core.Hash) (*core.Receipt, error) {
+	data, err := d.db.Get(makeReceiptKey(hash.Bytes()), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var receipt core.Receipt
+	if err := json.Unmarshal(data, &receipt); err != nil {
+		return nil, err
+	}
+	return &receipt, nil
+}
+
 // GetTransactionsByAddress 获取地址相关交易
 func (d *Database) GetTransactionsByAddress(address string, limit int) ([]*core.Transaction, error) {
 	if limit <= 0 {
@@ -49,6 +49,11 @@ func (db *Database) SavePeer(address string, lastSeen int64) error {
 	return db.db.Put(key, data, nil)
 }
 
+// 删除对等节点
+func (db *Database) DeletePeer(address string) error {
+	return db.db.Delete(append([]byte("p"), []byte(address)...), nil)
+}
+
 // 获取所有对等节点
 func (db *Database) GetAllPeers() (map[string]int64, error) {
 	peers := make(map[string]int64)
@@ -65,6 +70,87 @@ func (db *Database) GetAllPeers() (map[string]int64, error) {
 	return peers, iter.Error()
 }
 
+// 保存节点封禁（到期时间，Unix秒）
+func (db *Database) SavePeerBan(host string, bannedUntil int64) error {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, uint64(bannedUntil))
+
+	key := append([]byte("B"), []byte(host)...)
+	return db.db.Put(key, data, nil)
+}
+
+// 删除节点封禁
+func (db *Database) DeletePeerBan(host string) error {
+	return db.db.Delete(append([]byte("B"), []byte(host)...), nil)
+}
+
+// 获取所有节点封禁
+func (db *Database) GetAllPeerBans() (map[string]int64, error) {
+	bans := make(map[string]int64)
+
+	iter := db.db.NewIterator(util.BytesPrefix([]byte("B")), nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		host := string(iter.Key()[1:])
+		bans[host] = int64(binary.BigEndian.Uint64(iter.Value()))
+	}
+
+	return bans, iter.Error()
+}
+
+// ========== 分片状态同步进度 ==========
+
+// 已验证的状态分片（C + 状态根 + 分片序号），节点重启后只需补齐缺失的分片
+func stateChunkKey(stateRoot core.Hash, index uint32) []byte {
+	key := make([]byte, 0, 1+32+4)
+	key = append(key, 'C')
+	key = append(key, stateRoot[:]...)
+	return binary.BigEndian.AppendUint32(key, index)
+}
+
+// 保存已验证的状态分片
+func (db *Database) SaveStateChunk(stateRoot core.Hash, chunk *core.StateChunk) error {
+	data, err := chunk.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return db.db.Put(stateChunkKey(stateRoot, chunk.Index), data, nil)
+}
+
+// 获取某个状态根下已保存的分片
+func (db *Database) GetStateChunks(stateRoot core.Hash) ([]*core.StateChunk, error) {
+	prefix := append([]byte("C"), stateRoot[:]...)
+	iter := db.db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer iter.Release()
+
+	var chunks []*core.StateChunk
+	for iter.Next() {
+		chunk := new(core.StateChunk)
+		if err := chunk.UnmarshalBinary(iter.Value()); err != nil {
+			log.Printf("Warning: corrupted state chunk %x, skipping: %v", iter.Key()[33:], err)
+			continue
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	return chunks, iter.Error()
+}
+
+// 清除全部状态分片（同步完成或目标checkpoint变化时）
+func (db *Database) ClearStateChunks() error {
+	batch := new(leveldb.Batch)
+	iter := db.db.NewIterator(util.BytesPrefix([]byte("C")), nil)
+	for iter.Next() {
+		batch.Delete(append([]byte{}, iter.Key()...))
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	return db.db.Write(batch, nil)
+}
+
 // ========== 区块时间戳索引 ==========
 
 // 保存区块时间戳索引（在SaveBlock中调用）
@@ -42,10 +42,11 @@ const (
 
 // ShardedStateStore 分片状态存储
 type ShardedStateStore struct {
-	dataDir   string                 // 数据根目录
-	stateDir  string                 // state子目录
-	shards    map[string]*leveldb.DB // 36个分片的LevelDB实例
-	mu        sync.RWMutex           // 读写锁
+	dataDir  string                 // 数据根目录
+	stateDir string                 // state子目录
+	shards   map[string]*leveldb.DB // 36个分片的LevelDB实例
+	tree     *stateTree             // 稀疏Merkle状态树（state/tree）
+	mu       sync.RWMutex           // 读写锁
 }
 
 // NewShardedStateStore 创建分片状态存储
@@ -77,6 +78,26 @@ func NewShardedStateStore(dataDir string) (*ShardedStateStore, error) {
 		store.shards[shardKey] = db
 	}
 
+	// 打开状态树，缺失或上次写入中断时从分片重建
+	tree, err := openStateTree(filepath.Join(stateDir, StateTreeSubdir))
+	if err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to open state tree: %v", err)
+	}
+	store.tree = tree
+
+	ok, err := tree.loadMeta()
+	if err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to load state tree: %v", err)
+	}
+	if !ok {
+		if err := store.rebuildTreeLocked(); err != nil {
+			store.Close()
+			return nil, err
+		}
+	}
+
 	return store, nil
 }
 
@@ -92,6 +113,13 @@ func (s *ShardedStateStore) Close() error {
 		}
 	}
 	s.shards = make(map[string]*leveldb.DB)
+
+	if s.tree != nil {
+		if err := s.tree.close(); err != nil {
+			lastErr = fmt.Errorf("failed to close state tree: %v", err)
+		}
+		s.tree = nil
+	}
 	return lastErr
 }
 
@@ -124,21 +152,11 @@ func (s *ShardedStateStore) getShard(address string) (*leveldb.DB, error) {
 
 // SaveAccount 保存账户到对应分片
 func (s *ShardedStateStore) SaveAccount(account *core.Account) error {
-	db, err := s.getShard(account.Address)
-	if err != nil {
-		return err
-	}
-
-	data, err := account.ToJSON()
-	if err != nil {
-		return fmt.Errorf("failed to serialize account: %v", err)
-	}
-
-	return db.Put([]byte(account.Address), data, nil)
+	return s.SaveAccountsBatch([]*core.Account{account})
 }
 
 // SaveAccountsBatch 批量原子写入账户（跨分片）
-// 按分片分组后，每个分片使用WriteBatch原子写入
+// 按分片分组后，每个分片使用WriteBatch原子写入，随后更新状态树
 func (s *ShardedStateStore) SaveAccountsBatch(accounts []*core.Account) error {
 	if len(accounts) == 0 {
 		return nil
@@ -147,6 +165,22 @@ func (s *ShardedStateStore) SaveAccountsBatch(accounts []*core.Account) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	return s.writeAccountsLocked(accounts)
+}
+
+// writeAccountsLocked 写入账户分片并增量更新状态树
+// 顺序：标记写入中 -> 写分片 -> 提交树节点/根/总量（同时清除标记）
+// 中途崩溃时标记残留，下次启动从分片重建状态树
+func (s *ShardedStateStore) writeAccountsLocked(accounts []*core.Account) error {
+	root, supply, overlay, err := s.previewLocked(accounts)
+	if err != nil {
+		return fmt.Errorf("failed to update state tree: %v", err)
+	}
+
+	if err := s.tree.markPending(); err != nil {
+		return fmt.Errorf("failed to mark state tree pending: %v", err)
+	}
+
 	// 按分片分组
 	shardBatches := make(map[string]*leveldb.Batch)
 	for _, account := range accounts {
@@ -171,14 +205,69 @@ func (s *ShardedStateStore) SaveAccountsBatch(accounts []*core.Account) error {
 		}
 	}
 
+	if err := s.tree.commit(root, supply, overlay); err != nil {
+		return fmt.Errorf("failed to commit state tree: %v", err)
+	}
+
 	return nil
 }
 
+// previewLocked 计算写入这些账户后的状态根和总供应量（不落盘），返回的overlay供提交时写入新节点、删除旧节点
+// 只读取变更账户的旧值和其路径上的树节点：O(变更账户数 · log n)
+func (s *ShardedStateStore) previewLocked(accounts []*core.Account) (core.Hash, uint64, *treeOverlay, error) {
+	supply := s.tree.supply
+	updates := make([]treeUpdate, 0, len(accounts))
+	seen := make(map[string]bool, len(accounts))
+
+	// 同一批次中同一地址以最后一次写入为准
+	for i := len(accounts) - 1; i >= 0; i-- {
+		account := accounts[i]
+		if seen[account.Address] {
+			continue
+		}
+		seen[account.Address] = true
+
+		old, err := s.getAccountLocked(account.Address)
+		if err != nil {
+			return core.Hash{}, 0, nil, err
+		}
+		supply = supply - old.TotalBalance() + account.TotalBalance()
+
+		updates = append(updates, treeUpdate{
+			key:   core.StateKey(account.Address),
+			value: core.AccountLeafHash(account),
+		})
+	}
+
+	o := s.tree.newOverlay()
+
+	// 空树（如快照导入）直接按序构建，避免逐个插入产生大量中间节点
+	if s.tree.root == (core.Hash{}) {
+		sortTreeUpdates(updates)
+		root := o.build(updates, 0)
+		return root, supply, o, nil
+	}
+
+	root, err := o.apply(s.tree.root, updates)
+	if err != nil {
+		return core.Hash{}, 0, nil, err
+	}
+
+	return root, supply, o, nil
+}
+
 // GetAccount 从对应分片获取账户
 func (s *ShardedStateStore) GetAccount(address string) (*core.Account, error) {
-	db, err := s.getShard(address)
-	if err != nil {
-		return nil, err
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.getAccountLocked(address)
+}
+
+func (s *ShardedStateStore) getAccountLocked(address string) (*core.Account, error) {
+	db, ok := s.shards[getShardKey(address)]
+	if !ok {
+		return nil, fmt.Errorf("shard %s not found", getShardKey(address))
 	}
 
 	data, err := db.Get([]byte(address), nil)
@@ -203,6 +292,10 @@ func (s *ShardedStateStore) GetAllAccounts() ([]*core.Account, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	return s.getAllAccountsLocked()
+}
+
+func (s *ShardedStateStore) getAllAccountsLocked() ([]*core.Account, error) {
 	accounts := []*core.Account{}
 
 	// 遍历所有分片
@@ -246,6 +339,10 @@ func (s *ShardedStateStore) ClearAllAccounts() error {
 		}
 	}
 
+	if err := s.tree.reset(); err != nil {
+		return fmt.Errorf("failed to clear state tree: %v", err)
+	}
+
 	return nil
 }
 
@@ -338,6 +435,11 @@ func (s *ShardedStateStore) MigrateFromLegacy(legacyDB *leveldb.DB) (int, error)
 		}
 	}
 
+	// 迁移直接写入分片，需要重建状态树
+	if err := s.rebuildTreeLocked(); err != nil {
+		return count, err
+	}
+
 	return count, nil
 }
 
@@ -378,30 +480,86 @@ func (s *ShardedStateStore) SaveAccountsBatchWithHeight(accounts []*core.Account
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// 按分片分组
-	shardBatches := make(map[string]*leveldb.Batch)
-	for _, account := range accounts {
-		shardKey := getShardKey(account.Address)
+	if err := s.writeAccountsLocked(accounts); err != nil {
+		return err
+	}
 
-		if _, ok := shardBatches[shardKey]; !ok {
-			shardBatches[shardKey] = new(leveldb.Batch)
-		}
+	// 最后更新高度文件（作为提交完成的标记）
+	return s.SaveStateHeight(height)
+}
 
-		data, err := account.ToJSON()
-		if err != nil {
-			return fmt.Errorf("failed to serialize account %s: %v", account.Address, err)
-		}
-		shardBatches[shardKey].Put([]byte(account.Address), data)
+// ========== 状态树 ==========
+
+// rebuildTreeLocked 从全部分片账户重建状态树
+func (s *ShardedStateStore) rebuildTreeLocked() error {
+	accounts, err := s.getAllAccountsLocked()
+	if err != nil {
+		return fmt.Errorf("failed to load accounts for state tree: %v", err)
+	}
+	if err := s.tree.rebuild(accounts); err != nil {
+		return fmt.Errorf("failed to rebuild state tree: %v", err)
 	}
+	return nil
+}
 
-	// 顺序提交所有batch
-	for shardKey, batch := range shardBatches {
-		db := s.shards[shardKey]
-		if err := db.Write(batch, nil); err != nil {
-			return fmt.Errorf("failed to write batch to shard %s: %v", shardKey, err)
+// StateRoot 已提交状态的状态根
+func (s *ShardedStateStore) StateRoot() core.Hash {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.root
+}
+
+// TotalSupply 已提交状态的总供应量（随每次写入增量维护）
+func (s *ShardedStateStore) TotalSupply() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.supply
+}
+
+// PreviewState 计算写入这些账户后的状态根和总供应量，不修改存储
+func (s *ShardedStateStore) PreviewState(accounts []*core.Account) (core.Hash, uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(accounts) == 0 {
+		return s.tree.root, s.tree.supply, nil
+	}
+	root, supply, _, err := s.previewLocked(accounts)
+	return root, supply, err
+}
+
+// GetAccountProof 生成账户在已提交状态树中的证明（账户不存在时为非包含证明）
+func (s *ShardedStateStore) GetAccountProof(address string) (*core.AccountProof, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key := core.StateKey(address)
+	siblings, leaf, err := s.tree.proof(key)
+	if err != nil {
+		return nil, err
+	}
+
+	proof := &core.AccountProof{
+		Address:   address,
+		Siblings:  make([]string, len(siblings)),
+		StateRoot: s.tree.root.String(),
+	}
+	for i, sibling := range siblings {
+		proof.Siblings[i] = sibling.String()
+	}
+
+	if leaf != nil {
+		if leaf.key == key {
+			account, err := s.getAccountLocked(address)
+			if err != nil {
+				return nil, err
+			}
+			proof.Account = account
+		} else {
+			proof.OtherKey = leaf.key.String()
+			proof.OtherValue = leaf.value.String()
 		}
 	}
 
-	// 最后更新高度文件（作为提交完成的标记）
-	return s.SaveStateHeight(height)
+	return proof, nil
 }
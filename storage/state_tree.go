@@ -0,0 +1,394 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sort"
+
+	"fan-chain/core"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// stateTree 持久化稀疏Merkle状态树（哈希规则见core/account_proof.go）
+// 节点按哈希寻址存放在 state/tree LevelDB 中：
+// - n{hash} : 叶子 0x00|key|value，或中间节点 0x01|left|right
+// - meta:root / meta:supply : 当前根和总供应量（随账户写入一起更新）
+// - meta:pending : 账户写入进行中标记，启动时存在则说明上次写入中断，需要重建
+// - meta:version : 树格式版本，与treeVersion不符时重建（旧版本未删除被替换的节点）
+// 每次提交只新增被修改路径上的节点并删除被替换的旧节点，只保留当前根可达的节点，
+// 根计算和总量校验为 O(变更账户数 · log n)
+const (
+	StateTreeSubdir = "tree"
+
+	treeNodeLeaf     = 0x00
+	treeNodeInternal = 0x01
+
+	treeVersion = 1
+)
+
+var (
+	treeNodePrefix = []byte("n")
+	treeRootKey    = []byte("meta:root")
+	treeSupplyKey  = []byte("meta:supply")
+	treePendingKey = []byte("meta:pending")
+	treeVersionKey = []byte("meta:version")
+)
+
+type stateTree struct {
+	db     *leveldb.DB
+	root   core.Hash
+	supply uint64
+}
+
+// treeNode 解码后的树节点
+type treeNode struct {
+	leaf        bool
+	key, value  core.Hash // 叶子
+	left, right core.Hash // 中间节点
+}
+
+// treeUpdate 一次账户写入对应的叶子更新
+type treeUpdate struct {
+	key   core.Hash
+	value core.Hash
+}
+
+// treeOverlay 未提交的新节点（计算根时在内存中构建，提交时批量写入）
+type treeOverlay struct {
+	tree     *stateTree
+	nodes    map[core.Hash][]byte
+	replaced map[core.Hash]bool // 更新路径上被新节点取代的已提交节点（提交时删除）
+}
+
+func openStateTree(path string) (*stateTree, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &stateTree{db: db}, nil
+}
+
+func makeTreeNodeKey(hash core.Hash) []byte {
+	return append(append([]byte{}, treeNodePrefix...), hash[:]...)
+}
+
+// loadMeta 读取根和总量；返回false表示树需要重建（从未建立或上次写入中断）
+func (t *stateTree) loadMeta() (bool, error) {
+	if pending, err := t.db.Has(treePendingKey, nil); err != nil {
+		return false, err
+	} else if pending {
+		return false, nil
+	}
+
+	if version, err := t.db.Get(treeVersionKey, nil); err != nil || len(version) != 1 || version[0] != treeVersion {
+		return false, nil
+	}
+
+	rootData, err := t.db.Get(treeRootKey, nil)
+	if err == leveldb.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	supplyData, err := t.db.Get(treeSupplyKey, nil)
+	if err != nil {
+		return false, nil
+	}
+
+	t.root = core.BytesToHash(rootData)
+	t.supply = core.BytesToUint64(supplyData)
+	return true, nil
+}
+
+// markPending 标记账户写入开始（必须在写分片之前落盘）
+func (t *stateTree) markPending() error {
+	return t.db.Put(treePendingKey, []byte{1}, nil)
+}
+
+// commit 写入新根可达的新节点、删除被取代的旧节点和更新元数据，并清除写入中标记
+func (t *stateTree) commit(root core.Hash, supply uint64, o *treeOverlay) error {
+	nodes := o.reachable(root)
+
+	batch := new(leveldb.Batch)
+	for hash := range o.replaced {
+		// 内容相同的节点（如账户写入了相同的值）仍被新根引用
+		if _, ok := nodes[hash]; !ok {
+			batch.Delete(makeTreeNodeKey(hash))
+		}
+	}
+	for hash, data := range nodes {
+		batch.Put(makeTreeNodeKey(hash), data)
+	}
+	batch.Put(treeRootKey, root.Bytes())
+	batch.Put(treeSupplyKey, core.Uint64ToBytes(supply))
+	batch.Put(treeVersionKey, []byte{treeVersion})
+	batch.Delete(treePendingKey)
+
+	if err := t.db.Write(batch, nil); err != nil {
+		return err
+	}
+	t.root = root
+	t.supply = supply
+	return nil
+}
+
+// reset 清空整棵树（节点和元数据）
+func (t *stateTree) reset() error {
+	batch := new(leveldb.Batch)
+	iter := t.db.NewIterator(util.BytesPrefix(treeNodePrefix), nil)
+	for iter.Next() {
+		batch.Delete(append([]byte{}, iter.Key()...))
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	batch.Put(treeRootKey, make([]byte, 32))
+	batch.Put(treeSupplyKey, core.Uint64ToBytes(0))
+	batch.Delete(treePendingKey)
+
+	if err := t.db.Write(batch, nil); err != nil {
+		return err
+	}
+	t.root = core.Hash{}
+	t.supply = 0
+	return nil
+}
+
+func (t *stateTree) close() error {
+	return t.db.Close()
+}
+
+func (t *stateTree) newOverlay() *treeOverlay {
+	return &treeOverlay{
+		tree:     t,
+		nodes:    make(map[core.Hash][]byte),
+		replaced: make(map[core.Hash]bool),
+	}
+}
+
+// getNode 读取节点（优先未提交的新节点）
+func (o *treeOverlay) getNode(hash core.Hash) (*treeNode, error) {
+	data, ok := o.nodes[hash]
+	if !ok {
+		var err error
+		data, err = o.tree.db.Get(makeTreeNodeKey(hash), nil)
+		if err != nil {
+			return nil, fmt.Errorf("state tree node %s missing: %v", hash.String()[:16], err)
+		}
+	}
+	if len(data) != 65 {
+		return nil, fmt.Errorf("state tree node %s corrupted", hash.String()[:16])
+	}
+
+	node := &treeNode{leaf: data[0] == treeNodeLeaf}
+	if node.leaf {
+		node.key = core.BytesToHash(data[1:33])
+		node.value = core.BytesToHash(data[33:65])
+	} else {
+		node.left = core.BytesToHash(data[1:33])
+		node.right = core.BytesToHash(data[33:65])
+	}
+	return node, nil
+}
+
+func (o *treeOverlay) putLeaf(key, value core.Hash) core.Hash {
+	hash := core.StateLeafNode(key, value)
+	data := make([]byte, 0, 65)
+	data = append(data, treeNodeLeaf)
+	data = append(data, key[:]...)
+	data = append(data, value[:]...)
+	o.nodes[hash] = data
+	return hash
+}
+
+func (o *treeOverlay) putInternal(left, right core.Hash) core.Hash {
+	hash := core.StateInternalNode(left, right)
+	data := make([]byte, 0, 65)
+	data = append(data, treeNodeInternal)
+	data = append(data, left[:]...)
+	data = append(data, right[:]...)
+	o.nodes[hash] = data
+	return hash
+}
+
+// insert 在以nodeHash为根、位于depth层的子树中写入叶子，返回新的子树根
+func (o *treeOverlay) insert(nodeHash core.Hash, depth int, key, value core.Hash) (core.Hash, error) {
+	if nodeHash == (core.Hash{}) {
+		return o.putLeaf(key, value), nil
+	}
+	if depth >= core.StateTreeDepth {
+		return core.Hash{}, fmt.Errorf("state tree depth exceeded")
+	}
+
+	node, err := o.getNode(nodeHash)
+	if err != nil {
+		return core.Hash{}, err
+	}
+	_, fresh := o.nodes[nodeHash]
+
+	if node.leaf {
+		if node.key == key {
+			if !fresh {
+				o.replaced[nodeHash] = true
+			}
+			return o.putLeaf(key, value), nil
+		}
+		// 两个账户共用这一压缩路径：向下展开直到分叉（原叶子原样下移，不被取代）
+		return o.split(depth, node.key, nodeHash, key, o.putLeaf(key, value))
+	}
+
+	if !fresh {
+		o.replaced[nodeHash] = true
+	}
+
+	if core.StateKeyBit(key, depth) == 0 {
+		left, err := o.insert(node.left, depth+1, key, value)
+		if err != nil {
+			return core.Hash{}, err
+		}
+		return o.putInternal(left, node.right), nil
+	}
+	right, err := o.insert(node.right, depth+1, key, value)
+	if err != nil {
+		return core.Hash{}, err
+	}
+	return o.putInternal(node.left, right), nil
+}
+
+// split 为两个叶子构建从depth层开始的中间节点链
+func (o *treeOverlay) split(depth int, keyA, leafA, keyB, leafB core.Hash) (core.Hash, error) {
+	if depth >= core.StateTreeDepth {
+		return core.Hash{}, fmt.Errorf("state tree key collision")
+	}
+
+	bitA := core.StateKeyBit(keyA, depth)
+	bitB := core.StateKeyBit(keyB, depth)
+	if bitA != bitB {
+		if bitA == 0 {
+			return o.putInternal(leafA, leafB), nil
+		}
+		return o.putInternal(leafB, leafA), nil
+	}
+
+	child, err := o.split(depth+1, keyA, leafA, keyB, leafB)
+	if err != nil {
+		return core.Hash{}, err
+	}
+	if bitA == 0 {
+		return o.putInternal(child, core.Hash{}), nil
+	}
+	return o.putInternal(core.Hash{}, child), nil
+}
+
+// apply 依次写入所有更新，返回新根
+func (o *treeOverlay) apply(root core.Hash, updates []treeUpdate) (core.Hash, error) {
+	var err error
+	for _, u := range updates {
+		root, err = o.insert(root, 0, u.key, u.value)
+		if err != nil {
+			return core.Hash{}, err
+		}
+	}
+	return root, nil
+}
+
+// reachable 只保留新根可达的新节点（批量更新过程中产生的中间版本不落盘）
+func (o *treeOverlay) reachable(root core.Hash) map[core.Hash][]byte {
+	result := make(map[core.Hash][]byte)
+	var walk func(hash core.Hash)
+	walk = func(hash core.Hash) {
+		data, ok := o.nodes[hash]
+		if !ok {
+			return
+		}
+		if _, done := result[hash]; done {
+			return
+		}
+		result[hash] = data
+		if data[0] == treeNodeInternal {
+			walk(core.BytesToHash(data[1:33]))
+			walk(core.BytesToHash(data[33:65]))
+		}
+	}
+	walk(root)
+	return result
+}
+
+// sortTreeUpdates 按键排序（build的前置条件）
+func sortTreeUpdates(updates []treeUpdate) {
+	sort.Slice(updates, func(i, j int) bool {
+		return bytes.Compare(updates[i].key[:], updates[j].key[:]) < 0
+	})
+}
+
+// build 由按键排序的叶子自顶向下直接构建子树（重建时使用，不产生中间版本节点）
+func (o *treeOverlay) build(updates []treeUpdate, depth int) core.Hash {
+	switch len(updates) {
+	case 0:
+		return core.Hash{}
+	case 1:
+		return o.putLeaf(updates[0].key, updates[0].value)
+	}
+
+	mid := sort.Search(len(updates), func(i int) bool {
+		return core.StateKeyBit(updates[i].key, depth) == 1
+	})
+	left := o.build(updates[:mid], depth+1)
+	right := o.build(updates[mid:], depth+1)
+	return o.putInternal(left, right)
+}
+
+// proof 沿键路径收集兄弟节点，直到遇到叶子或空子树
+func (t *stateTree) proof(key core.Hash) (siblings []core.Hash, leaf *treeNode, err error) {
+	o := t.newOverlay()
+	nodeHash := t.root
+	for depth := 0; nodeHash != (core.Hash{}); depth++ {
+		node, err := o.getNode(nodeHash)
+		if err != nil {
+			return nil, nil, err
+		}
+		if node.leaf {
+			return siblings, node, nil
+		}
+		if core.StateKeyBit(key, depth) == 0 {
+			siblings = append(siblings, node.right)
+			nodeHash = node.left
+		} else {
+			siblings = append(siblings, node.left)
+			nodeHash = node.right
+		}
+	}
+	return siblings, nil, nil
+}
+
+// rebuild 从全部账户重建状态树（首次升级或写入中断后执行一次）
+func (t *stateTree) rebuild(accounts []*core.Account) error {
+	log.Printf("🌲 Rebuilding state tree from %d accounts...", len(accounts))
+
+	if err := t.reset(); err != nil {
+		return fmt.Errorf("failed to reset state tree: %v", err)
+	}
+
+	var supply uint64
+	updates := make([]treeUpdate, 0, len(accounts))
+	for _, acc := range accounts {
+		supply += acc.TotalBalance()
+		updates = append(updates, treeUpdate{key: core.StateKey(acc.Address), value: core.AccountLeafHash(acc)})
+	}
+
+	sortTreeUpdates(updates)
+
+	o := t.newOverlay()
+	root := o.build(updates, 0)
+	if err := t.commit(root, supply, o); err != nil {
+		return fmt.Errorf("failed to write state tree: %v", err)
+	}
+
+	log.Printf("🌲 State tree rebuilt: root=%s, supply=%d", root.String()[:16], supply)
+	return nil
+}
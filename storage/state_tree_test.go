@@ -0,0 +1,267 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+
+	"fan-chain/core"
+
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+func testAccount(i int, balance uint64) *core.Account {
+	acc := core.NewAccount(fmt.Sprintf("F%036d", i))
+	acc.AvailableBalance = balance
+	return acc
+}
+
+// 增量更新得到的根必须与从全部账户重建的根一致，证明和总量同样正确
+func TestStateTreeIncrementalMatchesRebuild(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewShardedStateStore(dir)
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+
+	var expectedSupply uint64
+	for i := 0; i < 50; i++ {
+		if err := store.SaveAccount(testAccount(i, uint64(i+1))); err != nil {
+			t.Fatalf("save account %d: %v", i, err)
+		}
+		expectedSupply += uint64(i + 1)
+	}
+
+	// 修改部分账户
+	batch := []*core.Account{testAccount(3, 100), testAccount(17, 0), testAccount(49, 7)}
+	expectedSupply = expectedSupply - 4 - 18 - 50 + 100 + 0 + 7
+
+	previewRoot, previewSupply, err := store.PreviewState(batch)
+	if err != nil {
+		t.Fatalf("preview: %v", err)
+	}
+	if err := store.SaveAccountsBatchWithHeight(batch, 1); err != nil {
+		t.Fatalf("save batch: %v", err)
+	}
+
+	root := store.StateRoot()
+	if root != previewRoot || store.TotalSupply() != previewSupply {
+		t.Fatalf("preview does not match committed state")
+	}
+	if store.TotalSupply() != expectedSupply {
+		t.Fatalf("supply = %d, want %d", store.TotalSupply(), expectedSupply)
+	}
+
+	for _, i := range []int{0, 3, 17, 31, 49} {
+		proof, err := store.GetAccountProof(fmt.Sprintf("F%036d", i))
+		if err != nil {
+			t.Fatalf("proof %d: %v", i, err)
+		}
+		if proof.Account == nil {
+			t.Fatalf("account %d missing from proof", i)
+		}
+		if err := proof.Verify(root); err != nil {
+			t.Fatalf("proof %d: %v", i, err)
+		}
+
+		proof.Account.AvailableBalance++
+		if proof.Verify(root) == nil {
+			t.Fatalf("tampered proof %d accepted", i)
+		}
+	}
+
+	absent, err := store.GetAccountProof(fmt.Sprintf("F%036d", 999))
+	if err != nil {
+		t.Fatalf("absent proof: %v", err)
+	}
+	if absent.Account != nil {
+		t.Fatalf("absent account has a leaf")
+	}
+	if err := absent.Verify(root); err != nil {
+		t.Fatalf("non-inclusion proof: %v", err)
+	}
+
+	// 重建（模拟写入中断后重启）结果必须一致
+	if err := store.tree.markPending(); err != nil {
+		t.Fatalf("mark pending: %v", err)
+	}
+	store.Close()
+
+	reopened, err := NewShardedStateStore(dir)
+	if err != nil {
+		t.Fatalf("reopen store: %v", err)
+	}
+	defer reopened.Close()
+
+	if reopened.StateRoot() != root {
+		t.Fatalf("rebuilt root %s != incremental root %s", reopened.StateRoot(), root)
+	}
+	if reopened.TotalSupply() != expectedSupply {
+		t.Fatalf("rebuilt supply = %d, want %d", reopened.TotalSupply(), expectedSupply)
+	}
+}
+
+func countTreeNodes(t *testing.T, tree *stateTree) int {
+	iter := tree.db.NewIterator(util.BytesPrefix(treeNodePrefix), nil)
+	defer iter.Release()
+	count := 0
+	for iter.Next() {
+		count++
+	}
+	if err := iter.Error(); err != nil {
+		t.Fatalf("iterate tree nodes: %v", err)
+	}
+	return count
+}
+
+// 增量提交删除被替换的节点：反复更新后节点数与从头重建的树相同
+func TestStateTreePrunesReplacedNodes(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewShardedStateStore(dir)
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+
+	for round := 0; round < 5; round++ {
+		batch := make([]*core.Account, 0, 40)
+		for i := 0; i < 40; i++ {
+			batch = append(batch, testAccount(i, uint64(round*100+i)))
+		}
+		// 同一批次中重复写入同一账户、写入未变化的值
+		batch = append(batch, testAccount(5, 1), testAccount(5, uint64(round*100+5)))
+		if err := store.SaveAccountsBatchWithHeight(batch, uint64(round+1)); err != nil {
+			t.Fatalf("round %d: %v", round, err)
+		}
+	}
+	if err := store.SaveAccount(testAccount(7, 700)); err != nil {
+		t.Fatalf("save account: %v", err)
+	}
+
+	root := store.StateRoot()
+	incremental := countTreeNodes(t, store.tree)
+	for _, i := range []int{0, 5, 7, 39} {
+		proof, err := store.GetAccountProof(fmt.Sprintf("F%036d", i))
+		if err != nil {
+			t.Fatalf("proof %d: %v", i, err)
+		}
+		if err := proof.Verify(root); err != nil {
+			t.Fatalf("proof %d: %v", i, err)
+		}
+	}
+
+	if err := store.tree.markPending(); err != nil {
+		t.Fatalf("mark pending: %v", err)
+	}
+	store.Close()
+
+	reopened, err := NewShardedStateStore(dir)
+	if err != nil {
+		t.Fatalf("reopen store: %v", err)
+	}
+	defer reopened.Close()
+
+	if reopened.StateRoot() != root {
+		t.Fatalf("rebuilt root %s != incremental root %s", reopened.StateRoot(), root)
+	}
+	if rebuilt := countTreeNodes(t, reopened.tree); rebuilt != incremental {
+		t.Fatalf("incremental tree holds %d nodes, rebuilt tree %d", incremental, rebuilt)
+	}
+}
+
+// 状态分片证明：每个分片都能单独验证到状态树的根，篡改、遗漏账户都会被发现
+func TestStateChunksVerifyAgainstTree(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 7, 300} {
+		store, err := NewShardedStateStore(t.TempDir())
+		if err != nil {
+			t.Fatalf("open store: %v", err)
+		}
+		accounts := make([]*core.Account, n)
+		for i := range accounts {
+			accounts[i] = testAccount(i, uint64(i+1))
+		}
+		if n > 0 {
+			if err := store.SaveAccountsBatchWithHeight(accounts, 1); err != nil {
+				t.Fatalf("save accounts: %v", err)
+			}
+		}
+		root := store.StateRoot()
+		store.Close()
+
+		chunkRoot, chunks := core.BuildStateChunks(accounts)
+		if chunkRoot != root || core.StateRootOf(accounts) != root {
+			t.Fatalf("n=%d: chunk root %s != tree root %s", n, chunkRoot, root)
+		}
+
+		total := 0
+		for _, chunk := range chunks {
+			if err := chunk.Verify(root); err != nil {
+				t.Fatalf("n=%d: chunk %d: %v", n, chunk.Index, err)
+			}
+			total += len(chunk.Accounts)
+		}
+		if total != n {
+			t.Fatalf("n=%d: chunks hold %d accounts", n, total)
+		}
+
+		for _, chunk := range chunks {
+			if len(chunk.Accounts) == 0 {
+				continue
+			}
+			// 遗漏账户
+			dropped := *chunk
+			dropped.Accounts = chunk.Accounts[1:]
+			if dropped.Verify(root) == nil {
+				t.Fatalf("n=%d: chunk %d with dropped account accepted", n, chunk.Index)
+			}
+			// 篡改余额
+			tampered := *chunk
+			acc := *chunk.Accounts[0]
+			acc.AvailableBalance++
+			tampered.Accounts = append([]*core.Account{&acc}, chunk.Accounts[1:]...)
+			if tampered.Verify(root) == nil {
+				t.Fatalf("n=%d: chunk %d with tampered account accepted", n, chunk.Index)
+			}
+			// 冒充空分片
+			empty := core.StateChunk{Index: chunk.Index, Siblings: chunk.Siblings}
+			if empty.Verify(root) == nil {
+				t.Fatalf("n=%d: chunk %d claimed empty", n, chunk.Index)
+			}
+		}
+	}
+}
+
+// 已验证的分片按状态根持久化，重启后可继续同步
+func TestStateChunkPersistence(t *testing.T) {
+	db, err := OpenDatabase(t.TempDir())
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	defer db.Close()
+
+	accounts := []*core.Account{testAccount(1, 10), testAccount(2, 20), testAccount(3, 30)}
+	root, chunks := core.BuildStateChunks(accounts)
+	for _, chunk := range chunks[:5] {
+		if err := db.SaveStateChunk(root, chunk); err != nil {
+			t.Fatalf("save chunk: %v", err)
+		}
+	}
+
+	loaded, err := db.GetStateChunks(root)
+	if err != nil || len(loaded) != 5 {
+		t.Fatalf("loaded %d chunks, err=%v", len(loaded), err)
+	}
+	for _, chunk := range loaded {
+		if err := chunk.Verify(root); err != nil {
+			t.Fatalf("loaded chunk %d: %v", chunk.Index, err)
+		}
+	}
+	if other, _ := db.GetStateChunks(core.Hash{1}); len(other) != 0 {
+		t.Fatalf("chunks leaked across state roots")
+	}
+
+	if err := db.ClearStateChunks(); err != nil {
+		t.Fatalf("clear: %v", err)
+	}
+	if loaded, _ := db.GetStateChunks(root); len(loaded) != 0 {
+		t.Fatalf("%d chunks left after clear", len(loaded))
+	}
+}
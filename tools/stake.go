@@ -57,6 +57,7 @@ func main() {
 	privKeyFile := flag.String("key", "", "私钥文件路径 (必填)")
 	pubKeyFile := flag.String("pub", "", "公钥文件路径 (必填)")
 	nodeURL := flag.String("node", "http://localhost:9000", "节点API地址")
+	nonceFlag := flag.Int64("nonce", -1, "交易nonce（默认-1：查询节点获取账户当前nonce；批量预签名时手动递增）")
 	output := flag.String("out", "", "仅生成交易JSON文件，不发送 (可选)")
 
 	flag.Parse()
@@ -66,7 +67,7 @@ func main() {
 		fmt.Println("错误：缺少必填参数")
 		fmt.Println()
 		fmt.Println("使用示例：")
-		fmt.Println("  go run stake.go \\")
+		fmt.Println("  go run stake.go tx_nonce.go \\")
 		fmt.Println("    -from F46yls4ckd2it5d6dnkx3qye1ldbh7e6ccpsg \\")
 		fmt.Println("    -amount 1000000000000 \\")
 		fmt.Println("    -key ./addr04_private.key \\")
@@ -97,7 +98,14 @@ func main() {
 	fmt.Println("==============")
 	fmt.Println()
 
-	// 创建质押交易（nonce由节点自动分配，签名不包含nonce）
+	// 客户端指定nonce（激活时间起参与签名）
+	timestamp := time.Now().UnixMilli()
+	nonce, err := resolveNonce(*nonceFlag, *nodeURL, *fromAddr, timestamp)
+	if err != nil {
+		log.Fatalf("查询 nonce 失败: %v", err)
+	}
+
+	// 创建质押交易
 	// 质押交易：from和to是同一个地址（自己给自己质押）
 	tx := &Transaction{
 		Type:      TxStake,
@@ -105,8 +113,8 @@ func main() {
 		To:        *fromAddr, // 质押交易to=from
 		Amount:    *amount,
 		GasFee:    gasFee,
-		Nonce:     0, // 将由节点自动分配
-		Timestamp: time.Now().Unix(),
+		Nonce:     nonce,
+		Timestamp: timestamp,
 		PublicKey: pubKeyBytes,
 	}
 
@@ -167,7 +175,7 @@ func main() {
 }
 
 // 获取签名数据（与core.Transaction.SignData()保持一致）
-// 注意：nonce不参与签名，由节点自动分配
+// 注意：激活时间（NonceSigningActivationMs）起nonce才参与签名，与SignsNonce规则一致
 func getSignData(tx *Transaction) []byte {
 	buf := new(bytes.Buffer)
 
@@ -176,7 +184,9 @@ func getSignData(tx *Transaction) []byte {
 	buf.WriteString(tx.To)
 	buf.Write(uint64ToBytes(tx.Amount))
 	buf.Write(uint64ToBytes(tx.GasFee))
-	// Nonce不参与签名 - 由节点自动分配
+	if tx.Timestamp >= NonceSigningActivationMs {
+		buf.Write(uint64ToBytes(tx.Nonce))
+	}
 	buf.Write(uint64ToBytes(uint64(tx.Timestamp)))
 
 	if len(tx.Data) > 0 {
@@ -0,0 +1,51 @@
+package main
+
+// 交易工具（transfer/stake/unstake/unjail）共用的nonce处理
+// 运行时需与工具一起编译，例如：go run transfer.go tx_nonce.go
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// 客户端签名nonce的激活时间（毫秒，与consensus.json的transaction_params.nonce_signing_activation_ms一致）
+// 时间戳早于此时的交易nonce不参与签名（由节点分配）
+const NonceSigningActivationMs int64 = 1793000000000
+
+// resolveNonce 使用-nonce参数，未指定时查询节点
+// 激活时间之前nonce不参与签名，节点要求提交0并自动分配
+func resolveNonce(nonceFlag int64, nodeURL, address string, timestamp int64) (uint64, error) {
+	if timestamp < NonceSigningActivationMs {
+		return 0, nil
+	}
+	if nonceFlag >= 0 {
+		return uint64(nonceFlag), nil
+	}
+	return queryAccountNonce(nodeURL, address)
+}
+
+// 查询账户 nonce
+func queryAccountNonce(nodeURL, address string) (uint64, error) {
+	url := fmt.Sprintf("%s/balance/%s", nodeURL, address)
+	resp, err := http.Get(url)
+	if err != nil {
+		return 0, fmt.Errorf("HTTP请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return 0, fmt.Errorf("节点返回错误 (状态码=%d): %s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		Nonce uint64 `json:"nonce"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, fmt.Errorf("解析响应失败: %v", err)
+	}
+
+	return result.Nonce, nil
+}
@@ -54,6 +54,7 @@ func main() {
 	privKeyFile := flag.String("key", "", "私钥文件路径 (必填)")
 	pubKeyFile := flag.String("pub", "", "公钥文件路径 (必填)")
 	nodeURL := flag.String("node", "http://localhost:9000", "节点API地址")
+	nonceFlag := flag.Int64("nonce", -1, "交易nonce（默认-1：查询节点获取账户当前nonce；批量预签名时手动递增）")
 
 	flag.Parse()
 
@@ -65,6 +66,7 @@ func main() {
 		fmt.Println("  unstake.exe -from F7biz3m3dfq966u5vqj2wcts4z21nmdbalsyy -amount 10 -key ../../addr/mywallet/wallet_private.key -pub ../../addr/mywallet/wallet_public.key")
 		fmt.Println()
 		fmt.Println("Windows直接用: unstake.exe ...，Git Bash用: ./unstake.exe ...")
+		fmt.Println("构建: go build -o unstake.exe unstake.go tx_nonce.go")
 		fmt.Println()
 		fmt.Println("参数说明：")
 		fmt.Println("  -from:   解押地址（已质押的验证者）")
@@ -73,6 +75,7 @@ func main() {
 		fmt.Println("  -pub:    公钥文件路径")
 		fmt.Println("  -node:   节点API地址（默认 http://localhost:9000）")
 		fmt.Println("  -gas:    手续费（解押交易不收取gas费,默认0）")
+		fmt.Println("  -nonce:  交易nonce（默认查询节点）")
 		fmt.Println()
 		os.Exit(1)
 	}
@@ -97,8 +100,9 @@ func main() {
 	fmt.Println("==============")
 	fmt.Println()
 
-	// 查询账户 nonce
-	nonce, err := queryAccountNonce(*nodeURL, *fromAddr)
+	// 客户端指定nonce（激活时间起参与签名）
+	timestamp := time.Now().UnixMilli()
+	nonce, err := resolveNonce(*nonceFlag, *nodeURL, *fromAddr, timestamp)
 	if err != nil {
 		log.Fatalf("查询 nonce 失败: %v\n", err)
 	}
@@ -107,11 +111,11 @@ func main() {
 	tx := &Transaction{
 		Type:      TxUnstake,
 		From:      *fromAddr,
-		To:        "",  // 解押交易To为空
-		Amount:    *amount * 1000000,  // 转换为最小单位
+		To:        "",                // 解押交易To为空
+		Amount:    *amount * 1000000, // 转换为最小单位
 		GasFee:    *gasFee,
 		Nonce:     nonce,
-		Timestamp: time.Now().Unix(),
+		Timestamp: timestamp,
 		PublicKey: pubKeyBytes,
 	}
 
@@ -145,14 +149,15 @@ func main() {
 	}
 
 	fmt.Println()
-	fmt.Println("✓ 解押成功！质押金额已返还到账户可用余额")
+	fmt.Println("✓ 解押交易已发送！质押进入解绑期，到期后自动返还到账户可用余额")
 	fmt.Printf("交易哈希: %s\n", txHash)
 	fmt.Println()
 	fmt.Println("查询交易状态：")
 	fmt.Printf("  curl %s/transaction/%s\n", *nodeURL, txHash)
 }
 
-// 获取签名数据
+// 获取签名数据（与core.Transaction.SignData()保持一致）
+// 注意：激活时间（NonceSigningActivationMs）起nonce才参与签名，与SignsNonce规则一致
 func getSignData(tx *Transaction) []byte {
 	buf := new(bytes.Buffer)
 	buf.WriteByte(byte(tx.Type))
@@ -160,7 +165,9 @@ func getSignData(tx *Transaction) []byte {
 	buf.WriteString(tx.To)
 	buf.Write(uint64ToBytes(tx.Amount))
 	buf.Write(uint64ToBytes(tx.GasFee))
-	buf.Write(uint64ToBytes(tx.Nonce))
+	if tx.Timestamp >= NonceSigningActivationMs {
+		buf.Write(uint64ToBytes(tx.Nonce))
+	}
 	buf.Write(uint64ToBytes(uint64(tx.Timestamp)))
 	if len(tx.Data) > 0 {
 		buf.Write(tx.Data)
@@ -238,27 +245,3 @@ func sendTransaction(tx *Transaction, nodeURL string) error {
 
 	return nil
 }
-
-// 查询账户 nonce
-func queryAccountNonce(nodeURL, address string) (uint64, error) {
-	url := fmt.Sprintf("%s/balance/%s", nodeURL, address)
-	resp, err := http.Get(url)
-	if err != nil {
-		return 0, fmt.Errorf("HTTP请求失败: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return 0, fmt.Errorf("节点返回错误 (状态码=%d): %s", resp.StatusCode, string(body))
-	}
-
-	var result struct {
-		Nonce uint64 `json:"nonce"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return 0, fmt.Errorf("解析响应失败: %v", err)
-	}
-
-	return result.Nonce, nil
-}
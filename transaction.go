@@ -10,50 +10,85 @@ import (
 	"fan-chain/core"
 )
 
+// SubmitTransaction 处理API提交的交易：校验入池后广播给其他节点
 func (n *Node) SubmitTransaction(tx *core.Transaction) error {
-	// ============ 【架构约束】非验证者节点不处理交易 ============
-	// 原因：
-	// 1. 交易池只应由验证者节点维护
-	// 2. 客户端应直接提交交易到验证者节点
-	// 3. 非验证者节点不参与交易打包，无需维护交易池
-	if !n.isActiveValidator(n.address) {
+	// ============ 【架构约束】非验证者节点默认不处理交易 ============
+	// 交易池只应由验证者节点维护；配置tx_relay的全节点可作为中继接收并转发
+	if !n.acceptsTransactions() {
 		log.Printf("❌ [FULL NODE] Transaction rejected: non-validator nodes do not accept transactions")
 		return fmt.Errorf("this node is not a validator and cannot accept transactions, please submit to a validator node")
 	}
 
+	if err := n.addTransactionToPool(tx, true); err != nil {
+		return err
+	}
+
+	// 广播给其他节点，下一个出块者无论是谁都能打包
+	if n.p2pServer != nil {
+		n.p2pServer.BroadcastTransaction(tx)
+	}
+
+	return nil
+}
+
+// acceptsTransactions 本节点是否接收交易（验证者或中继节点）
+func (n *Node) acceptsTransactions() bool {
+	return n.isActiveValidator(n.address) || n.config.TxRelay
+}
+
+// addTransactionToPool 校验交易并加入交易池
+// assignLegacyNonce: 旧格式交易（nonce不参与签名）是否由本节点分配nonce
+// API提交时为true；P2P收到的旧格式交易已由源节点分配nonce，为false
+func (n *Node) addTransactionToPool(tx *core.Transaction, assignLegacyNonce bool) error {
+	// 系统交易（奖励/惩罚）只能由出块者在区块内生成，不接受外部提交
+	if tx.Type.IsSystemTx() {
+		return fmt.Errorf("system transaction type %s cannot be submitted", tx.TypeString())
+	}
+
 	// ============ 1. 基于哈希的去重检查（最高优先级）============
 	// 这是防止重复提交的第一道防线
 	txHash := tx.Hash()
-	filename := filepath.Join(n.pendingTxDir, "tx_"+fmt.Sprintf("%x", txHash.Bytes()[:8])+".json")
-
-	if _, err := os.Stat(filename); err == nil {
+	if n.txPool.Has(txHash) {
 		// 交易已存在于pending池，直接拒绝
 		log.Printf("⚠️  [TX_POOL] Transaction already exists in pool: %x", txHash.Bytes()[:8])
 		return fmt.Errorf("transaction already exists in pool (duplicate hash)")
 	}
 
-	// ============ 2. Nonce 检查和分配 ============
-	// 跳过系统交易（奖励/惩罚）的nonce检查
-	if tx.Type != core.TxReward && tx.Type != core.TxSlash {
-		// 用户不应该指定nonce，如果指定了则拒绝（nonce必须为0）
+	// ============ 2. Nonce 检查 ============
+	currentNonce, err := n.state.GetNonce(tx.From)
+	if err != nil {
+		return fmt.Errorf("failed to get nonce for %s: %v", tx.From, err)
+	}
+
+	if tx.SignsNonce() {
+		// 客户端指定并签名nonce
+		// nonce < 链上nonce：已被消耗；nonce远超链上nonce：拒绝，防止占满交易池
+		if tx.Nonce < currentNonce {
+			return fmt.Errorf("nonce too low: got %d, account nonce is %d", tx.Nonce, currentNonce)
+		}
+		if maxGap := core.MaxNonceGap(); maxGap > 0 && tx.Nonce > currentNonce+maxGap {
+			return fmt.Errorf("nonce too high: got %d, account nonce is %d (max gap %d)", tx.Nonce, currentNonce, maxGap)
+		}
+	} else if !assignLegacyNonce {
+		// 【旧格式交易】P2P收到时nonce已由源节点分配
+		// nonce不在签名内，任何中继都能改写，同样限制最大间隔，防止改写成远期nonce占满交易池
+		if tx.Nonce < currentNonce {
+			return fmt.Errorf("nonce too low: got %d, account nonce is %d", tx.Nonce, currentNonce)
+		}
+		if maxGap := core.MaxNonceGap(); maxGap > 0 && tx.Nonce > currentNonce+maxGap {
+			return fmt.Errorf("nonce too high: got %d, account nonce is %d (max gap %d)", tx.Nonce, currentNonce, maxGap)
+		}
+	} else {
+		// 【旧格式交易】nonce不参与签名，由节点自动分配
 		if tx.Nonce != 0 {
 			return fmt.Errorf("user should not specify nonce (got %d), nonce must be 0 and will be auto-assigned by node", tx.Nonce)
 		}
 
-		// 验证者节点：自动计算并分配nonce
-		currentNonce, err := n.state.GetNonce(tx.From)
-		if err != nil {
-			return fmt.Errorf("failed to get nonce for %s: %v", tx.From, err)
-		}
-
-		// 检查pending池中该账户已有的交易数量
-		pendingCount := n.countPendingTransactions(tx.From)
-
-		// 分配nonce = 当前nonce + pending池中的交易数
-		tx.Nonce = currentNonce + uint64(pendingCount)
+		// 分配nonce = 当前nonce之后第一个未被交易池占用的nonce
+		tx.Nonce = n.txPool.NextNonce(tx.From, currentNonce)
 
 		log.Printf("🔄 [VALIDATOR] Auto-assigned nonce for %s: current=%d, pending=%d, assigned=%d",
-			tx.From[:10], currentNonce, pendingCount, tx.Nonce)
+			tx.From[:10], currentNonce, n.txPool.PendingCount(tx.From), tx.Nonce)
 	}
 
 	// ============ 3. 交易格式和签名验证 ============
@@ -62,46 +97,55 @@ func (n *Node) SubmitTransaction(tx *core.Transaction) error {
 		return fmt.Errorf("transaction validation failed: %v", err)
 	}
 
-	// 验证签名
+	// 验证签名（含密码学校验）和公钥与发送者地址的对应关系
 	if err := tx.VerifySignature(); err != nil {
 		return fmt.Errorf("signature verification failed: %v", err)
 	}
-
-	// ============ 4. 入池（不广播）============
-	// 序列化交易
-	txJSON, err := json.Marshal(tx)
+	derivedAddress, err := core.AddressFromPublicKey(tx.PublicKey)
 	if err != nil {
-		return fmt.Errorf("serialize transaction failed: %v", err)
+		return fmt.Errorf("invalid public key: %v", err)
+	}
+	if derivedAddress != tx.From {
+		return fmt.Errorf("public key does not match sender address")
 	}
 
-	// 保存到pending目录
-	if err := os.WriteFile(filename, txJSON, 0644); err != nil {
-		return fmt.Errorf("write transaction file failed: %v", err)
+	// 解除监禁交易：账户须处于监禁中且在下一区块高度监禁期已满，否则上链必然失败
+	if tx.Type == core.TxUnjail {
+		account, err := n.state.GetAccount(tx.From)
+		if err != nil {
+			return fmt.Errorf("failed to get account %s: %v", tx.From, err)
+		}
+		if err := checkUnjail(account.Jailed, account.JailedUntil, n.chain.GetLatestHeight()+1); err != nil {
+			return err
+		}
 	}
 
-	log.Printf("✓ [TX_POOL] Transaction accepted: %x", txHash.Bytes()[:8])
+	// ============ 4. 入池 ============
+	if err := n.txPool.Add(tx); err != nil {
+		return err
+	}
 
-	// 【架构变更】验证者节点不广播交易
-	// 原因：客户端应该直接提交到验证者，而不是依赖P2P广播
-	// 如果需要多验证者冗余，客户端可以同时提交到多个验证者
+	pending, queued := n.txPool.SenderStatus(tx.From, currentNonce)
+	log.Printf("✓ [TX_POOL] Transaction accepted: %x (nonce=%d, sender pending=%d queued=%d, pool size: %d)",
+		txHash.Bytes()[:8], tx.Nonce, pending, queued, n.txPool.Len())
 
 	return nil
 }
 
-// countPendingTransactions 统计pending池中指定地址的交易数量
-func (n *Node) countPendingTransactions(address string) int {
-	files, err := os.ReadDir(n.pendingTxDir)
+// migrateLegacyPendingTxs 将旧版pending_txs目录中的交易导入交易池，导入后删除目录
+func (n *Node) migrateLegacyPendingTxs(dir string) {
+	files, err := os.ReadDir(dir)
 	if err != nil {
-		return 0
+		return
 	}
 
-	count := 0
+	imported := 0
 	for _, file := range files {
 		if file.IsDir() {
 			continue
 		}
 
-		data, err := os.ReadFile(filepath.Join(n.pendingTxDir, file.Name()))
+		data, err := os.ReadFile(filepath.Join(dir, file.Name()))
 		if err != nil {
 			continue
 		}
@@ -111,24 +155,48 @@ func (n *Node) countPendingTransactions(address string) int {
 			continue
 		}
 
-		// 统计来自该地址的交易
-		if tx.From == address && tx.Type != core.TxReward && tx.Type != core.TxSlash {
-			count++
+		if err := n.txPool.Add(&tx); err == nil {
+			imported++
 		}
 	}
 
-	return count
+	if err := os.RemoveAll(dir); err != nil {
+		log.Printf("⚠️  [TX_POOL] Failed to remove legacy pending_txs dir: %v", err)
+		return
+	}
+
+	log.Printf("📥 [TX_POOL] Migrated %d transactions from legacy pending_txs dir", imported)
+}
+
+// nonceOf 交易池查询链上nonce的回调
+func (n *Node) nonceOf(address string) (uint64, error) {
+	return n.state.GetNonce(address)
 }
 
 // HandleReceivedTransaction 处理从P2P网络接收到的交易
-// 【架构约束】此函数已废弃，因为交易不应通过P2P广播
-// 客户端应直接提交交易到验证者节点，而不是通过P2P网络传播
+// 返回nil表示交易已入池，网络层会继续转发；返回错误则不转发
 func (n *Node) HandleReceivedTransaction(tx *core.Transaction) error {
-	log.Printf("⚠️  [DEPRECATED] Received transaction via P2P (this should not happen in current architecture)")
-	return fmt.Errorf("transaction broadcasting via P2P is deprecated, clients should submit directly to validators")
+	if !n.acceptsTransactions() {
+		return fmt.Errorf("node does not accept transactions (not a validator, tx_relay disabled)")
+	}
+
+	return n.addTransactionToPool(tx, false)
+}
+
+// checkUnjail 解除监禁交易的前置条件（与state.executeUnjail一致）：已被监禁且执行高度不早于JailedUntil
+func checkUnjail(jailed bool, jailedUntil uint64, height uint64) error {
+	if !jailed {
+		return fmt.Errorf("account is not jailed")
+	}
+	if height < jailedUntil {
+		return fmt.Errorf("account is jailed until height %d (next block %d)", jailedUntil, height)
+	}
+	return nil
 }
 
-func (n *Node) validateAndDeduplicateTransactions(txs []*core.Transaction) []*core.Transaction {
+// validateAndDeduplicateTransactions 按发送者检查nonce连续性和余额，剔除打包后必然执行失败的交易
+// height: 待打包区块的高度
+func (n *Node) validateAndDeduplicateTransactions(height uint64, txs []*core.Transaction) []*core.Transaction {
 	if len(txs) == 0 {
 		return txs
 	}
@@ -155,6 +223,16 @@ func (n *Node) validateAndDeduplicateTransactions(txs []*core.Transaction) []*co
 
 		log.Printf("[TX_VALIDATE] Account %s has %d txs, current nonce=%d", address[:10], len(accountTxs), currentNonce)
 
+		// 同一发送者的多笔交易按顺序扣减余额，避免每笔都按出块前余额检查导致透支
+		account, err := n.state.GetAccount(address)
+		if err != nil {
+			log.Printf("[TX_VALIDATE] Failed to get account %s: %v", address[:10], err)
+			continue
+		}
+		available := account.AvailableBalance
+		staked := account.StakedBalance
+		jailed := account.Jailed
+
 		seenNonces := make(map[uint64]bool)
 		for _, tx := range accountTxs {
 			if seenNonces[tx.Nonce] {
@@ -171,40 +249,37 @@ func (n *Node) validateAndDeduplicateTransactions(txs []*core.Transaction) []*co
 			switch tx.Type {
 			case core.TxTransfer:
 				// 转账：检查可用余额
-				balance, err := n.state.GetBalance(address)
-				if err != nil {
-					log.Printf("[TX_VALIDATE] SKIP tx (failed to get balance): %v", err)
-					continue
-				}
 				totalCost := tx.Amount + tx.GasFee
-				if balance < totalCost {
-					log.Printf("[TX_VALIDATE] SKIP tx (insufficient balance): balance=%d < cost=%d", balance, totalCost)
+				if available < totalCost {
+					log.Printf("[TX_VALIDATE] SKIP tx (insufficient balance): balance=%d < cost=%d", available, totalCost)
 					continue
 				}
+				available -= totalCost
 
 			case core.TxStake:
 				// 质押：检查可用余额
-				balance, err := n.state.GetBalance(address)
-				if err != nil {
-					log.Printf("[TX_VALIDATE] SKIP tx (failed to get balance): %v", err)
-					continue
-				}
-				if balance < tx.Amount {
-					log.Printf("[TX_VALIDATE] SKIP tx (insufficient balance for stake): balance=%d < amount=%d", balance, tx.Amount)
+				if available < tx.Amount {
+					log.Printf("[TX_VALIDATE] SKIP tx (insufficient balance for stake): balance=%d < amount=%d", available, tx.Amount)
 					continue
 				}
+				available -= tx.Amount
+				staked += tx.Amount
 
 			case core.TxUnstake:
-				// 解押：检查质押余额
-				account, err := n.state.GetAccount(address)
-				if err != nil {
-					log.Printf("[TX_VALIDATE] SKIP tx (failed to get account): %v", err)
+				// 解押：检查质押余额（解押金额保守地不计入本块可用余额）
+				if staked < tx.Amount {
+					log.Printf("[TX_VALIDATE] SKIP tx (insufficient staked balance): staked=%d < amount=%d", staked, tx.Amount)
 					continue
 				}
-				if account.StakedBalance < tx.Amount {
-					log.Printf("[TX_VALIDATE] SKIP tx (insufficient staked balance): staked=%d < amount=%d", account.StakedBalance, tx.Amount)
+				staked -= tx.Amount
+
+			case core.TxUnjail:
+				// 解除监禁：须已被监禁且监禁期已满
+				if err := checkUnjail(jailed, account.JailedUntil, height); err != nil {
+					log.Printf("[TX_VALIDATE] SKIP tx (unjail not allowed): %v", err)
 					continue
 				}
+				jailed = false
 			}
 
 			log.Printf("[TX_VALIDATE] ✓ ACCEPT tx: nonce=%d, amount=%d", tx.Nonce, tx.Amount)